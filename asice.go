@@ -0,0 +1,428 @@
+package smartid
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// ASiC-E container constants.
+const (
+	// MimeTypeASiCE is the mime type of ASiC-E (.asice, .bdoc) container.
+	MimeTypeASiCE = "application/vnd.etsi.asic-e+zip"
+
+	asiceMimetypeFile = "mimetype"
+	asiceManifestFile = "META-INF/manifest.xml"
+	asiceMetaInf      = "META-INF/"
+
+	nsManifest = "urn:oasis:names:tc:opendocument:xmlns:manifest:1.0"
+)
+
+// Size limits of the container read by ReadASiCE.
+const (
+	// MaxASiCEFileSize is the maximum uncompressed size of a file in the
+	// container.
+	MaxASiCEFileSize = 256 << 20
+
+	// MaxASiCESize is the maximum uncompressed size of all files in the
+	// container.
+	MaxASiCESize = 1 << 30
+)
+
+var (
+	// ErrASiCENoFiles error when container has no data files.
+	ErrASiCENoFiles = errors.New("ASiC-E container has no data files")
+
+	// ErrASiCEInvalidFileName error when data file name is not allowed.
+	ErrASiCEInvalidFileName = errors.New("ASiC-E data file name is not allowed")
+
+	// ErrASiCEDuplicateFile error when data file with the same name
+	// already exists or the container has several entries with the same
+	// name.
+	ErrASiCEDuplicateFile = errors.New("ASiC-E data file already exists")
+
+	// ErrASiCEManifestMismatch error when the manifest does not list
+	// exactly the data files of the container.
+	ErrASiCEManifestMismatch = errors.New("ASiC-E manifest does not match data files")
+
+	// ErrASiCEHasSignatures error when data files are added after the
+	// signature was prepared.
+	ErrASiCEHasSignatures = errors.New("ASiC-E container already has signatures")
+
+	// ErrASiCEFilesChanged error when data files are added after the
+	// signature was prepared.
+	ErrASiCEFilesChanged = errors.New("ASiC-E data files changed after the signature was prepared")
+
+	// ErrASiCEInvalidMimeType error when container does not start with
+	// ASiC-E mimetype file.
+	ErrASiCEInvalidMimeType = errors.New("Container is not ASiC-E")
+
+	// ErrASiCETooLarge error when file of the container or the container
+	// exceeds the size limit.
+	ErrASiCETooLarge = errors.New("ASiC-E container is too large")
+)
+
+// DataFile is the file in the signature container.
+type DataFile struct {
+	// Name is the path of the file inside the container.
+	Name string
+
+	// MimeType is the media type of the file.
+	MimeType string
+
+	// Data is the content of the file.
+	Data []byte
+}
+
+// ASiCEBuilder builds ASiC-E (BDOC 2.1) containers with XAdES signatures,
+// which DigiDoc4 can open. The signing is the two-step process:
+//
+//	builder := NewASiCEBuilder()
+//	builder.AddFile("contract.pdf", "application/pdf", data)
+//	sig, _ := builder.PrepareSignature(cert, SHA256)
+//	resp, _ := sig.Sign(ctx, client, &request) // or SetSignatureValue
+//	builder.Write(w)
+//
+// Prepared signature is added to the container when its value is set, so
+// the signing can be retried after refusal or timeout.
+type ASiCEBuilder struct {
+	files      []DataFile
+	signatures []*XAdESSignature
+
+	// prepared is the number of prepared signatures, which numbers their
+	// identifiers.
+	prepared int
+
+	// now returns current time for signing time.
+	now func() time.Time
+}
+
+// NewASiCEBuilder creates a new empty container builder.
+func NewASiCEBuilder() *ASiCEBuilder {
+	return &ASiCEBuilder{now: time.Now}
+}
+
+// AddFile adds data file to the container. If mime type is empty,
+// application/octet-stream is used. Files cannot be added after the
+// signature is prepared.
+func (b *ASiCEBuilder) AddFile(name, mimeType string, data []byte) error {
+	if len(b.signatures) > 0 {
+		return ErrASiCEHasSignatures
+	}
+	if !isValidDataFileName(name) {
+		return ErrASiCEInvalidFileName
+	}
+	for _, f := range b.files {
+		if f.Name == name {
+			return ErrASiCEDuplicateFile
+		}
+	}
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	b.files = append(b.files, DataFile{Name: name, MimeType: mimeType, Data: data})
+	return nil
+}
+
+// Files returns data files of the container.
+func (b *ASiCEBuilder) Files() []DataFile {
+	return b.files
+}
+
+// PrepareSignature prepares XAdES signature of all data files for the
+// signer certificate, usually got from Client.ChooseCertificateSync.
+// Digest of the signature must be signed, the signature is added to the
+// container when its value is set.
+func (b *ASiCEBuilder) PrepareSignature(cert *x509.Certificate, algo string) (*XAdESSignature, error) {
+	if len(b.files) == 0 {
+		return nil, ErrASiCENoFiles
+	}
+	refs := make([]xadesReference, len(b.files))
+	for i, f := range b.files {
+		digest, err := HashData(f.Data, algo)
+		if err != nil {
+			return nil, err
+		}
+		refs[i] = xadesReference{
+			uri:      fileURI(f.Name),
+			mimeType: f.MimeType,
+			digest:   digest,
+		}
+	}
+	id := fmt.Sprintf("S%d", b.prepared)
+	sig, err := newXAdESSignature(id, refs, cert, algo, b.now(), nil)
+	if err != nil {
+		return nil, err
+	}
+	b.prepared++
+	files := len(b.files)
+	sig.onSigned = func() error {
+		if files != len(b.files) {
+			return ErrASiCEFilesChanged
+		}
+		b.signatures = append(b.signatures, sig)
+		return nil
+	}
+	return sig, nil
+}
+
+// Write writes the container with the signed signatures. Prepared
+// signatures, which are not signed, are left out.
+func (b *ASiCEBuilder) Write(w io.Writer) error {
+	if len(b.files) == 0 {
+		return ErrASiCENoFiles
+	}
+	sigs := make([][]byte, len(b.signatures))
+	for i, s := range b.signatures {
+		data, err := s.Bytes()
+		if err != nil {
+			return err
+		}
+		sigs[i] = data
+	}
+
+	now := b.now()
+	zw := zip.NewWriter(w)
+	if err := writeASiCMimetype(zw, MimeTypeASiCE); err != nil {
+		return err
+	}
+	for _, f := range b.files {
+		if err := writeZipFile(zw, f.Name, f.Data, now); err != nil {
+			return err
+		}
+	}
+	if err := writeZipFile(zw, asiceManifestFile, b.manifest(), now); err != nil {
+		return err
+	}
+	for i, data := range sigs {
+		name := fmt.Sprintf("%vsignatures%d.xml", asiceMetaInf, i)
+		if err := writeZipFile(zw, name, data, now); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// manifest makes OpenDocument manifest of the container.
+func (b *ASiCEBuilder) manifest() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	fmt.Fprintf(&buf, `<manifest:manifest xmlns:manifest="%s" manifest:version="1.2">`,
+		nsManifest)
+	buf.WriteString("\n")
+	fmt.Fprintf(&buf, `<manifest:file-entry manifest:full-path="/" manifest:media-type="%s"/>`,
+		MimeTypeASiCE)
+	buf.WriteString("\n")
+	for _, f := range b.files {
+		fmt.Fprintf(&buf, `<manifest:file-entry manifest:full-path="%s" manifest:media-type="%s"/>`,
+			xmlEscape(f.Name), xmlEscape(f.MimeType))
+		buf.WriteString("\n")
+	}
+	buf.WriteString("</manifest:manifest>\n")
+	return buf.Bytes()
+}
+
+// writeASiCMimetype writes mimetype file as the first stored entry without
+// data descriptor, as required by ASiC.
+func writeASiCMimetype(zw *zip.Writer, mimeType string) error {
+	data := []byte(mimeType)
+	fw, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               asiceMimetypeFile,
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(data),
+		CompressedSize64:   uint64(len(data)),
+		UncompressedSize64: uint64(len(data)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(data)
+	return err
+}
+
+// writeZipFile writes compressed file with the modification time to the
+// archive.
+func writeZipFile(zw *zip.Writer, name string, data []byte, modified time.Time) error {
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(data)
+	return err
+}
+
+// isValidDataFileName checks that the name can be used for data file.
+func isValidDataFileName(name string) bool {
+	if name == "" || name == asiceMimetypeFile ||
+		strings.HasPrefix(name, asiceMetaInf) ||
+		strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") {
+		return false
+	}
+	return path.Clean(name) == name && !strings.HasPrefix(name, "../")
+}
+
+// ASiCEContainer is the ASiC-E (BDOC) container read for validation.
+type ASiCEContainer struct {
+	files      []DataFile
+	signatures []*XAdESSignature
+}
+
+// ReadASiCE reads ASiC-E (.asice, .sce) or BDOC container. Data files
+// and XAdES signatures are parsed, but not validated.
+func ReadASiCE(r io.ReaderAt, size int64) (*ASiCEContainer, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	// The mimetype file must be first and stored without compression.
+	if len(zr.File) == 0 || zr.File[0].Name != asiceMimetypeFile ||
+		zr.File[0].Method != zip.Store {
+		return nil, ErrASiCEInvalidMimeType
+	}
+	mimeType, err := readZipFile(zr.File[0], MaxASiCEFileSize)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(string(mimeType)) != MimeTypeASiCE {
+		return nil, ErrASiCEInvalidMimeType
+	}
+
+	c := &ASiCEContainer{}
+	var mimeTypes map[string]string
+	names := map[string]bool{asiceMimetypeFile: true}
+	remaining := int64(MaxASiCESize - len(mimeType))
+	for _, f := range zr.File[1:] {
+		// Later entry with the same name would replace the validated one.
+		if names[f.Name] {
+			return nil, fmt.Errorf("%w: %s", ErrASiCEDuplicateFile, f.Name)
+		}
+		names[f.Name] = true
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		data, err := readZipFile(f, min(MaxASiCEFileSize, remaining))
+		if err != nil {
+			return nil, err
+		}
+		remaining -= int64(len(data))
+		switch {
+		case f.Name == asiceManifestFile:
+			if mimeTypes, err = parseManifest(data); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(f.Name, asiceMetaInf):
+			base := path.Base(f.Name)
+			if !strings.Contains(base, "signatures") || path.Ext(base) != ".xml" {
+				continue
+			}
+			sigs, err := parseXAdESSignatures(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Name, err)
+			}
+			c.signatures = append(c.signatures, sigs...)
+		default:
+			c.files = append(c.files, DataFile{Name: f.Name, Data: data})
+		}
+	}
+	if len(c.files) == 0 {
+		return nil, ErrASiCENoFiles
+	}
+	if len(mimeTypes) != len(c.files) {
+		return nil, ErrASiCEManifestMismatch
+	}
+	for i, f := range c.files {
+		mt, ok := mimeTypes[f.Name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrASiCEManifestMismatch, f.Name)
+		}
+		c.files[i].MimeType = mt
+	}
+	return c, nil
+}
+
+// Files returns data files of the container.
+func (c *ASiCEContainer) Files() []DataFile {
+	return c.files
+}
+
+// Signatures returns XAdES signatures of the container.
+func (c *ASiCEContainer) Signatures() []*XAdESSignature {
+	return c.signatures
+}
+
+// Validate validates all signatures of the container: digests of data
+// files, signature values, signer certificates with the trust store,
+// signature timestamps and OCSP responses. Every data file must be
+// signed by every signature.
+func (c *ASiCEContainer) Validate(ctx context.Context, ts *TrustStore) []*SignatureResult {
+	files := make(map[string][]byte, len(c.files))
+	for _, f := range c.files {
+		files[f.Name] = f.Data
+	}
+	results := make([]*SignatureResult, len(c.signatures))
+	for i, s := range c.signatures {
+		r, signed := s.validate(ctx, ts, files)
+		for _, f := range c.files {
+			if !signed[f.Name] {
+				r.fail(fmt.Errorf("%w: %s", ErrDataFileNotSigned, f.Name))
+			}
+		}
+		results[i] = r
+	}
+	return results
+}
+
+// parseManifest returns media types of the files in the manifest. The
+// entry of the container itself is omitted.
+func parseManifest(data []byte) (map[string]string, error) {
+	root, err := parseXML(data)
+	if err != nil {
+		return nil, err
+	}
+	mimeTypes := make(map[string]string)
+	for _, e := range root.FindAll(nsManifest, "file-entry") {
+		name := e.AttrNS(nsManifest, "full-path")
+		if name == "/" {
+			continue
+		}
+		if _, ok := mimeTypes[name]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrASiCEManifestMismatch, name)
+		}
+		mimeTypes[name] = e.AttrNS(nsManifest, "media-type")
+	}
+	return mimeTypes, nil
+}
+
+// readZipFile reads the content of the archived file, ErrASiCETooLarge
+// if it exceeds maxSize bytes.
+func readZipFile(f *zip.File, maxSize int64) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(maxSize) {
+		return nil, ErrASiCETooLarge
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, ErrASiCETooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,796 @@
+package smartid
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testTimestamper struct {
+	digest *Digest
+}
+
+func (ts *testTimestamper) Timestamp(ctx context.Context, digest *Digest) ([]byte, error) {
+	ts.digest = digest
+	return []byte("token"), nil
+}
+
+func TestASiCEBuilder_AddFile(t *testing.T) {
+	testdata := []struct {
+		name string
+		err  error
+	}{
+		{"test.txt", nil},
+		{"dir/test.txt", nil},
+		{"test.txt", ErrASiCEDuplicateFile},
+		{"", ErrASiCEInvalidFileName},
+		{"mimetype", ErrASiCEInvalidFileName},
+		{"META-INF/manifest.xml", ErrASiCEInvalidFileName},
+		{"/etc/passwd", ErrASiCEInvalidFileName},
+		{"../test.txt", ErrASiCEInvalidFileName},
+		{"dir/../test.txt", ErrASiCEInvalidFileName},
+	}
+	b := NewASiCEBuilder()
+	for _, test := range testdata {
+		if err := b.AddFile(test.name, "", nil); err != test.err {
+			t.Error(test.name, "expected", test.err, "got", err)
+		}
+	}
+	if b.Files()[0].MimeType != "application/octet-stream" {
+		t.Error("expected", "application/octet-stream", "got", b.Files()[0].MimeType)
+	}
+	if err := b.Write(io.Discard); err != nil {
+		t.Error("expected", nil, "got", err)
+	}
+
+	// Entries have the time of the builder clock, so output is stable.
+	now := time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC)
+	b.now = func() time.Time { return now }
+	var first, second bytes.Buffer
+	b.Write(&first)
+	b.Write(&second)
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("expected the same container")
+	}
+	zr, err := zip.NewReader(bytes.NewReader(first.Bytes()), int64(first.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File[1:] {
+		if !f.Modified.Equal(now) {
+			t.Error(f.Name, "expected", now, "got", f.Modified)
+		}
+	}
+
+	if _, err := NewASiCEBuilder().PrepareSignature(nil, SHA256); err != ErrASiCENoFiles {
+		t.Error("expected", ErrASiCENoFiles, "got", err)
+	}
+}
+
+func TestASiCEBuilder_Sign(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	client := mock.client()
+
+	choice, err := client.ChooseCertificateSync(ctx, &AuthRequest{
+		Identifier: "PNOEE-30303039914",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := choice.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mock.requests[0]["hash"]; ok {
+		t.Error("expected no hash in certificate choice request")
+	}
+
+	data := []byte("Hello, Smart-ID!")
+	b := NewASiCEBuilder()
+	b.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+	b.AddFile("hello world.txt", "text/plain", data)
+	sig, err := b.PrepareSignature(choice.Cert.GetX509Cert(), SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = sig.Sign(ctx, client, &AuthRequest{
+		Identifier: choice.Result.DocumentNumber,
+		AuthType:   AuthTypeDocument,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile("late.txt", "", nil); err != ErrASiCEHasSignatures {
+		t.Error("expected", ErrASiCEHasSignatures, "got", err)
+	}
+	if mock.requests[1]["hashType"] != SHA256 {
+		t.Error("expected", SHA256, "got", mock.requests[1]["hashType"])
+	}
+	if sig.ID() != "S0" {
+		t.Error("expected", "S0", "got", sig.ID())
+	}
+
+	ts := &testTimestamper{}
+	if err := sig.AddTimestamp(ctx, ts); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{
+		"mimetype", "hello world.txt", "META-INF/manifest.xml", "META-INF/signatures0.xml",
+	}
+	if len(zr.File) != len(names) {
+		t.Fatal("expected", len(names), "got", len(zr.File))
+	}
+	files := make(map[string][]byte)
+	for i, f := range zr.File {
+		if f.Name != names[i] {
+			t.Error("expected", names[i], "got", f.Name)
+		}
+		r, _ := f.Open()
+		files[f.Name], _ = io.ReadAll(r)
+		r.Close()
+	}
+	if zr.File[0].Method != zip.Store {
+		t.Error("expected mimetype to be stored")
+	}
+	if string(files["mimetype"]) != MimeTypeASiCE {
+		t.Error("expected", MimeTypeASiCE, "got", string(files["mimetype"]))
+	}
+	if !strings.Contains(string(files["META-INF/manifest.xml"]),
+		`manifest:full-path="hello world.txt" manifest:media-type="text/plain"`) {
+		t.Error("expected data file in manifest")
+	}
+
+	root, err := parseXML(files["META-INF/signatures0.xml"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := root.Child(nsXMLDSig, "Signature")
+	signedInfo := signature.Child(nsXMLDSig, "SignedInfo")
+	refs := signedInfo.ChildrenNamed(nsXMLDSig, "Reference")
+	if len(refs) != 2 {
+		t.Fatal("expected", 2, "got", len(refs))
+	}
+	if uri := refs[0].Attr("URI"); uri != "hello%20world.txt" {
+		t.Error("expected", "hello%20world.txt", "got", uri)
+	}
+	fileDigest, _ := HashData(data, SHA256)
+	if v := refs[0].Child(nsXMLDSig, "DigestValue").Text(); v != fileDigest.AuthHash().ToBase64String() {
+		t.Error("expected", fileDigest.AuthHash().ToBase64String(), "got", v)
+	}
+	spDigest, _ := digestElement(root.FindByID("S0-SignedProperties"), algExcC14N, SHA256)
+	if v := refs[1].Child(nsXMLDSig, "DigestValue").Text(); v != spDigest.AuthHash().ToBase64String() {
+		t.Error("expected", spDigest.AuthHash().ToBase64String(), "got", v)
+	}
+	if st := root.Find(nsXAdES, "SigningTime").Text(); st != "2024-01-02T03:04:05Z" {
+		t.Error("expected", "2024-01-02T03:04:05Z", "got", st)
+	}
+
+	siDigest, _ := digestElement(signedInfo, algExcC14N, SHA256)
+	value, _ := base64.StdEncoding.DecodeString(signature.Child(nsXMLDSig, "SignatureValue").Text())
+	err = verifySignatureValue(mock.signCert, siDigest.CryptoHash(), siDigest.AuthHash(), value)
+	if err != nil {
+		t.Error("expected", nil, "got", err)
+	}
+
+	tsDigest, _ := digestElement(signature.Child(nsXMLDSig, "SignatureValue"), algExcC14N, SHA256)
+	if !bytes.Equal(ts.digest.AuthHash(), tsDigest.AuthHash()) {
+		t.Error("expected timestamp over signature value")
+	}
+	token := root.Find(nsXAdES, "EncapsulatedTimeStamp").Text()
+	if token != base64.StdEncoding.EncodeToString([]byte("token")) {
+		t.Error("expected", "token", "got", token)
+	}
+}
+
+func TestXAdESSignature_Sign_refused(t *testing.T) {
+	mock := newMockSmartID(t)
+	b := NewASiCEBuilder()
+	b.AddFile("test.txt", "text/plain", []byte("test"))
+	sig, _ := b.PrepareSignature(mock.signCert, SHA256)
+
+	_, err := sig.Sign(context.Background(), mock.client(), &AuthRequest{
+		Identifier: "PNOEE-REFUSED",
+	})
+	if err == nil || err.Error() != SessionResultUserRefusedDisplayTextAndPIN {
+		t.Error("expected", SessionResultUserRefusedDisplayTextAndPIN, "got", err)
+	}
+	if err := sig.AddTimestamp(context.Background(), &testTimestamper{}); err != ErrXAdESNotSigned {
+		t.Error("expected", ErrXAdESNotSigned, "got", err)
+	}
+}
+
+func TestASiCEBuilder_PrepareSignature_retry(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+	signer := NewSigner(mock.client())
+	req := &AuthRequest{Identifier: "PNOEE-30303039914"}
+	b := NewASiCEBuilder()
+	b.AddFile("test.txt", "text/plain", []byte("test"))
+
+	mock.refuseSignatures = true
+	_, _, err := signer.Sign(ctx, req, NewASiCEDocumentBuilder(b, SHA256))
+	if !errors.Is(err, ErrUserRefused) {
+		t.Fatal("expected", ErrUserRefused, "got", err)
+	}
+	if err := b.AddFile("more.txt", "text/plain", []byte("more")); err != nil {
+		t.Error("expected", nil, "got", err)
+	}
+
+	mock.refuseSignatures = false
+	data, _, err := signer.Sign(ctx, req, NewASiCEDocumentBuilder(b, SHA256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := readTestASiCE(t, data)
+	if len(c.Files()) != 2 {
+		t.Error("expected", 2, "got", len(c.Files()))
+	}
+	results := c.Validate(ctx, ts)
+	if len(results) != 1 || !results[0].IsValid() {
+		t.Fatal("expected one valid signature, got", results)
+	}
+	if results[0].ID != "S1" {
+		t.Error("expected", "S1", "got", results[0].ID)
+	}
+
+	// Signature prepared before the file is added does not sign it.
+	b = NewASiCEBuilder()
+	b.AddFile("test.txt", "text/plain", []byte("test"))
+	sig, _ := b.PrepareSignature(mock.signCert, SHA256)
+	b.AddFile("late.txt", "text/plain", []byte("late"))
+	_, err = sig.Sign(ctx, mock.client(), &AuthRequest{
+		Identifier: mockDocumentNumber,
+		AuthType:   AuthTypeDocument,
+	})
+	if err != ErrASiCEFilesChanged {
+		t.Error("expected", ErrASiCEFilesChanged, "got", err)
+	}
+}
+
+func TestXAdESSignature_Sign_certMismatch(t *testing.T) {
+	mock := newMockSmartID(t)
+	b := NewASiCEBuilder()
+	b.AddFile("test.txt", "text/plain", []byte("test"))
+	sig, _ := b.PrepareSignature(mock.authCert, SHA256)
+
+	_, err := sig.Sign(context.Background(), mock.client(), &AuthRequest{
+		Identifier: mockDocumentNumber,
+		AuthType:   AuthTypeDocument,
+	})
+	if err != ErrSignatureCertMismatch {
+		t.Error("expected", ErrSignatureCertMismatch, "got", err)
+	}
+}
+
+// signedTestASiCE builds container signed with the mock service, with
+// signature timestamp and OCSP response of the signer certificate.
+func signedTestASiCE(t *testing.T, mock *mockSmartID, ocspStatus int) []byte {
+	t.Helper()
+	return signedTestASiCEWith(t, mock, ocspStatus, nil)
+}
+
+// signedTestASiCEWith signs the test container after modify changes the
+// prepared signature. Digests of SignedProperties and SignedInfo are
+// recalculated.
+func signedTestASiCEWith(t *testing.T, mock *mockSmartID, ocspStatus int, modify func(s *XAdESSignature)) []byte {
+	t.Helper()
+	ctx := context.Background()
+	b := NewASiCEBuilder()
+	b.AddFile("test.txt", "text/plain", []byte("test"))
+	b.AddFile("dir/data.bin", "", []byte{1, 2, 3})
+	sig, err := b.PrepareSignature(mock.signCert, SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modify != nil {
+		modify(sig)
+		if ref, sp := sig.signedPropertiesReference(); ref != nil {
+			d, err := digestElement(sp, algExcC14N, SHA256)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ref.Child(nsXMLDSig, "DigestValue").SetText(d.AuthHash().ToBase64String())
+		}
+		signedInfo := sig.signature.Child(nsXMLDSig, "SignedInfo")
+		if sig.digest, err = digestElement(signedInfo, algExcC14N, SHA256); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = sig.Sign(ctx, mock.client(), &AuthRequest{
+		Identifier: mockDocumentNumber,
+		AuthType:   AuthTypeDocument,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sig.AddTimestamp(ctx, newTestTSA(t, mock.ca, mock.caKey)); err != nil {
+		t.Fatal(err)
+	}
+	ocsp := newTestOCSPResponse(t, mock.signCert, mock.ca, mock.ca, mock.caKey,
+		ocspStatus, time.Now())
+	sig.unsignedSignatureProperties().
+		AddChild("xades", "RevocationValues").
+		AddChild("xades", "OCSPValues").
+		AddChild("xades", "EncapsulatedOCSPValue").
+		SetText(base64.StdEncoding.EncodeToString(ocsp))
+
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// rewriteZip replaces files of the archive with the modify function. If
+// function returns nil, the file is removed. Extra files are appended.
+func rewriteZip(
+	t *testing.T,
+	data []byte,
+	modify func(name string, data []byte) []byte,
+	extra map[string][]byte,
+) []byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range zr.File {
+		content, _ := readZipFile(f, MaxASiCEFileSize)
+		if content = modify(f.Name, content); content == nil {
+			continue
+		}
+		if f.Name == asiceMimetypeFile {
+			writeASiCMimetype(zw, string(content))
+			continue
+		}
+		writeZipFile(zw, f.Name, content, f.Modified)
+	}
+	for name, content := range extra {
+		writeZipFile(zw, name, content, time.Time{})
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func readTestASiCE(t *testing.T, data []byte) *ASiCEContainer {
+	t.Helper()
+	c, err := ReadASiCE(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestASiCEContainer_Validate(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+	data := signedTestASiCE(t, mock, OCSPStatusGood)
+
+	c := readTestASiCE(t, data)
+	files := c.Files()
+	if len(files) != 2 || files[1].Name != "dir/data.bin" ||
+		files[1].MimeType != "application/octet-stream" {
+		t.Error("expected 2 data files, got", files)
+	}
+	if len(c.Signatures()) != 1 {
+		t.Fatal("expected", 1, "got", len(c.Signatures()))
+	}
+
+	results := c.Validate(ctx, ts)
+	if len(results) != 1 {
+		t.Fatal("expected", 1, "got", len(results))
+	}
+	r := results[0]
+	if !r.IsValid() {
+		t.Fatal("expected valid signature, got", r.Err())
+	}
+	if r.ID != "S0" {
+		t.Error("expected", "S0", "got", r.ID)
+	}
+	if r.Identity.SerialNumber != "PNOEE-30303039914" || r.Identity.GivenName != "OK" {
+		t.Error("expected", "PNOEE-30303039914", "got", r.Identity)
+	}
+	if len(r.Timestamps) != 1 || !r.TrustedTime.Equal(r.Timestamps[0].Time) {
+		t.Error("expected trusted time from timestamp, got", r.TrustedTime)
+	}
+	if r.OCSP == nil || len(r.Chain) != 2 {
+		t.Error("expected OCSP and chain, got", r.OCSP, r.Chain)
+	}
+	if len(r.Warnings) != 0 {
+		t.Error("expected no warnings, got", r.Warnings)
+	}
+
+	var uaErr x509.UnknownAuthorityError
+	r = c.Validate(ctx, NewTrustStore())[0]
+	if r.IsValid() || !errors.As(r.Err(), &uaErr) {
+		t.Error("expected", uaErr, "got", r.Err())
+	}
+
+	r = readTestASiCE(t, signedTestASiCE(t, mock, OCSPStatusRevoked)).Validate(ctx, ts)[0]
+	if !errors.Is(r.Err(), ErrCertRevoked) {
+		t.Error("expected", ErrCertRevoked, "got", r.Err())
+	}
+}
+
+func TestASiCEContainer_Validate_modified(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+	data := signedTestASiCE(t, mock, OCSPStatusGood)
+	keep := func(name string, data []byte) []byte { return data }
+
+	testdata := []struct {
+		name   string
+		modify func(name string, data []byte) []byte
+		extra  map[string][]byte
+		err    error
+	}{
+		{
+			"data file",
+			func(name string, data []byte) []byte {
+				if name == "test.txt" {
+					return []byte("tampered")
+				}
+				return data
+			},
+			nil,
+			ErrDigestMismatch,
+		},
+		{
+			"missing data file",
+			func(name string, data []byte) []byte {
+				switch name {
+				case "dir/data.bin":
+					return nil
+				case asiceManifestFile:
+					re := regexp.MustCompile(`<manifest:file-entry manifest:full-path="dir/data.bin"[^>]*>`)
+					return re.ReplaceAll(data, nil)
+				}
+				return data
+			},
+			nil,
+			ErrDataFileNotFound,
+		},
+		{
+			"extra data file",
+			func(name string, data []byte) []byte {
+				if name == asiceManifestFile {
+					return bytes.Replace(data, []byte("</manifest:manifest>"),
+						[]byte(`<manifest:file-entry manifest:full-path="extra.txt" manifest:media-type="text/plain"/></manifest:manifest>`), 1)
+				}
+				return data
+			},
+			map[string][]byte{"extra.txt": []byte("extra")},
+			ErrDataFileNotSigned,
+		},
+		{
+			"signing time",
+			func(name string, data []byte) []byte {
+				if strings.HasPrefix(name, "META-INF/signatures") {
+					re := regexp.MustCompile(`<xades:SigningTime>[^<]*<`)
+					return re.ReplaceAll(data, []byte("<xades:SigningTime>2000-01-01T00:00:00Z<"))
+				}
+				return data
+			},
+			nil,
+			ErrDigestMismatch,
+		},
+		{
+			"signature value",
+			func(name string, data []byte) []byte {
+				if strings.HasPrefix(name, "META-INF/signatures") {
+					re := regexp.MustCompile(`(<ds:SignatureValue Id="S0-SIG">)(.)`)
+					return re.ReplaceAllFunc(data, func(m []byte) []byte {
+						if m[len(m)-1] == 'A' {
+							m[len(m)-1] = 'B'
+						} else {
+							m[len(m)-1] = 'A'
+						}
+						return m
+					})
+				}
+				return data
+			},
+			nil,
+			ErrSignatureInvalid,
+		},
+		{
+			"RSA-PSS signature value of rsa-sha256 method",
+			func(name string, data []byte) []byte {
+				if !strings.HasPrefix(name, "META-INF/signatures") {
+					return data
+				}
+				sigs, err := parseXAdESSignatures(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				d := sigs[0].digest
+				pss, err := rsa.SignPSS(rand.Reader, mock.key, d.CryptoHash(), d.AuthHash(), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				re := regexp.MustCompile(`(<ds:SignatureValue Id="S0-SIG">)[^<]*`)
+				return re.ReplaceAll(data, []byte("${1}"+base64.StdEncoding.EncodeToString(pss)))
+			},
+			nil,
+			ErrSignatureInvalid,
+		},
+	}
+	for _, test := range testdata {
+		c := readTestASiCE(t, rewriteZip(t, data, test.modify, test.extra))
+		r := c.Validate(ctx, ts)[0]
+		if !errors.Is(r.Err(), test.err) {
+			t.Error(test.name, "expected", test.err, "got", r.Err())
+		}
+	}
+
+	testdata = []struct {
+		name   string
+		modify func(name string, data []byte) []byte
+		extra  map[string][]byte
+		err    error
+	}{
+		{
+			"data file not in manifest",
+			keep,
+			map[string][]byte{"extra.txt": []byte("extra")},
+			ErrASiCEManifestMismatch,
+		},
+		{
+			"manifest file not in container",
+			func(name string, data []byte) []byte {
+				if name == "dir/data.bin" {
+					return nil
+				}
+				return data
+			},
+			nil,
+			ErrASiCEManifestMismatch,
+		},
+		{
+			"no manifest",
+			func(name string, data []byte) []byte {
+				if name == asiceManifestFile {
+					return nil
+				}
+				return data
+			},
+			nil,
+			ErrASiCEManifestMismatch,
+		},
+		{
+			"duplicate manifest entry",
+			func(name string, data []byte) []byte {
+				if name == asiceManifestFile {
+					return bytes.Replace(data, []byte("</manifest:manifest>"),
+						[]byte(`<manifest:file-entry manifest:full-path="test.txt" manifest:media-type="text/html"/></manifest:manifest>`), 1)
+				}
+				return data
+			},
+			nil,
+			ErrASiCEManifestMismatch,
+		},
+	}
+	for _, test := range testdata {
+		modified := rewriteZip(t, data, test.modify, test.extra)
+		_, err := ReadASiCE(bytes.NewReader(modified), int64(len(modified)))
+		if !errors.Is(err, test.err) {
+			t.Error(test.name, "expected", test.err, "got", err)
+		}
+	}
+
+	noMimetype := rewriteZip(t, data, func(name string, data []byte) []byte {
+		if name == asiceMimetypeFile {
+			return nil
+		}
+		return data
+	}, nil)
+	_, err := ReadASiCE(bytes.NewReader(noMimetype), int64(len(noMimetype)))
+	if err != ErrASiCEInvalidMimeType {
+		t.Error("expected", ErrASiCEInvalidMimeType, "got", err)
+	}
+
+	// Mimetype must be stored, not compressed.
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	writeZipFile(zw, asiceMimetypeFile, []byte(MimeTypeASiCE), time.Time{})
+	writeZipFile(zw, "test.txt", []byte("test"), time.Time{})
+	zw.Close()
+	_, err = ReadASiCE(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != ErrASiCEInvalidMimeType {
+		t.Error("expected", ErrASiCEInvalidMimeType, "got", err)
+	}
+}
+
+func TestASiCEContainer_Validate_ocspFreshness(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+	data := signedTestASiCE(t, mock, OCSPStatusGood)
+	now := time.Now()
+
+	testdata := []struct {
+		name       string
+		producedAt []time.Time
+		err        error
+	}{
+		{"before timestamp", []time.Time{now.Add(-time.Hour)}, ErrOCSPStale},
+		{"too late", []time.Time{now.Add(MaxOCSPDelay + time.Hour)}, ErrOCSPStale},
+		{"stale and fresh", []time.Time{now.Add(-time.Hour), now.Add(time.Minute)}, nil},
+	}
+	for _, test := range testdata {
+		var values strings.Builder
+		for _, at := range test.producedAt {
+			der := newTestOCSPResponse(t, mock.signCert, mock.ca, mock.ca, mock.caKey,
+				OCSPStatusGood, at)
+			fmt.Fprintf(&values, "<xades:EncapsulatedOCSPValue>%s</xades:EncapsulatedOCSPValue>",
+				base64.StdEncoding.EncodeToString(der))
+		}
+		modified := rewriteZip(t, data, func(name string, data []byte) []byte {
+			if !strings.HasPrefix(name, "META-INF/signatures") {
+				return data
+			}
+			re := regexp.MustCompile(`<xades:EncapsulatedOCSPValue>[^<]*</xades:EncapsulatedOCSPValue>`)
+			return re.ReplaceAllLiteral(data, []byte(values.String()))
+		}, nil)
+		r := readTestASiCE(t, modified).Validate(ctx, ts)[0]
+		if !errors.Is(r.Err(), test.err) {
+			t.Error(test.name, "expected", test.err, "got", r.Err())
+		}
+	}
+}
+
+func TestReadASiCE_duplicateFile(t *testing.T) {
+	mock := newMockSmartID(t)
+	data := signedTestASiCE(t, mock, OCSPStatusGood)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unsigned entry precedes the signed entry with the same name.
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range zr.File {
+		content, _ := readZipFile(f, MaxASiCEFileSize)
+		if f.Name == asiceMimetypeFile {
+			writeASiCMimetype(zw, string(content))
+			continue
+		}
+		if f.Name == "test.txt" {
+			writeZipFile(zw, f.Name, []byte("EVIL"), f.Modified)
+		}
+		writeZipFile(zw, f.Name, content, f.Modified)
+	}
+	zw.Close()
+
+	_, err = ReadASiCE(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if !errors.Is(err, ErrASiCEDuplicateFile) {
+		t.Error("expected", ErrASiCEDuplicateFile, "got", err)
+	}
+}
+
+func TestASiCEContainer_Validate_signedProperties(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+
+	testdata := []struct {
+		name   string
+		modify func(s *XAdESSignature)
+		err    error
+	}{
+		{"signed", func(s *XAdESSignature) {}, nil},
+		{
+			"unsigned properties",
+			func(s *XAdESSignature) {
+				ssp := s.signature.Find(nsXAdES, "SignedSignatureProperties")
+				usp := s.unsignedSignatureProperties()
+				for _, el := range ssp.Elements() {
+					ssp.Remove(el)
+					el.Parent = usp
+					usp.Children = append(usp.Children, el)
+				}
+			},
+			ErrSigningCertMismatch,
+		},
+		{
+			"no signed properties reference",
+			func(s *XAdESSignature) {
+				ref, _ := s.signedPropertiesReference()
+				s.signature.Child(nsXMLDSig, "SignedInfo").Remove(ref)
+			},
+			ErrSignedPropertiesNotSigned,
+		},
+		{
+			"signed properties reference to other element",
+			func(s *XAdESSignature) {
+				ref, _ := s.signedPropertiesReference()
+				for i, a := range ref.Attrs {
+					if a.Local == "URI" {
+						ref.Attrs[i].Value = "#" + s.id + "-QualifyingProperties"
+					}
+				}
+			},
+			ErrSignedPropertiesNotSigned,
+		},
+	}
+	for _, test := range testdata {
+		data := signedTestASiCEWith(t, mock, OCSPStatusGood, test.modify)
+		r := readTestASiCE(t, data).Validate(ctx, ts)[0]
+		if !errors.Is(r.Err(), test.err) {
+			t.Error(test.name, "expected", test.err, "got", r.Err())
+		}
+		if test.err != nil && !r.SigningTime.IsZero() {
+			t.Error(test.name, "expected no signing time, got", r.SigningTime)
+		}
+	}
+}
+
+func TestReadZipFile_tooLarge(t *testing.T) {
+	data := make([]byte, 1<<20)
+	var compressed bytes.Buffer
+	fw, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	fw.Write(data)
+	fw.Close()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	writeZipFile(zw, "declared.bin", data, time.Time{})
+	// Header understates the size of the compressed data.
+	w, _ := zw.CreateRaw(&zip.FileHeader{
+		Name:               "bomb.bin",
+		Method:             zip.Deflate,
+		CRC32:              crc32.ChecksumIEEE(data),
+		CompressedSize64:   uint64(compressed.Len()),
+		UncompressedSize64: 10,
+	})
+	w.Write(compressed.Bytes())
+	zw.Close()
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readZipFile(zr.File[0], 1024); err != ErrASiCETooLarge {
+		t.Error("expected", ErrASiCETooLarge, "got", err)
+	}
+	if _, err := readZipFile(zr.File[1], 1024); err == nil {
+		t.Error("expected error of understated size")
+	}
+	if data, err := readZipFile(zr.File[0], 1<<20); err != nil || len(data) != 1<<20 {
+		t.Error("expected", 1<<20, "bytes got", len(data), err)
+	}
+}
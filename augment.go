@@ -0,0 +1,604 @@
+package smartid
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// Levels of long-term signatures.
+const (
+	// SignatureLevelLT adds certificate and revocation values to the
+	// signature with signature timestamp.
+	SignatureLevelLT = "LT"
+
+	// SignatureLevelLTA adds validation data and archive timestamp. It is
+	// repeated to re-timestamp the archive before the TSA certificate of
+	// the previous archive timestamp expires. CAdES signatures get
+	// archive-time-stamp-v3 of ETSI EN 319 122-1.
+	SignatureLevelLTA = "LTA"
+)
+
+var (
+	// ErrSignatureLevelUnsupported error when augmentation level is
+	// unknown.
+	ErrSignatureLevelUnsupported = errors.New("Unsupported signature level")
+)
+
+// Augmenter augments existing signatures to long-term levels. Certificate
+// chains of the signer and TSAs are verified with the trust store, their
+// OCSP responses (or CRLs, if OCSP is not available) are embedded.
+type Augmenter struct {
+	// TrustStore verifies certificate chains.
+	TrustStore *TrustStore
+
+	// Revocation obtains OCSP responses and CRLs.
+	Revocation RevocationSource
+
+	// Timestamper issues signature timestamps, if missing, and archive
+	// timestamps.
+	Timestamper Timestamper
+
+	// Algorithm is the hash algorithm of archive timestamps, if empty
+	// SHA512 is used.
+	Algorithm string
+}
+
+// NewAugmenter creates augmenter, which fetches revocation data over HTTP
+// and timestamps with the timestamper.
+func NewAugmenter(ts *TrustStore, timestamper Timestamper) *Augmenter {
+	return &Augmenter{
+		TrustStore:  ts,
+		Revocation:  &HTTPRevocationSource{},
+		Timestamper: timestamper,
+	}
+}
+
+// AugmentCAdES augments CMS signature to the level. For detached
+// signature the content must be given, as archive timestamp covers it.
+// Signature timestamp is added if missing. New values are added as
+// unsigned attributes after the existing ones.
+func (a *Augmenter) AugmentCAdES(ctx context.Context, p7s, content []byte, level string) ([]byte, error) {
+	if level != SignatureLevelLT && level != SignatureLevelLTA {
+		return nil, ErrSignatureLevelUnsupported
+	}
+	sd, err := parseSignedData(p7s)
+	if err != nil {
+		return nil, err
+	}
+	cert, si, err := sd.verify(content)
+	if err == ErrCMSSignerCertNotFound {
+		return nil, ErrSignerCertNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if content == nil {
+		if content, err = sd.content(); err != nil {
+			return nil, err
+		}
+	}
+	algo, err := digestAlgorithmName(si.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := splitAttributes(si.UnsignedAttrs.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := si.signatureTimestamps(ctx, a.TrustStore)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		token, attr, err := a.cmsTimestamp(ctx, oidAttrTimeStampToken, si.Signature, algo)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+		attrs = append(attrs, attr)
+	}
+	archived, err := si.archiveTimestamps(ctx, a.TrustStore, sd, content)
+	if err != nil {
+		return nil, err
+	}
+
+	embedded, err := sd.certificates()
+	if err != nil {
+		return nil, err
+	}
+	v := newValidationData(append(embedded, si.certificateValues()...),
+		si.revocationValues(), si.crlValues())
+	if err := a.collect(ctx, v, cert, nil, earliestTimestamp(tokens)); err != nil {
+		return nil, err
+	}
+	for _, token := range append(tokens, archived...) {
+		if err := a.collectTimestamp(ctx, v, token); err != nil {
+			return nil, err
+		}
+	}
+	if len(v.certs) > 0 {
+		values := make([]asn1.RawValue, len(v.certs))
+		for i, c := range v.certs {
+			values[i] = asn1.RawValue{FullBytes: c.Raw}
+		}
+		attr, err := marshalAttribute(oidAttrCertValues, values)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attr)
+	}
+	if len(v.ocsp) > 0 || len(v.crls) > 0 {
+		var rv revocationValues
+		for _, der := range v.crls {
+			rv.CRLVals = append(rv.CRLVals, asn1.RawValue{FullBytes: der})
+		}
+		for _, der := range v.ocsp {
+			basic, err := ocspBasicResponse(der)
+			if err != nil {
+				return nil, err
+			}
+			rv.OCSPVals = append(rv.OCSPVals, asn1.RawValue{FullBytes: basic})
+		}
+		attr, err := marshalAttribute(oidAttrRevocationValues, rv)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attr)
+	}
+
+	if level == SignatureLevelLTA {
+		attr, err := a.archiveTimestampV3(ctx, sd, *si, content, attrs)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attr)
+	}
+	return replaceUnsignedAttrs(sd, si, attrs)
+}
+
+// archiveTimestampV3 timestamps the signature with archive-time-stamp-v3
+// and encodes it as unsigned attribute. Hash index of the data present is
+// added to the unsigned attributes of the timestamp token.
+func (a *Augmenter) archiveTimestampV3(
+	ctx context.Context,
+	sd *signedData,
+	si signerInfo,
+	content []byte,
+	attrs [][]byte,
+) ([]byte, error) {
+	algo := a.algorithm()
+	index, err := newATSHashIndexV3(sd, attrs, algo)
+	if err != nil {
+		return nil, err
+	}
+	data, err := atsV3Data(sd, si, content, algo, index)
+	if err != nil {
+		return nil, err
+	}
+	token, err := a.timestamp(ctx, data, algo)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := token.sd.signerInfos()
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, ErrCMSNoSigner
+	}
+	tokenAttrs, err := splitAttributes(infos[0].UnsignedAttrs.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	indexAttr, err := marshalAttribute(oidAttrATSHashIndexV3, asn1.RawValue{FullBytes: index})
+	if err != nil {
+		return nil, err
+	}
+	der, err := replaceUnsignedAttrs(token.sd, &infos[0], append(tokenAttrs, indexAttr))
+	if err != nil {
+		return nil, err
+	}
+	return marshalAttribute(oidAttrArchiveTimestampV3, asn1.RawValue{FullBytes: der})
+}
+
+// cmsTimestamp timestamps the data and encodes the token as unsigned
+// attribute.
+func (a *Augmenter) cmsTimestamp(
+	ctx context.Context,
+	oid asn1.ObjectIdentifier,
+	data []byte,
+	algo string,
+) (*TimestampToken, []byte, error) {
+	token, err := a.timestamp(ctx, data, algo)
+	if err != nil {
+		return nil, nil, err
+	}
+	attr, err := marshalAttribute(oid, asn1.RawValue{FullBytes: token.Raw})
+	if err != nil {
+		return nil, nil, err
+	}
+	return token, attr, nil
+}
+
+// timestamp obtains timestamp token over the data.
+func (a *Augmenter) timestamp(ctx context.Context, data []byte, algo string) (*TimestampToken, error) {
+	if a.Timestamper == nil {
+		return nil, ErrTimestampMissing
+	}
+	digest, err := HashData(data, algo)
+	if err != nil {
+		return nil, err
+	}
+	der, err := a.Timestamper.Timestamp(ctx, digest)
+	if err != nil {
+		return nil, err
+	}
+	token, err := ParseTimestampToken(der)
+	if err != nil {
+		return nil, err
+	}
+	if err := token.CheckDigest(digest); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// algorithm returns hash algorithm of archive timestamps.
+func (a *Augmenter) algorithm() string {
+	if a.Algorithm == "" {
+		return SHA512
+	}
+	return a.Algorithm
+}
+
+// replaceUnsignedAttrs encodes SignedData with the unsigned attributes of
+// the first signer replaced. Attributes are kept in the given order.
+func replaceUnsignedAttrs(sd *signedData, si *signerInfo, attrs [][]byte) ([]byte, error) {
+	si.UnsignedAttrs = asn1.RawValue{
+		Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true,
+		Bytes: bytes.Join(attrs, nil),
+	}
+	siBytes, err := asn1.Marshal(*si)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := sd.signerInfos()
+	if err != nil {
+		return nil, err
+	}
+	for _, other := range infos[1:] {
+		der, err := asn1.Marshal(other)
+		if err != nil {
+			return nil, err
+		}
+		siBytes = append(siBytes, der...)
+	}
+	sd.SignerInfos = asn1.RawValue{
+		Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: siBytes,
+	}
+	der, err := asn1.Marshal(*sd)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidContentTypeSignedData,
+		Content: asn1.RawValue{
+			Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der,
+		},
+	})
+}
+
+// AugmentXAdES augments XAdES signatures of the signatures document or
+// the enveloped signatures of XML document to the level. Files are the
+// signed data files of the container, nil for enveloped signatures.
+// Signature timestamp is added if missing.
+func (a *Augmenter) AugmentXAdES(ctx context.Context, doc []byte, files []DataFile, level string) ([]byte, error) {
+	if level != SignatureLevelLT && level != SignatureLevelLTA {
+		return nil, ErrSignatureLevelUnsupported
+	}
+	sigs, err := parseXAdESSignatures(doc)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string][]byte, len(files))
+	for _, f := range files {
+		data[f.Name] = f.Data
+	}
+	for _, s := range sigs {
+		s.enveloped = !s.root.Is(nsASiC, "XAdESSignatures")
+		if err := a.augmentXAdES(ctx, s, data, level); err != nil {
+			return nil, fmt.Errorf("%s: %w", s.id, err)
+		}
+	}
+	return sigs[0].Bytes()
+}
+
+// augmentXAdES augments one signature of the document.
+func (a *Augmenter) augmentXAdES(ctx context.Context, s *XAdESSignature, files map[string][]byte, level string) error {
+	if s.signature.Find(nsXAdES, "QualifyingProperties") == nil {
+		return ErrXAdESMalformed
+	}
+	r, _ := s.validate(ctx, a.TrustStore, files)
+	if len(r.Timestamps) == 0 && r.IsValid() {
+		if a.Timestamper == nil {
+			return ErrTimestampMissing
+		}
+		if err := s.AddTimestamp(ctx, a.Timestamper); err != nil {
+			return err
+		}
+		r, _ = s.validate(ctx, a.TrustStore, files)
+	}
+	if !r.IsValid() {
+		return r.Err()
+	}
+
+	v := newValidationData(nil, nil, nil)
+	for _, local := range []string{"X509Certificate", "EncapsulatedX509Certificate"} {
+		for _, el := range s.signature.FindAll(nsXMLDSig, local) {
+			v.addExisting(el.Text(), nil)
+		}
+		for _, el := range s.signature.FindAll(nsXAdES, local) {
+			v.addExisting(el.Text(), nil)
+		}
+	}
+	for _, el := range s.signature.FindAll(nsXAdES, "EncapsulatedOCSPValue") {
+		v.addExisting(el.Text(), &v.knownOCSP)
+	}
+	for _, el := range s.signature.FindAll(nsXAdES, "EncapsulatedCRLValue") {
+		v.addExisting(el.Text(), &v.knownCRLs)
+	}
+	if err := a.collect(ctx, v, s.cert, nil, earliestTimestamp(r.Timestamps)); err != nil {
+		return err
+	}
+	for _, token := range append(r.Timestamps, r.ArchiveTimestamps...) {
+		if err := a.collectTimestamp(ctx, v, token); err != nil {
+			return err
+		}
+	}
+	s.addValidationData(v)
+
+	if level == SignatureLevelLTA {
+		data, err := s.archiveTimestampData(files, nil, algExcC14N, nil)
+		if err != nil {
+			return err
+		}
+		token, err := a.timestamp(ctx, data, a.algorithm())
+		if err != nil {
+			return err
+		}
+		usp := s.unsignedSignatureProperties()
+		n := len(s.signature.FindAll(nsXAdES141, "ArchiveTimeStamp"))
+		ats := usp.AddChild("xadesv141", "ArchiveTimeStamp",
+			xmlAttr{Local: "Id", Value: fmt.Sprintf("%s-A%d", s.id, n)})
+		ats.NS = []xmlAttr{{Local: "xadesv141", Value: nsXAdES141}}
+		ats.AddChild("ds", "CanonicalizationMethod",
+			xmlAttr{Local: "Algorithm", Value: algExcC14N})
+		ats.AddChild("xades", "EncapsulatedTimeStamp").
+			SetText(base64.StdEncoding.EncodeToString(token.Raw))
+	}
+	return nil
+}
+
+// addValidationData adds certificate and revocation values to the
+// unsigned signature properties. After archive timestamp they are added
+// as timestamp validation data.
+func (s *XAdESSignature) addValidationData(v *validationData) {
+	if len(v.certs) == 0 && len(v.ocsp) == 0 && len(v.crls) == 0 {
+		return
+	}
+	parent := s.unsignedSignatureProperties()
+	if parent.Child(nsXAdES141, "ArchiveTimeStamp") != nil {
+		parent = parent.AddChild("xadesv141", "TimeStampValidationData")
+		parent.NS = []xmlAttr{{Local: "xadesv141", Value: nsXAdES141}}
+	}
+	if len(v.certs) > 0 {
+		values := parent.AddChild("xades", "CertificateValues")
+		for _, c := range v.certs {
+			values.AddChild("xades", "EncapsulatedX509Certificate").
+				SetText(base64.StdEncoding.EncodeToString(c.Raw))
+		}
+	}
+	if len(v.ocsp) == 0 && len(v.crls) == 0 {
+		return
+	}
+	values := parent.AddChild("xades", "RevocationValues")
+	if len(v.crls) > 0 {
+		crls := values.AddChild("xades", "CRLValues")
+		for _, der := range v.crls {
+			crls.AddChild("xades", "EncapsulatedCRLValue").
+				SetText(base64.StdEncoding.EncodeToString(der))
+		}
+	}
+	if len(v.ocsp) > 0 {
+		ocsp := values.AddChild("xades", "OCSPValues")
+		for _, der := range v.ocsp {
+			ocsp.AddChild("xades", "EncapsulatedOCSPValue").
+				SetText(base64.StdEncoding.EncodeToString(der))
+		}
+	}
+}
+
+// AugmentASiCE augments all signatures of ASiC-E container to the level.
+// Other files of the container are copied as they are.
+func (a *Augmenter) AugmentASiCE(ctx context.Context, container []byte, level string) ([]byte, error) {
+	c, err := ReadASiCE(bytes.NewReader(container), int64(len(container)))
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(container), int64(len(container)))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if err := writeASiCMimetype(zw, MimeTypeASiCE); err != nil {
+		return nil, err
+	}
+	for _, f := range zr.File[1:] {
+		data, err := readZipFile(f, MaxASiCEFileSize)
+		if err != nil {
+			return nil, err
+		}
+		base := path.Base(f.Name)
+		if strings.HasPrefix(f.Name, asiceMetaInf) &&
+			strings.Contains(base, "signatures") && path.Ext(base) == ".xml" {
+			if data, err = a.AugmentXAdES(ctx, data, c.files, level); err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Name, err)
+			}
+		}
+		if err := writeZipFile(zw, f.Name, data, f.Modified); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// validationData collects certificate and revocation values, which are
+// not yet in the signature.
+type validationData struct {
+	certs []*x509.Certificate
+	ocsp  [][]byte
+	crls  [][]byte
+
+	known     []*x509.Certificate
+	knownOCSP [][]byte
+	knownCRLs [][]byte
+}
+
+// newValidationData creates collector with the values already present in
+// the signature.
+func newValidationData(certs []*x509.Certificate, ocsp, crls [][]byte) *validationData {
+	return &validationData{known: certs, knownOCSP: ocsp, knownCRLs: crls}
+}
+
+// addExisting adds base64 encoded value present in the signature. If
+// values is nil, it is a certificate.
+func (v *validationData) addExisting(text string, values *[][]byte) {
+	der, err := decodeBase64Text(text)
+	if err != nil {
+		return
+	}
+	if values != nil {
+		*values = append(*values, der)
+	} else if c, err := x509.ParseCertificate(der); err == nil {
+		v.known = append(v.known, c)
+	}
+}
+
+// addCert adds certificate, if it is not known yet.
+func (v *validationData) addCert(cert *x509.Certificate) {
+	for _, c := range v.known {
+		if c.Equal(cert) {
+			return
+		}
+	}
+	v.known = append(v.known, cert)
+	v.certs = append(v.certs, cert)
+}
+
+// hasRevocation checks that revocation data of the certificate is known.
+func (v *validationData) hasRevocation(cert, issuer *x509.Certificate) bool {
+	for _, der := range v.knownOCSP {
+		if _, err := ParseOCSPResponse(der, cert); err == nil {
+			return true
+		}
+	}
+	for _, der := range v.knownCRLs {
+		crl, err := x509.ParseRevocationList(der)
+		if err == nil && bytes.Equal(crl.RawIssuer, cert.RawIssuer) &&
+			crl.CheckSignatureFrom(issuer) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// collect adds the verified chain of the certificate and revocation data
+// of each certificate in the chain except the trust anchor. Chain is
+// verified at the time, zero means now.
+func (a *Augmenter) collect(
+	ctx context.Context,
+	v *validationData,
+	cert *x509.Certificate,
+	intermediates []*x509.Certificate,
+	at time.Time,
+) error {
+	chains, err := a.TrustStore.VerifyWithIntermediates(ctx, cert,
+		append(intermediates, v.known...), at)
+	if err != nil {
+		return err
+	}
+	chain := chains[0]
+	for i, c := range chain {
+		v.addCert(c)
+		if i == len(chain)-1 || v.hasRevocation(c, chain[i+1]) {
+			continue
+		}
+		if err := a.collectRevocation(ctx, v, c, chain[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectRevocation obtains OCSP response of the certificate, or CRL if
+// OCSP is not available.
+func (a *Augmenter) collectRevocation(ctx context.Context, v *validationData, cert, issuer *x509.Certificate) error {
+	if der, err := a.Revocation.OCSP(ctx, cert, issuer); err == nil {
+		resp, err := ParseOCSPResponse(der, cert)
+		if err == nil {
+			if _, err = resp.Verify(ctx, a.TrustStore, issuer); err == nil {
+				v.ocsp = append(v.ocsp, der)
+				v.knownOCSP = append(v.knownOCSP, der)
+				for _, c := range resp.Certificates {
+					v.addCert(c)
+				}
+				return nil
+			}
+		}
+	}
+	if der, err := a.Revocation.CRL(ctx, cert); err == nil {
+		crl, err := x509.ParseRevocationList(der)
+		if err == nil && crl.CheckSignatureFrom(issuer) == nil {
+			v.crls = append(v.crls, der)
+			v.knownCRLs = append(v.knownCRLs, der)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrRevocationUnavailable, cert.Subject.CommonName)
+}
+
+// collectTimestamp adds validation data of the TSA certificate.
+func (a *Augmenter) collectTimestamp(ctx context.Context, v *validationData, token *TimestampToken) error {
+	cert, err := token.Verify(ctx, a.TrustStore)
+	if err != nil {
+		return err
+	}
+	return a.collect(ctx, v, cert, token.Certificates, token.Time)
+}
+
+// earliestTimestamp returns generation time of the earliest token, zero
+// if there are no tokens.
+func earliestTimestamp(tokens []*TimestampToken) time.Time {
+	var t time.Time
+	for _, token := range tokens {
+		if t.IsZero() || token.Time.Before(t) {
+			t = token.Time
+		}
+	}
+	return t
+}
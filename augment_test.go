@@ -0,0 +1,239 @@
+package smartid
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testRevocationSource returns OCSP responses signed by the test CA.
+type testRevocationSource struct {
+	t     *testing.T
+	mock  *mockSmartID
+	calls int
+}
+
+func (s *testRevocationSource) OCSP(ctx context.Context, cert, issuer *x509.Certificate) ([]byte, error) {
+	s.calls++
+	return newTestOCSPResponse(s.t, cert, s.mock.ca, s.mock.ca, s.mock.caKey,
+		OCSPStatusGood, time.Now()), nil
+}
+
+func (s *testRevocationSource) CRL(ctx context.Context, cert *x509.Certificate) ([]byte, error) {
+	return nil, ErrRevocationUnavailable
+}
+
+func newTestAugmenter(t *testing.T, mock *mockSmartID) (*Augmenter, *TrustStore) {
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+	a := NewAugmenter(ts, newTestTSA(t, mock.ca, mock.caKey))
+	a.Revocation = &testRevocationSource{t: t, mock: mock}
+	return a, ts
+}
+
+func TestAugmenter_AugmentCAdES(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	a, ts := newTestAugmenter(t, mock)
+	content := []byte("Hello, Smart-ID!")
+
+	digest, _ := HashData(content, SHA256)
+	sig, _ := PrepareCAdESSignature(digest, mock.signCert, nil)
+	_, err := sig.Sign(ctx, mock.client(), &AuthRequest{
+		Identifier: mockDocumentNumber,
+		AuthType:   AuthTypeDocument,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7s, _ := sig.Bytes()
+
+	lt, err := a.AugmentCAdES(ctx, p7s, content, SignatureLevelLT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := VerifyCAdES(ctx, lt, content, ts)
+	if !r.IsValid() || len(r.Timestamps) != 1 || r.OCSP == nil {
+		t.Fatal("expected LT signature, got", r.Err(), r.Timestamps, r.OCSP)
+	}
+
+	lta, err := a.AugmentCAdES(ctx, lt, content, SignatureLevelLTA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := VerifyCAdES(ctx, lta, content, ts); !r.IsValid() || len(r.ArchiveTimestamps) != 1 {
+		t.Fatal("expected LTA signature, got", r.Err(), r.ArchiveTimestamps)
+	}
+	calls := a.Revocation.(*testRevocationSource).calls
+	lta, err = a.AugmentCAdES(ctx, lta, content, SignatureLevelLTA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := VerifyCAdES(ctx, lta, content, ts); !r.IsValid() || len(r.ArchiveTimestamps) != 2 {
+		t.Error("expected re-timestamped signature, got", r.Err(), r.ArchiveTimestamps)
+	}
+	if n := a.Revocation.(*testRevocationSource).calls; n != calls {
+		t.Error("expected", calls, "OCSP requests, got", n)
+	}
+
+	if r := VerifyCAdES(ctx, lta, []byte("Hello, Mallory!"), ts); r.IsValid() {
+		t.Error("expected invalid signature of modified content")
+	}
+	if _, err := a.AugmentCAdES(ctx, p7s, content, "B"); err != ErrSignatureLevelUnsupported {
+		t.Error("expected", ErrSignatureLevelUnsupported, "got", err)
+	}
+	a.Revocation = &HTTPRevocationSource{}
+	if _, err := a.AugmentCAdES(ctx, p7s, content, SignatureLevelLT); !errors.Is(err, ErrRevocationUnavailable) {
+		t.Error("expected", ErrRevocationUnavailable, "got", err)
+	}
+}
+
+func TestAugmenter_AugmentCAdES_archiveTimestampV3(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	a, ts := newTestAugmenter(t, mock)
+	content := []byte("Hello, Smart-ID!")
+
+	digest, _ := HashData(content, SHA256)
+	sig, _ := PrepareCAdESSignature(digest, mock.signCert, nil)
+	_, err := sig.Sign(ctx, mock.client(), &AuthRequest{
+		Identifier: mockDocumentNumber,
+		AuthType:   AuthTypeDocument,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7s, _ := sig.Bytes()
+	lta, err := a.AugmentCAdES(ctx, p7s, content, SignatureLevelLTA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd, _ := parseSignedData(lta)
+	infos, _ := sd.signerInfos()
+	si := infos[0]
+	if n := len(si.unsignedAttributes(oidAttrArchiveTimestampV2)); n != 0 {
+		t.Error("expected no archive-time-stamp-v2, got", n)
+	}
+	values := si.unsignedAttributes(oidAttrArchiveTimestampV3)
+	if len(values) != 1 {
+		t.Fatal("expected archive-time-stamp-v3, got", len(values))
+	}
+	token, err := ParseTimestampToken(values[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenInfos, _ := token.sd.signerInfos()
+	if _, err := tokenInfos[0].unsignedAttribute(oidAttrATSHashIndexV3, nil); err != nil {
+		t.Error("expected ats-hash-index-v3, got", err)
+	}
+
+	// Validation data covered by the hash index is removed.
+	attrs, _ := splitAttributes(si.UnsignedAttrs.Bytes)
+	var kept [][]byte
+	for _, raw := range attrs {
+		var attr attribute
+		asn1.Unmarshal(raw, &attr)
+		if !attr.Type.Equal(oidAttrRevocationValues) {
+			kept = append(kept, raw)
+		}
+	}
+	modified, err := replaceUnsignedAttrs(sd, &si, kept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := VerifyCAdES(ctx, modified, content, ts); !errors.Is(r.Err(), ErrTimestampImprintMismatch) {
+		t.Error("expected", ErrTimestampImprintMismatch, "got", r.Err())
+	}
+
+	// Legacy archive-time-stamp-v2 is verified.
+	lt, _ := a.AugmentCAdES(ctx, p7s, content, SignatureLevelLT)
+	sd, _ = parseSignedData(lt)
+	infos, _ = sd.signerInfos()
+	attrs, _ = splitAttributes(infos[0].UnsignedAttrs.Bytes)
+	data, _ := archiveTimestampData(sd, infos[0], content, attrs)
+	_, attr, err := a.cmsTimestamp(ctx, oidAttrArchiveTimestampV2, data, SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, _ := replaceUnsignedAttrs(sd, &infos[0], append(attrs, attr))
+	if r := VerifyCAdES(ctx, legacy, content, ts); !r.IsValid() || len(r.ArchiveTimestamps) != 1 {
+		t.Error("expected valid legacy archive timestamp, got", r.Err(), r.ArchiveTimestamps)
+	}
+}
+
+func TestAugmenter_AugmentASiCE(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	a, ts := newTestAugmenter(t, mock)
+	data := signedTestASiCE(t, mock, OCSPStatusGood)
+
+	lta, err := a.AugmentASiCE(ctx, data, SignatureLevelLTA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lta, err = a.AugmentASiCE(ctx, lta, SignatureLevelLTA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := readTestASiCE(t, lta).Validate(ctx, ts)
+	if len(results) != 1 || !results[0].IsValid() {
+		t.Fatal("expected valid signature, got", results[0].Err())
+	}
+	if len(results[0].ArchiveTimestamps) != 2 {
+		t.Error("expected", 2, "got", len(results[0].ArchiveTimestamps))
+	}
+
+	modified := rewriteZip(t, lta, func(name string, data []byte) []byte {
+		if name == "META-INF/signatures0.xml" {
+			return bytes.Replace(data, []byte("<xades:CertificateValues>"),
+				[]byte("<xades:CertificateValues><xades:EncapsulatedX509Certificate/>"), 1)
+		}
+		return data
+	}, nil)
+	if results := readTestASiCE(t, modified).Validate(ctx, ts); results[0].IsValid() {
+		t.Error("expected invalid archive timestamp")
+	}
+}
+
+func TestAugmenter_AugmentXAdES(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	a, ts := newTestAugmenter(t, mock)
+
+	s, _ := PrepareXMLSignature([]byte(testInvoice), mock.signCert, SHA256)
+	_, err := s.Sign(ctx, mock.client(), &AuthRequest{
+		Identifier: mockDocumentNumber,
+		AuthType:   AuthTypeDocument,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, _ := s.Bytes()
+
+	lt, err := a.AugmentXAdES(ctx, doc, nil, SignatureLevelLT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := VerifyXMLSignature(ctx, lt, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := results[0]
+	if !r.IsValid() || len(r.Timestamps) != 1 || r.OCSP == nil {
+		t.Fatal("expected LT signature, got", r.Err(), r.Timestamps, r.OCSP)
+	}
+
+	lta, err := a.AugmentXAdES(ctx, lt, nil, SignatureLevelLTA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, _ = VerifyXMLSignature(ctx, lta, ts)
+	if !results[0].IsValid() || len(results[0].ArchiveTimestamps) != 1 {
+		t.Error("expected LTA signature, got", results[0].Err(), results[0].ArchiveTimestamps)
+	}
+}
@@ -26,10 +26,14 @@ type AuthRequest struct {
 
 	// Base64 encoded hash function output to be signed (base64 encoding
 	// according to rfc4648).
-	Hash AuthHash `json:"hash"`
+	Hash AuthHash `json:"hash,omitempty"`
 
 	// Hash algorithm. At the moment used only SHA512
-	HashType string `json:"hashType"`
+	HashType string `json:"hashType,omitempty"`
+
+	// Digest of the data to be signed. If set, Hash and HashType are
+	// taken from the digest.
+	Digest *Digest `json:"-"`
 
 	// Nonce set behavior when requester wants, it can override the
 	// idempotent behavior inside of this timeframe using an optional
@@ -58,6 +62,11 @@ type AuthRequest struct {
 	// is identifier used for person's identication.
 	Identifier string
 
+	// OnPoll is called with each response of the session status while
+	// polling, including the completed one. It reports progress of the
+	// session and must not block.
+	OnPoll func(resp *SessionResponse) `json:"-"`
+
 	// endpoint is the API endendpoint
 	endpoint string
 }
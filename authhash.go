@@ -31,7 +31,9 @@ const nbytes int = 64
 // and the calculation of the verification code.
 type AuthHash []byte
 
-// GenerateAuthHash generates a new random hashe.
+// GenerateAuthHash generates a new random hashe. For unknown algorithm
+// empty hash is returned, use GenerateDigest to get an error instead. To
+// sign the real data use HashData or HashReader.
 func GenerateAuthHash(algo string) AuthHash {
 	bs := randByteGenerator(nbytes)
 	var sum []byte
@@ -0,0 +1,314 @@
+package smartid
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+)
+
+// Prefixes of the Merkle tree hashes, which separate leaves from inner
+// nodes (RFC 6962).
+const (
+	batchLeafPrefix = 0x00
+	batchNodePrefix = 0x01
+)
+
+var (
+	// ErrBatchEmpty error when batch has no documents.
+	ErrBatchEmpty = errors.New("Batch has no documents")
+
+	// ErrBatchNotSigned error when signature value of the batch is not yet
+	// set.
+	ErrBatchNotSigned = errors.New("Batch signature value is not set")
+
+	// ErrBatchProofInvalid error when proof does not link the document to
+	// the batch.
+	ErrBatchProofInvalid = errors.New("Invalid batch proof")
+)
+
+// Batch signs many documents with one Smart-ID signature. Document
+// digests are leaves of Merkle tree and the root of the tree is signed.
+// Each document gets a proof which links it to the signature.
+type Batch struct {
+	algo      string
+	cert      *x509.Certificate
+	levels    [][][]byte
+	root      *Digest
+	signature []byte
+	timestamp []byte
+}
+
+// BatchProof links one document of the batch to the signature. It is
+// stored as evidence together with the document.
+type BatchProof struct {
+	// Algorithm is the hash algorithm of documents and the tree.
+	Algorithm string `json:"algorithm"`
+
+	// Index is the index of the document in the batch.
+	Index int `json:"index"`
+
+	// Count is the number of documents in the batch.
+	Count int `json:"count"`
+
+	// Path are base64 encoded sibling hashes from the leaf to the root.
+	Path []string `json:"path"`
+
+	// Signature is base64 encoded signature value of the root.
+	Signature string `json:"signature"`
+
+	// Certificate is base64 encoded signer certificate.
+	Certificate string `json:"certificate"`
+
+	// Timestamp is base64 encoded timestamp token over the signature
+	// value, optional.
+	Timestamp string `json:"timestamp,omitempty"`
+}
+
+// NewBatch builds Merkle tree over the document digests. All digests must
+// have the same algorithm.
+func NewBatch(digests []*Digest, cert *x509.Certificate) (*Batch, error) {
+	if len(digests) == 0 {
+		return nil, ErrBatchEmpty
+	}
+	b := &Batch{algo: digests[0].Algorithm(), cert: cert}
+	level := make([][]byte, len(digests))
+	for i, d := range digests {
+		if d.Algorithm() != b.algo {
+			return nil, ErrHashTypeMismatch
+		}
+		leaf, err := batchHash(b.algo, batchLeafPrefix, d.AuthHash())
+		if err != nil {
+			return nil, err
+		}
+		level[i] = leaf
+	}
+	b.levels = append(b.levels, level)
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				// Odd node is carried to the next level.
+				next = append(next, level[i])
+				continue
+			}
+			node, err := batchHash(b.algo, batchNodePrefix, level[i], level[i+1])
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, node)
+		}
+		b.levels = append(b.levels, next)
+		level = next
+	}
+	var err error
+	if b.root, err = NewDigest(b.algo, level[0]); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Len returns the number of documents in the batch.
+func (b *Batch) Len() int {
+	return len(b.levels[0])
+}
+
+// Digest returns the root of the tree, which is signed by Smart-ID.
+func (b *Batch) Digest() *Digest {
+	return b.root
+}
+
+// Certificate returns the signer certificate.
+func (b *Batch) Certificate() *x509.Certificate {
+	return b.cert
+}
+
+// SetSignatureValue sets the signature value returned by Smart-ID. Value
+// is verified with the signer certificate.
+func (b *Batch) SetSignatureValue(sig Signature) error {
+	value, err := base64.StdEncoding.DecodeString(sig.Value)
+	if err != nil {
+		return err
+	}
+	err = verifySignatureValue(b.cert, b.root.CryptoHash(), b.root.AuthHash(), value)
+	if err != nil {
+		return err
+	}
+	b.signature = value
+	return nil
+}
+
+// Sign signs the root with Smart-ID using SignSync. Request should have
+// the identifier of the person, preferably document number from the
+// certificate choice. Digest of the request is set automatically.
+func (b *Batch) Sign(ctx context.Context, c *Client, req *AuthRequest) (*SessionResponse, error) {
+	resp, err := signDigest(ctx, c, req, b.root, b.cert)
+	if err != nil {
+		return resp, err
+	}
+	return resp, b.SetSignatureValue(resp.Signature)
+}
+
+// AddTimestamp adds timestamp over the signature value, which is included
+// in the proofs.
+func (b *Batch) AddTimestamp(ctx context.Context, ts Timestamper) error {
+	if b.signature == nil {
+		return ErrBatchNotSigned
+	}
+	digest, err := HashData(b.signature, b.algo)
+	if err != nil {
+		return err
+	}
+	b.timestamp, err = ts.Timestamp(ctx, digest)
+	return err
+}
+
+// Proof returns the proof of the document at index i.
+func (b *Batch) Proof(i int) (*BatchProof, error) {
+	if b.signature == nil {
+		return nil, ErrBatchNotSigned
+	}
+	if i < 0 || i >= b.Len() {
+		return nil, ErrBatchProofInvalid
+	}
+	p := &BatchProof{
+		Algorithm:   b.algo,
+		Index:       i,
+		Count:       b.Len(),
+		Path:        []string{},
+		Signature:   base64.StdEncoding.EncodeToString(b.signature),
+		Certificate: base64.StdEncoding.EncodeToString(b.cert.Raw),
+	}
+	if b.timestamp != nil {
+		p.Timestamp = base64.StdEncoding.EncodeToString(b.timestamp)
+	}
+	for _, level := range b.levels[:len(b.levels)-1] {
+		sibling := i ^ 1
+		if sibling < len(level) {
+			p.Path = append(p.Path, base64.StdEncoding.EncodeToString(level[sibling]))
+		}
+		i /= 2
+	}
+	return p, nil
+}
+
+// root computes the root of the tree from the document digest and the
+// path.
+func (p *BatchProof) root(digest *Digest) (*Digest, error) {
+	if digest.Algorithm() != p.Algorithm {
+		return nil, ErrHashTypeMismatch
+	}
+	if p.Index < 0 || p.Index >= p.Count {
+		return nil, ErrBatchProofInvalid
+	}
+	node, err := batchHash(p.Algorithm, batchLeafPrefix, digest.AuthHash())
+	if err != nil {
+		return nil, err
+	}
+	path := p.Path
+	for i, n := p.Index, p.Count; n > 1; i, n = i/2, (n+1)/2 {
+		sibling := i ^ 1
+		if sibling >= n {
+			continue
+		}
+		if len(path) == 0 {
+			return nil, ErrBatchProofInvalid
+		}
+		hash, err := base64.StdEncoding.DecodeString(path[0])
+		if err != nil {
+			return nil, err
+		}
+		path = path[1:]
+		if sibling < i {
+			node, err = batchHash(p.Algorithm, batchNodePrefix, hash, node)
+		} else {
+			node, err = batchHash(p.Algorithm, batchNodePrefix, node, hash)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(path) > 0 {
+		return nil, ErrBatchProofInvalid
+	}
+	return NewDigest(p.Algorithm, node)
+}
+
+// VerifyBatchProof validates that the document with the digest is signed
+// as a part of the batch. Signature, timestamp and the signer certificate
+// are validated.
+func VerifyBatchProof(ctx context.Context, digest *Digest, p *BatchProof, ts *TrustStore) *SignatureResult {
+	r := &SignatureResult{}
+	der, err := base64.StdEncoding.DecodeString(p.Certificate)
+	if err != nil {
+		r.fail(err)
+		return r
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		r.fail(ErrSignerCertNotFound)
+		return r
+	}
+	r.setSigner(cert)
+
+	root, err := p.root(digest)
+	if err != nil {
+		r.fail(err)
+		return r
+	}
+	sig, err := base64.StdEncoding.DecodeString(p.Signature)
+	if err != nil {
+		r.fail(err)
+		return r
+	}
+	if err := verifySignatureValue(cert, root.CryptoHash(), root.AuthHash(), sig); err != nil {
+		r.fail(ErrSignatureInvalid)
+		return r
+	}
+
+	if p.Timestamp != "" {
+		if err := r.verifyBatchTimestamp(ctx, ts, p.Timestamp, sig); err != nil {
+			r.fail(err)
+		}
+	}
+	r.verifyCertificate(ctx, ts, nil, nil)
+	if r.OCSP == nil {
+		r.warn(ErrRevocationMissing)
+	}
+	return r
+}
+
+// verifyBatchTimestamp verifies timestamp over the signature value.
+func (r *SignatureResult) verifyBatchTimestamp(ctx context.Context, ts *TrustStore, enc string, sig []byte) error {
+	der, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return err
+	}
+	token, err := ParseTimestampToken(der)
+	if err != nil {
+		return err
+	}
+	digest, err := HashData(sig, token.Digest.Algorithm())
+	if err != nil {
+		return err
+	}
+	if err := token.CheckDigest(digest); err != nil {
+		return err
+	}
+	if _, err := token.Verify(ctx, ts); err != nil {
+		return err
+	}
+	r.Timestamps = append(r.Timestamps, token)
+	r.TrustedTime = token.Time
+	return nil
+}
+
+// batchHash hashes the prefix and the data.
+func batchHash(algo string, prefix byte, data ...[]byte) ([]byte, error) {
+	d, err := HashData(append([]byte{prefix}, bytes.Join(data, nil)...), algo)
+	if err != nil {
+		return nil, err
+	}
+	return d.AuthHash(), nil
+}
@@ -0,0 +1,126 @@
+package smartid
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+
+	for n := 1; n <= 7; n++ {
+		var digests []*Digest
+		for i := 0; i < n; i++ {
+			d, _ := HashData([]byte(fmt.Sprint("document ", i)), SHA256)
+			digests = append(digests, d)
+		}
+		b, err := NewBatch(digests, mock.signCert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.Proof(0); err != ErrBatchNotSigned {
+			t.Error("expected", ErrBatchNotSigned, "got", err)
+		}
+		_, err = b.Sign(ctx, mock.client(), &AuthRequest{
+			Identifier: mockDocumentNumber,
+			AuthType:   AuthTypeDocument,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, d := range digests {
+			p, err := b.Proof(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := json.Marshal(p)
+			var proof BatchProof
+			if err := json.Unmarshal(data, &proof); err != nil {
+				t.Fatal(err)
+			}
+			r := VerifyBatchProof(ctx, d, &proof, ts)
+			if !r.IsValid() {
+				t.Error(n, i, "expected valid proof, got", r.Err())
+			}
+			if r.Identity.SerialNumber != "PNOEE-30303039914" {
+				t.Error("expected", "PNOEE-30303039914", "got", r.Identity)
+			}
+			if n > 1 {
+				other := digests[(i+1)%n]
+				if r := VerifyBatchProof(ctx, other, &proof, ts); r.IsValid() {
+					t.Error(n, i, "expected invalid proof for other document")
+				}
+			}
+		}
+	}
+	if mock.requests[0]["hashType"] != SHA256 {
+		t.Error("expected", SHA256, "got", mock.requests[0]["hashType"])
+	}
+}
+
+func TestVerifyBatchProof_invalid(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+
+	var digests []*Digest
+	for i := 0; i < 3; i++ {
+		d, _ := HashData([]byte(fmt.Sprint("document ", i)), SHA384)
+		digests = append(digests, d)
+	}
+	b, _ := NewBatch(digests, mock.signCert)
+	_, err := b.Sign(ctx, mock.client(), &AuthRequest{
+		Identifier: mockDocumentNumber,
+		AuthType:   AuthTypeDocument,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddTimestamp(ctx, newTestTSA(t, mock.ca, mock.caKey)); err != nil {
+		t.Fatal(err)
+	}
+	p, _ := b.Proof(2)
+	r := VerifyBatchProof(ctx, digests[2], p, ts)
+	if !r.IsValid() || len(r.Timestamps) != 1 || r.TrustedTime.IsZero() {
+		t.Error("expected valid proof with timestamp, got", r.Err())
+	}
+
+	testdata := []struct {
+		name   string
+		modify func(p *BatchProof)
+		err    error
+	}{
+		{"index", func(p *BatchProof) { p.Index = 1 }, ErrBatchProofInvalid},
+		{"path hash", func(p *BatchProof) { p.Path[0] = p.Signature }, ErrSignatureInvalid},
+		{"index out of range", func(p *BatchProof) { p.Index = 3 }, ErrBatchProofInvalid},
+		{"count", func(p *BatchProof) { p.Count = 4 }, ErrBatchProofInvalid},
+		{"path", func(p *BatchProof) { p.Path = append(p.Path, p.Path[0]) }, ErrBatchProofInvalid},
+		{"algorithm", func(p *BatchProof) { p.Algorithm = SHA256 }, ErrHashTypeMismatch},
+		{"timestamp", func(p *BatchProof) { p.Timestamp = p.Signature }, nil},
+	}
+	for _, test := range testdata {
+		proof := *p
+		proof.Path = append([]string(nil), p.Path...)
+		test.modify(&proof)
+		r := VerifyBatchProof(ctx, digests[2], &proof, ts)
+		if r.IsValid() || (test.err != nil && !errors.Is(r.Err(), test.err)) {
+			t.Error(test.name, "expected", test.err, "got", r.Err())
+		}
+	}
+
+	sha256Digest, _ := HashData([]byte("document"), SHA256)
+	if _, err := NewBatch(append(digests, sha256Digest), mock.signCert); err != ErrHashTypeMismatch {
+		t.Error("expected", ErrHashTypeMismatch, "got", err)
+	}
+	if _, err := NewBatch(nil, mock.signCert); err != ErrBatchEmpty {
+		t.Error("expected", ErrBatchEmpty, "got", err)
+	}
+}
@@ -0,0 +1,212 @@
+package smartid
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// XML canonicalization algorithms.
+const (
+	algC14N10         = "http://www.w3.org/TR/2001/REC-xml-c14n-20010315"
+	algC14N10Comments = "http://www.w3.org/TR/2001/REC-xml-c14n-20010315#WithComments"
+	algC14N11         = "http://www.w3.org/2006/12/xml-c14n11"
+	algC14N11Comments = "http://www.w3.org/2006/12/xml-c14n11#WithComments"
+	algExcC14N        = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algExcC14NComment = "http://www.w3.org/2001/10/xml-exc-c14n#WithComments"
+)
+
+var (
+	// ErrC14NUnsupported error when canonicalization algorithm is unknown.
+	ErrC14NUnsupported = errors.New("Unsupported canonicalization algorithm")
+)
+
+// canonicalize serializes the element subtree with the canonicalization
+// algorithm. For exclusive canonicalization inclusivePrefixes are the
+// prefixes from InclusiveNamespaces PrefixList.
+func canonicalize(el *xmlElement, alg string, inclusivePrefixes []string) ([]byte, error) {
+	w := &c14nWriter{}
+	switch alg {
+	case algC14N10, algC14N11:
+	case algC14N10Comments, algC14N11Comments:
+		w.comments = true
+	case algExcC14N:
+		w.exclusive = true
+	case algExcC14NComment:
+		w.exclusive, w.comments = true, true
+	default:
+		return nil, ErrC14NUnsupported
+	}
+	w.inclusive = make(map[string]bool)
+	for _, p := range inclusivePrefixes {
+		if p == "#default" {
+			p = ""
+		}
+		w.inclusive[p] = true
+	}
+	w.element(el, map[string]string{}, true)
+	return w.buf.Bytes(), nil
+}
+
+type c14nWriter struct {
+	buf       bytes.Buffer
+	exclusive bool
+	comments  bool
+	inclusive map[string]bool
+}
+
+// element writes element with namespace declarations which are not yet
+// rendered by output ancestors.
+func (w *c14nWriter) element(el *xmlElement, rendered map[string]string, apex bool) {
+	scope := inScopeNS(el)
+
+	var prefixes []string
+	switch {
+	case w.exclusive:
+		used := map[string]bool{el.Prefix: true}
+		for _, a := range el.Attrs {
+			if a.Prefix != "" && a.Prefix != "xml" {
+				used[a.Prefix] = true
+			}
+		}
+		for p := range w.inclusive {
+			if _, ok := scope[p]; ok {
+				used[p] = true
+			}
+		}
+		for p := range used {
+			prefixes = append(prefixes, p)
+		}
+	case apex:
+		for p := range scope {
+			prefixes = append(prefixes, p)
+		}
+	default:
+		for _, ns := range el.NS {
+			prefixes = append(prefixes, ns.Local)
+		}
+	}
+	sort.Strings(prefixes)
+
+	next := make(map[string]string, len(rendered))
+	for k, v := range rendered {
+		next[k] = v
+	}
+
+	w.buf.WriteByte('<')
+	w.buf.WriteString(qualifiedName(el.Prefix, el.Local))
+	for _, p := range prefixes {
+		uri, inScope := scope[p]
+		if !inScope {
+			continue
+		}
+		prev, ok := rendered[p]
+		if ok && prev == uri {
+			continue
+		}
+		if p == "" && uri == "" && prev == "" {
+			continue
+		}
+		next[p] = uri
+		if p == "" {
+			w.buf.WriteString(` xmlns="`)
+		} else {
+			w.buf.WriteString(` xmlns:` + p + `="`)
+		}
+		w.buf.WriteString(escapeC14NAttr(uri))
+		w.buf.WriteByte('"')
+	}
+
+	attrs := append([]xmlAttr(nil), el.Attrs...)
+	sort.SliceStable(attrs, func(i, j int) bool {
+		ui, uj := attrNS(el, attrs[i]), attrNS(el, attrs[j])
+		if ui != uj {
+			return ui < uj
+		}
+		return attrs[i].Local < attrs[j].Local
+	})
+	for _, a := range attrs {
+		w.buf.WriteByte(' ')
+		w.buf.WriteString(qualifiedName(a.Prefix, a.Local))
+		w.buf.WriteString(`="`)
+		w.buf.WriteString(escapeC14NAttr(a.Value))
+		w.buf.WriteByte('"')
+	}
+	w.buf.WriteByte('>')
+
+	for _, c := range el.Children {
+		switch n := c.(type) {
+		case *xmlElement:
+			w.element(n, next, false)
+		case xmlText:
+			w.buf.WriteString(escapeC14NText(string(n)))
+		case xmlComment:
+			if w.comments {
+				w.buf.WriteString("<!--" + string(n) + "-->")
+			}
+		case xmlProcInst:
+			w.buf.WriteString("<?" + n.Target)
+			if n.Inst != "" {
+				w.buf.WriteString(" " + n.Inst)
+			}
+			w.buf.WriteString("?>")
+		}
+	}
+
+	w.buf.WriteString("</" + qualifiedName(el.Prefix, el.Local) + ">")
+}
+
+// inScopeNS returns namespace declarations in scope of the element. The
+// xml prefix is never included.
+func inScopeNS(el *xmlElement) map[string]string {
+	scope := make(map[string]string)
+	for e := el; e != nil; e = e.Parent {
+		for _, ns := range e.NS {
+			if _, ok := scope[ns.Local]; !ok && ns.Local != "xml" {
+				scope[ns.Local] = ns.Value
+			}
+		}
+	}
+	if scope[""] == "" {
+		delete(scope, "")
+		if el.Prefix == "" {
+			scope[""] = ""
+		}
+	}
+	return scope
+}
+
+// attrNS returns namespace URI of the attribute. Attributes without prefix
+// have no namespace.
+func attrNS(el *xmlElement, a xmlAttr) string {
+	if a.Prefix == "" {
+		return ""
+	}
+	uri, _ := el.lookupNS(a.Prefix)
+	return uri
+}
+
+func qualifiedName(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+var c14nTextReplacer = strings.NewReplacer(
+	"&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;",
+)
+
+var c14nAttrReplacer = strings.NewReplacer(
+	"&", "&amp;", "<", "&lt;", `"`, "&quot;",
+	"\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;",
+)
+
+func escapeC14NText(s string) string {
+	return c14nTextReplacer.Replace(s)
+}
+
+func escapeC14NAttr(s string) string {
+	return c14nAttrReplacer.Replace(s)
+}
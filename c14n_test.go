@@ -0,0 +1,73 @@
+package smartid
+
+import "testing"
+
+func TestCanonicalize(t *testing.T) {
+	// Example from Exclusive XML Canonicalization specification.
+	doc := `<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org">` +
+		`<n1:elem2 xmlns:n1="http://example.net" xml:lang="en">` +
+		`<n3:stuff xmlns:n3="ftp://example.org"/></n1:elem2></n0:local>`
+	root, err := parseXML([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	elem2 := root.Elements()[0]
+
+	testdata := []struct {
+		alg, exp string
+	}{
+		{
+			algC14N10,
+			`<n1:elem2 xmlns:n0="foo:bar" xmlns:n1="http://example.net" ` +
+				`xmlns:n3="ftp://example.org" xml:lang="en">` +
+				`<n3:stuff></n3:stuff></n1:elem2>`,
+		},
+		{
+			algExcC14N,
+			`<n1:elem2 xmlns:n1="http://example.net" xml:lang="en">` +
+				`<n3:stuff xmlns:n3="ftp://example.org"></n3:stuff></n1:elem2>`,
+		},
+	}
+	for _, test := range testdata {
+		got, err := canonicalize(elem2, test.alg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.exp {
+			t.Errorf("%v\nexpected %v\ngot      %v", test.alg, test.exp, string(got))
+		}
+	}
+
+	got, _ := canonicalize(elem2, algExcC14N, []string{"n0"})
+	exp := `<n1:elem2 xmlns:n0="foo:bar" xmlns:n1="http://example.net" xml:lang="en">` +
+		`<n3:stuff xmlns:n3="ftp://example.org"></n3:stuff></n1:elem2>`
+	if string(got) != exp {
+		t.Errorf("expected %v\ngot      %v", exp, string(got))
+	}
+
+	if _, err := canonicalize(elem2, "urn:unknown", nil); err != ErrC14NUnsupported {
+		t.Error("expected", ErrC14NUnsupported, "got", err)
+	}
+}
+
+func TestCanonicalize_escaping(t *testing.T) {
+	doc := "<a xmlns=\"urn:a\" b=\"x&amp;&quot;&#9;y\" a=\"1\"><!-- c -->" +
+		"<c xmlns=\"\">1 &lt; 2 &amp; 3 &gt; 2\r\n</c><?pi data?></a>"
+	root, err := parseXML([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "<a xmlns=\"urn:a\" a=\"1\" b=\"x&amp;&quot;&#x9;y\">" +
+		"<c xmlns=\"\">1 &lt; 2 &amp; 3 &gt; 2\n</c><?pi data?></a>"
+	got, _ := canonicalize(root, algC14N11, nil)
+	if string(got) != exp {
+		t.Errorf("expected %q\ngot      %q", exp, string(got))
+	}
+
+	expComments := "<a xmlns=\"urn:a\" a=\"1\" b=\"x&amp;&quot;&#x9;y\"><!-- c -->" +
+		"<c xmlns=\"\">1 &lt; 2 &amp; 3 &gt; 2\n</c><?pi data?></a>"
+	got, _ = canonicalize(root, algC14N11Comments, nil)
+	if string(got) != expComments {
+		t.Errorf("expected %q\ngot      %q", expComments, string(got))
+	}
+}
@@ -0,0 +1,93 @@
+package smartid
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"time"
+)
+
+// CAdESSignature is detached CAdES-BES signature which is being prepared.
+// Digest of the signed attributes is signed with Smart-ID and the value is
+// set with SetSignatureValue. Optionally timestamp makes it CAdES-T.
+type CAdESSignature struct {
+	signingTime time.Time
+	cms         *cmsSigner
+}
+
+// PrepareCAdESSignature makes signed attributes for the content digest:
+// content type, message digest, signing time and signing certificate. The
+// chain certificates are included in the signature.
+func PrepareCAdESSignature(
+	contentDigest *Digest,
+	cert *x509.Certificate,
+	chain []*x509.Certificate,
+) (*CAdESSignature, error) {
+	s := &CAdESSignature{signingTime: time.Now().UTC().Truncate(time.Second)}
+	var err error
+	s.cms, err = newCMSSigner(oidContentTypeData, contentDigest, cert, chain, s.signingTime)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Digest returns digest of the signed attributes, which is signed by
+// Smart-ID.
+func (s *CAdESSignature) Digest() *Digest {
+	return s.cms.digest
+}
+
+// SigningTime returns claimed signing time.
+func (s *CAdESSignature) SigningTime() time.Time {
+	return s.signingTime
+}
+
+// Certificate returns the signer certificate.
+func (s *CAdESSignature) Certificate() *x509.Certificate {
+	return s.cms.cert
+}
+
+// SetSignatureValue sets the signature value returned by Smart-ID. Value
+// is verified with the signer certificate.
+func (s *CAdESSignature) SetSignatureValue(sig Signature) error {
+	value, err := base64.StdEncoding.DecodeString(sig.Value)
+	if err != nil {
+		return err
+	}
+	return s.cms.setSignature(value)
+}
+
+// Sign signs the signature with Smart-ID using SignSync. Request should
+// have the identifier of the person, preferably document number from the
+// certificate choice. Digest of the request is set automatically.
+func (s *CAdESSignature) Sign(ctx context.Context, c *Client, req *AuthRequest) (*SessionResponse, error) {
+	resp, err := signDigest(ctx, c, req, s.cms.digest, s.cms.cert)
+	if err != nil {
+		return resp, err
+	}
+	return resp, s.SetSignatureValue(resp.Signature)
+}
+
+// AddTimestamp adds signature timestamp over the signature value, which
+// makes the signature CAdES-T.
+func (s *CAdESSignature) AddTimestamp(ctx context.Context, ts Timestamper) error {
+	return s.cms.addTimestamp(ctx, ts)
+}
+
+// Bytes returns DER encoded ContentInfo with detached SignedData, which is
+// usually stored as .p7s file.
+func (s *CAdESSignature) Bytes() ([]byte, error) {
+	return s.cms.bytes()
+}
+
+// VerifyCAdES validates CMS signature over the content. If content is nil,
+// encapsulated content of the signature is used.
+func VerifyCAdES(ctx context.Context, p7s, content []byte, ts *TrustStore) *SignatureResult {
+	r := &SignatureResult{}
+	r.verifyCMS(ctx, ts, p7s, content, nil, nil, true)
+	if r.Certificate != nil && r.OCSP == nil {
+		r.warn(ErrRevocationMissing)
+	}
+	return r
+}
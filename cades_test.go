@@ -0,0 +1,146 @@
+package smartid
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCAdESSignature(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+	content := []byte("Hello, Smart-ID!")
+
+	digest, _ := HashData(content, SHA384)
+	sig, err := PrepareCAdESSignature(digest, mock.signCert, []*x509.Certificate{mock.ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.Digest().Algorithm() != SHA384 {
+		t.Error("expected", SHA384, "got", sig.Digest().Algorithm())
+	}
+	if _, err := sig.Bytes(); err != ErrCMSNotSigned {
+		t.Error("expected", ErrCMSNotSigned, "got", err)
+	}
+	_, err = sig.Sign(ctx, mock.client(), &AuthRequest{
+		Identifier: mockDocumentNumber,
+		AuthType:   AuthTypeDocument,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mock.requests[0]["hash"] != sig.Digest().AuthHash().ToBase64String() {
+		t.Error("expected signed attributes digest to be signed")
+	}
+	if err := sig.AddTimestamp(ctx, newTestTSA(t, mock.ca, mock.caKey)); err != nil {
+		t.Fatal(err)
+	}
+	p7s, err := sig.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := VerifyCAdES(ctx, p7s, content, ts)
+	if !r.IsValid() {
+		t.Fatal("expected", nil, "got", r.Err())
+	}
+	if !r.Certificate.Equal(mock.signCert) || r.Identity.SerialNumber != "PNOEE-30303039914" {
+		t.Error("expected signer certificate, got", r.Identity)
+	}
+	if !r.SigningTime.Equal(sig.SigningTime()) {
+		t.Error("expected", sig.SigningTime(), "got", r.SigningTime)
+	}
+	if len(r.Timestamps) != 1 || r.TrustedTime.IsZero() {
+		t.Error("expected signature timestamp, got", r.Timestamps)
+	}
+	if len(r.Chain) != 2 {
+		t.Error("expected", 2, "got", len(r.Chain))
+	}
+
+	r = VerifyCAdES(ctx, p7s, []byte("Hello, Mallory!"), ts)
+	if !errors.Is(r.Err(), ErrCMSMessageDigestMismatch) {
+		t.Error("expected", ErrCMSMessageDigestMismatch, "got", r.Err())
+	}
+	r = VerifyCAdES(ctx, p7s, content, NewTrustStore())
+	if r.IsValid() {
+		t.Error("expected untrusted signer error")
+	}
+	if r := VerifyCAdES(ctx, []byte("not CMS"), content, ts); r.IsValid() {
+		t.Error("expected parse error")
+	}
+}
+
+func TestVerifyCAdES_signingCertificate(t *testing.T) {
+	ctx := context.Background()
+	ca, caKey := newTestCA(t, "TEST of EID-SK 2016")
+	ts := NewTrustStore()
+	ts.AddRoot(ca)
+	cert, key := newTestLeaf(t, signTemplate(), ca, caKey)
+	other, _ := newTestLeaf(t, signTemplate(), ca, caKey)
+	content := []byte("Hello, Smart-ID!")
+
+	digest, _ := HashData(content, SHA256)
+	s, err := newCMSSigner(oidContentTypeData, digest, other, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Signing certificate attribute references another certificate.
+	s.cert = cert
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, s.digest.CryptoHash(), s.digest.AuthHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setSignature(sig); err != nil {
+		t.Fatal(err)
+	}
+	p7s, _ := s.bytes()
+	r := VerifyCAdES(ctx, p7s, content, ts)
+	if !errors.Is(r.Err(), ErrSigningCertMismatch) {
+		t.Error("expected", ErrSigningCertMismatch, "got", r.Err())
+	}
+
+	// CMS signature without signing certificate attribute is not CAdES.
+	p7s = testSignedData(t, oidContentTypeData, content, true, cert, key)
+	r = VerifyCAdES(ctx, p7s, content, ts)
+	if !errors.Is(r.Err(), ErrCMSAttributeMissing) {
+		t.Error("expected", ErrCMSAttributeMissing, "got", r.Err())
+	}
+}
+
+func TestVerifyCAdES_authenticationCertificate(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+	content := []byte("Hello, Smart-ID!")
+
+	digest, _ := HashData(content, SHA256)
+	sig, err := PrepareCAdESSignature(digest, mock.authCert, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := rsa.SignPKCS1v15(rand.Reader, mock.key, crypto.SHA256, sig.Digest().AuthHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sig.SetSignatureValue(Signature{
+		Value:     base64.StdEncoding.EncodeToString(value),
+		Algorithm: "sha256WithRSAEncryption",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7s, _ := sig.Bytes()
+	r := VerifyCAdES(ctx, p7s, content, ts)
+	if !errors.Is(r.Err(), ErrCertNotSigning) {
+		t.Error("expected", ErrCertNotSigning, "got", r.Err())
+	}
+}
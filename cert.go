@@ -1,8 +1,11 @@
 package smartid
 
 import (
+	"context"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
 	"encoding/pem"
 	"errors"
 	"io/ioutil"
@@ -29,8 +32,46 @@ var (
 	// ErrCertNoCertGiven error when no certificates used in Verify()
 	// function.
 	ErrCertNoCertGiven = errors.New("No certs given")
+
+	// ErrCertTestIssuer error when certificate issued by the test CA is
+	// used in production environment.
+	ErrCertTestIssuer = errors.New("Certificate is issued by TEST CA")
+
+	// ErrCertNotAuthentication error when certificate of the response is
+	// not the authentication certificate.
+	ErrCertNotAuthentication = errors.New(
+		"Certificate is not for authentication")
+
+	// ErrCertNotSigning error when certificate of the response is not the
+	// signing (non-repudiation) certificate.
+	ErrCertNotSigning = errors.New("Certificate is not for signing")
 )
 
+// Certificate policies used in Smart-ID certificates. See
+// https://www.skidsolutions.eu/resources/profiles/
+var (
+	// oidPolicyNCPPlus is ETSI EN 319 411-1 NCP+ policy, used in
+	// authentication and in non-qualified signing certificates.
+	oidPolicyNCPPlus = asn1.ObjectIdentifier{0, 4, 0, 2042, 1, 2}
+
+	// oidPolicyQCPn is ETSI EN 319 411-2 QCP-n policy.
+	oidPolicyQCPn = asn1.ObjectIdentifier{0, 4, 0, 194112, 1, 0}
+
+	// oidPolicyQCPnQSCD is ETSI EN 319 411-2 QCP-n-qscd policy, used in
+	// qualified signing certificates.
+	oidPolicyQCPnQSCD = asn1.ObjectIdentifier{0, 4, 0, 194112, 1, 2}
+)
+
+// authenticationPolicies are policies of authentication certificates.
+var authenticationPolicies = []asn1.ObjectIdentifier{oidPolicyNCPPlus}
+
+// signingPolicies are policies of signing certificates.
+var signingPolicies = []asn1.ObjectIdentifier{
+	oidPolicyQCPnQSCD,
+	oidPolicyQCPn,
+	oidPolicyNCPPlus,
+}
+
 // Cert represents certificate from session response.
 type Cert struct {
 	// Value is the base64 encoded string of certificate.
@@ -43,6 +84,9 @@ type Cert struct {
 
 	// x509Cert is the X509 certificate.
 	x509Cert *x509.Certificate
+
+	// environment of the client, which received the certificate.
+	environment string
 }
 
 // IsExpired checks that certificate has expired.
@@ -62,27 +106,25 @@ func (c *Cert) IsSameLevel(lvl string) bool {
 
 // Verify certificate by file system paths.
 func (c *Cert) Verify(paths []string) (bool, error) {
-	if len(paths) == 0 {
-		return false, ErrCertNoCertGiven
-	}
-	roots := x509.NewCertPool()
-
-	for _, path := range paths {
-		cert, err := createCertFromPath(path)
-		if err != nil {
-			return false, err
-		}
-		roots.AddCert(cert)
+	ts, err := NewTrustStoreFromPaths(paths)
+	if err != nil {
+		return false, err
 	}
+	return c.VerifyWithTrustStore(context.Background(), ts)
+}
 
-	opts := x509.VerifyOptions{
-		Roots: roots,
-		// NOTE SK generated cert have incompatible keys.
-		// NOTE Maybe need to change this behaviour.
-		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+// VerifyWithTrustStore verifies certificate with the trust store. If the
+// trust store has AIA resolver, missing intermediates are downloaded.
+//
+// SK certificates have different extended key usages for authentication
+// and signing, key usage and policies are checked by
+// CheckAuthenticationUsage and CheckSigningUsage instead. Certificates
+// received in production environment must not be issued by the test CA.
+func (c *Cert) VerifyWithTrustStore(ctx context.Context, ts *TrustStore) (bool, error) {
+	if c.environment == EnvironmentProduction && c.IsTestIssued() {
+		return false, ErrCertTestIssuer
 	}
-
-	if _, err := c.x509Cert.Verify(opts); err != nil {
+	if _, err := ts.Verify(ctx, c.x509Cert, time.Time{}); err != nil {
 		return false, err
 	}
 	return true, nil
@@ -103,6 +145,52 @@ func (c *Cert) GetIssuer() *pkix.Name {
 	return &c.GetX509Cert().Issuer
 }
 
+// CheckAuthenticationUsage checks that certificate is the authentication
+// certificate: it has digitalSignature key usage and the authentication
+// policy.
+func (c *Cert) CheckAuthenticationUsage() error {
+	cert := c.GetX509Cert()
+	if cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 ||
+		!hasAnyPolicy(cert, authenticationPolicies) {
+		return ErrCertNotAuthentication
+	}
+	return nil
+}
+
+// CheckSigningUsage checks that certificate is the signing certificate: it
+// has nonRepudiation (contentCommitment) key usage and the signing policy.
+func (c *Cert) CheckSigningUsage() error {
+	cert := c.GetX509Cert()
+	if cert.KeyUsage&x509.KeyUsageContentCommitment == 0 ||
+		!hasAnyPolicy(cert, signingPolicies) {
+		return ErrCertNotSigning
+	}
+	return nil
+}
+
+// GetKnownIssuer finds the issuer of the certificate in the list of known
+// SK CAs.
+func (c *Cert) GetKnownIssuer() (Issuer, bool) {
+	return LookupIssuer(c.GetIssuer().CommonName)
+}
+
+// IsTestIssued checks that certificate is issued by the SK test CA.
+func (c *Cert) IsTestIssued() bool {
+	return isTestIssuerName(c.GetIssuer().CommonName)
+}
+
+// hasAnyPolicy checks that certificate has at least one of the policies.
+func hasAnyPolicy(cert *x509.Certificate, policies []asn1.ObjectIdentifier) bool {
+	for _, p := range cert.PolicyIdentifiers {
+		for _, want := range policies {
+			if p.Equal(want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // createCertFromPath certificate from given file system path.
 func createCertFromPath(path string) (*x509.Certificate, error) {
 	bs, err := ioutil.ReadFile(path)
@@ -132,6 +220,14 @@ func decodePEMBlock(pemData []byte) *pem.Block {
 	return block
 }
 
+// certFromX509 wraps X509 certificate into Cert.
+func certFromX509(cert *x509.Certificate) *Cert {
+	return &Cert{
+		Value:    base64.StdEncoding.EncodeToString(cert.Raw),
+		x509Cert: cert,
+	}
+}
+
 // createX509CertIfNeeded creates X509 certificate from response if not yet
 // certificate exists.
 func (c *Cert) createX509CertIfNeeded() {
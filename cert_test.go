@@ -0,0 +1,245 @@
+package smartid
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	testKeysMu sync.Mutex
+	testKeys   = map[string]*rsa.PrivateKey{}
+)
+
+// testKey returns RSA key by name. Keys are cached, because generating
+// them is slow.
+func testKey(t *testing.T, name string) *rsa.PrivateKey {
+	t.Helper()
+	testKeysMu.Lock()
+	defer testKeysMu.Unlock()
+	if key, ok := testKeys[name]; ok {
+		return key
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testKeys[name] = key
+	return key
+}
+
+// newTestCA creates self-signed CA certificate.
+func newTestCA(t *testing.T, cn string) (*x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
+	key := testKey(t, cn)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn, Country: []string{"EE"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	return signTestCert(t, tmpl, tmpl, &key.PublicKey, key), key
+}
+
+// newTestLeaf creates the Smart-ID like end-entity certificate for the
+// given person signed by CA. Template fields which are set are kept.
+func newTestLeaf(
+	t *testing.T,
+	tmpl *x509.Certificate,
+	ca *x509.Certificate,
+	caKey *rsa.PrivateKey,
+) (*x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
+	key := testKey(t, "leaf")
+	if tmpl.SerialNumber == nil {
+		tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	}
+	if tmpl.NotBefore.IsZero() {
+		tmpl.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if tmpl.NotAfter.IsZero() {
+		tmpl.NotAfter = time.Now().Add(12 * time.Hour)
+	}
+	if tmpl.Subject.CommonName == "" {
+		tmpl.Subject = pkix.Name{
+			CommonName:   "TESTNUMBER,OK",
+			SerialNumber: "PNOEE-30303039914",
+			Country:      []string{"EE"},
+			ExtraNames: []pkix.AttributeTypeAndValue{
+				{Type: oidAttributeGivenName, Value: "OK"},
+				{Type: oidAttributeSurname, Value: "TESTNUMBER"},
+			},
+		}
+	}
+	return signTestCert(t, tmpl, ca, &key.PublicKey, caKey), key
+}
+
+func signTestCert(
+	t *testing.T,
+	tmpl, parent *x509.Certificate,
+	pub *rsa.PublicKey,
+	priv *rsa.PrivateKey,
+) *x509.Certificate {
+	t.Helper()
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// newTestSessionResponse makes completed session response signed with the
+// key of the certificate.
+func newTestSessionResponse(
+	t *testing.T,
+	cert *x509.Certificate,
+	key *rsa.PrivateKey,
+	session Session,
+) *SessionResponse {
+	t.Helper()
+	if session.hash == nil {
+		session.hash = GenerateAuthHash(SHA512)
+	}
+	if session.certificateLevel == "" {
+		session.certificateLevel = CertLevelQualified
+	}
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA512, session.hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &SessionResponse{
+		State:  SessionStatusComplete,
+		Result: Result{EndResult: SessionResultOK},
+		Signature: Signature{
+			Value:     base64.StdEncoding.EncodeToString(sig),
+			Algorithm: "sha512WithRSAEncryption",
+		},
+		Cert: Cert{
+			Value:            base64.StdEncoding.EncodeToString(cert.Raw),
+			CertificateLevel: CertLevelQualified,
+		},
+		Session: session,
+	}
+	resp.Cert.createX509CertIfNeeded()
+	return resp
+}
+
+func TestCert_GetIssuer(t *testing.T) {
+	ca, caKey := newTestCA(t, "TEST of EID-SK 2016")
+	leaf, _ := newTestLeaf(t, &x509.Certificate{}, ca, caKey)
+	cert := Cert{Value: base64.StdEncoding.EncodeToString(leaf.Raw)}
+	cert.createX509CertIfNeeded()
+
+	if cn := cert.GetIssuer().CommonName; cn != "TEST of EID-SK 2016" {
+		t.Error("expected TEST of EID-SK 2016 got", cn)
+	}
+	if cn := cert.GetSubject().CommonName; cn != "TESTNUMBER,OK" {
+		t.Error("expected TESTNUMBER,OK got", cn)
+	}
+	issuer, ok := cert.GetKnownIssuer()
+	if !ok || !issuer.IsTest() || issuer.Level != CertLevelQualified {
+		t.Error("unexpected issuer", issuer, ok)
+	}
+	if !cert.IsTestIssued() {
+		t.Error("expected certificate to be issued by test CA")
+	}
+}
+
+// testPolicies converts object identifiers to certificate policies.
+func testPolicies(oids ...asn1.ObjectIdentifier) []x509.OID {
+	var policies []x509.OID
+	for _, oid := range oids {
+		ints := make([]uint64, len(oid))
+		for i, v := range oid {
+			ints[i] = uint64(v)
+		}
+		policy, _ := x509.OIDFromInts(ints)
+		policies = append(policies, policy)
+	}
+	return policies
+}
+
+// authTemplate is the template of the Smart-ID authentication certificate.
+func authTemplate() *x509.Certificate {
+	return &x509.Certificate{
+		KeyUsage: x509.KeyUsageDigitalSignature,
+		Policies: testPolicies(oidPolicyNCPPlus),
+	}
+}
+
+// signTemplate is the template of the Smart-ID signing certificate.
+func signTemplate() *x509.Certificate {
+	return &x509.Certificate{
+		KeyUsage: x509.KeyUsageContentCommitment,
+		Policies: testPolicies(oidPolicyQCPnQSCD),
+	}
+}
+
+func TestCert_CheckUsage(t *testing.T) {
+	ca, caKey := newTestCA(t, "TEST of EID-SK 2016")
+	authLeaf, _ := newTestLeaf(t, authTemplate(), ca, caKey)
+	signLeaf, _ := newTestLeaf(t, signTemplate(), ca, caKey)
+	noPolicy := authTemplate()
+	noPolicy.Policies = nil
+	noPolicyLeaf, _ := newTestLeaf(t, noPolicy, ca, caKey)
+
+	authCert := Cert{x509Cert: authLeaf}
+	signCert := Cert{x509Cert: signLeaf}
+	noPolicyCert := Cert{x509Cert: noPolicyLeaf}
+
+	if err := authCert.CheckAuthenticationUsage(); err != nil {
+		t.Error("expected authentication certificate got", err)
+	}
+	if err := authCert.CheckSigningUsage(); err != ErrCertNotSigning {
+		t.Error("expected", ErrCertNotSigning, "got", err)
+	}
+	if err := signCert.CheckSigningUsage(); err != nil {
+		t.Error("expected signing certificate got", err)
+	}
+	if err := signCert.CheckAuthenticationUsage(); err != ErrCertNotAuthentication {
+		t.Error("expected", ErrCertNotAuthentication, "got", err)
+	}
+	if err := noPolicyCert.CheckAuthenticationUsage(); err != ErrCertNotAuthentication {
+		t.Error("expected", ErrCertNotAuthentication, "got", err)
+	}
+}
+
+func TestSessionResponse_Validate_usage(t *testing.T) {
+	ca, caKey := newTestCA(t, "TEST of EID-SK 2016")
+	authLeaf, key := newTestLeaf(t, authTemplate(), ca, caKey)
+	signLeaf, _ := newTestLeaf(t, signTemplate(), ca, caKey)
+
+	testdata := []struct {
+		cert     *x509.Certificate
+		endpoint string
+		err      error
+	}{
+		{authLeaf, EndpointAuthentication, nil},
+		{signLeaf, EndpointAuthentication, ErrCertNotAuthentication},
+		{signLeaf, EndpointSignature, nil},
+		{authLeaf, EndpointSignature, ErrCertNotSigning},
+	}
+	for _, test := range testdata {
+		resp := newTestSessionResponse(t, test.cert, key, Session{
+			endpoint: test.endpoint,
+		})
+		if _, err := resp.Validate(); err != test.err {
+			t.Error(test.endpoint, "expected", test.err, "got", err)
+		}
+	}
+}
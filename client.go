@@ -9,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 const pollDown = 1000
@@ -27,6 +28,10 @@ type Client struct {
 	Poll uint32
 
 	httpClient *http.Client
+
+	// environment is PRODUCTION or TEST. Responses with certificates
+	// issued by the test CA are rejected in production.
+	environment string
 }
 
 // Option interface used for setting optional Client properties.
@@ -43,8 +48,16 @@ func WithHTTPClient(httpClient *http.Client) Option {
 	return optionFunc(func(c *Client) { c.httpClient = httpClient })
 }
 
+// WithEnvironment specifies the Smart-ID environment: EnvironmentProduction
+// or EnvironmentTest. In production environment Validate and Cert.Verify
+// reject certificates issued by the test CA.
+func WithEnvironment(env string) Option {
+	return optionFunc(func(c *Client) { c.environment = env })
+}
+
 // NewClient creates a new client instance. Poll will be in range 1000ms to
-// 120000ms.
+// 120000ms. If url points to the production Smart-ID API and no
+// environment is given, the production environment is used.
 func NewClient(url string, poll uint32, opts ...Option) *Client {
 	client := &Client{
 		APIUrl: url,
@@ -59,6 +72,10 @@ func NewClient(url string, poll uint32, opts ...Option) *Client {
 		client.httpClient = new(http.Client)
 	}
 
+	if client.environment == "" && strings.Contains(url, productionAPIHost) {
+		client.environment = EnvironmentProduction
+	}
+
 	return client
 }
 
@@ -67,13 +84,18 @@ func (c *Client) Authenticate(ctx context.Context, req *AuthRequest) chan *Sessi
 	ch := make(chan *SessionResponse)
 	go func() {
 		resp, err := c.AuthenticateSync(ctx, req)
-		if err != nil {
+		var respErr *Error
+		if errors.As(err, &respErr) {
 			ch <- &SessionResponse{
 				Response: Response{
-					Code:    err.(*Error).Code,
-					Message: err.(*Error).Message,
+					Code:    respErr.Code,
+					Message: respErr.Message,
 				},
 			}
+		} else if err != nil {
+			ch <- &SessionResponse{
+				Response: Response{Message: err.Error()},
+			}
 		} else {
 			ch <- resp
 		}
@@ -109,26 +131,49 @@ func (c *Client) SignSync(ctx context.Context, req *AuthRequest) (*SessionRespon
 	return c.AuthenticateSync(ctx, req)
 }
 
+// ChooseCertificate does certificate choice in asynchronous way using
+// channel. Certificate choice returns the signing certificate and the
+// document number of the user, nothing is signed. Hash is not needed.
+func (c *Client) ChooseCertificate(ctx context.Context, req *AuthRequest) chan *SessionResponse {
+	req.endpoint = EndpointCertificateChoice
+	return c.Authenticate(ctx, req)
+}
+
+// ChooseCertificateSync does certificate choice in synchronous way.
+func (c *Client) ChooseCertificateSync(ctx context.Context, req *AuthRequest) (*SessionResponse, error) {
+	req.endpoint = EndpointCertificateChoice
+	return c.AuthenticateSync(ctx, req)
+}
+
 // --------------- unexposed -----------------
 
 // newSession contacts Smart-ID service for authentication to get
 // session ID in UUID format. This step also sends interaction order
 // to user's app.
 func (c *Client) newSession(ctx context.Context, req *AuthRequest) (*Session, error) {
+	if req.Digest != nil {
+		if req.HashType != "" && req.HashType != req.Digest.Algorithm() {
+			return nil, ErrHashTypeMismatch
+		}
+		req.Hash = req.Digest.AuthHash()
+		req.HashType = req.Digest.Algorithm()
+	}
+
 	// Set some defaults fallback
+	if req.endpoint == "" {
+		req.endpoint = EndpointAuthentication
+	}
+	certChoice := req.endpoint == EndpointCertificateChoice
 	if req.CertificateLevel == "" {
 		req.CertificateLevel = CertLevelQualified
 	}
-	if req.HashType == "" {
+	if req.HashType == "" && !certChoice {
 		req.HashType = SHA512
 	}
 	if req.AuthType == "" {
 		req.AuthType = AuthTypeEtsi
 	}
-	if req.endpoint == "" {
-		req.endpoint = EndpointAuthentication
-	}
-	if len(req.AllowedInteractionsOrder) == 0 {
+	if len(req.AllowedInteractionsOrder) == 0 && !certChoice {
 		req.AllowedInteractionsOrder = []AllowedInteractionsOrder{
 			{
 				Type:          InteractionDisplayTextAndPIN,
@@ -138,6 +183,12 @@ func (c *Client) newSession(ctx context.Context, req *AuthRequest) (*Session, er
 	}
 	// end of defaults fallback
 
+	if !certChoice {
+		if err := checkHash(req.Hash, req.HashType); err != nil {
+			return nil, err
+		}
+	}
+
 	resp, err := c.getEndpointResponse(ctx, req)
 	if err != nil {
 		return nil, err
@@ -146,7 +197,11 @@ func (c *Client) newSession(ctx context.Context, req *AuthRequest) (*Session, er
 	return &Session{
 		SessionID:        resp.SessionID,
 		hash:             req.Hash,
+		hashType:         req.HashType,
 		certificateLevel: req.CertificateLevel,
+		environment:      c.environment,
+		endpoint:         req.endpoint,
+		onPoll:           req.OnPoll,
 	}, nil
 }
 
@@ -238,6 +293,7 @@ func (c *Client) getSessionResponse(
 	// Make this expensive operation here, to make certificate available
 	// for all required methods in Cert.
 	resp.Cert.createX509CertIfNeeded()
+	resp.Cert.environment = s.environment
 	return &resp, nil
 }
 
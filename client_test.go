@@ -586,3 +586,19 @@ func TestNewClient(t *testing.T) {
 		}
 	})
 }
+
+func TestClient_OnPoll(t *testing.T) {
+	mock := newMockSmartID(t)
+	var polled []*SessionResponse
+	resp, err := mock.client().AuthenticateSync(context.Background(), &AuthRequest{
+		Identifier: "PNOEE-30303039914",
+		Hash:       GenerateAuthHash(SHA512),
+		OnPoll:     func(resp *SessionResponse) { polled = append(polled, resp) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(polled) != 1 || polled[0] != resp {
+		t.Error("expected completed response to be polled, got", polled)
+	}
+}
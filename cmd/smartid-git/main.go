@@ -0,0 +1,322 @@
+// Command smartid-git signs and verifies git commits and tags with
+// Smart-ID. It implements the gpg.x509.program interface of git, like
+// smimesign, and produces detached CAdES signatures.
+//
+// Configure git:
+//
+//	git config gpg.format x509
+//	git config gpg.x509.program smartid-git
+//	git config user.signingkey PNOEE-30303039914
+//
+// The signing key is the semantic identifier of the person. The tool is
+// configured with environment variables:
+//
+//	SMARTID_URL              Smart-ID API URL, demo service by default
+//	SMARTID_RP_UUID          relying party UUID
+//	SMARTID_RP_NAME          relying party name
+//	SMARTID_TSA_URL          TSA URL for signature timestamps, optional
+//	SMARTID_TRUST            trusted CA certificate files for verification,
+//	                         separated by the OS path list separator
+//
+// Verification code is shown in the terminal while waiting for the user
+// to confirm signing in the app.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dknight/go-smartid"
+)
+
+const (
+	defaultURL  = "https://sid.demo.sk.ee/smart-id-rp/v2/"
+	pemType     = "SIGNED MESSAGE"
+	signTimeout = 5 * time.Minute
+)
+
+var (
+	errUsage       = errors.New("usage: smartid-git --status-fd=N (-bsau KEY | --verify SIGFILE -)")
+	errNoSignature = errors.New("no signature found")
+)
+
+// options are the gpg options used by git.
+type options struct {
+	sign, verify bool
+	localUser    string
+	statusFD     int
+	files        []string
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "smartid-git:", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command with the arguments.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	opts, err := parseArgs(args)
+	if err != nil {
+		return err
+	}
+	status := io.Discard
+	switch opts.statusFD {
+	case 0:
+	case 1:
+		status = stdout
+	case 2:
+		status = stderr
+	default:
+		f := os.NewFile(uintptr(opts.statusFD), "status")
+		defer f.Close()
+		status = f
+	}
+
+	switch {
+	case opts.sign && opts.localUser != "":
+		return sign(ctx, opts.localUser, stdin, stdout, status)
+	case opts.verify && len(opts.files) > 0:
+		return verify(ctx, opts.files, stdin, stderr, status)
+	default:
+		return errUsage
+	}
+}
+
+// parseArgs parses the subset of gpg arguments, which git uses. Short
+// options may be combined, like -bsau KEY.
+func parseArgs(args []string) (*options, error) {
+	opts := &options{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		value := func() (string, error) {
+			if k, v, ok := strings.Cut(arg, "="); ok && strings.HasPrefix(k, "--") {
+				return v, nil
+			}
+			if i+1 >= len(args) {
+				return "", errUsage
+			}
+			i++
+			return args[i], nil
+		}
+		var err error
+		switch name, _, _ := strings.Cut(arg, "="); name {
+		case "--status-fd":
+			var v string
+			if v, err = value(); err == nil {
+				opts.statusFD, err = strconv.Atoi(v)
+			}
+		case "--local-user", "-u":
+			opts.localUser, err = value()
+		case "--keyid-format":
+			_, err = value()
+		case "--sign", "-s":
+			opts.sign = true
+		case "--verify":
+			opts.verify = true
+		case "--detach-sign", "-b", "--armor", "-a":
+		default:
+			if len(arg) > 1 && arg[0] == '-' && arg[1] != '-' {
+				for _, c := range arg[1:] {
+					switch c {
+					case 's':
+						opts.sign = true
+					case 'u':
+						opts.localUser, err = value()
+					case 'b', 'a':
+					default:
+						return nil, errUsage
+					}
+				}
+			} else if arg == "-" || arg[0] != '-' {
+				opts.files = append(opts.files, arg)
+			} else {
+				return nil, errUsage
+			}
+		}
+		if err != nil {
+			return nil, errUsage
+		}
+	}
+	return opts, nil
+}
+
+// sign signs data from stdin and writes PEM encoded detached signature.
+func sign(ctx context.Context, identifier string, stdin io.Reader, stdout, status io.Writer) error {
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return err
+	}
+	digest, err := smartid.HashData(data, smartid.SHA256)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(status, "[GNUPG:] BEGIN_SIGNING")
+
+	ctx, cancel := context.WithTimeout(ctx, signTimeout)
+	defer cancel()
+	signer := smartid.NewSigner(smartid.NewClient(env("SMARTID_URL", defaultURL), 5000))
+	if url := os.Getenv("SMARTID_TSA_URL"); url != "" {
+		ts, err := trustStore()
+		if err != nil {
+			return err
+		}
+		signer.Timestamper = smartid.NewTSAClient(url, ts)
+	}
+	out := terminal()
+	defer out.Close()
+	b := &codeBuilder{
+		DocumentBuilder: smartid.NewCAdESDocumentBuilder(digest, nil),
+		out:             out,
+	}
+	p7s, resp, err := signer.Sign(ctx, &smartid.AuthRequest{
+		RelyingPartyUUID: os.Getenv("SMARTID_RP_UUID"),
+		RelyingPartyName: os.Getenv("SMARTID_RP_NAME"),
+		Identifier:       identifier,
+		AllowedInteractionsOrder: []smartid.AllowedInteractionsOrder{{
+			Type:          smartid.InteractionDisplayTextAndPIN,
+			DisplayText60: "Sign git " + objectType(data),
+		}},
+	}, b)
+	if err != nil {
+		return err
+	}
+
+	if err := pem.Encode(stdout, &pem.Block{Type: pemType, Bytes: p7s}); err != nil {
+		return err
+	}
+	fmt.Fprintf(status, "[GNUPG:] SIG_CREATED D 1 8 00 %d %s\n",
+		time.Now().Unix(), fingerprint(resp.Cert.GetX509Cert().Raw))
+	return nil
+}
+
+// verify verifies detached signature from the file over data from the
+// second file, or stdin if it is "-".
+func verify(ctx context.Context, files []string, stdin io.Reader, stderr, status io.Writer) error {
+	sig, err := os.ReadFile(files[0])
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(sig)
+	if block == nil || block.Type != pemType {
+		fmt.Fprintln(status, "[GNUPG:] NODATA 1")
+		return errNoSignature
+	}
+	var data []byte
+	if len(files) > 1 && files[1] != "-" {
+		data, err = os.ReadFile(files[1])
+	} else {
+		data, err = io.ReadAll(stdin)
+	}
+	if err != nil {
+		return err
+	}
+
+	ts, err := trustStore()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(status, "[GNUPG:] NEWSIG")
+	r := smartid.VerifyCAdES(ctx, block.Bytes, data, ts)
+	if r.Certificate == nil {
+		fmt.Fprintln(status, "[GNUPG:] ERRSIG 0000000000000000 1 8 00", time.Now().Unix(), 9)
+		return r.Err()
+	}
+
+	fpr := fingerprint(r.Certificate.Raw)
+	name := r.Identity.CommonName
+	signed := r.SigningTime
+	if !r.TrustedTime.IsZero() {
+		signed = r.TrustedTime
+	}
+	fmt.Fprintf(stderr, "smartid-git: Signature made %s\n", signed.Format(time.RFC1123))
+	fmt.Fprintf(stderr, "smartid-git: Signer %s (%s)\n", name, r.Identity.SerialNumber)
+	if !r.IsValid() {
+		fmt.Fprintf(status, "[GNUPG:] BADSIG %s %s\n", fpr, name)
+		fmt.Fprintln(stderr, "smartid-git: BAD signature:", r.Err())
+		return r.Err()
+	}
+	fmt.Fprintf(status, "[GNUPG:] GOODSIG %s %s\n", fpr, name)
+	fmt.Fprintf(status, "[GNUPG:] VALIDSIG %s %s %d\n", fpr, signed.Format("2006-01-02"), signed.Unix())
+	fmt.Fprintln(status, "[GNUPG:] TRUST_FULLY 0 shell")
+	fmt.Fprintln(stderr, "smartid-git: Good signature")
+	return nil
+}
+
+// codeBuilder shows verification code of the prepared digest.
+type codeBuilder struct {
+	smartid.DocumentBuilder
+	out io.Writer
+}
+
+func (b *codeBuilder) Prepare(cert *x509.Certificate) (*smartid.Digest, error) {
+	digest, err := b.DocumentBuilder.Prepare(cert)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(b.out, "Smart-ID verification code: %s\n", digest.CalculateVerificationCode())
+	fmt.Fprintln(b.out, "Confirm signing in the Smart-ID app.")
+	return digest, nil
+}
+
+// terminal returns the controlling terminal for messages, because git
+// captures stderr of the program. Stderr is used, if there is no
+// terminal, closing it does nothing.
+func terminal() io.WriteCloser {
+	if tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0); err == nil {
+		return tty
+	}
+	return nopCloser{os.Stderr}
+}
+
+// nopCloser is io.WriteCloser which does not close the writer.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// objectType returns the type of signed git object.
+func objectType(data []byte) string {
+	if bytes.HasPrefix(data, []byte("object ")) {
+		return "tag"
+	}
+	return "commit"
+}
+
+// fingerprint returns SHA-1 fingerprint of the certificate.
+func fingerprint(der []byte) string {
+	return fmt.Sprintf("%X", sha1.Sum(der))
+}
+
+// trustStore loads trusted CA certificates from SMARTID_TRUST files.
+func trustStore() (*smartid.TrustStore, error) {
+	paths := os.Getenv("SMARTID_TRUST")
+	if paths == "" {
+		return smartid.NewTrustStore(), nil
+	}
+	return smartid.NewTrustStoreFromPaths(filepath.SplitList(paths))
+}
+
+// env returns environment variable or the default.
+func env(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dknight/go-smartid"
+)
+
+func TestParseArgs(t *testing.T) {
+	testdata := []struct {
+		args []string
+		opts options
+	}{
+		{
+			[]string{"--status-fd=2", "-bsau", "PNOEE-30303039914"},
+			options{sign: true, localUser: "PNOEE-30303039914", statusFD: 2},
+		},
+		{
+			[]string{"--keyid-format=long", "--status-fd=1", "--verify", "sig.pem", "-"},
+			options{verify: true, statusFD: 1, files: []string{"sig.pem", "-"}},
+		},
+		{
+			[]string{"--status-fd", "3", "--detach-sign", "--sign", "--local-user", "X"},
+			options{sign: true, localUser: "X", statusFD: 3},
+		},
+	}
+	for _, test := range testdata {
+		opts, err := parseArgs(test.args)
+		if err != nil {
+			t.Fatal(test.args, err)
+		}
+		if opts.sign != test.opts.sign || opts.verify != test.opts.verify ||
+			opts.localUser != test.opts.localUser || opts.statusFD != test.opts.statusFD ||
+			strings.Join(opts.files, " ") != strings.Join(test.opts.files, " ") {
+			t.Error(test.args, "expected", test.opts, "got", *opts)
+		}
+	}
+
+	for _, args := range [][]string{{"-bsu"}, {"--status-fd=x"}, {"-x"}, {"--unknown"}} {
+		if _, err := parseArgs(args); err != errUsage {
+			t.Error(args, "expected", errUsage, "got", err)
+		}
+	}
+}
+
+// newTestCert creates certificate signed by the parent, self-signed if
+// parent is nil.
+func newTestCert(t *testing.T, cn string, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, _ := x509.OIDFromInts([]uint64{0, 4, 0, 194112, 1, 2})
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn, SerialNumber: "PNOEE-30303039914"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
+		Policies:     []x509.OID{policy},
+	}
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	ca, caKey := newTestCert(t, "TEST CA", nil, nil)
+	cert, key := newTestCert(t, "TESTNUMBER,OK", ca, caKey)
+	caFile := filepath.Join(dir, "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0o600)
+	t.Setenv("SMARTID_TRUST", caFile)
+
+	commit := []byte("tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n\nTest commit\n")
+	digest, _ := smartid.HashData(commit, smartid.SHA256)
+	sig, err := smartid.PrepareCAdESSignature(digest, cert, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sig.Digest().AuthHash())
+	err = sig.SetSignatureValue(smartid.Signature{
+		Value:     base64.StdEncoding.EncodeToString(value),
+		Algorithm: "sha256WithRSAEncryption",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7s, _ := sig.Bytes()
+	sigFile := filepath.Join(dir, "sig.pem")
+	os.WriteFile(sigFile, pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: p7s}), 0o600)
+
+	args := []string{"--keyid-format=long", "--status-fd=1", "--verify", sigFile, "-"}
+	var stdout, stderr bytes.Buffer
+	if err := run(ctx, args, bytes.NewReader(commit), &stdout, &stderr); err != nil {
+		t.Fatal(err, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "[GNUPG:] GOODSIG "+fingerprint(cert.Raw)+" TESTNUMBER,OK\n") {
+		t.Error("expected GOODSIG status, got", stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "[GNUPG:] TRUST_FULLY") {
+		t.Error("expected TRUST_FULLY status, got", stdout.String())
+	}
+
+	stdout.Reset()
+	modified := append([]byte(nil), commit...)
+	modified[len(modified)-2] = '!'
+	if err := run(ctx, args, bytes.NewReader(modified), &stdout, &stderr); err == nil {
+		t.Error("expected error of modified commit")
+	}
+	if !strings.Contains(stdout.String(), "[GNUPG:] BADSIG ") {
+		t.Error("expected BADSIG status, got", stdout.String())
+	}
+
+	os.WriteFile(sigFile, []byte("not signature"), 0o600)
+	if err := run(ctx, args, bytes.NewReader(commit), &stdout, &stderr); err != errNoSignature {
+		t.Error("expected", errNoSignature, "got", err)
+	}
+}
@@ -0,0 +1,1041 @@
+package smartid
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Object identifiers of CMS attributes and content types.
+var (
+	oidAttrContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttrMessageDigest        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttrSigningTime          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidAttrSigningCertificate   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 12}
+	oidAttrSigningCertificateV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	oidAttrTimeStampToken       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+	oidContentTypeTSTInfo       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+
+	// oidAttrRevocationInfoArchival is Adobe attribute for revocation
+	// values embedded in PDF signatures.
+	oidAttrRevocationInfoArchival = asn1.ObjectIdentifier{1, 2, 840, 113583, 1, 1, 8}
+
+	// CAdES unsigned attributes of long-term signatures (RFC 5126).
+	oidAttrCertValues         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 23}
+	oidAttrRevocationValues   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 24}
+	oidAttrArchiveTimestampV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 48}
+
+	// CAdES archive-time-stamp-v3 and its hash index, which is unsigned
+	// attribute of the timestamp token (ETSI EN 319 122-1).
+	oidAttrArchiveTimestampV3 = asn1.ObjectIdentifier{0, 4, 0, 1733, 2, 4}
+	oidAttrATSHashIndexV3     = asn1.ObjectIdentifier{0, 4, 0, 1733, 2, 5}
+)
+
+// Object identifiers of digest algorithms.
+var (
+	oidDigestSHA1     = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidDigestSHA256   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidDigestSHA384   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidDigestSHA512   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidDigestSHA3_256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 8}
+	oidDigestSHA3_384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 9}
+	oidDigestSHA3_512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 10}
+)
+
+// Object identifiers of signature algorithms.
+var (
+	oidPKCS1             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1}
+	oidRSAEncryption     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidRSAPSS            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	oidRSAWithSHA3_256   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 14}
+	oidRSAWithSHA3_384   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 15}
+	oidRSAWithSHA3_512   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 16}
+	oidANSIX962          = asn1.ObjectIdentifier{1, 2, 840, 10045}
+	oidECDSAWithSHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	oidECDSAWithSHA3_256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 10}
+	oidECDSAWithSHA3_384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 11}
+	oidECDSAWithSHA3_512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 12}
+)
+
+var (
+	// ErrCMSNoSigner error when SignedData has no signer info.
+	ErrCMSNoSigner = errors.New("CMS SignedData has no signers")
+
+	// ErrCMSSignerCertNotFound error when signer certificate is not
+	// embedded in SignedData.
+	ErrCMSSignerCertNotFound = errors.New("CMS signer certificate not found")
+
+	// ErrCMSMessageDigestMismatch error when messageDigest attribute does
+	// not match the content.
+	ErrCMSMessageDigestMismatch = errors.New("CMS message digest does not match content")
+
+	// ErrCMSContentTypeMismatch error when contentType attribute does not
+	// match the encapsulated content type.
+	ErrCMSContentTypeMismatch = errors.New("CMS content type does not match")
+
+	// ErrCMSAttributeMissing error when required signed attribute is
+	// missing.
+	ErrCMSAttributeMissing = errors.New("CMS signed attribute is missing")
+
+	// ErrCMSNotSigned error when signature value is not yet set.
+	ErrCMSNotSigned = errors.New("CMS signature value is not set")
+)
+
+// signerInfo is CMS SignerInfo.
+//
+//	SignerInfo ::= SEQUENCE {
+//		version CMSVersion,
+//		sid SignerIdentifier,
+//		digestAlgorithm DigestAlgorithmIdentifier,
+//		signedAttrs [0] IMPLICIT SignedAttributes OPTIONAL,
+//		signatureAlgorithm SignatureAlgorithmIdentifier,
+//		signature SignatureValue,
+//		unsignedAttrs [1] IMPLICIT UnsignedAttributes OPTIONAL }
+type signerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// issuerAndSerialNumber identifies the signer certificate.
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// attribute is CMS Attribute.
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// signerInfos parses signer infos of SignedData.
+func (sd *signedData) signerInfos() ([]signerInfo, error) {
+	var infos []signerInfo
+	rest := sd.SignerInfos.Bytes
+	for len(rest) > 0 {
+		var si signerInfo
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &si); err != nil {
+			return nil, err
+		}
+		infos = append(infos, si)
+	}
+	return infos, nil
+}
+
+// content returns encapsulated content, nil for detached signature.
+func (sd *signedData) content() ([]byte, error) {
+	if len(sd.EncapContentInfo.EContent.Bytes) == 0 {
+		return nil, nil
+	}
+	var data []byte
+	if _, err := asn1.Unmarshal(sd.EncapContentInfo.EContent.Bytes, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// verify verifies the first signer of SignedData over the content and
+// returns the signer certificate. For detached signatures content must be
+// given, otherwise encapsulated content is used.
+func (sd *signedData) verify(content []byte) (*x509.Certificate, *signerInfo, error) {
+	infos, err := sd.signerInfos()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(infos) == 0 {
+		return nil, nil, ErrCMSNoSigner
+	}
+	si := &infos[0]
+	certs, err := sd.certificates()
+	if err != nil {
+		return nil, nil, err
+	}
+	cert := si.findCertificate(certs)
+	if cert == nil {
+		return nil, si, ErrCMSSignerCertNotFound
+	}
+	if content == nil {
+		if content, err = sd.content(); err != nil {
+			return cert, si, err
+		}
+	}
+	algo, err := digestAlgorithmName(si.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return cert, si, err
+	}
+	digest, err := HashData(content, algo)
+	if err != nil {
+		return cert, si, err
+	}
+	return cert, si, si.verify(cert, sd.EncapContentInfo.EContentType, digest)
+}
+
+// verify verifies the signature of the signer with the certificate. The
+// digest is the digest of the content.
+func (si *signerInfo) verify(
+	cert *x509.Certificate,
+	contentType asn1.ObjectIdentifier,
+	digest *Digest,
+) error {
+	if len(si.SignedAttrs.Bytes) == 0 {
+		return verifySignatureScheme(cert, oidSignatureScheme(si.SignatureAlgorithm.Algorithm),
+			digest.CryptoHash(), digest.AuthHash(), si.Signature)
+	}
+
+	var ct asn1.ObjectIdentifier
+	if _, err := si.signedAttribute(oidAttrContentType, &ct); err != nil {
+		return err
+	}
+	if !ct.Equal(contentType) {
+		return ErrCMSContentTypeMismatch
+	}
+	var md []byte
+	if _, err := si.signedAttribute(oidAttrMessageDigest, &md); err != nil {
+		return err
+	}
+	if !bytes.Equal(md, digest.AuthHash()) {
+		return ErrCMSMessageDigestMismatch
+	}
+
+	attrsDigest, err := HashData(si.signedAttrsDER(), digest.Algorithm())
+	if err != nil {
+		return err
+	}
+	return verifySignatureScheme(cert, oidSignatureScheme(si.SignatureAlgorithm.Algorithm),
+		attrsDigest.CryptoHash(), attrsDigest.AuthHash(), si.Signature)
+}
+
+// signedAttrsDER returns DER encoding of signed attributes, which is
+// signed. The implicit [0] tag is replaced with SET OF.
+func (si *signerInfo) signedAttrsDER() []byte {
+	der := append([]byte(nil), si.SignedAttrs.FullBytes...)
+	der[0] = 0x31
+	return der
+}
+
+// signedAttribute unmarshals the first value of the signed attribute. If
+// attribute is missing, ErrCMSAttributeMissing is returned.
+func (si *signerInfo) signedAttribute(oid asn1.ObjectIdentifier, v interface{}) ([]byte, error) {
+	return findAttribute(si.SignedAttrs.Bytes, oid, v)
+}
+
+// unsignedAttribute unmarshals the first value of the unsigned attribute.
+func (si *signerInfo) unsignedAttribute(oid asn1.ObjectIdentifier, v interface{}) ([]byte, error) {
+	return findAttribute(si.UnsignedAttrs.Bytes, oid, v)
+}
+
+// checkSigningCertificate checks that signed signing certificate
+// reference matches the signer certificate. If the reference is required,
+// ErrCMSAttributeMissing is returned when neither signing-certificate nor
+// signing-certificate-v2 attribute is present.
+func (si *signerInfo) checkSigningCertificate(cert *x509.Certificate, required bool) error {
+	var v2 signingCertificateV2
+	if _, err := si.signedAttribute(oidAttrSigningCertificateV2, &v2); err == nil {
+		if len(v2.Certs) == 0 {
+			return ErrSigningCertMismatch
+		}
+		algo := SHA256
+		if oid := v2.Certs[0].HashAlgorithm.Algorithm; len(oid) > 0 {
+			if algo, err = digestAlgorithmName(oid); err != nil {
+				return err
+			}
+		}
+		sum, err := HashData(cert.Raw, algo)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(sum.AuthHash(), v2.Certs[0].CertHash) {
+			return ErrSigningCertMismatch
+		}
+		return nil
+	} else if err != ErrCMSAttributeMissing {
+		return err
+	}
+
+	var v1 signingCertificate
+	if _, err := si.signedAttribute(oidAttrSigningCertificate, &v1); err == nil {
+		sum := sha1.Sum(cert.Raw)
+		if len(v1.Certs) == 0 || !bytes.Equal(sum[:], v1.Certs[0].CertHash) {
+			return ErrSigningCertMismatch
+		}
+	} else if err != ErrCMSAttributeMissing || required {
+		return err
+	}
+	return nil
+}
+
+// signatureTimestamps verifies the signature timestamp tokens over the
+// signature value.
+func (si *signerInfo) signatureTimestamps(ctx context.Context, ts *TrustStore) ([]*TimestampToken, error) {
+	var tokens []*TimestampToken
+	for rest := si.UnsignedAttrs.Bytes; len(rest) > 0; {
+		var attr attribute
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+			return nil, err
+		}
+		if !attr.Type.Equal(oidAttrTimeStampToken) {
+			continue
+		}
+		for _, v := range attr.Values {
+			token, err := ParseTimestampToken(v.FullBytes)
+			if err != nil {
+				return nil, err
+			}
+			digest, err := HashData(si.Signature, token.Digest.Algorithm())
+			if err != nil {
+				return nil, err
+			}
+			if err := token.CheckDigest(digest); err != nil {
+				return nil, err
+			}
+			if _, err := token.Verify(ctx, ts); err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// revocationValues returns OCSP responses of Adobe revocation information
+// attribute and CAdES revocation values attributes.
+func (si *signerInfo) revocationValues() [][]byte {
+	var values [][]byte
+	var ria revocationInfoArchival
+	if _, err := si.signedAttribute(oidAttrRevocationInfoArchival, &ria); err == nil {
+		for _, v := range ria.OCSP {
+			values = append(values, v.FullBytes)
+		}
+	}
+	for _, raw := range si.unsignedAttributes(oidAttrRevocationValues) {
+		var rv revocationValues
+		if _, err := asn1.Unmarshal(raw, &rv); err != nil {
+			continue
+		}
+		for _, v := range rv.OCSPVals {
+			if der, err := ocspResponseFromBasic(v.FullBytes); err == nil {
+				values = append(values, der)
+			}
+		}
+	}
+	return values
+}
+
+// crlValues returns CRLs of CAdES revocation values attributes.
+func (si *signerInfo) crlValues() [][]byte {
+	var values [][]byte
+	for _, raw := range si.unsignedAttributes(oidAttrRevocationValues) {
+		var rv revocationValues
+		if _, err := asn1.Unmarshal(raw, &rv); err != nil {
+			continue
+		}
+		for _, v := range rv.CRLVals {
+			values = append(values, v.FullBytes)
+		}
+	}
+	return values
+}
+
+// certificateValues returns certificates of CAdES certificate values
+// attributes.
+func (si *signerInfo) certificateValues() []*x509.Certificate {
+	var certs []*x509.Certificate
+	for _, raw := range si.unsignedAttributes(oidAttrCertValues) {
+		var values []asn1.RawValue
+		if _, err := asn1.Unmarshal(raw, &values); err != nil {
+			continue
+		}
+		for _, v := range values {
+			if c, err := x509.ParseCertificate(v.FullBytes); err == nil {
+				certs = append(certs, c)
+			}
+		}
+	}
+	return certs
+}
+
+// unsignedAttributes returns values of all unsigned attributes with the
+// type.
+func (si *signerInfo) unsignedAttributes(oid asn1.ObjectIdentifier) [][]byte {
+	attrs, _ := splitAttributes(si.UnsignedAttrs.Bytes)
+	var values [][]byte
+	for _, raw := range attrs {
+		var attr attribute
+		if _, err := asn1.Unmarshal(raw, &attr); err != nil || !attr.Type.Equal(oid) {
+			continue
+		}
+		for _, v := range attr.Values {
+			values = append(values, v.FullBytes)
+		}
+	}
+	return values
+}
+
+// archiveTimestamps verifies archive-time-stamp-v3 and legacy
+// archive-time-stamp-v2 tokens of the signer. Content is the signed
+// content.
+func (si *signerInfo) archiveTimestamps(
+	ctx context.Context,
+	ts *TrustStore,
+	sd *signedData,
+	content []byte,
+) ([]*TimestampToken, error) {
+	attrs, err := splitAttributes(si.UnsignedAttrs.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []*TimestampToken
+	for i, raw := range attrs {
+		var attr attribute
+		if _, err := asn1.Unmarshal(raw, &attr); err != nil {
+			return nil, err
+		}
+		v3 := attr.Type.Equal(oidAttrArchiveTimestampV3)
+		if !v3 && !attr.Type.Equal(oidAttrArchiveTimestampV2) {
+			continue
+		}
+		for _, v := range attr.Values {
+			token, err := ParseTimestampToken(v.FullBytes)
+			if err != nil {
+				return nil, err
+			}
+			var data []byte
+			if v3 {
+				others := append(append([][]byte(nil), attrs[:i]...), attrs[i+1:]...)
+				data, err = archiveTimestampV3Data(sd, *si, content, others, token)
+			} else {
+				data, err = archiveTimestampData(sd, *si, content, attrs[:i])
+			}
+			if err != nil {
+				return nil, err
+			}
+			digest, err := HashData(data, token.Digest.Algorithm())
+			if err != nil {
+				return nil, err
+			}
+			if err := token.CheckDigest(digest); err != nil {
+				return nil, err
+			}
+			if _, err := token.Verify(ctx, ts); err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// archiveTimestampData returns data covered by archive-time-stamp-v2
+// (RFC 5126 section 6.4.1): the content, certificates and CRLs of
+// SignedData, fields of SignerInfo and the unsigned attributes, which
+// precede the timestamp, in the order they appear.
+func archiveTimestampData(sd *signedData, si signerInfo, content []byte, attrs [][]byte) ([]byte, error) {
+	fields, err := signerInfoFields(si)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Write(content)
+	buf.Write(sd.Certificates.FullBytes)
+	buf.Write(sd.CRLs.FullBytes)
+	buf.Write(fields)
+	for _, attr := range attrs {
+		buf.Write(attr)
+	}
+	return buf.Bytes(), nil
+}
+
+// archiveTimestampV3Data returns data covered by the archive-time-stamp-v3
+// token. Hash index of the token must list only the certificates, CRLs
+// and unsigned attribute values of the signature, attrs are the unsigned
+// attributes without the timestamp.
+func archiveTimestampV3Data(
+	sd *signedData,
+	si signerInfo,
+	content []byte,
+	attrs [][]byte,
+	token *TimestampToken,
+) ([]byte, error) {
+	infos, err := token.sd.signerInfos()
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, ErrCMSNoSigner
+	}
+	index, err := infos[0].unsignedAttribute(oidAttrATSHashIndexV3, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkATSHashIndexV3(index, sd, attrs); err != nil {
+		return nil, err
+	}
+	return atsV3Data(sd, si, content, token.Digest.Algorithm(), index)
+}
+
+// atsV3Data returns data covered by archive-time-stamp-v3 (ETSI EN 319
+// 122-1 section 5.5.3): content type, hash of the content, fields of
+// SignerInfo and the encoded ats-hash-index-v3.
+func atsV3Data(sd *signedData, si signerInfo, content []byte, algo string, index []byte) ([]byte, error) {
+	contentType, err := asn1.Marshal(sd.EncapContentInfo.EContentType)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := HashData(content, algo)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := signerInfoFields(si)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Write(contentType)
+	buf.Write(digest.AuthHash())
+	buf.Write(fields)
+	buf.Write(index)
+	return buf.Bytes(), nil
+}
+
+// signerInfoFields returns encoded fields of SignerInfo without the
+// unsigned attributes.
+func signerInfoFields(si signerInfo) ([]byte, error) {
+	si.UnsignedAttrs = asn1.RawValue{}
+	der, err := asn1.Marshal(si)
+	if err != nil {
+		return nil, err
+	}
+	var fields asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &fields); err != nil {
+		return nil, err
+	}
+	return fields.Bytes, nil
+}
+
+// atsHashIndexV3 is ats-hash-index-v3 attribute value.
+//
+//	ATSHashIndexV3 ::= SEQUENCE {
+//		hashIndAlgorithm AlgorithmIdentifier DEFAULT {algorithm id-sha256},
+//		certificatesHashIndex SEQUENCE OF OCTET STRING,
+//		crlsHashIndex SEQUENCE OF OCTET STRING,
+//		unsignedAttrValuesHashIndex SEQUENCE OF OCTET STRING }
+type atsHashIndexV3 struct {
+	HashIndAlgorithm            pkix.AlgorithmIdentifier `asn1:"optional"`
+	CertificatesHashIndex       [][]byte
+	CRLsHashIndex               [][]byte
+	UnsignedAttrValuesHashIndex [][]byte
+}
+
+// newATSHashIndexV3 encodes hash index of the certificates and CRLs of
+// SignedData and the unsigned attribute values of the signer.
+func newATSHashIndexV3(sd *signedData, attrs [][]byte, algo string) ([]byte, error) {
+	var index atsHashIndexV3
+	var err error
+	if index.CertificatesHashIndex, index.CRLsHashIndex, index.UnsignedAttrValuesHashIndex, err =
+		hashIndex(sd, attrs, algo); err != nil {
+		return nil, err
+	}
+	if algo != SHA256 {
+		index.HashIndAlgorithm.Algorithm = digestAlgorithmOIDs[algo]
+	}
+	return asn1.Marshal(index)
+}
+
+// checkATSHashIndexV3 checks that every hash of the encoded index is the
+// hash of a certificate, CRL or unsigned attribute value of the signature,
+// so the timestamp covers only the data present.
+func checkATSHashIndexV3(der []byte, sd *signedData, attrs [][]byte) error {
+	// Default hash algorithm is omitted, so the fields are parsed one by
+	// one.
+	var fields []asn1.RawValue
+	if rest, err := asn1.Unmarshal(der, &fields); err != nil || len(rest) > 0 {
+		return ErrTimestampImprintMismatch
+	}
+	algo := SHA256
+	if len(fields) == 4 {
+		var alg pkix.AlgorithmIdentifier
+		if _, err := asn1.Unmarshal(fields[0].FullBytes, &alg); err != nil {
+			return err
+		}
+		var err error
+		if algo, err = digestAlgorithmName(alg.Algorithm); err != nil {
+			return err
+		}
+		fields = fields[1:]
+	}
+	if len(fields) != 3 {
+		return ErrTimestampImprintMismatch
+	}
+	certs, crls, values, err := hashIndex(sd, attrs, algo)
+	if err != nil {
+		return err
+	}
+	for i, present := range [][][]byte{certs, crls, values} {
+		var hashes [][]byte
+		if _, err := asn1.Unmarshal(fields[i].FullBytes, &hashes); err != nil {
+			return err
+		}
+		for _, h := range hashes {
+			if !slices.ContainsFunc(present, func(p []byte) bool { return bytes.Equal(p, h) }) {
+				return ErrTimestampImprintMismatch
+			}
+		}
+	}
+	return nil
+}
+
+// hashIndex returns hashes of each certificate and CRL of SignedData and
+// of each unsigned attribute value, which is hashed with its type.
+func hashIndex(sd *signedData, attrs [][]byte, algo string) (certs, crls, values [][]byte, err error) {
+	hash := func(data []byte) ([]byte, error) {
+		d, err := HashData(data, algo)
+		if err != nil {
+			return nil, err
+		}
+		return d.AuthHash(), nil
+	}
+	for _, list := range []struct {
+		der    []byte
+		hashes *[][]byte
+	}{{sd.Certificates.Bytes, &certs}, {sd.CRLs.Bytes, &crls}} {
+		elems, err := splitAttributes(list.der)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for _, el := range elems {
+			h, err := hash(el)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			*list.hashes = append(*list.hashes, h)
+		}
+	}
+	for _, raw := range attrs {
+		var attr attribute
+		if _, err := asn1.Unmarshal(raw, &attr); err != nil {
+			return nil, nil, nil, err
+		}
+		attrType, err := asn1.Marshal(attr.Type)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for _, v := range attr.Values {
+			h, err := hash(append(attrType, v.FullBytes...))
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			values = append(values, h)
+		}
+	}
+	return certs, crls, values, nil
+}
+
+// splitAttributes splits encoded attributes keeping their order.
+func splitAttributes(attrs []byte) ([][]byte, error) {
+	var values [][]byte
+	for rest := attrs; len(rest) > 0; {
+		var raw asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &raw); err != nil {
+			return nil, err
+		}
+		values = append(values, raw.FullBytes)
+	}
+	return values, nil
+}
+
+// findCertificate finds signer certificate by issuer and serial number or
+// subject key identifier.
+func (si *signerInfo) findCertificate(certs []*x509.Certificate) *x509.Certificate {
+	if si.SID.Class == asn1.ClassContextSpecific && si.SID.Tag == 0 {
+		for _, c := range certs {
+			if bytes.Equal(c.SubjectKeyId, si.SID.Bytes) {
+				return c
+			}
+		}
+		return nil
+	}
+	var ias issuerAndSerialNumber
+	if _, err := asn1.Unmarshal(si.SID.FullBytes, &ias); err != nil {
+		return nil
+	}
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, ias.Issuer.FullBytes) &&
+			c.SerialNumber.Cmp(ias.SerialNumber) == 0 {
+			return c
+		}
+	}
+	return nil
+}
+
+// findAttribute finds attribute from the encoded attributes and unmarshals
+// its first value. The raw value is returned as well.
+func findAttribute(attrs []byte, oid asn1.ObjectIdentifier, v interface{}) ([]byte, error) {
+	for rest := attrs; len(rest) > 0; {
+		var attr attribute
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+			return nil, err
+		}
+		if !attr.Type.Equal(oid) || len(attr.Values) == 0 {
+			continue
+		}
+		raw := attr.Values[0].FullBytes
+		if v != nil {
+			if _, err := asn1.Unmarshal(raw, v); err != nil {
+				return nil, err
+			}
+		}
+		return raw, nil
+	}
+	return nil, ErrCMSAttributeMissing
+}
+
+// digestAlgorithmName returns hash type of the digest algorithm.
+func digestAlgorithmName(oid asn1.ObjectIdentifier) (string, error) {
+	for algo, o := range digestAlgorithmOIDs {
+		if o.Equal(oid) {
+			return algo, nil
+		}
+	}
+	return "", ErrHashUnsupported
+}
+
+// digestAlgorithmOIDs maps hash types to digest algorithm identifiers.
+var digestAlgorithmOIDs = map[string]asn1.ObjectIdentifier{
+	SHA256:   oidDigestSHA256,
+	SHA384:   oidDigestSHA384,
+	SHA512:   oidDigestSHA512,
+	SHA3_256: oidDigestSHA3_256,
+	SHA3_384: oidDigestSHA3_384,
+	SHA3_512: oidDigestSHA3_512,
+}
+
+// signingCertificateV2 is ESS signing certificate attribute (RFC 5035).
+//
+//	SigningCertificateV2 ::= SEQUENCE {
+//		certs    SEQUENCE OF ESSCertIDv2,
+//		policies SEQUENCE OF PolicyInformation OPTIONAL }
+type signingCertificateV2 struct {
+	Certs []essCertIDv2
+}
+
+// essCertIDv2 identifies the certificate by hash. Hash algorithm is
+// omitted for SHA-256, which is the default.
+type essCertIDv2 struct {
+	HashAlgorithm pkix.AlgorithmIdentifier `asn1:"optional"`
+	CertHash      []byte
+	IssuerSerial  issuerSerial `asn1:"optional"`
+}
+
+// signingCertificate is ESS signing certificate attribute with SHA-1 hash
+// (RFC 2634).
+type signingCertificate struct {
+	Certs []essCertID
+}
+
+// essCertID identifies the certificate by SHA-1 hash.
+type essCertID struct {
+	CertHash     []byte
+	IssuerSerial issuerSerial `asn1:"optional"`
+}
+
+// revocationInfoArchival is the value of Adobe revocation information
+// attribute.
+//
+//	RevocationInfoArchival ::= SEQUENCE {
+//		crl          [0] EXPLICIT SEQUENCE of CRLs OPTIONAL,
+//		ocsp         [1] EXPLICIT SEQUENCE of OCSPResponse OPTIONAL,
+//		otherRevInfo [2] EXPLICIT SEQUENCE of OtherRevInfo OPTIONAL }
+type revocationInfoArchival struct {
+	CRL   asn1.RawValue   `asn1:"optional,explicit,tag:0"`
+	OCSP  []asn1.RawValue `asn1:"optional,explicit,tag:1"`
+	Other asn1.RawValue   `asn1:"optional,explicit,tag:2"`
+}
+
+// revocationValues is CAdES revocation values attribute.
+//
+//	RevocationValues ::= SEQUENCE {
+//		crlVals      [0] SEQUENCE OF CertificateList OPTIONAL,
+//		ocspVals     [1] SEQUENCE OF BasicOCSPResponse OPTIONAL,
+//		otherRevVals [2] OtherRevVals OPTIONAL }
+type revocationValues struct {
+	CRLVals  []asn1.RawValue `asn1:"optional,explicit,tag:0"`
+	OCSPVals []asn1.RawValue `asn1:"optional,explicit,tag:1"`
+}
+
+// cmsSigner builds detached CAdES-BES SignedData with one signer. Digest
+// of the signed attributes is signed with Smart-ID.
+type cmsSigner struct {
+	cert          *x509.Certificate
+	chain         []*x509.Certificate
+	algo          string
+	contentType   asn1.ObjectIdentifier
+	signedAttrs   []byte
+	digest        *Digest
+	signature     []byte
+	unsignedAttrs [][]byte
+}
+
+// newCMSSigner makes signed attributes for the content digest: content
+// type, message digest, signing certificate and signing time, if not
+// zero. Digest of the attributes is signed with the same algorithm.
+func newCMSSigner(
+	contentType asn1.ObjectIdentifier,
+	contentDigest *Digest,
+	cert *x509.Certificate,
+	chain []*x509.Certificate,
+	signingTime time.Time,
+) (*cmsSigner, error) {
+	algo := contentDigest.Algorithm()
+	certDigest, err := HashData(cert.Raw, algo)
+	if err != nil {
+		return nil, err
+	}
+	certID := essCertIDv2{
+		CertHash:     certDigest.AuthHash(),
+		IssuerSerial: newIssuerSerial(cert),
+	}
+	if algo != SHA256 {
+		certID.HashAlgorithm = pkix.AlgorithmIdentifier{Algorithm: digestAlgorithmOIDs[algo]}
+	}
+
+	var attrs [][]byte
+	add := func(oid asn1.ObjectIdentifier, v interface{}) {
+		if err == nil {
+			var attr []byte
+			attr, err = marshalAttribute(oid, v)
+			attrs = append(attrs, attr)
+		}
+	}
+	add(oidAttrContentType, contentType)
+	add(oidAttrMessageDigest, []byte(contentDigest.AuthHash()))
+	add(oidAttrSigningCertificateV2, signingCertificateV2{Certs: []essCertIDv2{certID}})
+	if !signingTime.IsZero() {
+		add(oidAttrSigningTime, signingTime.UTC())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &cmsSigner{
+		cert:        cert,
+		chain:       chain,
+		algo:        algo,
+		contentType: contentType,
+		signedAttrs: joinSorted(attrs),
+	}
+	set, err := asn1.Marshal(asn1.RawValue{
+		Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: s.signedAttrs,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s.digest, err = HashData(set, algo); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// setSignature sets and verifies the signature value of the signed
+// attributes.
+func (s *cmsSigner) setSignature(sig []byte) error {
+	err := verifySignatureValue(s.cert, s.digest.CryptoHash(), s.digest.AuthHash(), sig)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.cert.PublicKey.(*ecdsa.PublicKey); ok {
+		sig = ecdsaSignatureASN1(sig)
+	}
+	s.signature = sig
+	return nil
+}
+
+// addTimestamp adds signature timestamp token over the signature value as
+// unsigned attribute (CAdES-T).
+func (s *cmsSigner) addTimestamp(ctx context.Context, ts Timestamper) error {
+	if s.signature == nil {
+		return ErrCMSNotSigned
+	}
+	digest, err := HashData(s.signature, s.algo)
+	if err != nil {
+		return err
+	}
+	der, err := ts.Timestamp(ctx, digest)
+	if err != nil {
+		return err
+	}
+	token, err := ParseTimestampToken(der)
+	if err != nil {
+		return err
+	}
+	if err := token.CheckDigest(digest); err != nil {
+		return err
+	}
+	attr, err := marshalAttribute(oidAttrTimeStampToken, asn1.RawValue{FullBytes: der})
+	if err != nil {
+		return err
+	}
+	s.unsignedAttrs = append(s.unsignedAttrs, attr)
+	return nil
+}
+
+// bytes encodes ContentInfo with detached SignedData.
+func (s *cmsSigner) bytes() ([]byte, error) {
+	if s.signature == nil {
+		return nil, ErrCMSNotSigned
+	}
+	sigAlgo, err := cmsSignatureAlgorithm(s.cert, s.algo)
+	if err != nil {
+		return nil, err
+	}
+	sid, err := asn1.Marshal(issuerAndSerialNumber{
+		Issuer:       asn1.RawValue{FullBytes: s.cert.RawIssuer},
+		SerialNumber: s.cert.SerialNumber,
+	})
+	if err != nil {
+		return nil, err
+	}
+	digestAlgo := pkix.AlgorithmIdentifier{Algorithm: digestAlgorithmOIDs[s.algo]}
+	si := signerInfo{
+		Version:         1,
+		SID:             asn1.RawValue{FullBytes: sid},
+		DigestAlgorithm: digestAlgo,
+		SignedAttrs: asn1.RawValue{
+			Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true,
+			Bytes: s.signedAttrs,
+		},
+		SignatureAlgorithm: sigAlgo,
+		Signature:          s.signature,
+	}
+	if len(s.unsignedAttrs) > 0 {
+		si.UnsignedAttrs = asn1.RawValue{
+			Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true,
+			Bytes: joinSorted(s.unsignedAttrs),
+		}
+	}
+	siBytes, err := asn1.Marshal(si)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []byte
+	for _, c := range append([]*x509.Certificate{s.cert}, s.chain...) {
+		certs = append(certs, c.Raw...)
+	}
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlgo},
+		EncapContentInfo: encapsulatedContentInfo{EContentType: s.contentType},
+		Certificates: asn1.RawValue{
+			Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs,
+		},
+		SignerInfos: asn1.RawValue{
+			Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: siBytes,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidContentTypeSignedData,
+		Content: asn1.RawValue{
+			Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd,
+		},
+	})
+}
+
+// marshalAttribute encodes attribute with one value.
+func marshalAttribute(oid asn1.ObjectIdentifier, v interface{}) ([]byte, error) {
+	value, err := asn1.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(attribute{Type: oid, Values: []asn1.RawValue{{FullBytes: value}}})
+}
+
+// joinSorted joins DER encoded elements in the order required for SET OF.
+func joinSorted(elems [][]byte) []byte {
+	sorted := append([][]byte(nil), elems...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+	return bytes.Join(sorted, nil)
+}
+
+// cmsSignatureAlgorithm returns signature algorithm identifier for the key
+// of the certificate.
+func cmsSignatureAlgorithm(cert *x509.Certificate, algo string) (pkix.AlgorithmIdentifier, error) {
+	switch cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return pkix.AlgorithmIdentifier{
+			Algorithm:  oidRSAEncryption,
+			Parameters: asn1.NullRawValue,
+		}, nil
+	case *ecdsa.PublicKey:
+		oid, ok := map[string]asn1.ObjectIdentifier{
+			SHA256:   oidECDSAWithSHA256,
+			SHA384:   oidECDSAWithSHA384,
+			SHA512:   oidECDSAWithSHA512,
+			SHA3_256: oidECDSAWithSHA3_256,
+			SHA3_384: oidECDSAWithSHA3_384,
+			SHA3_512: oidECDSAWithSHA3_512,
+		}[algo]
+		if !ok {
+			return pkix.AlgorithmIdentifier{}, ErrHashUnsupported
+		}
+		return pkix.AlgorithmIdentifier{Algorithm: oid}, nil
+	default:
+		return pkix.AlgorithmIdentifier{}, ErrSignatureKeyUnsupported
+	}
+}
+
+// oidSignatureScheme returns the scheme of the signature algorithm, zero
+// if it is unknown.
+func oidSignatureScheme(oid asn1.ObjectIdentifier) signatureScheme {
+	hasPrefix := func(prefix asn1.ObjectIdentifier) bool {
+		return len(oid) > len(prefix) && prefix.Equal(oid[:len(prefix)])
+	}
+	switch {
+	case oid.Equal(oidRSAPSS):
+		return schemePSS
+	case hasPrefix(oidPKCS1), oid.Equal(oidRSAWithSHA3_256),
+		oid.Equal(oidRSAWithSHA3_384), oid.Equal(oidRSAWithSHA3_512):
+		return schemePKCS1v15
+	case hasPrefix(oidANSIX962), oid.Equal(oidECDSAWithSHA3_256),
+		oid.Equal(oidECDSAWithSHA3_384), oid.Equal(oidECDSAWithSHA3_512):
+		return schemeECDSA
+	default:
+		return 0
+	}
+}
+
+// ecdsaSignatureASN1 converts raw r||s ECDSA signature to ASN.1 encoding.
+// Already encoded signature is returned as is.
+func ecdsaSignatureASN1(sig []byte) []byte {
+	var v struct{ R, S *big.Int }
+	if rest, err := asn1.Unmarshal(sig, &v); err == nil && len(rest) == 0 {
+		return sig
+	}
+	v.R = new(big.Int).SetBytes(sig[:len(sig)/2])
+	v.S = new(big.Int).SetBytes(sig[len(sig)/2:])
+	der, _ := asn1.Marshal(v)
+	return der
+}
@@ -0,0 +1,145 @@
+package smartid
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"sort"
+	"testing"
+)
+
+// testSignedData makes CMS SignedData with contentType and messageDigest
+// signed attributes and the extra attributes. Content is encapsulated
+// unless detached.
+func testSignedData(
+	t *testing.T,
+	contentType asn1.ObjectIdentifier,
+	content []byte,
+	detached bool,
+	cert *x509.Certificate,
+	key *rsa.PrivateKey,
+	extra ...[]byte,
+) []byte {
+	t.Helper()
+	digest, _ := HashData(content, SHA256)
+	attrs := append([][]byte{
+		testAttribute(t, oidAttrContentType, contentType),
+		testAttribute(t, oidAttrMessageDigest, []byte(digest.AuthHash())),
+	}, extra...)
+	sort.Slice(attrs, func(i, j int) bool { return bytes.Compare(attrs[i], attrs[j]) < 0 })
+	attrsBytes := bytes.Join(attrs, nil)
+
+	signedAttrs := asn1.RawValue{
+		Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrsBytes,
+	}
+	full, _ := asn1.Marshal(signedAttrs)
+	full[0] = 0x31
+	attrsDigest, _ := HashData(full, SHA256)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, attrsDigest.AuthHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sid, _ := asn1.Marshal(issuerAndSerialNumber{
+		Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+		SerialNumber: cert.SerialNumber,
+	})
+	si, err := asn1.Marshal(signerInfo{
+		Version:            1,
+		SID:                asn1.RawValue{FullBytes: sid},
+		DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidDigestSHA256},
+		SignedAttrs:        signedAttrs,
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}},
+		Signature:          sig,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eci := encapsulatedContentInfo{EContentType: contentType}
+	if !detached {
+		octets, _ := asn1.Marshal(content)
+		eci.EContent = asn1.RawValue{
+			Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: octets,
+		}
+	}
+	sdBytes, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidDigestSHA256}},
+		EncapContentInfo: eci,
+		Certificates: asn1.RawValue{
+			Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cert.Raw,
+		},
+		SignerInfos: asn1.RawValue{
+			Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: si,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(contentInfo{
+		ContentType: oidContentTypeSignedData,
+		Content: asn1.RawValue{
+			Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sdBytes,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// testAttribute marshals CMS attribute with one value.
+func testAttribute(t *testing.T, oid asn1.ObjectIdentifier, v interface{}) []byte {
+	t.Helper()
+	value, err := asn1.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(attribute{Type: oid, Values: []asn1.RawValue{{FullBytes: value}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestSignedData_verify(t *testing.T) {
+	ca, caKey := newTestCA(t, "TEST of EID-SK 2016")
+	cert, key := newTestLeaf(t, signTemplate(), ca, caKey)
+	content := []byte("Hello, Smart-ID!")
+
+	sd, err := parseSignedData(testSignedData(t, oidContentTypeData, content, false, cert, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, _, err := sd.verify(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !signer.Equal(cert) {
+		t.Error("expected signer certificate")
+	}
+
+	sd, _ = parseSignedData(testSignedData(t, oidContentTypeData, content, true, cert, key))
+	if _, _, err := sd.verify(content); err != nil {
+		t.Error("expected", nil, "got", err)
+	}
+	if _, _, err := sd.verify([]byte("tampered")); err != ErrCMSMessageDigestMismatch {
+		t.Error("expected", ErrCMSMessageDigestMismatch, "got", err)
+	}
+
+	sd.EncapContentInfo.EContentType = oidContentTypeTSTInfo
+	if _, _, err := sd.verify(content); err != ErrCMSContentTypeMismatch {
+		t.Error("expected", ErrCMSContentTypeMismatch, "got", err)
+	}
+
+	sd, _ = parseSignedData(testSignedData(t, oidContentTypeData, content, false, cert, key))
+	sd.Certificates = asn1.RawValue{}
+	if _, _, err := sd.verify(nil); err != ErrCMSSignerCertNotFound {
+		t.Error("expected", ErrCMSSignerCertNotFound, "got", err)
+	}
+}
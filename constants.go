@@ -23,10 +23,11 @@ const (
 	InteractionConfirmationMessageAndVerificationCodeChoice = "confirmationMessageAndVerificationCodeChoice"
 )
 
-// API endpoints. There are currently supported 2 endpoints for requests.
+// API endpoints. There are currently supported 3 endpoints for requests.
 const (
-	EndpointAuthentication = "authentication" // default
-	EndpointSignature      = "signature"
+	EndpointAuthentication    = "authentication" // default
+	EndpointSignature         = "signature"
+	EndpointCertificateChoice = "certificatechoice"
 	// EndpointPrivate     = "private" // TODO Not implemenented yet
 )
 
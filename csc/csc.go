@@ -0,0 +1,202 @@
+// Package csc exposes Smart-ID accounts as remote signing credentials of
+// the Cloud Signature Consortium API v2 (CSC API), so signing platforms
+// speaking the CSC API can sign with Smart-ID.
+//
+// Credential ID is the document number of the Smart-ID account. The
+// credentials/list method looks the account up by semantic identifier of
+// the user with certificate choice. The chosen certificate is cached, so
+// the user confirms the choice once, and signing sessions must return the
+// certificate chosen for the SAD. Other methods accept only the credentials
+// listed before and never start certificate choice. Authorization is
+// SCAL2: credentials/authorize binds the signature activation data (SAD)
+// to the hash and returns the verification code, which the platform shows
+// to the user. The user confirms signing, after comparing the code, in
+// the Smart-ID app during signatures/signHash.
+//
+// Calling platforms must be authenticated: credentials/list sends the
+// certificate choice to the phone of any person. NewServer requires the
+// Authenticator, which is checked for all methods but info.
+package csc
+
+import (
+	"crypto"
+	"errors"
+)
+
+// Specs is the implemented version of the CSC API specification.
+const Specs = "2.0.0.2"
+
+// AuthModeImplicit is the authorization mode of the credentials: the user
+// is authorized by Smart-ID, not by the signing platform.
+const AuthModeImplicit = "implicit"
+
+// Error codes of the CSC API.
+const (
+	ErrorInvalidRequest = "invalid_request"
+	ErrorAccessDenied   = "access_denied"
+	ErrorServer         = "server_error"
+)
+
+// OIDs of the key and signature algorithms.
+const (
+	OIDRSAEncryption           = "1.2.840.113549.1.1.1"
+	OIDSHA256WithRSAEncryption = "1.2.840.113549.1.1.11"
+	OIDSHA384WithRSAEncryption = "1.2.840.113549.1.1.12"
+	OIDSHA512WithRSAEncryption = "1.2.840.113549.1.1.13"
+)
+
+var (
+	// ErrSADInvalid error when SAD is unknown, expired or for other
+	// credential or hash.
+	ErrSADInvalid = errors.New("Invalid SAD")
+
+	// ErrNumSignatures error when more than one signature is authorized.
+	ErrNumSignatures = errors.New("Only one signature can be authorized")
+
+	// ErrCredentialNotListed error when credential is not listed by
+	// credentials/list or its certificate choice has expired.
+	ErrCredentialNotListed = errors.New("Credential is not listed")
+
+	// ErrUnauthenticated error when Authenticator rejects the platform.
+	ErrUnauthenticated = errors.New("Platform is not authenticated")
+)
+
+// hashAlgorithms are hash functions of the hash algorithm OIDs.
+var hashAlgorithms = map[string]crypto.Hash{
+	"2.16.840.1.101.3.4.2.1":  crypto.SHA256,
+	"2.16.840.1.101.3.4.2.2":  crypto.SHA384,
+	"2.16.840.1.101.3.4.2.3":  crypto.SHA512,
+	"2.16.840.1.101.3.4.2.8":  crypto.SHA3_256,
+	"2.16.840.1.101.3.4.2.9":  crypto.SHA3_384,
+	"2.16.840.1.101.3.4.2.10": crypto.SHA3_512,
+}
+
+// signAlgorithms are hash functions implied by the signature algorithm
+// OIDs. Plain rsaEncryption needs hash algorithm OID.
+var signAlgorithms = map[string]crypto.Hash{
+	OIDRSAEncryption:           0,
+	OIDSHA256WithRSAEncryption: crypto.SHA256,
+	OIDSHA384WithRSAEncryption: crypto.SHA384,
+	OIDSHA512WithRSAEncryption: crypto.SHA512,
+}
+
+// Error is the error response of the CSC API.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// Info is the response of info method.
+type Info struct {
+	Specs       string   `json:"specs"`
+	Name        string   `json:"name"`
+	Logo        string   `json:"logo"`
+	Region      string   `json:"region"`
+	Lang        string   `json:"lang"`
+	Description string   `json:"description"`
+	AuthType    []string `json:"authType"`
+	OAuth2      string   `json:"oauth2,omitempty"`
+	Methods     []string `json:"methods"`
+}
+
+// CredentialsListRequest is the request of credentials/list method.
+type CredentialsListRequest struct {
+	UserID         string `json:"userID"`
+	CredentialInfo bool   `json:"credentialInfo,omitempty"`
+	Certificates   string `json:"certificates,omitempty"`
+	CertInfo       bool   `json:"certInfo,omitempty"`
+	AuthInfo       bool   `json:"authInfo,omitempty"`
+	ClientData     string `json:"clientData,omitempty"`
+}
+
+// CredentialsListResponse is the response of credentials/list method.
+type CredentialsListResponse struct {
+	CredentialIDs   []string          `json:"credentialIDs"`
+	CredentialInfos []*CredentialInfo `json:"credentialInfos,omitempty"`
+}
+
+// CredentialsInfoRequest is the request of credentials/info method.
+type CredentialsInfoRequest struct {
+	CredentialID string `json:"credentialID"`
+	Certificates string `json:"certificates,omitempty"`
+	CertInfo     bool   `json:"certInfo,omitempty"`
+	AuthInfo     bool   `json:"authInfo,omitempty"`
+	ClientData   string `json:"clientData,omitempty"`
+}
+
+// CredentialInfo is the response of credentials/info method.
+type CredentialInfo struct {
+	CredentialID       string    `json:"credentialID,omitempty"`
+	Description        string    `json:"description,omitempty"`
+	SignatureQualifier string    `json:"signatureQualifier,omitempty"`
+	Key                KeyInfo   `json:"key"`
+	Cert               CertInfo  `json:"cert"`
+	Auth               *AuthInfo `json:"auth,omitempty"`
+	SCAL               string    `json:"SCAL"`
+	Multisign          int       `json:"multisign"`
+	Lang               string    `json:"lang,omitempty"`
+}
+
+// KeyInfo is the signing key of the credential.
+type KeyInfo struct {
+	Status string   `json:"status"`
+	Algo   []string `json:"algo"`
+	Len    int      `json:"len"`
+}
+
+// CertInfo is the certificate of the credential. Certificates are base64
+// encoded DER certificates, end entity first.
+type CertInfo struct {
+	Status       string   `json:"status,omitempty"`
+	Certificates []string `json:"certificates,omitempty"`
+	IssuerDN     string   `json:"issuerDN,omitempty"`
+	SerialNumber string   `json:"serialNumber,omitempty"`
+	SubjectDN    string   `json:"subjectDN,omitempty"`
+	ValidFrom    string   `json:"validFrom,omitempty"`
+	ValidTo      string   `json:"validTo,omitempty"`
+}
+
+// AuthInfo is the authorization mode of the credential.
+type AuthInfo struct {
+	Mode string `json:"mode"`
+}
+
+// AuthorizeRequest is the request of credentials/authorize method.
+type AuthorizeRequest struct {
+	CredentialID     string   `json:"credentialID"`
+	NumSignatures    int      `json:"numSignatures"`
+	Hashes           []string `json:"hashes"`
+	HashAlgorithmOID string   `json:"hashAlgorithmOID"`
+	Description      string   `json:"description,omitempty"`
+	ClientData       string   `json:"clientData,omitempty"`
+}
+
+// AuthorizeResponse is the response of credentials/authorize method.
+// VerificationCode is extension of the CSC API, the platform shows it to
+// the user before signing.
+type AuthorizeResponse struct {
+	SAD              string `json:"SAD"`
+	ExpiresIn        int    `json:"expiresIn"`
+	VerificationCode string `json:"verificationCode"`
+}
+
+// SignHashRequest is the request of signatures/signHash method.
+type SignHashRequest struct {
+	CredentialID     string   `json:"credentialID"`
+	SAD              string   `json:"SAD"`
+	Hashes           []string `json:"hashes"`
+	HashAlgorithmOID string   `json:"hashAlgorithmOID,omitempty"`
+	SignAlgo         string   `json:"signAlgo"`
+	SignAlgoParams   string   `json:"signAlgoParams,omitempty"`
+	OperationMode    string   `json:"operationMode,omitempty"`
+	ClientData       string   `json:"clientData,omitempty"`
+}
+
+// SignHashResponse is the response of signatures/signHash method.
+type SignHashResponse struct {
+	Signatures []string `json:"signatures"`
+}
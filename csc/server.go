@@ -0,0 +1,474 @@
+package csc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dknight/go-smartid"
+	"github.com/dknight/go-smartid/internal/httputil"
+)
+
+// Defaults of the server.
+const (
+	DefaultSADLifetime        = 5 * time.Minute
+	DefaultCredentialLifetime = time.Hour
+)
+
+// maxRequestSize limits the size of the request body.
+const maxRequestSize = 64 << 10
+
+// Limits of the SADs and cached credentials kept by the server. When full,
+// entry which expires first is dropped.
+const (
+	maxSADs        = 1024
+	maxCredentials = 1024
+)
+
+// generalizedTime is the layout of certificate validity dates.
+const generalizedTime = "20060102150405Z"
+
+// methods are the CSC API methods of the server.
+var methods = []string{
+	"info",
+	"credentials/list",
+	"credentials/info",
+	"credentials/authorize",
+	"signatures/signHash",
+}
+
+// Authenticator authenticates the calling signing platform. Request is
+// rejected with access_denied, if error is returned.
+type Authenticator func(r *http.Request) error
+
+// Server is http.Handler of the CSC API methods. Methods are served at
+// the paths relative to the base URI, use http.StripPrefix to mount it:
+//
+//	mux.Handle("/csc/v2/", http.StripPrefix("/csc/v2", csc.NewServer(c, req, auth)))
+type Server struct {
+	// Info is returned by the info method. Specs and methods are set by
+	// the server.
+	Info Info
+
+	// SADLifetime is the lifetime of the SAD, DefaultSADLifetime if zero.
+	SADLifetime time.Duration
+
+	// CredentialLifetime is how long the chosen certificate of the
+	// credential is cached, DefaultCredentialLifetime if zero.
+	CredentialLifetime time.Duration
+
+	client *smartid.Client
+	req    smartid.AuthRequest
+	auth   Authenticator
+	mux    *http.ServeMux
+
+	mu          sync.Mutex
+	sads        map[string]*authorization
+	credentials map[string]*credential
+}
+
+// authorization is the SAD of the credential, bound to the hash and the
+// certificate chosen for the credential.
+type authorization struct {
+	credentialID string
+	signer       *smartid.KeySigner
+	hash         crypto.Hash
+	digest       []byte
+	expires      time.Time
+}
+
+// credential is the cached certificate choice of the account.
+type credential struct {
+	signer  *smartid.KeySigner
+	expires time.Time
+}
+
+// NewServer creates server, which signs with the client. Request is the
+// template of Smart-ID requests: relying party, certificate level and
+// interactions. All methods but info require the platform to be
+// authenticated by auth, it panics if auth is nil.
+func NewServer(c *smartid.Client, req *smartid.AuthRequest, auth Authenticator) *Server {
+	if auth == nil {
+		panic("csc: nil authenticator")
+	}
+	s := &Server{
+		Info: Info{
+			Name:     req.RelyingPartyName,
+			Region:   "EE",
+			Lang:     "en-US",
+			AuthType: []string{"external"},
+		},
+		client:      c,
+		req:         *req,
+		auth:        auth,
+		mux:         http.NewServeMux(),
+		sads:        make(map[string]*authorization),
+		credentials: make(map[string]*credential),
+	}
+	s.mux.HandleFunc("POST /info", s.handleInfo)
+	s.mux.HandleFunc("POST /credentials/list", s.handleCredentialsList)
+	s.mux.HandleFunc("POST /credentials/info", s.handleCredentialsInfo)
+	s.mux.HandleFunc("POST /credentials/authorize", s.handleAuthorize)
+	s.mux.HandleFunc("POST /signatures/signHash", s.handleSignHash)
+	return s
+}
+
+// ServeHTTP serves the CSC API methods.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/info" && s.auth(r) != nil {
+		writeError(w, ErrUnauthenticated)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	info := s.Info
+	info.Specs = Specs
+	info.Methods = methods
+	httputil.WriteJSON(w, http.StatusOK, info)
+}
+
+func (s *Server) handleCredentialsList(w http.ResponseWriter, r *http.Request) {
+	var req CredentialsListRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.UserID == "" {
+		writeError(w, invalidParameter("userID"))
+		return
+	}
+	signer, err := s.chooseSigner(r.Context(), req.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	resp := CredentialsListResponse{
+		CredentialIDs: []string{signer.DocumentNumber()},
+	}
+	if req.CredentialInfo {
+		info := s.credentialInfo(signer, req.Certificates, req.CertInfo, req.AuthInfo)
+		info.CredentialID = signer.DocumentNumber()
+		resp.CredentialInfos = []*CredentialInfo{info}
+	}
+	httputil.WriteJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleCredentialsInfo(w http.ResponseWriter, r *http.Request) {
+	var req CredentialsInfoRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.CredentialID == "" {
+		writeError(w, invalidParameter("credentialID"))
+		return
+	}
+	signer, err := s.listedSigner(req.CredentialID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, s.credentialInfo(signer, req.Certificates, req.CertInfo, req.AuthInfo))
+}
+
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	var req AuthorizeRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.CredentialID == "" {
+		writeError(w, invalidParameter("credentialID"))
+		return
+	}
+	if req.NumSignatures != 1 || len(req.Hashes) != 1 {
+		writeError(w, &Error{Code: ErrorInvalidRequest, Description: ErrNumSignatures.Error()})
+		return
+	}
+	h, ok := hashAlgorithms[req.HashAlgorithmOID]
+	if !ok {
+		writeError(w, invalidParameter("hashAlgorithmOID"))
+		return
+	}
+	digest, err := base64.StdEncoding.DecodeString(req.Hashes[0])
+	if err != nil || len(digest) != h.Size() {
+		writeError(w, invalidParameter("hashes"))
+		return
+	}
+	signer, err := s.listedSigner(req.CredentialID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	sad, err := httputil.RandomString()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	lifetime := s.SADLifetime
+	if lifetime == 0 {
+		lifetime = DefaultSADLifetime
+	}
+	s.mu.Lock()
+	s.removeExpired()
+	httputil.Evict(s.sads, maxSADs, func(a *authorization) time.Time { return a.expires })
+	s.sads[sad] = &authorization{
+		credentialID: req.CredentialID,
+		signer:       signer,
+		hash:         h,
+		digest:       digest,
+		expires:      time.Now().Add(lifetime),
+	}
+	s.mu.Unlock()
+
+	httputil.WriteJSON(w, http.StatusOK, AuthorizeResponse{
+		SAD:              sad,
+		ExpiresIn:        int(lifetime.Seconds()),
+		VerificationCode: smartid.AuthHash(digest).CalculateVerificationCode(),
+	})
+}
+
+func (s *Server) handleSignHash(w http.ResponseWriter, r *http.Request) {
+	var req SignHashRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.OperationMode != "" && req.OperationMode != "S" {
+		writeError(w, invalidParameter("operationMode"))
+		return
+	}
+	h, ok := signAlgorithms[req.SignAlgo]
+	if !ok {
+		writeError(w, invalidParameter("signAlgo"))
+		return
+	}
+	if req.HashAlgorithmOID != "" || h == 0 {
+		ah, ok := hashAlgorithms[req.HashAlgorithmOID]
+		if !ok || (h != 0 && ah != h) {
+			writeError(w, invalidParameter("hashAlgorithmOID"))
+			return
+		}
+		h = ah
+	}
+	if len(req.Hashes) != 1 {
+		writeError(w, invalidParameter("hashes"))
+		return
+	}
+	digest, err := base64.StdEncoding.DecodeString(req.Hashes[0])
+	if err != nil {
+		writeError(w, invalidParameter("hashes"))
+		return
+	}
+	a, err := s.useSAD(req.SAD, req.CredentialID, h, digest)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// Signing session of the document number must return the certificate
+	// chosen when the SAD was issued.
+	sig, err := a.signer.SignContext(r.Context(), digest, h)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, SignHashResponse{
+		Signatures: []string{base64.StdEncoding.EncodeToString(sig)},
+	})
+}
+
+// useSAD checks that SAD authorizes signing the digest with the credential
+// and returns the authorization. SAD can be used once.
+func (s *Server) useSAD(sad, credentialID string, h crypto.Hash, digest []byte) (*authorization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeExpired()
+	a, ok := s.sads[sad]
+	if !ok || a.credentialID != credentialID || a.hash != h ||
+		subtle.ConstantTimeCompare(a.digest, digest) != 1 {
+		return nil, &Error{Code: ErrorInvalidRequest, Description: ErrSADInvalid.Error()}
+	}
+	delete(s.sads, sad)
+	return a, nil
+}
+
+// removeExpired removes expired SADs and credentials, lock must be held.
+func (s *Server) removeExpired() {
+	httputil.RemoveExpired(s.sads, func(a *authorization) time.Time { return a.expires })
+	httputil.RemoveExpired(s.credentials, func(c *credential) time.Time { return c.expires })
+}
+
+// cachedSigner returns the cached signer of the credential key.
+func (s *Server) cachedSigner(key string) (*smartid.KeySigner, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeExpired()
+	c, ok := s.credentials[key]
+	if !ok {
+		return nil, false
+	}
+	return c.signer, true
+}
+
+// listedSigner returns the signer of the credential listed by
+// credentials/list. Certificate choice is not started, so only the user
+// can be asked to choose the certificate.
+func (s *Server) listedSigner(credentialID string) (*smartid.KeySigner, error) {
+	signer, ok := s.cachedSigner(smartid.AuthTypeDocument + ":" + credentialID)
+	if !ok {
+		return nil, &Error{Code: ErrorInvalidRequest, Description: ErrCredentialNotListed.Error()}
+	}
+	return signer, nil
+}
+
+// chooseSigner returns the signer of the user by semantic identifier. The
+// user confirms the certificate choice in the app, so the chosen
+// certificate is cached by the identifier and by the document number.
+func (s *Server) chooseSigner(ctx context.Context, userID string) (*smartid.KeySigner, error) {
+	key := smartid.AuthTypeEtsi + ":" + userID
+	if signer, ok := s.cachedSigner(key); ok {
+		return signer, nil
+	}
+
+	req := s.req
+	req.AuthType = smartid.AuthTypeEtsi
+	req.Identifier = userID
+	choice, err := s.client.ChooseCertificateSync(ctx, &req)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := smartid.NewKeySigner(s.client, &s.req, choice)
+	if err != nil {
+		return nil, err
+	}
+	lifetime := s.CredentialLifetime
+	if lifetime == 0 {
+		lifetime = DefaultCredentialLifetime
+	}
+	c := &credential{signer: signer, expires: time.Now().Add(lifetime)}
+	s.mu.Lock()
+	expires := func(c *credential) time.Time { return c.expires }
+	httputil.Evict(s.credentials, maxCredentials-1, expires)
+	s.credentials[key] = c
+	s.credentials[smartid.AuthTypeDocument+":"+signer.DocumentNumber()] = c
+	s.mu.Unlock()
+	return signer, nil
+}
+
+// credentialInfo returns credential info of the signer. Certificates are
+// "none", "single" (default) or "chain". Smart-ID returns only the end
+// entity certificate, so chain is the same as single.
+func (s *Server) credentialInfo(signer *smartid.KeySigner, certificates string, certInfo, authInfo bool) *CredentialInfo {
+	cert := signer.Certificate()
+	info := &CredentialInfo{
+		Description:        "Smart-ID " + signer.DocumentNumber(),
+		SignatureQualifier: "eu_eidas_aes",
+		Key: KeyInfo{
+			Status: "enabled",
+			Algo: []string{
+				OIDRSAEncryption,
+				OIDSHA256WithRSAEncryption,
+				OIDSHA384WithRSAEncryption,
+				OIDSHA512WithRSAEncryption,
+			},
+		},
+		Cert: CertInfo{Status: "valid"},
+		SCAL: "2",
+		// Each signature is confirmed by the user in the app.
+		Multisign: 1,
+		Lang:      s.Info.Lang,
+	}
+	if s.req.CertificateLevel == "" || s.req.CertificateLevel == smartid.CertLevelQualified {
+		info.SignatureQualifier = "eu_eidas_qes"
+	}
+	if key, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+		info.Key.Len = key.N.BitLen()
+	}
+	now := time.Now()
+	switch {
+	case now.After(cert.NotAfter):
+		info.Cert.Status = "expired"
+	case now.Before(cert.NotBefore):
+		info.Cert.Status = "not_yet_valid"
+	}
+	if certificates != "none" {
+		info.Cert.Certificates = []string{base64.StdEncoding.EncodeToString(cert.Raw)}
+	}
+	if certInfo {
+		info.Cert.IssuerDN = cert.Issuer.String()
+		info.Cert.SubjectDN = cert.Subject.String()
+		info.Cert.SerialNumber = strings.ToUpper(cert.SerialNumber.Text(16))
+		info.Cert.ValidFrom = cert.NotBefore.UTC().Format(generalizedTime)
+		info.Cert.ValidTo = cert.NotAfter.UTC().Format(generalizedTime)
+	}
+	if authInfo {
+		info.Auth = &AuthInfo{Mode: AuthModeImplicit}
+	}
+	return info
+}
+
+// invalidParameter returns error of the invalid or missing request
+// parameter.
+func invalidParameter(name string) *Error {
+	return &Error{
+		Code:        ErrorInvalidRequest,
+		Description: "Invalid parameter " + name,
+	}
+}
+
+// readJSON decodes JSON request body of at most maxRequestSize bytes.
+// Body must have only the one JSON value.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	err := dec.Decode(v)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("trailing data")
+	}
+	if err != nil {
+		return &Error{
+			Code:        ErrorInvalidRequest,
+			Description: fmt.Sprintf("Malformed request: %v", err),
+		}
+	}
+	return nil
+}
+
+// writeError writes CSC error response of the error. Failed Smart-ID
+// sessions are access_denied errors.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	var cscErr *Error
+	var sessionErr *smartid.SessionError
+	var apiErr *smartid.Error
+	switch {
+	case errors.As(err, &cscErr):
+	case errors.Is(err, ErrUnauthenticated):
+		status = http.StatusUnauthorized
+		cscErr = &Error{Code: ErrorAccessDenied, Description: err.Error()}
+	case errors.As(err, &sessionErr):
+		cscErr = &Error{Code: ErrorAccessDenied, Description: err.Error()}
+	case errors.Is(err, smartid.ErrHashUnsupported),
+		errors.Is(err, smartid.ErrHashLengthMismatch),
+		errors.Is(err, smartid.ErrSignatureCertMismatch):
+		cscErr = &Error{Code: ErrorInvalidRequest, Description: err.Error()}
+	case errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound:
+		cscErr = &Error{Code: ErrorInvalidRequest, Description: "Smart-ID account not found"}
+	default:
+		status = http.StatusInternalServerError
+		cscErr = &Error{Code: ErrorServer, Description: err.Error()}
+	}
+	httputil.WriteJSON(w, status, cscErr)
+}
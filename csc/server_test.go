@@ -0,0 +1,280 @@
+package csc
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dknight/go-smartid"
+	"github.com/dknight/go-smartid/internal/smartidtest"
+)
+
+const oidSHA256 = "2.16.840.1.101.3.4.2.1"
+
+// testToken is the bearer token of the test platform.
+const testToken = "Bearer platform"
+
+// testAuth accepts the test platform.
+func testAuth(r *http.Request) error {
+	if r.Header.Get("Authorization") != testToken {
+		return errors.New("invalid token")
+	}
+	return nil
+}
+
+// call calls the CSC method and decodes the response into v, or the error
+// response if status is not OK.
+func call(t *testing.T, h http.Handler, method string, req, v interface{}) (int, *Error) {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/"+method, bytes.NewReader(body))
+	r.Header.Set("Authorization", testToken)
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		var cscErr Error
+		if err := json.NewDecoder(w.Body).Decode(&cscErr); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, &cscErr
+	}
+	if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, nil
+}
+
+func TestServer(t *testing.T) {
+	mock := smartidtest.NewServer(t)
+	srv := NewServer(mock.Client(), &smartid.AuthRequest{RelyingPartyName: "DEMO"}, testAuth)
+
+	var info Info
+	if _, err := call(t, srv, "info", struct{}{}, &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Specs != Specs || info.Name != "DEMO" || len(info.Methods) != 5 {
+		t.Error("expected service info, got", info)
+	}
+
+	var list CredentialsListResponse
+	_, err := call(t, srv, "credentials/list", CredentialsListRequest{
+		UserID:         smartidtest.Identifier,
+		CredentialInfo: true,
+		CertInfo:       true,
+	}, &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.CredentialIDs) != 1 || list.CredentialIDs[0] != smartidtest.DocumentNumber {
+		t.Fatal("expected", smartidtest.DocumentNumber, "got", list.CredentialIDs)
+	}
+	if len(list.CredentialInfos) != 1 || list.CredentialInfos[0].Cert.SubjectDN == "" {
+		t.Error("expected credential info, got", list.CredentialInfos)
+	}
+
+	var cred CredentialInfo
+	_, err = call(t, srv, "credentials/info", CredentialsInfoRequest{
+		CredentialID: smartidtest.DocumentNumber,
+		AuthInfo:     true,
+	}, &cred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.SCAL != "2" || cred.Multisign != 1 || cred.Auth.Mode != AuthModeImplicit {
+		t.Error("expected SCAL2 implicit credential, got", cred)
+	}
+	if cred.SignatureQualifier != "eu_eidas_qes" || cred.Key.Len != 2048 {
+		t.Error("expected qualified 2048 bit key, got", cred.SignatureQualifier, cred.Key.Len)
+	}
+	if len(cred.Cert.Certificates) != 1 ||
+		cred.Cert.Certificates[0] != base64.StdEncoding.EncodeToString(mock.SignCert.Raw) {
+		t.Error("expected signing certificate")
+	}
+
+	sum := sha256.Sum256([]byte("Hello, Smart-ID!"))
+	hash := base64.StdEncoding.EncodeToString(sum[:])
+	var auth AuthorizeResponse
+	_, err = call(t, srv, "credentials/authorize", AuthorizeRequest{
+		CredentialID:     smartidtest.DocumentNumber,
+		NumSignatures:    1,
+		Hashes:           []string{hash},
+		HashAlgorithmOID: oidSHA256,
+	}, &auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := smartid.AuthHash(sum[:]).CalculateVerificationCode(); auth.VerificationCode != code {
+		t.Error("expected", code, "got", auth.VerificationCode)
+	}
+
+	signReq := SignHashRequest{
+		CredentialID: smartidtest.DocumentNumber,
+		SAD:          auth.SAD,
+		Hashes:       []string{hash},
+		SignAlgo:     OIDSHA256WithRSAEncryption,
+	}
+	other := signReq
+	other.Hashes = []string{base64.StdEncoding.EncodeToString(make([]byte, 32))}
+	var signed SignHashResponse
+	if _, err := call(t, srv, "signatures/signHash", other, &signed); err == nil ||
+		err.Description != ErrSADInvalid.Error() {
+		t.Error("expected", ErrSADInvalid, "got", err)
+	}
+	if _, err := call(t, srv, "signatures/signHash", signReq, &signed); err != nil {
+		t.Fatal(err)
+	}
+	sig, _ := base64.StdEncoding.DecodeString(signed.Signatures[0])
+	if err := rsa.VerifyPKCS1v15(&mock.Key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+		t.Error("expected valid signature, got", err)
+	}
+	requests := mock.Requests()
+	if last := requests[len(requests)-1]; last["endpoint"] != smartid.EndpointSignature ||
+		last["identifier"] != smartidtest.DocumentNumber {
+		t.Error("expected signing session of the credential, got", last)
+	}
+	var choices int
+	for _, req := range requests {
+		if req["endpoint"] == smartid.EndpointCertificateChoice {
+			choices++
+		}
+	}
+	if choices != 1 {
+		t.Error("expected 1 certificate choice, got", choices)
+	}
+
+	if _, err := call(t, srv, "signatures/signHash", signReq, &signed); err == nil {
+		t.Error("expected SAD to be used once")
+	}
+}
+
+func TestServer_certificateChanged(t *testing.T) {
+	mock := smartidtest.NewServer(t)
+	srv := NewServer(mock.Client(), &smartid.AuthRequest{}, testAuth)
+	sum := sha256.Sum256([]byte("Hello, Smart-ID!"))
+	hash := base64.StdEncoding.EncodeToString(sum[:])
+
+	var list CredentialsListResponse
+	if _, err := call(t, srv, "credentials/list", CredentialsListRequest{UserID: smartidtest.Identifier}, &list); err != nil {
+		t.Fatal(err)
+	}
+	var auth AuthorizeResponse
+	_, err := call(t, srv, "credentials/authorize", AuthorizeRequest{
+		CredentialID:     smartidtest.DocumentNumber,
+		NumSignatures:    1,
+		Hashes:           []string{hash},
+		HashAlgorithmOID: oidSHA256,
+	}, &auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Certificate of the document number is renewed after the choice.
+	tmpl := *mock.SignCert
+	tmpl.SerialNumber = big.NewInt(2)
+	der, cerr := x509.CreateCertificate(rand.Reader, &tmpl, mock.CA, &mock.Key.PublicKey, mock.CAKey)
+	if cerr != nil {
+		t.Fatal(cerr)
+	}
+	if mock.SignCert, cerr = x509.ParseCertificate(der); cerr != nil {
+		t.Fatal(cerr)
+	}
+
+	var signed SignHashResponse
+	code, err := call(t, srv, "signatures/signHash", SignHashRequest{
+		CredentialID: smartidtest.DocumentNumber,
+		SAD:          auth.SAD,
+		Hashes:       []string{hash},
+		SignAlgo:     OIDSHA256WithRSAEncryption,
+	}, &signed)
+	if err == nil || code != http.StatusBadRequest ||
+		err.Description != smartid.ErrSignatureCertMismatch.Error() {
+		t.Error("expected", smartid.ErrSignatureCertMismatch, "got", code, err)
+	}
+}
+
+func TestServer_errors(t *testing.T) {
+	mock := smartidtest.NewServer(t)
+	srv := NewServer(mock.Client(), &smartid.AuthRequest{}, testAuth)
+	sum := sha256.Sum256([]byte("Hello, Smart-ID!"))
+	hash := base64.StdEncoding.EncodeToString(sum[:])
+
+	testdata := []struct {
+		name   string
+		method string
+		req    interface{}
+		status int
+		code   string
+	}{
+		{"userID", "credentials/list", CredentialsListRequest{}, 400, ErrorInvalidRequest},
+		{"refused", "credentials/list", CredentialsListRequest{UserID: "PNOEE-REFUSED"}, 400, ErrorAccessDenied},
+		{"credentialID", "credentials/info", CredentialsInfoRequest{}, 400, ErrorInvalidRequest},
+		{"not listed", "credentials/info", CredentialsInfoRequest{
+			CredentialID: smartidtest.DocumentNumber,
+		}, 400, ErrorInvalidRequest},
+		{"not listed", "credentials/authorize", AuthorizeRequest{
+			CredentialID: smartidtest.DocumentNumber, NumSignatures: 1, Hashes: []string{hash}, HashAlgorithmOID: oidSHA256,
+		}, 400, ErrorInvalidRequest},
+		{"numSignatures", "credentials/authorize", AuthorizeRequest{
+			CredentialID: "X", NumSignatures: 2, Hashes: []string{hash, hash}, HashAlgorithmOID: oidSHA256,
+		}, 400, ErrorInvalidRequest},
+		{"hashLength", "credentials/authorize", AuthorizeRequest{
+			CredentialID: "X", NumSignatures: 1, Hashes: []string{"AAAA"}, HashAlgorithmOID: oidSHA256,
+		}, 400, ErrorInvalidRequest},
+		{"signAlgo", "signatures/signHash", SignHashRequest{
+			CredentialID: "X", SAD: "X", Hashes: []string{hash}, SignAlgo: "1.2.840.10045.4.3.2",
+		}, 400, ErrorInvalidRequest},
+		{"hashAlgorithmOID", "signatures/signHash", SignHashRequest{
+			CredentialID: "X", SAD: "X", Hashes: []string{hash}, SignAlgo: OIDRSAEncryption,
+		}, 400, ErrorInvalidRequest},
+		{"SAD", "signatures/signHash", SignHashRequest{
+			CredentialID: "X", SAD: "X", Hashes: []string{hash}, SignAlgo: OIDSHA256WithRSAEncryption,
+		}, 400, ErrorInvalidRequest},
+	}
+	for _, test := range testdata {
+		status, err := call(t, srv, test.method, test.req, nil)
+		if status != test.status || err == nil || err.Code != test.code {
+			t.Error(test.name, "expected", test.status, test.code, "got", status, err)
+		}
+	}
+
+	for _, body := range []string{
+		"{",
+		`{"userID":"PNOEE-30303039914"} {}`,
+		`{"userID":"` + strings.Repeat("A", maxRequestSize) + `"}`,
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/credentials/list", strings.NewReader(body))
+		r.Header.Set("Authorization", testToken)
+		srv.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Error("expected", http.StatusBadRequest, "got", w.Code)
+		}
+	}
+
+	body := `{"userID":"PNOEE-30303039914"}`
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/credentials/list", strings.NewReader(body)))
+	if w.Code != http.StatusUnauthorized {
+		t.Error("expected", http.StatusUnauthorized, "got", w.Code)
+	}
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/info", strings.NewReader("{}")))
+	if w.Code != http.StatusOK {
+		t.Error("expected public info, got", w.Code)
+	}
+
+	if len(mock.Requests()) != 1 {
+		t.Error("expected only the refused session, got", mock.Requests())
+	}
+}
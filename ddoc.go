@@ -0,0 +1,288 @@
+package smartid
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Namespaces of DigiDoc XML 1.3 and XAdES 1.1.1 used in it.
+const (
+	nsDDOC     = "http://www.sk.ee/DigiDoc/v1.3.0#"
+	nsXAdES111 = "http://uri.etsi.org/01903/v1.1.1#"
+)
+
+// Content types of DDOC data files.
+const (
+	ddocEmbedded       = "EMBEDDED"
+	ddocEmbeddedBase64 = "EMBEDDED_BASE64"
+	ddocHashcode       = "HASHCODE"
+)
+
+var (
+	// ErrDDOCUnsupported error when document is not DigiDoc XML 1.3.
+	ErrDDOCUnsupported = errors.New("Unsupported DigiDoc format")
+
+	// ErrDDOCNoFiles error when DDOC container has no data files.
+	ErrDDOCNoFiles = errors.New("DDOC container has no data files")
+
+	// ErrDataFileNotVerified warning when data file in HASHCODE form has
+	// no data, only its digest is checked.
+	ErrDataFileNotVerified = errors.New("Data file content is not verified")
+)
+
+// DDOCContainer is the legacy DigiDoc XML 1.3 (.ddoc) container read for
+// validation. Containers can be read, but not created.
+type DDOCContainer struct {
+	root       *xmlElement
+	files      []DataFile
+	dataFiles  []*xmlElement
+	signatures []*xmlElement
+}
+
+// ReadDDOC reads DigiDoc XML 1.3 container. Data files are extracted,
+// signatures are parsed when validated. Data files in HASHCODE form have
+// no data.
+func ReadDDOC(data []byte) (*DDOCContainer, error) {
+	root, err := parseXML(data)
+	if err != nil {
+		return nil, err
+	}
+	if !root.Is(nsDDOC, "SignedDoc") || root.Attr("format") != "DIGIDOC-XML" ||
+		root.Attr("version") != "1.3" {
+		return nil, ErrDDOCUnsupported
+	}
+
+	c := &DDOCContainer{root: root}
+	for _, el := range root.ChildrenNamed(nsDDOC, "DataFile") {
+		f := DataFile{Name: el.Attr("Filename"), MimeType: el.Attr("MimeType")}
+		switch el.Attr("ContentType") {
+		case ddocEmbeddedBase64:
+			if f.Data, err = decodeBase64Text(el.Text()); err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Name, err)
+			}
+		case ddocEmbedded:
+			f.Data = []byte(el.Text())
+		case ddocHashcode:
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrDDOCUnsupported, el.Attr("ContentType"))
+		}
+		c.files = append(c.files, f)
+		c.dataFiles = append(c.dataFiles, el)
+	}
+	if len(c.files) == 0 {
+		return nil, ErrDDOCNoFiles
+	}
+	c.signatures = root.ChildrenNamed(nsXMLDSig, "Signature")
+	return c, nil
+}
+
+// Files returns data files of the container.
+func (c *DDOCContainer) Files() []DataFile {
+	return c.files
+}
+
+// Validate validates all signatures of the container: digests of data
+// files and signed properties, signature values, signer certificates with
+// the trust store and OCSP responses. OCSP response is the time-mark of
+// DDOC signature and it is required, its nonce must be the digest of the
+// signature value. Every data file must be signed by every signature.
+// Data files in HASHCODE form have no data to verify, ErrDataFileNotVerified
+// warning is added for each.
+//
+// Legacy signer and OCSP responder certificates are signed with SHA-1,
+// which crypto/x509 rejects. Their chains are verified with SHA-1 allowed
+// and ErrLegacyChain warning is added to the result.
+func (c *DDOCContainer) Validate(ctx context.Context, ts *TrustStore) []*SignatureResult {
+	results := make([]*SignatureResult, len(c.signatures))
+	for i, el := range c.signatures {
+		results[i] = c.validate(ctx, ts, el)
+	}
+	return results
+}
+
+// validate validates one signature of the container.
+func (c *DDOCContainer) validate(ctx context.Context, ts *TrustStore, el *xmlElement) *SignatureResult {
+	r := &SignatureResult{ID: el.Attr("Id"), legacy: true}
+	signedInfo := el.Child(nsXMLDSig, "SignedInfo")
+	sigValue := el.Child(nsXMLDSig, "SignatureValue")
+	if signedInfo == nil || sigValue == nil {
+		r.fail(ErrXAdESMalformed)
+		return r
+	}
+
+	// Signing time and certificate are read only from the signed
+	// SignedProperties.
+	sp := ddocSignedProperties(el)
+	spSigned := false
+	signed := make(map[*xmlElement]bool)
+	s := &XAdESSignature{root: c.root, signature: el}
+	for _, ref := range signedInfo.ChildrenNamed(nsXMLDSig, "Reference") {
+		df, err := c.checkReference(s, ref)
+		if err != nil {
+			r.fail(err)
+		} else if df != nil {
+			signed[df] = true
+		} else if sp != nil && ref.Attr("URI") == "#"+sp.Attr("Id") &&
+			c.root.FindByID(sp.Attr("Id")) == sp {
+			spSigned = true
+		}
+	}
+	for i, df := range c.dataFiles {
+		if !signed[df] {
+			r.fail(fmt.Errorf("%w: %s", ErrDataFileNotSigned, c.files[i].Name))
+		} else if df.Attr("ContentType") == ddocHashcode {
+			r.warn(fmt.Errorf("%w: %s", ErrDataFileNotVerified, c.files[i].Name))
+		}
+	}
+	if !spSigned {
+		r.fail(ErrSignedPropertiesNotSigned)
+	} else if st := ddocProperty(sp, "SigningTime"); st != nil {
+		if t, err := time.Parse(time.RFC3339, st.Text()); err == nil {
+			r.SigningTime = t
+		}
+	}
+
+	der, err := decodeBase64Text(el.Path(nsXMLDSig, "KeyInfo", "X509Data", "X509Certificate").Text())
+	if err != nil || len(der) == 0 {
+		r.fail(ErrSignerCertNotFound)
+		return r
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		r.fail(ErrSignerCertNotFound)
+		return r
+	}
+	r.setSigner(cert)
+	value, err := decodeBase64Text(sigValue.Text())
+	if err != nil {
+		r.fail(ErrXAdESMalformed)
+		return r
+	}
+	if err := verifyDDOCSignatureValue(signedInfo, cert, value); err != nil {
+		r.fail(err)
+	}
+	if spSigned {
+		if sc := ddocProperty(sp, "SigningCertificate"); sc == nil {
+			r.fail(ErrSigningCertMismatch)
+		} else if err := checkCertDigest(sc, sc.Space(), cert); err != nil {
+			r.fail(err)
+		}
+	}
+
+	var certs []*x509.Certificate
+	for _, v := range el.FindAll(nsXAdES111, "EncapsulatedX509Certificate") {
+		der, err := decodeBase64Text(v.Text())
+		if err != nil {
+			continue
+		}
+		if c, err := x509.ParseCertificate(der); err == nil {
+			certs = append(certs, c)
+		}
+	}
+	var ocspValues [][]byte
+	for _, v := range el.FindAll(nsXAdES111, "EncapsulatedOCSPValue") {
+		if der, err := decodeBase64Text(v.Text()); err == nil {
+			ocspValues = append(ocspValues, der)
+		}
+	}
+	r.verifyCertificate(ctx, ts, certs, ocspValues)
+	if r.OCSP == nil {
+		r.fail(ErrRevocationMissing)
+	} else if !checkDDOCNonce(r.OCSP.Nonce, value) {
+		r.fail(ErrOCSPNonceMismatch)
+	}
+	return r
+}
+
+// checkReference checks digest of the referenced data file or signed
+// properties. For data file its element is returned.
+func (c *DDOCContainer) checkReference(s *XAdESSignature, ref *xmlElement) (*xmlElement, error) {
+	uri := ref.Attr("URI")
+	var df *xmlElement
+	for _, el := range c.dataFiles {
+		if uri == "#"+el.Attr("Id") {
+			df = el
+		}
+	}
+	if df == nil || df.Attr("ContentType") != ddocHashcode {
+		// Digest of embedded data file is calculated over the
+		// canonicalized DataFile element.
+		_, err := s.checkReference(ref, nil)
+		return df, err
+	}
+
+	expected, err := decodeBase64Text(ref.Child(nsXMLDSig, "DigestValue").Text())
+	if err != nil {
+		return nil, err
+	}
+	given, err := decodeBase64Text(df.Attr("DigestValue"))
+	if err != nil || !bytes.Equal(given, expected) {
+		return nil, fmt.Errorf("%w: %s", ErrDigestMismatch, uri)
+	}
+	return df, nil
+}
+
+// ddocSignedProperties finds SignedProperties element of the signature,
+// which DigiDoc 1.3 declares in XMLDSig namespace.
+func ddocSignedProperties(el *xmlElement) *xmlElement {
+	if sp := el.Find(nsXAdES111, "SignedProperties"); sp != nil {
+		return sp
+	}
+	return el.Find(nsXMLDSig, "SignedProperties")
+}
+
+// ddocProperty finds signed signature property. DigiDoc 1.3 declares
+// SignedProperties in XMLDSig namespace, so its descendants are in that
+// namespace instead of XAdES.
+func ddocProperty(el *xmlElement, local string) *xmlElement {
+	if p := el.Find(nsXAdES111, local); p != nil {
+		return p
+	}
+	return el.Find(nsXMLDSig, local)
+}
+
+// verifyDDOCSignatureValue verifies signature value over the
+// canonicalized SignedInfo. DDOC signatures are mostly RSA-SHA1, which is
+// supported only for verification.
+func verifyDDOCSignatureValue(signedInfo *xmlElement, cert *x509.Certificate, value []byte) error {
+	alg, prefixes := c14nMethod(signedInfo.Child(nsXMLDSig, "CanonicalizationMethod"), algC14N10)
+	c14n, err := canonicalize(signedInfo, alg, prefixes)
+	if err != nil {
+		return err
+	}
+	method := signedInfo.Child(nsXMLDSig, "SignatureMethod").Attr("Algorithm")
+	h, digest := crypto.SHA1, []byte(nil)
+	if method == algRSASHA1 {
+		sum := sha1.Sum(c14n)
+		digest = sum[:]
+	} else {
+		algo, ok := xmlSignatureHashes[method]
+		if !ok {
+			return ErrHashUnsupported
+		}
+		d, err := HashData(c14n, algo)
+		if err != nil {
+			return err
+		}
+		h, digest = d.CryptoHash(), d.AuthHash()
+	}
+	if err := verifySignatureScheme(cert, xmlSignatureScheme(method), h, digest, value); err != nil {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+// checkDDOCNonce checks that OCSP nonce is SHA-1 digest of the signature
+// value. Older DigiDoc versions stored it without OCTET STRING wrapping.
+func checkDDOCNonce(nonce, value []byte) bool {
+	sum := sha1.Sum(value)
+	wrapped, _ := asn1.Marshal(sum[:])
+	return bytes.Equal(nonce, sum[:]) || bytes.Equal(nonce, wrapped)
+}
@@ -0,0 +1,333 @@
+package smartid
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"crypto/x509/pkix"
+)
+
+// testDDOC is DigiDoc XML 1.3 container with embedded and hashcode data
+// files. Placeholders in braces are replaced by newTestDDOC.
+const testDDOC = `<?xml version="1.0" encoding="UTF-8"?>
+<SignedDoc format="DIGIDOC-XML" version="1.3" xmlns="http://www.sk.ee/DigiDoc/v1.3.0#">
+<DataFile ContentType="EMBEDDED_BASE64" Filename="test.txt" Id="D0" MimeType="text/plain" Size="4" xmlns="http://www.sk.ee/DigiDoc/v1.3.0#">dGVzdA==
+</DataFile>
+<DataFile ContentType="HASHCODE" DigestType="sha1" DigestValue="{HASHCODE}" Filename="big.bin" Id="D1" MimeType="application/octet-stream" Size="3" xmlns="http://www.sk.ee/DigiDoc/v1.3.0#"></DataFile>
+<Signature Id="S0" xmlns="http://www.w3.org/2000/09/xmldsig#">
+<SignedInfo xmlns="http://www.w3.org/2000/09/xmldsig#">
+<CanonicalizationMethod Algorithm="http://www.w3.org/TR/2001/REC-xml-c14n-20010315"></CanonicalizationMethod>
+<SignatureMethod Algorithm="http://www.w3.org/2000/09/xmldsig#rsa-sha1"></SignatureMethod>
+<Reference URI="#D0">
+<DigestMethod Algorithm="http://www.w3.org/2000/09/xmldsig#sha1"></DigestMethod>
+<DigestValue>{D0}</DigestValue>
+</Reference>
+<Reference URI="#D1">
+<DigestMethod Algorithm="http://www.w3.org/2000/09/xmldsig#sha1"></DigestMethod>
+<DigestValue>{HASHCODE}</DigestValue>
+</Reference>
+<Reference Type="http://uri.etsi.org/01903/v1.1.1#SignedProperties" URI="#S0-SignedProperties">
+<DigestMethod Algorithm="http://www.w3.org/2000/09/xmldsig#sha1"></DigestMethod>
+<DigestValue>{SP}</DigestValue>
+</Reference>
+</SignedInfo>
+<SignatureValue Id="S0-SIG">{SIG}</SignatureValue>
+<KeyInfo>
+<X509Data><X509Certificate>{CERT}</X509Certificate></X509Data>
+</KeyInfo>
+<Object><QualifyingProperties xmlns="http://uri.etsi.org/01903/v1.1.1#" Target="#S0"><SignedProperties xmlns="http://www.w3.org/2000/09/xmldsig#" Id="S0-SignedProperties" Target="#S0">
+<SignedSignatureProperties>
+<SigningTime>{TIME}</SigningTime>
+<SigningCertificate>
+<Cert>
+<CertDigest>
+<DigestMethod xmlns="http://www.w3.org/2000/09/xmldsig#" Algorithm="http://www.w3.org/2000/09/xmldsig#sha1"></DigestMethod>
+<DigestValue xmlns="http://www.w3.org/2000/09/xmldsig#">{CERTDIGEST}</DigestValue>
+</CertDigest>
+</Cert>
+</SigningCertificate>
+</SignedSignatureProperties>
+<SignedDataObjectProperties></SignedDataObjectProperties>
+</SignedProperties>
+<UnsignedProperties xmlns="http://uri.etsi.org/01903/v1.1.1#">
+<UnsignedSignatureProperties>
+<RevocationValues>
+<OCSPValues>
+<EncapsulatedOCSPValue Id="N0">{OCSP}</EncapsulatedOCSPValue>
+</OCSPValues>
+</RevocationValues>
+</UnsignedSignatureProperties>
+</UnsignedProperties>
+</QualifyingProperties></Object>
+</Signature>
+</SignedDoc>
+`
+
+// newTestDDOC builds DDOC container signed by the mock signer certificate
+// with OCSP time-mark of the responder.
+func newTestDDOC(
+	t *testing.T,
+	mock *mockSmartID,
+	responder *x509.Certificate,
+	responderKey *rsa.PrivateKey,
+) []byte {
+	t.Helper()
+	return newTestDDOCFrom(t, testDDOC, mock, responder, responderKey)
+}
+
+// newTestDDOCFrom builds DDOC container from the template like
+// newTestDDOC.
+func newTestDDOCFrom(
+	t *testing.T,
+	template string,
+	mock *mockSmartID,
+	responder *x509.Certificate,
+	responderKey *rsa.PrivateKey,
+) []byte {
+	t.Helper()
+	b64 := base64.StdEncoding.EncodeToString
+	hashcode := sha1.Sum([]byte{1, 2, 3})
+	certDigest := sha1.Sum(mock.signCert.Raw)
+	values := map[string]string{
+		"{HASHCODE}":   b64(hashcode[:]),
+		"{CERT}":       b64(mock.signCert.Raw),
+		"{CERTDIGEST}": b64(certDigest[:]),
+		"{TIME}":       time.Now().UTC().Format(xadesTimeFormat),
+	}
+	render := func() []byte {
+		doc := template
+		for k, v := range values {
+			doc = strings.ReplaceAll(doc, k, v)
+		}
+		return []byte(doc)
+	}
+	digest := func(root *xmlElement, id string) string {
+		c14n, err := canonicalize(root.FindByID(id), algC14N10, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha1.Sum(c14n)
+		return b64(sum[:])
+	}
+
+	root, err := parseXML(render())
+	if err != nil {
+		t.Fatal(err)
+	}
+	values["{D0}"] = digest(root, "D0")
+	values["{SP}"] = digest(root, "S0-SignedProperties")
+
+	root, _ = parseXML(render())
+	c14n, _ := canonicalize(root.Find(nsXMLDSig, "SignedInfo"), algC14N10, nil)
+	sum := sha1.Sum(c14n)
+	value, err := mock.key.Sign(rand.Reader, sum[:], crypto.SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values["{SIG}"] = b64(value)
+	nonce := sha1.Sum(value)
+	values["{OCSP}"] = b64(newTestOCSPResponseNonce(t, mock, responder, responderKey, nonce[:]))
+	return render()
+}
+
+// newTestOCSPResponseNonce makes good OCSP response of the mock signer
+// certificate with the nonce extension.
+func newTestOCSPResponseNonce(
+	t *testing.T,
+	mock *mockSmartID,
+	responder *x509.Certificate,
+	responderKey *rsa.PrivateKey,
+	nonce []byte,
+) []byte {
+	t.Helper()
+	der := newTestOCSPResponse(t, mock.signCert, mock.ca, responder, responderKey,
+		OCSPStatusGood, time.Now())
+	var resp ocspResponse
+	var basic basicOCSPResponse
+	var data ocspResponseData
+	asn1.Unmarshal(der, &resp)
+	asn1.Unmarshal(resp.ResponseBytes.Response, &basic)
+	asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data)
+
+	value, _ := asn1.Marshal(nonce)
+	data.Extensions = []pkix.Extension{{Id: oidOCSPNonce, Value: value}}
+	tbs, _ := asn1.Marshal(data)
+	sum := sha256.Sum256(tbs)
+	sig, err := responderKey.Sign(rand.Reader, sum[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic.TBSResponseData = asn1.RawValue{FullBytes: tbs}
+	basic.Signature = asn1.BitString{Bytes: sig, BitLength: len(sig) * 8}
+	resp.ResponseBytes.Response, _ = asn1.Marshal(basic)
+	der, _ = asn1.Marshal(resp)
+	return der
+}
+
+func TestReadDDOC(t *testing.T) {
+	mock := newMockSmartID(t)
+	c, err := ReadDDOC(newTestDDOC(t, mock, mock.ca, mock.caKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := c.Files()
+	if len(files) != 2 || files[0].Name != "test.txt" || string(files[0].Data) != "test" {
+		t.Fatal("expected data files, got", files)
+	}
+	if files[1].Name != "big.bin" || files[1].Data != nil {
+		t.Error("expected hashcode file without data, got", files[1])
+	}
+
+	testdata := []struct {
+		name string
+		doc  string
+		err  error
+	}{
+		{"version", strings.Replace(testDDOC, `version="1.3"`, `version="1.2"`, 1), ErrDDOCUnsupported},
+		{"format", strings.Replace(testDDOC, `DIGIDOC-XML`, `SK-XML`, 1), ErrDDOCUnsupported},
+		{"no files", `<SignedDoc format="DIGIDOC-XML" version="1.3" xmlns="http://www.sk.ee/DigiDoc/v1.3.0#"/>`, ErrDDOCNoFiles},
+	}
+	for _, test := range testdata {
+		if _, err := ReadDDOC([]byte(test.doc)); err != test.err {
+			t.Error(test.name, "expected", test.err, "got", err)
+		}
+	}
+}
+
+func TestDDOCContainer_Validate(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+	data := newTestDDOC(t, mock, mock.ca, mock.caKey)
+
+	c, _ := ReadDDOC(data)
+	results := c.Validate(ctx, ts)
+	if len(results) != 1 || !results[0].IsValid() {
+		t.Fatal("expected valid signature, got", results[0].Err())
+	}
+	r := results[0]
+	if r.ID != "S0" || r.OCSP == nil || r.TrustedTime.IsZero() || r.SigningTime.IsZero() {
+		t.Error("expected signature with time-mark, got", r)
+	}
+	if r.Identity.SerialNumber != "PNOEE-30303039914" || len(r.Chain) != 2 {
+		t.Error("expected verified signer, got", r.Identity, r.Chain)
+	}
+	if len(r.Warnings) != 1 || !errors.Is(r.Warnings[0], ErrDataFileNotVerified) {
+		t.Error("expected", ErrDataFileNotVerified, "got", r.Warnings)
+	}
+
+	testdata := []struct {
+		name     string
+		old, new string
+		err      error
+	}{
+		{"data file", "dGVzdA==", "dGVzdB==", ErrDigestMismatch},
+		{"hashcode", `DigestType="sha1" DigestValue="`, `DigestType="sha1" DigestValue="AA`, ErrDigestMismatch},
+		{"signing time", "<SigningTime>2", "<SigningTime>1", ErrDigestMismatch},
+		{"ocsp", `<EncapsulatedOCSPValue Id="N0">`, `<EncapsulatedOCSPValue Id="N0">AAAA`, ErrRevocationMissing},
+	}
+	for _, test := range testdata {
+		c, err := ReadDDOC(bytes.Replace(data, []byte(test.old), []byte(test.new), 1))
+		if err != nil {
+			t.Fatal(test.name, err)
+		}
+		r := c.Validate(ctx, ts)[0]
+		if r.IsValid() || !errors.Is(r.Err(), test.err) {
+			t.Error(test.name, "expected", test.err, "got", r.Err())
+		}
+	}
+
+	if r := c.Validate(ctx, NewTrustStore())[0]; r.IsValid() {
+		t.Error("expected untrusted signer")
+	}
+}
+
+func TestDDOCContainer_Validate_signedProperties(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+
+	ref := `<Reference Type="http://uri.etsi.org/01903/v1.1.1#SignedProperties" URI="#S0-SignedProperties">
+<DigestMethod Algorithm="http://www.w3.org/2000/09/xmldsig#sha1"></DigestMethod>
+<DigestValue>{SP}</DigestValue>
+</Reference>
+`
+	if !strings.Contains(testDDOC, ref) {
+		t.Fatal("expected SignedProperties reference in template")
+	}
+	data := newTestDDOCFrom(t, strings.Replace(testDDOC, ref, "", 1), mock, mock.ca, mock.caKey)
+	// Signing time is not protected without the reference.
+	data = bytes.Replace(data, []byte("<SigningTime>2"), []byte("<SigningTime>1"), 1)
+
+	c, err := ReadDDOC(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := c.Validate(ctx, ts)[0]
+	if !errors.Is(r.Err(), ErrSignedPropertiesNotSigned) {
+		t.Error("expected", ErrSignedPropertiesNotSigned, "got", r.Err())
+	}
+	if !r.SigningTime.IsZero() {
+		t.Error("expected no signing time, got", r.SigningTime)
+	}
+}
+
+func TestDDOCContainer_Validate_sha1(t *testing.T) {
+	ctx := context.Background()
+	ca, caKey := newTestCA(t, "TEST of ESTEID-SK 2007")
+	ts := NewTrustStore()
+	ts.AddRoot(ca)
+
+	// Signer and OCSP responder certificates are signed with SHA-1, which
+	// crypto/x509 does not verify.
+	legacy := &mockSmartID{ca: ca, caKey: caKey}
+	tmpl := signTemplate()
+	tmpl.SignatureAlgorithm = x509.SHA1WithRSA
+	legacy.signCert, legacy.key = newTestLeaf(t, tmpl, ca, caKey)
+	responder, responderKey := newTestLeaf(t, &x509.Certificate{
+		Subject:            pkix.Name{CommonName: "TEST of ESTEID-SK 2007 OCSP RESPONDER"},
+		SignatureAlgorithm: x509.SHA1WithRSA,
+		ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+	}, ca, caKey)
+	if legacy.signCert.CheckSignatureFrom(ca) == nil {
+		t.Fatal("expected SHA-1 signature to be rejected by crypto/x509")
+	}
+
+	c, err := ReadDDOC(newTestDDOC(t, legacy, responder, responderKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := c.Validate(ctx, ts)[0]
+	if !r.IsValid() || r.OCSP == nil || len(r.Chain) != 2 {
+		t.Fatal("expected valid signature, got", r.Err(), r.Chain)
+	}
+	if !slices.Contains(r.Warnings, ErrLegacyChain) {
+		t.Error("expected", ErrLegacyChain, "got", r.Warnings)
+	}
+	if r := c.Validate(ctx, NewTrustStore())[0]; r.IsValid() {
+		t.Error("expected untrusted signer")
+	}
+
+	// Other signatures do not allow SHA-1.
+	r = &SignatureResult{}
+	r.setSigner(legacy.signCert)
+	r.verifyCertificate(ctx, ts, nil, nil)
+	if r.IsValid() {
+		t.Error("expected SHA-1 chain to be rejected")
+	}
+}
@@ -0,0 +1,158 @@
+package smartid
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/sha3"
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"io"
+)
+
+// SHA-3 hashing algorithms, supported by Smart-ID API v3.
+const (
+	// SHA3_256 algorithm for encryption.
+	SHA3_256 = "SHA3-256"
+
+	// SHA3_384 algorithm for encryption.
+	SHA3_384 = "SHA3-384"
+
+	// SHA3_512 algorithm for encryption.
+	SHA3_512 = "SHA3-512"
+)
+
+var (
+	// ErrHashUnsupported error when hash algorithm is unknown.
+	ErrHashUnsupported = errors.New("Unsupported hash algorithm")
+
+	// ErrHashLengthMismatch error when length of the hash does not match
+	// the hash type.
+	ErrHashLengthMismatch = errors.New("Hash length does not match hash type")
+
+	// ErrHashTypeMismatch error when HashType of the request differs from
+	// the algorithm of the digest.
+	ErrHashTypeMismatch = errors.New("Hash type does not match digest")
+)
+
+// Digest is the hash sum of the data together with the algorithm used. It
+// is used in AuthRequest to sign real documents instead of random hashes.
+type Digest struct {
+	algo string
+	sum  AuthHash
+}
+
+// NewDigest makes digest from already computed hash sum. Length of the
+// sum is checked against the algorithm.
+func NewDigest(algo string, sum []byte) (*Digest, error) {
+	h, err := hashFunc(algo)
+	if err != nil {
+		return nil, err
+	}
+	if len(sum) != h.Size() {
+		return nil, ErrHashLengthMismatch
+	}
+	return &Digest{algo: algo, sum: AuthHash(sum)}, nil
+}
+
+// HashData computes digest of the data.
+func HashData(data []byte, algo string) (*Digest, error) {
+	h, err := newHash(algo)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(data)
+	return &Digest{algo: algo, sum: h.Sum(nil)}, nil
+}
+
+// HashReader computes digest of the data read from r until EOF. It is
+// useful for large documents, which should not be loaded into memory.
+func HashReader(r io.Reader, algo string) (*Digest, error) {
+	h, err := newHash(algo)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return &Digest{algo: algo, sum: h.Sum(nil)}, nil
+}
+
+// GenerateDigest generates digest of random bytes. Unlike GenerateAuthHash
+// it returns error for unknown algorithm.
+func GenerateDigest(algo string) (*Digest, error) {
+	return HashData(randByteGenerator(nbytes), algo)
+}
+
+// Algorithm returns hash algorithm of the digest, e.g. SHA512.
+func (d *Digest) Algorithm() string {
+	return d.algo
+}
+
+// AuthHash returns hash sum of the digest.
+func (d *Digest) AuthHash() AuthHash {
+	return d.sum
+}
+
+// CryptoHash returns crypto.Hash of the digest algorithm.
+func (d *Digest) CryptoHash() crypto.Hash {
+	h, _ := hashFunc(d.algo)
+	return h
+}
+
+// CalculateVerificationCode computes the verification code of the digest.
+func (d *Digest) CalculateVerificationCode() string {
+	return d.sum.CalculateVerificationCode()
+}
+
+// hashFunc resolves crypto.Hash for the Smart-ID hash type.
+func hashFunc(algo string) (crypto.Hash, error) {
+	switch algo {
+	case SHA256:
+		return crypto.SHA256, nil
+	case SHA384:
+		return crypto.SHA384, nil
+	case SHA512:
+		return crypto.SHA512, nil
+	case SHA3_256:
+		return crypto.SHA3_256, nil
+	case SHA3_384:
+		return crypto.SHA3_384, nil
+	case SHA3_512:
+		return crypto.SHA3_512, nil
+	default:
+		return 0, ErrHashUnsupported
+	}
+}
+
+// newHash creates hash.Hash for the Smart-ID hash type.
+func newHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case SHA256:
+		return sha256.New(), nil
+	case SHA384:
+		return sha512.New384(), nil
+	case SHA512:
+		return sha512.New(), nil
+	case SHA3_256:
+		return sha3.New256(), nil
+	case SHA3_384:
+		return sha3.New384(), nil
+	case SHA3_512:
+		return sha3.New512(), nil
+	default:
+		return nil, ErrHashUnsupported
+	}
+}
+
+// checkHash checks that hash length matches the hash type.
+func checkHash(h AuthHash, algo string) error {
+	f, err := hashFunc(algo)
+	if err != nil {
+		return err
+	}
+	if len(h) != f.Size() {
+		return ErrHashLengthMismatch
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package smartid
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHashData(t *testing.T) {
+	testdata := map[string]string{
+		SHA256:   "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		SHA3_256: "3338be694f50c5f338814986cdf0686453a888b84f424d792af4b9202398f392",
+	}
+	for algo, exp := range testdata {
+		d, err := HashData([]byte("hello"), algo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(d.AuthHash()); got != exp {
+			t.Error(algo, "expected", exp, "got", got)
+		}
+		if d.Algorithm() != algo {
+			t.Error("expected", algo, "got", d.Algorithm())
+		}
+	}
+
+	if _, err := HashData([]byte("hello"), "MD5"); !errors.Is(err, ErrHashUnsupported) {
+		t.Error("expected", ErrHashUnsupported, "got", err)
+	}
+}
+
+func TestHashReader(t *testing.T) {
+	data := bytes.Repeat([]byte("smart-id"), 10000)
+	for _, algo := range []string{SHA256, SHA384, SHA512, SHA3_256, SHA3_384, SHA3_512} {
+		d1, err := HashReader(bytes.NewReader(data), algo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d2, _ := HashData(data, algo)
+		if !bytes.Equal(d1.AuthHash(), d2.AuthHash()) {
+			t.Error(algo, "reader and data digests differ")
+		}
+		if len(d1.AuthHash()) != d1.CryptoHash().Size() {
+			t.Error(algo, "unexpected length", len(d1.AuthHash()))
+		}
+	}
+}
+
+func TestNewDigest(t *testing.T) {
+	if _, err := NewDigest(SHA512, make([]byte, 32)); !errors.Is(err, ErrHashLengthMismatch) {
+		t.Error("expected", ErrHashLengthMismatch, "got", err)
+	}
+	d, err := NewDigest(SHA3_256, make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.CryptoHash() != crypto.SHA3_256 {
+		t.Error("expected", crypto.SHA3_256, "got", d.CryptoHash())
+	}
+	if _, err := GenerateDigest("SHA1"); !errors.Is(err, ErrHashUnsupported) {
+		t.Error("expected", ErrHashUnsupported, "got", err)
+	}
+}
+
+func TestClient_Digest(t *testing.T) {
+	var payload map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			json.Unmarshal(body, &payload)
+			w.WriteHeader(http.StatusNotFound)
+		}))
+	defer server.Close()
+	client := NewClient(server.URL+"/", 1000)
+
+	digest, _ := HashData([]byte("contract"), SHA256)
+	_, err := client.SignSync(context.TODO(), &AuthRequest{
+		Digest:     digest,
+		Identifier: "PNOEE-30303039914",
+	})
+	var respErr *Error
+	if !errors.As(err, &respErr) || respErr.Code != http.StatusNotFound {
+		t.Fatal("expected not found got", err)
+	}
+	if payload["hashType"] != SHA256 {
+		t.Error("expected", SHA256, "got", payload["hashType"])
+	}
+	if payload["hash"] != digest.AuthHash().ToBase64String() {
+		t.Error("unexpected hash", payload["hash"])
+	}
+
+	_, err = client.SignSync(context.TODO(), &AuthRequest{
+		Digest:   digest,
+		HashType: SHA512,
+	})
+	if !errors.Is(err, ErrHashTypeMismatch) {
+		t.Error("expected", ErrHashTypeMismatch, "got", err)
+	}
+
+	_, err = client.AuthenticateSync(context.TODO(), &AuthRequest{
+		Hash:     GenerateAuthHash(SHA256),
+		HashType: SHA512,
+	})
+	if !errors.Is(err, ErrHashLengthMismatch) {
+		t.Error("expected", ErrHashLengthMismatch, "got", err)
+	}
+
+	resp := <-client.Authenticate(context.TODO(), &AuthRequest{
+		Hash: GenerateAuthHash("MD5"),
+	})
+	if resp.Message != ErrHashLengthMismatch.Error() {
+		t.Error("expected", ErrHashLengthMismatch, "got", resp.Message)
+	}
+}
+
+func TestSignDigest_sha3(t *testing.T) {
+	mock := newMockSmartID(t)
+	client := mock.client()
+	for _, algo := range []string{SHA3_256, SHA3_384, SHA3_512} {
+		digest, err := HashData([]byte("contract"), algo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := signDigest(context.TODO(), client, &AuthRequest{
+			Identifier: "PNOEE-30303039914",
+		}, digest, mock.signCert)
+		if err != nil {
+			t.Fatal(algo, err)
+		}
+		if resp.Signature.resolveSignatureAlgo() != digest.CryptoHash() {
+			t.Error(algo, "expected", digest.CryptoHash(), "got", resp.Signature.Algorithm)
+		}
+
+		// Algorithm of other hash type does not verify.
+		resp.Signature.Algorithm = "sha512WithRSAEncryption"
+		if resp.IsValidSignature() {
+			t.Error(algo, "expected invalid signature of mismatching algorithm")
+		}
+	}
+}
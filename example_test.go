@@ -30,6 +30,25 @@ func ExampleAuthHash_CalculateVerificationCode() {
 	// Output: 3174
 }
 
+func ExampleHashData() {
+	digest, err := HashData([]byte("Hello, Smart-ID!"), SHA256)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	request := AuthRequest{
+		RelyingPartyUUID: "00000000-0000-0000-0000-000000000000",
+		RelyingPartyName: "DEMO",
+		// Hash and HashType are set from the digest automatically.
+		Digest:     digest,
+		Identifier: "PNOEE-30303039914",
+	}
+	fmt.Println(request.Digest.Algorithm())
+	fmt.Println(request.Digest.CalculateVerificationCode())
+	// Output:
+	// SHA256
+	// 4516
+}
+
 func ExampleNewSemanticIdentifier() {
 	semid := NewSemanticIdentifier(IdentifierTypePNO, CountryEE, "12345678901")
 	fmt.Println(semid)
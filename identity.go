@@ -2,9 +2,17 @@ package smartid
 
 import (
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"strings"
 )
 
+// Object identifiers of the subject attributes which are not exposed
+// by pkix.Name directly.
+var (
+	oidAttributeSurname   = asn1.ObjectIdentifier{2, 5, 4, 4}
+	oidAttributeGivenName = asn1.ObjectIdentifier{2, 5, 4, 42}
+)
+
 // Identity represents simpler format of PKIX Subject.
 type Identity struct {
 	Country            string
@@ -16,6 +24,14 @@ type Identity struct {
 	PostalCode         string
 	SerialNumber       string
 	CommonName         string
+
+	// GivenName is the given name (GN) attribute of the subject. If the
+	// certificate has no such attribute, it is empty.
+	GivenName string
+
+	// Surname is the surname (SN) attribute of the subject. If the
+	// certificate has no such attribute, it is empty.
+	Surname string
 }
 
 // newIdentity makes identity from PKIX Subject retrieved
@@ -26,7 +42,7 @@ type Identity struct {
 //
 // TODO: refactor
 func newIdentity(n *pkix.Name) *Identity {
-	return &Identity{
+	identity := &Identity{
 		Country:            strings.Join(n.Country, ""),
 		Organization:       strings.Join(n.Organization, ""),
 		OrganizationalUnit: strings.Join(n.OrganizationalUnit, ""),
@@ -37,4 +53,17 @@ func newIdentity(n *pkix.Name) *Identity {
 		SerialNumber:       n.SerialNumber,
 		CommonName:         n.CommonName,
 	}
+	for _, atv := range n.Names {
+		v, ok := atv.Value.(string)
+		if !ok {
+			continue
+		}
+		switch {
+		case atv.Type.Equal(oidAttributeGivenName):
+			identity.GivenName = v
+		case atv.Type.Equal(oidAttributeSurname):
+			identity.Surname = v
+		}
+	}
+	return identity
 }
@@ -0,0 +1,60 @@
+// Package httputil provides helpers shared by the HTTP servers built on
+// smartid: random tokens, JSON responses and maps of entries, which
+// expire.
+package httputil
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// RandomString returns random URL-safe string of 32 bytes.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// WriteJSON writes JSON response with the status.
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// RemoveExpired removes expired entries of the map and returns them, lock
+// of the map must be held.
+func RemoveExpired[V any](m map[string]V, expires func(V) time.Time) []V {
+	var removed []V
+	now := time.Now()
+	for k, v := range m {
+		if now.After(expires(v)) {
+			removed = append(removed, v)
+			delete(m, k)
+		}
+	}
+	return removed
+}
+
+// Evict removes entries which expire first until there is room for one
+// more entry in the map and returns them, lock of the map must be held.
+func Evict[V any](m map[string]V, max int, expires func(V) time.Time) []V {
+	var removed []V
+	for len(m) > 0 && len(m) >= max {
+		var first string
+		var at time.Time
+		for k, v := range m {
+			if at.IsZero() || expires(v).Before(at) {
+				first, at = k, expires(v)
+			}
+		}
+		removed = append(removed, m[first])
+		delete(m, first)
+	}
+	return removed
+}
@@ -0,0 +1,36 @@
+package httputil
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRemoveExpired(t *testing.T) {
+	now := time.Now()
+	m := map[string]time.Time{
+		"expired": now.Add(-time.Minute),
+		"valid":   now.Add(time.Minute),
+	}
+	removed := RemoveExpired(m, func(t time.Time) time.Time { return t })
+	if _, ok := m["valid"]; len(removed) != 1 || len(m) != 1 || !ok {
+		t.Error("expected expired entry to be removed, got", removed, m)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	now := time.Now()
+	m := make(map[string]time.Time)
+	for i := 0; i < 10; i++ {
+		m[strconv.Itoa(i)] = now.Add(time.Duration(i) * time.Minute)
+	}
+	expires := func(t time.Time) time.Time { return t }
+	removed := Evict(m, 8, expires)
+	if _, ok := m["0"]; len(m) != 7 || ok || len(removed) != 3 || !removed[0].Equal(now) {
+		t.Error("expected 7 entries without the first expiring, got", m)
+	}
+	Evict(m, 8, expires)
+	if len(m) != 7 {
+		t.Error("expected 7 entries, got", len(m))
+	}
+}
@@ -0,0 +1,261 @@
+// Package smartidtest provides local stand-in of the Smart-ID relying
+// party API for tests of the packages built on smartid. It signs whatever
+// hash is requested with the key of the test certificates.
+package smartidtest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dknight/go-smartid"
+)
+
+// DocumentNumber is the document number returned by the server.
+const DocumentNumber = "PNOEE-30303039914-MOCK-Q"
+
+// Identifier is the semantic identifier of the test person. Identifiers
+// containing REFUSED are refused by the user.
+const Identifier = "PNOEE-30303039914"
+
+// RefusedIdentifier is the semantic identifier of the person who refuses
+// the sessions, along with the document numbers of the person.
+const RefusedIdentifier = "PNOEE-30303039903"
+
+var (
+	keysOnce sync.Once
+	caKey    *rsa.PrivateKey
+	userKey  *rsa.PrivateKey
+	keysErr  error
+)
+
+// Server is the local Smart-ID API.
+type Server struct {
+	*httptest.Server
+
+	CA                 *x509.Certificate
+	CAKey              *rsa.PrivateKey
+	AuthCert, SignCert *x509.Certificate
+	Key                *rsa.PrivateKey
+
+	// Running is the number of RUNNING responses of the session before
+	// it completes.
+	Running int
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	requests []map[string]interface{}
+}
+
+type session struct {
+	endpoint, identifier string
+	hash                 []byte
+	hashType             string
+	polls                int
+}
+
+// NewServer starts the server with authentication and signing
+// certificates issued by the test CA. Server is closed with the test.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	keysOnce.Do(func() {
+		if caKey, keysErr = rsa.GenerateKey(rand.Reader, 2048); keysErr == nil {
+			userKey, keysErr = rsa.GenerateKey(rand.Reader, 2048)
+		}
+	})
+	if keysErr != nil {
+		t.Fatal(keysErr)
+	}
+
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "TEST of EID-SK 2016", Country: []string{"EE"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	s := &Server{
+		CAKey:    caKey,
+		Key:      userKey,
+		sessions: make(map[string]*session),
+	}
+	s.CA = newCert(t, caTmpl, caTmpl, &caKey.PublicKey)
+	// NCP+ and QCP-n-qscd policies of ETSI EN 319 411.
+	s.AuthCert = newCert(t, leaf(x509.KeyUsageDigitalSignature, 0, 4, 0, 2042, 1, 2), s.CA, &userKey.PublicKey)
+	s.SignCert = newCert(t, leaf(x509.KeyUsageContentCommitment, 0, 4, 0, 194112, 1, 2), s.CA, &userKey.PublicKey)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Client returns client configured for the server.
+func (s *Server) Client() *smartid.Client {
+	return smartid.NewClient(s.URL+"/", 1000)
+}
+
+// Requests returns bodies of the session requests received.
+func (s *Server) Requests() []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]interface{}(nil), s.requests...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if r.Method == http.MethodGet && len(parts) == 2 && parts[0] == "session" {
+		s.serveSession(w, parts[1])
+		return
+	}
+	if r.Method != http.MethodPost || len(parts) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hash, _ := base64.StdEncoding.DecodeString(fmt.Sprint(req["hash"]))
+	hashType, _ := req["hashType"].(string)
+	req["endpoint"] = parts[0]
+	req["identifier"] = parts[2]
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	id := fmt.Sprintf("session-%d", len(s.sessions))
+	s.sessions[id] = &session{
+		endpoint:   parts[0],
+		identifier: parts[2],
+		hash:       hash,
+		hashType:   hashType,
+	}
+	s.mu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]string{"sessionID": id})
+}
+
+func (s *Server) serveSession(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	sess, ok := s.sessions[id]
+	var running bool
+	if ok {
+		running = sess.polls < s.Running
+		sess.polls++
+	}
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	if running {
+		json.NewEncoder(w).Encode(map[string]string{"state": smartid.SessionStatusRunning})
+		return
+	}
+
+	resp := map[string]interface{}{
+		"state":               smartid.SessionStatusComplete,
+		"interactionFlowUsed": smartid.InteractionDisplayTextAndPIN,
+	}
+	if strings.Contains(sess.identifier, "REFUSED") ||
+		strings.HasPrefix(sess.identifier, RefusedIdentifier) {
+		resp["result"] = map[string]string{
+			"endResult": smartid.SessionResultUserRefusedDisplayTextAndPIN,
+		}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	cert := s.SignCert
+	if sess.endpoint == smartid.EndpointAuthentication {
+		cert = s.AuthCert
+	}
+	resp["result"] = map[string]string{
+		"endResult":      smartid.SessionResultOK,
+		"documentNumber": DocumentNumber,
+	}
+	resp["cert"] = map[string]string{
+		"value":            base64.StdEncoding.EncodeToString(cert.Raw),
+		"certificateLevel": smartid.CertLevelQualified,
+	}
+	if sess.endpoint != smartid.EndpointCertificateChoice {
+		h, name := hashAlgorithm(sess.hashType)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, s.Key, h, sess.hash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp["signature"] = map[string]string{
+			"value":     base64.StdEncoding.EncodeToString(sig),
+			"algorithm": name,
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// hashAlgorithm returns hash function and Smart-ID signature algorithm name
+// of the hash type.
+func hashAlgorithm(hashType string) (crypto.Hash, string) {
+	switch hashType {
+	case smartid.SHA256:
+		return crypto.SHA256, "sha256WithRSAEncryption"
+	case smartid.SHA384:
+		return crypto.SHA384, "sha384WithRSAEncryption"
+	case smartid.SHA3_256:
+		return crypto.SHA3_256, "sha3-256WithRSAEncryption"
+	case smartid.SHA3_384:
+		return crypto.SHA3_384, "sha3-384WithRSAEncryption"
+	case smartid.SHA3_512:
+		return crypto.SHA3_512, "sha3-512WithRSAEncryption"
+	default:
+		return crypto.SHA512, "sha512WithRSAEncryption"
+	}
+}
+
+// leaf returns template of the test person certificate with the policy.
+func leaf(usage x509.KeyUsage, policy ...uint64) *x509.Certificate {
+	oid, _ := x509.OIDFromInts(policy)
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject: pkix.Name{
+			CommonName:   "TESTNUMBER,OK",
+			SerialNumber: Identifier,
+			Country:      []string{"EE"},
+			ExtraNames: []pkix.AttributeTypeAndValue{
+				{Type: []int{2, 5, 4, 42}, Value: "OK"},
+				{Type: []int{2, 5, 4, 4}, Value: "TESTNUMBER"},
+			},
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(12 * time.Hour),
+		KeyUsage:  usage,
+		Policies:  []x509.OID{oid},
+	}
+}
+
+func newCert(t testing.TB, tmpl, parent *x509.Certificate, pub *rsa.PublicKey) *x509.Certificate {
+	t.Helper()
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
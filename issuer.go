@@ -0,0 +1,89 @@
+package smartid
+
+import "strings"
+
+// Smart-ID environments. Production environment accepts only certificates
+// issued by production CAs.
+const (
+	// EnvironmentProduction is the live Smart-ID service.
+	EnvironmentProduction = "PRODUCTION"
+
+	// EnvironmentTest is the demo Smart-ID service.
+	EnvironmentTest = "TEST"
+)
+
+// productionAPIHost is the host of the production Smart-ID relying party
+// API. Clients which use it are switched to production environment
+// automatically.
+const productionAPIHost = "rp-api.smart-id.com"
+
+// testIssuerPrefix is the common name prefix of all SK test CAs.
+const testIssuerPrefix = "TEST of "
+
+// Issuer describes the certification authority which issues Smart-ID
+// certificates.
+type Issuer struct {
+	// CommonName is the common name of the CA as it appears in the issuer
+	// field of the certificate.
+	CommonName string
+
+	// Level is the level of certificates issued by the CA:
+	//	QUALIFIED
+	//	ADVANCED
+	Level string
+
+	// Environment is where the CA is used:
+	//	PRODUCTION
+	//	TEST
+	Environment string
+}
+
+// IsTest checks that issuer is the test CA.
+func (i Issuer) IsTest() bool {
+	return i.Environment == EnvironmentTest
+}
+
+// knownIssuers is the list of SK CAs which issue Smart-ID certificates.
+// https://www.skidsolutions.eu/resources/certificates/
+var knownIssuers = []Issuer{
+	{"EID-SK 2016", CertLevelQualified, EnvironmentProduction},
+	{"NQ-SK 2016", CertLevelAdvanced, EnvironmentProduction},
+	{"EID-Q 2021E", CertLevelQualified, EnvironmentProduction},
+	{"EID-Q 2021R", CertLevelQualified, EnvironmentProduction},
+	{"EID-NQ 2021E", CertLevelAdvanced, EnvironmentProduction},
+	{"EID-NQ 2021R", CertLevelAdvanced, EnvironmentProduction},
+	{"TEST of EID-SK 2016", CertLevelQualified, EnvironmentTest},
+	{"TEST of NQ-SK 2016", CertLevelAdvanced, EnvironmentTest},
+	{"TEST of EID-Q 2021E", CertLevelQualified, EnvironmentTest},
+	{"TEST of EID-Q 2021R", CertLevelQualified, EnvironmentTest},
+	{"TEST of EID-NQ 2021E", CertLevelAdvanced, EnvironmentTest},
+	{"TEST of EID-NQ 2021R", CertLevelAdvanced, EnvironmentTest},
+}
+
+// KnownIssuers returns the list of known SK CAs issuing Smart-ID
+// certificates.
+func KnownIssuers() []Issuer {
+	issuers := make([]Issuer, len(knownIssuers))
+	copy(issuers, knownIssuers)
+	return issuers
+}
+
+// LookupIssuer finds known issuer by its common name.
+func LookupIssuer(commonName string) (Issuer, bool) {
+	for _, issuer := range knownIssuers {
+		if issuer.CommonName == commonName {
+			return issuer, true
+		}
+	}
+	return Issuer{}, false
+}
+
+// isTestIssuerName checks that common name belongs to the test CA, even if
+// the CA is not in the list of known issuers.
+func isTestIssuerName(commonName string) bool {
+	if issuer, ok := LookupIssuer(commonName); ok {
+		return issuer.IsTest()
+	}
+	return strings.HasPrefix(strings.ToUpper(commonName),
+		strings.ToUpper(testIssuerPrefix))
+}
@@ -0,0 +1,96 @@
+package smartid
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"testing"
+)
+
+func TestLookupIssuer(t *testing.T) {
+	issuer, ok := LookupIssuer("EID-Q 2021E")
+	if !ok {
+		t.Fatal("EID-Q 2021E not found")
+	}
+	if issuer.IsTest() || issuer.Level != CertLevelQualified {
+		t.Error("unexpected issuer", issuer)
+	}
+	if _, ok := LookupIssuer("Unknown CA"); ok {
+		t.Error("unknown CA should not be found")
+	}
+	for _, issuer := range KnownIssuers() {
+		if issuer.IsTest() != isTestIssuerName(issuer.CommonName) {
+			t.Error("environment mismatch for", issuer.CommonName)
+		}
+	}
+	if !isTestIssuerName("TEST of EID-Q 2030") {
+		t.Error("unknown TEST CA should be detected by name")
+	}
+}
+
+func TestNewClient_environment(t *testing.T) {
+	client := NewClient("https://rp-api.smart-id.com/v2/", 5000)
+	if client.environment != EnvironmentProduction {
+		t.Error("expected production got", client.environment)
+	}
+	client = NewClient("https://sid.demo.sk.ee/smart-id-rp/v2/", 5000)
+	if client.environment != "" {
+		t.Error("expected no environment got", client.environment)
+	}
+	client = NewClient("https://sid.demo.sk.ee/smart-id-rp/v2/", 5000,
+		WithEnvironment(EnvironmentTest))
+	if client.environment != EnvironmentTest {
+		t.Error("expected test got", client.environment)
+	}
+}
+
+func TestSessionResponse_Validate_environment(t *testing.T) {
+	testCA, testCAKey := newTestCA(t, "TEST of EID-SK 2016")
+	testLeaf, key := newTestLeaf(t, &x509.Certificate{}, testCA, testCAKey)
+
+	resp := newTestSessionResponse(t, testLeaf, key, Session{
+		environment: EnvironmentTest,
+	})
+	if _, err := resp.Validate(); err != nil {
+		t.Error("test certificate should be valid in test", err)
+	}
+
+	resp = newTestSessionResponse(t, testLeaf, key, Session{
+		environment: EnvironmentProduction,
+	})
+	if _, err := resp.Validate(); !errors.Is(err, ErrCertTestIssuer) {
+		t.Error("expected", ErrCertTestIssuer, "got", err)
+	}
+
+	prodCA, prodCAKey := newTestCA(t, "EID-SK 2016")
+	prodLeaf, key := newTestLeaf(t, &x509.Certificate{}, prodCA, prodCAKey)
+	resp = newTestSessionResponse(t, prodLeaf, key, Session{
+		environment: EnvironmentProduction,
+	})
+	if _, err := resp.Validate(); err != nil {
+		t.Error("production certificate should be valid", err)
+	}
+}
+
+func TestCert_VerifyWithTrustStore_environment(t *testing.T) {
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+
+	for _, env := range []string{EnvironmentTest, EnvironmentProduction} {
+		client := NewClient(mock.URL+"/", 1000, WithEnvironment(env))
+		resp, err := client.ChooseCertificateSync(context.Background(), &AuthRequest{
+			Identifier: "PNOEE-30303039914",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, err := resp.Cert.VerifyWithTrustStore(context.Background(), ts)
+		if env == EnvironmentTest && (!ok || err != nil) {
+			t.Error("test certificate should be valid in test", err)
+		}
+		if env == EnvironmentProduction && (ok || !errors.Is(err, ErrCertTestIssuer)) {
+			t.Error("expected", ErrCertTestIssuer, "got", err)
+		}
+	}
+}
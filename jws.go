@@ -0,0 +1,381 @@
+package smartid
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// JWS signature algorithms. Smart-ID signs with RSASSA-PKCS1-v1_5 (RS256,
+// ...), RSASSA-PSS (PS256, ...) signatures are only verified.
+const (
+	JWSAlgorithmRS256 = "RS256"
+	JWSAlgorithmRS384 = "RS384"
+	JWSAlgorithmRS512 = "RS512"
+	JWSAlgorithmPS256 = "PS256"
+	JWSAlgorithmPS384 = "PS384"
+	JWSAlgorithmPS512 = "PS512"
+)
+
+var (
+	// ErrJWSNotSigned error when signature value is not yet set.
+	ErrJWSNotSigned = errors.New("JWS signature value is not set")
+
+	// ErrJWSMalformed error when JWS structure is invalid.
+	ErrJWSMalformed = errors.New("Malformed JWS")
+
+	// ErrJWSAlgorithmUnsupported error when JWS algorithm is not supported.
+	ErrJWSAlgorithmUnsupported = errors.New("Unsupported JWS algorithm")
+
+	// ErrJWSAlgorithmMismatch error when Smart-ID signature algorithm
+	// differs from the algorithm in the JWS header.
+	ErrJWSAlgorithmMismatch = errors.New("Signature algorithm does not match JWS header")
+
+	// ErrJWSCritUnsupported error when JWS has critical header parameter,
+	// which is not understood.
+	ErrJWSCritUnsupported = errors.New("Unsupported critical JWS header")
+)
+
+// jwsHeader is the protected header of JAdES baseline signature.
+type jwsHeader struct {
+	Alg     string   `json:"alg"`
+	Typ     string   `json:"typ,omitempty"`
+	Cty     string   `json:"cty,omitempty"`
+	Kid     string   `json:"kid,omitempty"`
+	X5c     []string `json:"x5c,omitempty"`
+	X5tS256 string   `json:"x5t#S256,omitempty"`
+	SigT    string   `json:"sigT,omitempty"`
+	Crit    []string `json:"crit,omitempty"`
+}
+
+// jwsCritSupported are the critical header parameters understood by the
+// verifier.
+var jwsCritSupported = map[string]bool{"sigT": true}
+
+// jwsSignatureJSON is one signature of JWS JSON serialization.
+type jwsSignatureJSON struct {
+	Protected string `json:"protected"`
+	Signature string `json:"signature"`
+}
+
+// jwsJSON is JWS JSON serialization, general or flattened.
+type jwsJSON struct {
+	Payload    string             `json:"payload"`
+	Signatures []jwsSignatureJSON `json:"signatures,omitempty"`
+	Protected  string             `json:"protected,omitempty"`
+	Signature  string             `json:"signature,omitempty"`
+}
+
+// JWSOptions are optional parameters of JWS signature.
+type JWSOptions struct {
+	// Type is the typ header, e.g. "JOSE".
+	Type string
+
+	// ContentType is the cty header, e.g. "json".
+	ContentType string
+
+	// KeyID is the kid header.
+	KeyID string
+
+	// Chain are the certificates included in x5c header after the signer
+	// certificate.
+	Chain []*x509.Certificate
+}
+
+// JWSSignature is JAdES baseline B signature of the JSON payload which is
+// being prepared. Digest of the JWS signing input is signed with Smart-ID
+// and the value is set with SetSignatureValue.
+type JWSSignature struct {
+	alg         string
+	cert        *x509.Certificate
+	signingTime time.Time
+	protected   string
+	payload     string
+	digest      *Digest
+	value       []byte
+}
+
+// PrepareJWS makes protected header with the algorithm, x5c certificate
+// chain, x5t#S256 certificate digest and sigT signing time, and computes
+// digest of the signing input. Algorithm must be SHA256, SHA384 or SHA512.
+func PrepareJWS(payload []byte, cert *x509.Certificate, algo string, opts *JWSOptions) (*JWSSignature, error) {
+	if opts == nil {
+		opts = &JWSOptions{}
+	}
+	if _, ok := cert.PublicKey.(*rsa.PublicKey); !ok {
+		return nil, ErrSignatureKeyUnsupported
+	}
+	alg, err := jwsAlgorithm(algo)
+	if err != nil {
+		return nil, err
+	}
+	s := &JWSSignature{
+		alg:         alg,
+		cert:        cert,
+		signingTime: time.Now().UTC().Truncate(time.Second),
+		payload:     base64.RawURLEncoding.EncodeToString(payload),
+	}
+
+	thumbprint := sha256.Sum256(cert.Raw)
+	header := jwsHeader{
+		Alg:     alg,
+		Typ:     opts.Type,
+		Cty:     opts.ContentType,
+		Kid:     opts.KeyID,
+		X5tS256: base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+		SigT:    s.signingTime.Format(time.RFC3339),
+		Crit:    []string{"sigT"},
+	}
+	for _, c := range append([]*x509.Certificate{cert}, opts.Chain...) {
+		header.X5c = append(header.X5c, base64.StdEncoding.EncodeToString(c.Raw))
+	}
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	s.protected = base64.RawURLEncoding.EncodeToString(data)
+
+	if s.digest, err = HashData([]byte(s.protected+"."+s.payload), algo); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Algorithm returns JWS algorithm of the signature, e.g. RS256.
+func (s *JWSSignature) Algorithm() string {
+	return s.alg
+}
+
+// Digest returns digest of the signing input, which is signed by
+// Smart-ID.
+func (s *JWSSignature) Digest() *Digest {
+	return s.digest
+}
+
+// SigningTime returns claimed signing time.
+func (s *JWSSignature) SigningTime() time.Time {
+	return s.signingTime
+}
+
+// Certificate returns the signer certificate.
+func (s *JWSSignature) Certificate() *x509.Certificate {
+	return s.cert
+}
+
+// SetSignatureValue sets the signature value returned by Smart-ID. The
+// signature algorithm must match the JWS header and value is verified
+// with the signer certificate.
+func (s *JWSSignature) SetSignatureValue(sig Signature) error {
+	if sig.Algorithm != "" {
+		if strings.Contains(strings.ToLower(sig.Algorithm), "pss") {
+			return ErrJWSAlgorithmMismatch
+		}
+		if h := sig.resolveSignatureAlgo(); h != 0 && h != s.digest.CryptoHash() {
+			return ErrJWSAlgorithmMismatch
+		}
+	}
+	value, err := base64.StdEncoding.DecodeString(sig.Value)
+	if err != nil {
+		return err
+	}
+	if err := jwsVerify(s.cert, s.alg, s.digest, value); err != nil {
+		return err
+	}
+	s.value = value
+	return nil
+}
+
+// Sign signs the signing input with Smart-ID using SignSync. Request
+// should have the identifier of the person, preferably document number
+// from the certificate choice. Digest of the request is set automatically.
+func (s *JWSSignature) Sign(ctx context.Context, c *Client, req *AuthRequest) (*SessionResponse, error) {
+	resp, err := signDigest(ctx, c, req, s.digest, s.cert)
+	if err != nil {
+		return resp, err
+	}
+	return resp, s.SetSignatureValue(resp.Signature)
+}
+
+// Compact returns JWS compact serialization.
+func (s *JWSSignature) Compact() (string, error) {
+	if s.value == nil {
+		return "", ErrJWSNotSigned
+	}
+	return s.protected + "." + s.payload + "." +
+		base64.RawURLEncoding.EncodeToString(s.value), nil
+}
+
+// JSON returns JWS general JSON serialization.
+func (s *JWSSignature) JSON() ([]byte, error) {
+	if s.value == nil {
+		return nil, ErrJWSNotSigned
+	}
+	return json.Marshal(jwsJSON{
+		Payload: s.payload,
+		Signatures: []jwsSignatureJSON{{
+			Protected: s.protected,
+			Signature: base64.RawURLEncoding.EncodeToString(s.value),
+		}},
+	})
+}
+
+// VerifyJWS validates JWS in compact, general or flattened JSON
+// serialization. Decoded payload and result of each signature are
+// returned. Error is returned if the JWS cannot be parsed.
+func VerifyJWS(ctx context.Context, token []byte, ts *TrustStore) ([]byte, []*SignatureResult, error) {
+	var doc jwsJSON
+	if s := strings.TrimSpace(string(token)); strings.HasPrefix(s, "{") {
+		if err := json.Unmarshal(token, &doc); err != nil {
+			return nil, nil, err
+		}
+		if doc.Protected != "" || doc.Signature != "" {
+			doc.Signatures = append(doc.Signatures, jwsSignatureJSON{
+				Protected: doc.Protected,
+				Signature: doc.Signature,
+			})
+		}
+	} else {
+		parts := strings.Split(s, ".")
+		if len(parts) != 3 {
+			return nil, nil, ErrJWSMalformed
+		}
+		doc.Payload = parts[1]
+		doc.Signatures = []jwsSignatureJSON{{Protected: parts[0], Signature: parts[2]}}
+	}
+	if len(doc.Signatures) == 0 {
+		return nil, nil, ErrJWSMalformed
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(doc.Payload)
+	if err != nil {
+		return nil, nil, ErrJWSMalformed
+	}
+
+	results := make([]*SignatureResult, len(doc.Signatures))
+	for i, sig := range doc.Signatures {
+		results[i] = verifyJWSSignature(ctx, ts, doc.Payload, sig)
+	}
+	return payload, results, nil
+}
+
+// verifyJWSSignature verifies one signature over the encoded payload.
+func verifyJWSSignature(ctx context.Context, ts *TrustStore, payload string, sig jwsSignatureJSON) *SignatureResult {
+	r := &SignatureResult{}
+	data, err := base64.RawURLEncoding.DecodeString(sig.Protected)
+	if err != nil {
+		r.fail(ErrJWSMalformed)
+		return r
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(data, &header); err != nil {
+		r.fail(ErrJWSMalformed)
+		return r
+	}
+	r.ID = header.Kid
+	for _, name := range header.Crit {
+		if !jwsCritSupported[name] {
+			r.fail(ErrJWSCritUnsupported)
+			return r
+		}
+	}
+
+	var chain []*x509.Certificate
+	for _, enc := range header.X5c {
+		der, err := base64.StdEncoding.DecodeString(enc)
+		if err != nil {
+			r.fail(ErrJWSMalformed)
+			return r
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			r.fail(err)
+			return r
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		r.fail(ErrSignerCertNotFound)
+		return r
+	}
+	r.setSigner(chain[0])
+	if header.X5tS256 != "" {
+		thumbprint := sha256.Sum256(chain[0].Raw)
+		if header.X5tS256 != base64.RawURLEncoding.EncodeToString(thumbprint[:]) {
+			r.fail(ErrSigningCertMismatch)
+		}
+	}
+	if header.SigT != "" {
+		if r.SigningTime, err = time.Parse(time.RFC3339, header.SigT); err != nil {
+			r.fail(ErrJWSMalformed)
+		}
+	}
+
+	algo, ok := jwsHashes[header.Alg]
+	if !ok {
+		r.fail(ErrJWSAlgorithmUnsupported)
+		return r
+	}
+	digest, _ := HashData([]byte(sig.Protected+"."+payload), algo)
+	value, err := base64.RawURLEncoding.DecodeString(sig.Signature)
+	if err != nil {
+		r.fail(ErrJWSMalformed)
+		return r
+	}
+	if err := jwsVerify(chain[0], header.Alg, digest, value); err != nil {
+		r.fail(err)
+		return r
+	}
+
+	r.verifyCertificate(ctx, ts, chain[1:], nil)
+	if r.OCSP == nil {
+		r.warn(ErrRevocationMissing)
+	}
+	return r
+}
+
+// jwsHashes maps JWS algorithms to hash types.
+var jwsHashes = map[string]string{
+	JWSAlgorithmRS256: SHA256,
+	JWSAlgorithmRS384: SHA384,
+	JWSAlgorithmRS512: SHA512,
+	JWSAlgorithmPS256: SHA256,
+	JWSAlgorithmPS384: SHA384,
+	JWSAlgorithmPS512: SHA512,
+}
+
+// jwsAlgorithm maps hash type to RSASSA-PKCS1-v1_5 JWS algorithm.
+func jwsAlgorithm(algo string) (string, error) {
+	for alg, h := range jwsHashes {
+		if h == algo && strings.HasPrefix(alg, "RS") {
+			return alg, nil
+		}
+	}
+	return "", ErrHashUnsupported
+}
+
+// jwsVerify verifies signature value of the signing input digest with the
+// scheme of the JWS algorithm.
+func jwsVerify(cert *x509.Certificate, alg string, digest *Digest, sig []byte) error {
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return ErrSignatureKeyUnsupported
+	}
+	var err error
+	switch alg[:2] {
+	case "RS":
+		err = rsa.VerifyPKCS1v15(pub, digest.CryptoHash(), digest.AuthHash(), sig)
+	case "PS":
+		err = rsa.VerifyPSS(pub, digest.CryptoHash(), digest.AuthHash(), sig, nil)
+	default:
+		return ErrJWSAlgorithmUnsupported
+	}
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
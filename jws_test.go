@@ -0,0 +1,164 @@
+package smartid
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestJWSSignature(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+	payload := []byte(`{"consent":"payment","amount":"10.00"}`)
+
+	testdata := []struct {
+		algo, alg string
+	}{
+		{SHA256, JWSAlgorithmRS256},
+		{SHA384, JWSAlgorithmRS384},
+		{SHA512, JWSAlgorithmRS512},
+	}
+	for _, test := range testdata {
+		s, err := PrepareJWS(payload, mock.signCert, test.algo, &JWSOptions{
+			Type:  "JOSE",
+			Chain: []*x509.Certificate{mock.ca},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Algorithm() != test.alg {
+			t.Error("expected", test.alg, "got", s.Algorithm())
+		}
+		if _, err := s.Compact(); err != ErrJWSNotSigned {
+			t.Error("expected", ErrJWSNotSigned, "got", err)
+		}
+		_, err = s.Sign(ctx, mock.client(), &AuthRequest{
+			Identifier: mockDocumentNumber,
+			AuthType:   AuthTypeDocument,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		compact, _ := s.Compact()
+		general, _ := s.JSON()
+		for _, token := range [][]byte{[]byte(compact), general} {
+			data, results, err := VerifyJWS(ctx, token, ts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != string(payload) {
+				t.Error("expected", string(payload), "got", string(data))
+			}
+			if len(results) != 1 || !results[0].IsValid() {
+				t.Fatal("expected valid signature, got", results[0].Err())
+			}
+			r := results[0]
+			if !r.SigningTime.Equal(s.SigningTime()) {
+				t.Error("expected", s.SigningTime(), "got", r.SigningTime)
+			}
+			if len(r.Chain) != 2 || r.Identity.SerialNumber != "PNOEE-30303039914" {
+				t.Error("expected verified chain, got", r.Chain, r.Identity)
+			}
+		}
+	}
+}
+
+func TestVerifyJWS_pss(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"mandate":"api"}`))
+
+	header, _ := json.Marshal(map[string]interface{}{
+		"alg": JWSAlgorithmPS256,
+		"x5c": []string{base64.StdEncoding.EncodeToString(mock.signCert.Raw)},
+	})
+	protected := base64.RawURLEncoding.EncodeToString(header)
+	d, _ := HashData([]byte(protected+"."+payload), SHA256)
+	value, _ := rsa.SignPSS(rand.Reader, mock.key, d.CryptoHash(), d.AuthHash(),
+		&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	flattened, _ := json.Marshal(map[string]string{
+		"payload":   payload,
+		"protected": protected,
+		"signature": base64.RawURLEncoding.EncodeToString(value),
+	})
+	_, results, err := VerifyJWS(ctx, flattened, ts)
+	if err != nil || len(results) != 1 || !results[0].IsValid() {
+		t.Error("expected valid signature, got", err, results)
+	}
+
+	// Smart-ID signature of RSASSA-PSS does not match RS256 header.
+	s, _ := PrepareJWS([]byte(`{"a":1}`), mock.signCert, SHA256, nil)
+	err = s.SetSignatureValue(Signature{
+		Value:     base64.StdEncoding.EncodeToString(value),
+		Algorithm: "rsassa-pss",
+	})
+	if err != ErrJWSAlgorithmMismatch {
+		t.Error("expected", ErrJWSAlgorithmMismatch, "got", err)
+	}
+}
+
+func TestVerifyJWS_invalid(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+
+	s, _ := PrepareJWS([]byte(`{"a":1}`), mock.signCert, SHA256, nil)
+	_, err := s.Sign(ctx, mock.client(), &AuthRequest{
+		Identifier: mockDocumentNumber,
+		AuthType:   AuthTypeDocument,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compact, _ := s.Compact()
+	parts := strings.Split(compact, ".")
+	header := func(modify func(h map[string]interface{})) string {
+		data, _ := base64.RawURLEncoding.DecodeString(parts[0])
+		var h map[string]interface{}
+		json.Unmarshal(data, &h)
+		modify(h)
+		data, _ = json.Marshal(h)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	testdata := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"payload", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"a":2}`)) + "." + parts[2], ErrSignatureInvalid},
+		{"alg none", header(func(h map[string]interface{}) { h["alg"] = "none" }) + "." + parts[1] + "." + parts[2], ErrJWSAlgorithmUnsupported},
+		{"alg", header(func(h map[string]interface{}) { h["alg"] = JWSAlgorithmPS256 }) + "." + parts[1] + "." + parts[2], ErrSignatureInvalid},
+		{"crit", header(func(h map[string]interface{}) { h["crit"] = []string{"b64"} }) + "." + parts[1] + "." + parts[2], ErrJWSCritUnsupported},
+		{"x5c", header(func(h map[string]interface{}) { delete(h, "x5c") }) + "." + parts[1] + "." + parts[2], ErrSignerCertNotFound},
+		{"x5t#S256", header(func(h map[string]interface{}) { h["x5t#S256"] = "AAAA" }) + "." + parts[1] + "." + parts[2], ErrSigningCertMismatch},
+	}
+	for _, test := range testdata {
+		_, results, err := VerifyJWS(ctx, []byte(test.token), ts)
+		if err != nil {
+			t.Fatal(test.name, err)
+		}
+		if r := results[0]; r.IsValid() || !errors.Is(r.Err(), test.err) {
+			t.Error(test.name, "expected", test.err, "got", r.Err())
+		}
+	}
+
+	if _, _, err := VerifyJWS(ctx, []byte(parts[0]+"."+parts[1]), ts); err != ErrJWSMalformed {
+		t.Error("expected", ErrJWSMalformed, "got", err)
+	}
+	if _, err := PrepareJWS(nil, mock.signCert, SHA3_256, nil); err != ErrHashUnsupported {
+		t.Error("expected", ErrHashUnsupported, "got", err)
+	}
+}
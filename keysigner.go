@@ -0,0 +1,119 @@
+package smartid
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrSignerOptsUnsupported error when crypto.SignerOpts is missing or
+// requests signature scheme, which Smart-ID does not produce, like
+// RSA-PSS.
+var ErrSignerOptsUnsupported = errors.New("Unsupported signer options")
+
+// KeySigner is crypto.Signer backed by the Smart-ID account, chosen with
+// certificate choice. Each Sign call runs signing session, which the user
+// confirms in the app, so it may take minutes.
+type KeySigner struct {
+	client         *Client
+	req            AuthRequest
+	cert           *x509.Certificate
+	documentNumber string
+
+	// Timeout limits the signing session of Sign, zero means no limit.
+	// SignContext uses deadline of its context.
+	Timeout time.Duration
+}
+
+// NewKeySigner creates signer for the account of the certificate choice
+// response. Request is the template of signing requests: relying party,
+// certificate level and interactions. Its identifier is replaced by the
+// document number of the chosen account.
+func NewKeySigner(c *Client, req *AuthRequest, choice *SessionResponse) (*KeySigner, error) {
+	if _, err := choice.Validate(); err != nil {
+		return nil, err
+	}
+	cert := choice.Cert.GetX509Cert()
+	if cert == nil {
+		return nil, ErrSignerCertNotFound
+	}
+	s := &KeySigner{
+		client:         c,
+		req:            *req,
+		cert:           cert,
+		documentNumber: choice.Result.DocumentNumber,
+	}
+	s.req.Hash, s.req.HashType, s.req.Digest = nil, "", nil
+	return s, nil
+}
+
+// Public returns public key of the signing certificate.
+func (s *KeySigner) Public() crypto.PublicKey {
+	return s.cert.PublicKey
+}
+
+// Certificate returns the signing certificate.
+func (s *KeySigner) Certificate() *x509.Certificate {
+	return s.cert
+}
+
+// DocumentNumber returns document number of the Smart-ID account.
+func (s *KeySigner) DocumentNumber() string {
+	return s.documentNumber
+}
+
+// Sign implements crypto.Signer. Digest is signed with RSASSA-PKCS1-v1_5,
+// hash function of opts must be supported by Smart-ID. Random source is
+// not used.
+func (s *KeySigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	ctx := context.Background()
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	return s.SignContext(ctx, digest, opts)
+}
+
+// SignContext is like Sign, but the session is canceled with the context.
+// Unsuccessful session returns SessionError.
+func (s *KeySigner) SignContext(ctx context.Context, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts == nil {
+		return nil, ErrSignerOptsUnsupported
+	}
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		return nil, ErrSignerOptsUnsupported
+	}
+	h := opts.HashFunc()
+	var algo string
+	for _, a := range []string{SHA256, SHA384, SHA512, SHA3_256, SHA3_384, SHA3_512} {
+		if ah, _ := hashFunc(a); ah == h {
+			algo = a
+		}
+	}
+	if algo == "" {
+		return nil, ErrHashUnsupported
+	}
+	d, err := NewDigest(algo, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	req := s.req
+	req.Identifier = s.documentNumber
+	req.AuthType = AuthTypeDocument
+	resp, err := signDigest(ctx, s.client, &req, d, s.cert)
+	if err != nil {
+		return nil, err
+	}
+	value, err := base64.StdEncoding.DecodeString(resp.Signature.Value)
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
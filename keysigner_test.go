@@ -0,0 +1,118 @@
+package smartid
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"testing"
+)
+
+func newTestKeySigner(t *testing.T, mock *mockSmartID) *KeySigner {
+	t.Helper()
+	c := mock.client()
+	req := &AuthRequest{Identifier: "PNOEE-30303039914"}
+	choice, err := c.ChooseCertificateSync(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewKeySigner(c, req, choice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestKeySigner_Sign(t *testing.T) {
+	mock := newMockSmartID(t)
+	s := newTestKeySigner(t, mock)
+	var _ crypto.Signer = s
+	if !s.Certificate().Equal(mock.signCert) {
+		t.Error("expected chosen certificate")
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:            pkix.Name{CommonName: "Smart-ID CSR"},
+		SignatureAlgorithm: x509.SHA256WithRSA,
+	}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		t.Error("expected valid CSR signature, got", err)
+	}
+	last := mock.requests[len(mock.requests)-1]
+	if last["hashType"] != SHA256 {
+		t.Error("expected", SHA256, "got", last["hashType"])
+	}
+
+	sum := sha256.Sum256([]byte("Hello, Smart-ID!"))
+	value, err := s.Sign(nil, sum[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rsa.VerifyPKCS1v15(s.Public().(*rsa.PublicKey), crypto.SHA256, sum[:], value); err != nil {
+		t.Error("expected valid signature, got", err)
+	}
+}
+
+func TestKeySigner_Sign_errors(t *testing.T) {
+	mock := newMockSmartID(t)
+	s := newTestKeySigner(t, mock)
+	sum := sha256.Sum256([]byte("Hello, Smart-ID!"))
+
+	testdata := []struct {
+		name   string
+		digest []byte
+		opts   crypto.SignerOpts
+		err    error
+	}{
+		{"pss", sum[:], &rsa.PSSOptions{Hash: crypto.SHA256}, ErrSignerOptsUnsupported},
+		{"nil", sum[:], nil, ErrSignerOptsUnsupported},
+		{"sha1", sum[:20], crypto.SHA1, ErrHashUnsupported},
+		{"raw", sum[:], crypto.Hash(0), ErrHashUnsupported},
+		{"length", sum[:], crypto.SHA512, ErrHashLengthMismatch},
+	}
+	for _, test := range testdata {
+		if _, err := s.Sign(nil, test.digest, test.opts); err != test.err {
+			t.Error(test.name, "expected", test.err, "got", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.SignContext(ctx, sum[:], crypto.SHA256); !errors.Is(err, context.Canceled) {
+		t.Error("expected", context.Canceled, "got", err)
+	}
+
+	s.documentNumber = "PNOEE-REFUSED"
+	_, err := s.Sign(nil, sum[:], crypto.SHA256)
+	var sessionErr *SessionError
+	if !errors.As(err, &sessionErr) || !errors.Is(err, ErrUserRefused) {
+		t.Error("expected", ErrUserRefused, "got", err)
+	}
+	if sessionErr.Result != SessionResultUserRefusedDisplayTextAndPIN {
+		t.Error("expected", SessionResultUserRefusedDisplayTextAndPIN, "got", sessionErr.Result)
+	}
+}
+
+func TestNewKeySigner_refused(t *testing.T) {
+	mock := newMockSmartID(t)
+	c := mock.client()
+	req := &AuthRequest{Identifier: "PNOEE-REFUSED"}
+	choice, err := c.ChooseCertificateSync(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewKeySigner(c, req, choice); !errors.Is(err, ErrUserRefused) {
+		t.Error("expected", ErrUserRefused, "got", err)
+	}
+}
@@ -0,0 +1,168 @@
+package smartid
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mockDocumentNumber is the document number returned by the mock service.
+const mockDocumentNumber = "PNOEE-30303039914-MOCK-Q"
+
+// mockSmartID is local stand-in of the Smart-ID relying party API. It signs
+// whatever hash is requested with the key of the test certificates.
+type mockSmartID struct {
+	*httptest.Server
+
+	ca                 *x509.Certificate
+	caKey              *rsa.PrivateKey
+	authCert, signCert *x509.Certificate
+	key                *rsa.PrivateKey
+
+	// refuseSignatures makes the user refuse signature sessions.
+	refuseSignatures bool
+
+	mu       sync.Mutex
+	sessions map[string]mockSession
+	requests []map[string]interface{}
+}
+
+type mockSession struct {
+	endpoint, identifier string
+	hash                 []byte
+	hashType             string
+}
+
+// newMockSmartID starts the mock service with authentication and signing
+// certificates issued by the test CA.
+func newMockSmartID(t *testing.T) *mockSmartID {
+	t.Helper()
+	ca, caKey := newTestCA(t, "TEST of EID-SK 2016")
+	authCert, key := newTestLeaf(t, authTemplate(), ca, caKey)
+	signCert, _ := newTestLeaf(t, signTemplate(), ca, caKey)
+	m := &mockSmartID{
+		ca:       ca,
+		caKey:    caKey,
+		authCert: authCert,
+		signCert: signCert,
+		key:      key,
+		sessions: make(map[string]mockSession),
+	}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+	t.Cleanup(m.Close)
+	return m
+}
+
+// client returns client configured for the mock service.
+func (m *mockSmartID) client() *Client {
+	return NewClient(m.URL+"/", 1000)
+}
+
+func (m *mockSmartID) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if r.Method == http.MethodGet && len(parts) == 2 && parts[0] == "session" {
+		m.serveSession(w, parts[1])
+		return
+	}
+	if r.Method != http.MethodPost || len(parts) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hash, _ := base64.StdEncoding.DecodeString(fmt.Sprint(req["hash"]))
+	hashType, _ := req["hashType"].(string)
+
+	m.mu.Lock()
+	m.requests = append(m.requests, req)
+	id := fmt.Sprintf("session-%d", len(m.sessions))
+	m.sessions[id] = mockSession{
+		endpoint:   parts[0],
+		identifier: parts[2],
+		hash:       hash,
+		hashType:   hashType,
+	}
+	m.mu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]string{"sessionID": id})
+}
+
+func (m *mockSmartID) serveSession(w http.ResponseWriter, id string) {
+	m.mu.Lock()
+	s, ok := m.sessions[id]
+	m.mu.Unlock()
+	if !ok {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"state":               SessionStatusComplete,
+		"interactionFlowUsed": InteractionDisplayTextAndPIN,
+	}
+	if strings.Contains(s.identifier, "REFUSED") ||
+		m.refuseSignatures && s.endpoint == EndpointSignature {
+		resp["result"] = map[string]string{
+			"endResult": SessionResultUserRefusedDisplayTextAndPIN,
+		}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	cert := m.signCert
+	if s.endpoint == EndpointAuthentication {
+		cert = m.authCert
+	}
+	resp["result"] = map[string]string{
+		"endResult":      SessionResultOK,
+		"documentNumber": mockDocumentNumber,
+	}
+	resp["cert"] = map[string]string{
+		"value":            base64.StdEncoding.EncodeToString(cert.Raw),
+		"certificateLevel": CertLevelQualified,
+	}
+	if s.endpoint != EndpointCertificateChoice {
+		h, _ := hashFunc(s.hashType)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, h, s.hash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp["signature"] = map[string]string{
+			"value":     base64.StdEncoding.EncodeToString(sig),
+			"algorithm": rsaAlgorithmName(h),
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// rsaAlgorithmName returns Smart-ID signature algorithm name.
+func rsaAlgorithmName(h crypto.Hash) string {
+	switch h {
+	case crypto.SHA256:
+		return "sha256WithRSAEncryption"
+	case crypto.SHA384:
+		return "sha384WithRSAEncryption"
+	case crypto.SHA3_256:
+		return "sha3-256WithRSAEncryption"
+	case crypto.SHA3_384:
+		return "sha3-384WithRSAEncryption"
+	case crypto.SHA3_512:
+		return "sha3-512WithRSAEncryption"
+	default:
+		return "sha512WithRSAEncryption"
+	}
+}
@@ -0,0 +1,372 @@
+package smartid
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DefaultMatchThreshold is the score from which Match considers the
+// identity and the customer record to be the same person.
+const DefaultMatchThreshold = 0.85
+
+// foldTable transliterates lowercase letters with diacritics to plain
+// ASCII. Baltic letters come first, some common Latin letters are added
+// for names of foreign origin.
+var foldTable = map[rune]string{
+	// Estonian
+	'õ': "o", 'ä': "a", 'ö': "o", 'ü': "u", 'š': "s", 'ž': "z",
+	// Latvian
+	'ā': "a", 'č': "c", 'ē': "e", 'ģ': "g", 'ī': "i", 'ķ': "k", 'ļ': "l",
+	'ņ': "n", 'ū': "u",
+	// Lithuanian
+	'ą': "a", 'ę': "e", 'ė': "e", 'į': "i", 'ų': "u",
+	// Others
+	'á': "a", 'à': "a", 'â': "a", 'å': "a", 'ã': "a", 'æ': "ae",
+	'ç': "c", 'ć': "c", 'é': "e", 'è': "e", 'ê': "e", 'ë': "e",
+	'í': "i", 'ì': "i", 'î': "i", 'ï': "i", 'ł': "l", 'ñ': "n", 'ń': "n",
+	'ó': "o", 'ò': "o", 'ô': "o", 'ø': "o", 'œ': "oe", 'ř': "r", 'ś': "s",
+	'ß': "ss", 'ú': "u", 'ù': "u", 'û': "u", 'ý': "y", 'ÿ': "y", 'ź': "z",
+	'ż': "z",
+}
+
+// countryFoldTables contains the alternative transliterations used by
+// the country convention. For example Estonian names are often written
+// in the German way in foreign systems: Mägi becomes Maegi.
+var countryFoldTables = map[string]map[rune]string{
+	CountryEE: {
+		'ä': "ae", 'ö': "oe", 'ü': "ue", 'õ': "o", 'š': "sh", 'ž': "zh",
+	},
+	CountryLV: {
+		'š': "sh", 'ž': "zh", 'č': "ch",
+	},
+	CountryLT: {
+		'š': "sh", 'ž': "zh", 'č': "ch",
+	},
+}
+
+// NameMatch is the result of the comparison an identity with the
+// customer record.
+type NameMatch struct {
+	// Score is the total score in range from 0 to 1.
+	Score float64
+
+	// GivenNameScore is the score for the given name.
+	GivenNameScore float64
+
+	// SurnameScore is the score for the surname.
+	SurnameScore float64
+
+	// BirthDateKnown is true, if birth date could be compared.
+	BirthDateKnown bool
+
+	// BirthDateMatch is true, if birth dates are equal.
+	BirthDateMatch bool
+}
+
+// IsMatch checks that score reaches DefaultMatchThreshold and birth dates
+// are not different.
+func (m NameMatch) IsMatch() bool {
+	if m.BirthDateKnown && !m.BirthDateMatch {
+		return false
+	}
+	return m.Score >= DefaultMatchThreshold
+}
+
+// NormalizeName folds the case of the name, transliterates diacritics to
+// ASCII and collapses separators. Hyphens and commas are treated as
+// spaces, so multi-part names are returned as space separated parts.
+//
+//	NormalizeName("TAMM-KÕIV") // "tamm koiv"
+func NormalizeName(name string) string {
+	return strings.Join(nameParts(name, nil), " ")
+}
+
+// TransliterateName is like NormalizeName, but uses the transliteration
+// convention of the country for the letters it defines. Unknown
+// countries fall back to NormalizeName.
+//
+//	TransliterateName("MÄGI", CountryEE) // "maegi"
+func TransliterateName(name, country string) string {
+	return strings.Join(nameParts(name, countryFoldTables[country]), " ")
+}
+
+// NameVariants returns all normalized forms of the name, which might be
+// found in other systems. The first variant is always NormalizeName.
+func NameVariants(name, country string) []string {
+	variants := []string{NormalizeName(name)}
+	if alt := TransliterateName(name, country); alt != variants[0] {
+		variants = append(variants, alt)
+	}
+	return variants
+}
+
+// SplitName returns given name and surname of the identity. Subject
+// attributes are preferred, if they are not set, the common name in
+// format "SURNAME,GIVEN" is used.
+func (i *Identity) SplitName() (givenName, surname string) {
+	if i.GivenName != "" || i.Surname != "" {
+		return i.GivenName, i.Surname
+	}
+	parts := strings.SplitN(i.CommonName, ",", 2)
+	if len(parts) != 2 {
+		return "", strings.TrimSpace(i.CommonName)
+	}
+	return strings.TrimSpace(parts[1]), strings.TrimSpace(parts[0])
+}
+
+// NormalizedName returns the name of the identity in format "given
+// surname", in lowercase and without diacritics.
+func (i *Identity) NormalizedName() string {
+	given, surname := i.SplitName()
+	return strings.TrimSpace(NormalizeName(given) + " " + NormalizeName(surname))
+}
+
+// BirthDate extracts date of birth from the personal code. Estonian,
+// Lithuanian and old format Latvian personal codes contain it. The
+// second return value is false when birth date cannot be determined, for
+// example for the new Latvian personal codes starting with 32.
+func (i *Identity) BirthDate() (time.Time, bool) {
+	country, code := i.personalCode()
+	switch country {
+	case CountryEE, CountryLT:
+		return birthDateEELT(code)
+	case CountryLV:
+		return birthDateLV(code)
+	}
+	return time.Time{}, false
+}
+
+// Match compares identity with the given name, surname and birth date
+// from the customer record. Names can be in any case and with or without
+// diacritics. Zero birthDate is not compared.
+func (i *Identity) Match(givenName, surname string, birthDate time.Time) NameMatch {
+	var m NameMatch
+	idGiven, idSurname := i.SplitName()
+
+	m.GivenNameScore = nameScore(idGiven, givenName, i.Country)
+	m.SurnameScore = nameScore(idSurname, surname, i.Country)
+	m.Score = (m.GivenNameScore + m.SurnameScore) / 2
+
+	// Given name and surname are often swapped in customer records.
+	swapped := (nameScore(idGiven, surname, i.Country) +
+		nameScore(idSurname, givenName, i.Country)) / 2 * 0.95
+	if swapped > m.Score {
+		m.Score = swapped
+	}
+
+	if birthDate.IsZero() {
+		return m
+	}
+	bd, ok := i.BirthDate()
+	if !ok {
+		return m
+	}
+	m.BirthDateKnown = true
+	y1, m1, d1 := bd.Date()
+	y2, m2, d2 := birthDate.Date()
+	m.BirthDateMatch = y1 == y2 && m1 == m2 && d1 == d2
+	if m.BirthDateMatch {
+		m.Score = 0.8*m.Score + 0.2
+	} else {
+		m.Score = 0.8 * m.Score
+	}
+	return m
+}
+
+// personalCode splits the serial number such as PNOEE-30303039914 into
+// country and the personal code.
+func (i *Identity) personalCode() (string, string) {
+	parts := strings.SplitN(i.SerialNumber, "-", 2)
+	if len(parts) != 2 || len(parts[0]) != 5 {
+		return "", ""
+	}
+	return parts[0][3:], parts[1]
+}
+
+// birthDateEELT parses birth date from Estonian and Lithuanian personal
+// code: GYYMMDDSSSC, where G is century and gender.
+func birthDateEELT(code string) (time.Time, bool) {
+	if len(code) != 11 || !isDigits(code) {
+		return time.Time{}, false
+	}
+	var century int
+	switch code[0] {
+	case '1', '2':
+		century = 1800
+	case '3', '4':
+		century = 1900
+	case '5', '6':
+		century = 2000
+	default:
+		return time.Time{}, false
+	}
+	return makeBirthDate(century, code[1:3], code[3:5], code[5:7])
+}
+
+// birthDateLV parses birth date from Latvian personal code: DDMMYY-CNNNN,
+// where C is century.
+func birthDateLV(code string) (time.Time, bool) {
+	code = strings.Replace(code, "-", "", 1)
+	if len(code) != 11 || !isDigits(code) || strings.HasPrefix(code, "32") {
+		return time.Time{}, false
+	}
+	var century int
+	switch code[6] {
+	case '0':
+		century = 1800
+	case '1':
+		century = 1900
+	case '2':
+		century = 2000
+	default:
+		return time.Time{}, false
+	}
+	return makeBirthDate(century, code[4:6], code[2:4], code[0:2])
+}
+
+// makeBirthDate makes date from string parts and checks that it exists.
+func makeBirthDate(century int, yy, mm, dd string) (time.Time, bool) {
+	y, _ := strconv.Atoi(yy)
+	m, _ := strconv.Atoi(mm)
+	d, _ := strconv.Atoi(dd)
+	t := time.Date(century+y, time.Month(m), d, 0, 0, 0, 0, time.UTC)
+	if t.Day() != d || int(t.Month()) != m {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// nameParts folds the case, transliterates and splits the name into
+// parts. Letters in alt table override the default transliteration.
+func nameParts(name string, alt map[rune]string) []string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if s, ok := alt[r]; ok {
+			sb.WriteString(s)
+			continue
+		}
+		if s, ok := foldTable[r]; ok {
+			sb.WriteString(s)
+			continue
+		}
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			sb.WriteRune(r)
+		case r == '\'' || r == '’' || r == '.':
+			// Drop apostrophes and dots: O'Brien, J. Smith.
+		case unicode.IsLetter(r):
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune(' ')
+		}
+	}
+	return strings.Fields(sb.String())
+}
+
+// nameScore compares name from the certificate with the name from
+// the customer record using all transliteration variants.
+func nameScore(certName, name, country string) float64 {
+	if strings.TrimSpace(certName) == "" || strings.TrimSpace(name) == "" {
+		return 0
+	}
+	best := 0.0
+	for _, a := range NameVariants(certName, country) {
+		for _, b := range NameVariants(name, country) {
+			if s := partsScore(strings.Fields(a), strings.Fields(b)); s > best {
+				best = s
+			}
+		}
+	}
+	return best
+}
+
+// partsScore compares multi-part names. Every part of the customer name
+// is compared with the closest part of the certificate name. Missing parts
+// (e.g. only one part of a double surname is stored) lower the score
+// slightly.
+func partsScore(cert, other []string) float64 {
+	joined := similarity(strings.Join(cert, ""), strings.Join(other, ""))
+
+	used := make([]bool, len(cert))
+	total := 0.0
+	for _, o := range other {
+		best, bestIdx := 0.0, -1
+		for idx, c := range cert {
+			if s := similarity(c, o); s > best {
+				best, bestIdx = s, idx
+			}
+		}
+		if bestIdx >= 0 {
+			used[bestIdx] = true
+		}
+		total += best
+	}
+	matched := 0
+	for _, u := range used {
+		if u {
+			matched++
+		}
+	}
+	coverage := float64(matched) / float64(len(cert))
+	byParts := total / float64(len(other)) * (0.85 + 0.15*coverage)
+
+	if joined > byParts {
+		return joined
+	}
+	return byParts
+}
+
+// similarity is normalized Levenshtein similarity of two strings in
+// range from 0 to 1.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+	max := len(ra)
+	if len(rb) > max {
+		max = len(rb)
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(max)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(vals ...int) int {
+	m := vals[0]
+	for _, v := range vals[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,119 @@
+package smartid
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNormalizeName(t *testing.T) {
+	testdata := map[string]string{
+		"TAMM-KÕIV":           "tamm koiv",
+		"JĀNIS  BĒRZIŅŠ":      "janis berzins",
+		"ŽEMAITĖ, GINTARĖ":    "zemaite gintare",
+		"O'Brien":             "obrien",
+		"TESTNUMBER,OK":       "testnumber ok",
+		"  Mari-Liis  Männik": "mari liis mannik",
+	}
+	for in, exp := range testdata {
+		if got := NormalizeName(in); got != exp {
+			t.Error("expected", exp, "got", got)
+		}
+	}
+}
+
+func TestTransliterateName(t *testing.T) {
+	if got := TransliterateName("MÄGI", CountryEE); got != "maegi" {
+		t.Error("expected maegi got", got)
+	}
+	if got := TransliterateName("ŠVEIKAUSKAS", CountryLT); got != "shveikauskas" {
+		t.Error("expected shveikauskas got", got)
+	}
+	if got := TransliterateName("MÄGI", CountryKZ); got != "magi" {
+		t.Error("expected magi got", got)
+	}
+}
+
+func TestIdentity_SplitName(t *testing.T) {
+	identity := Identity{CommonName: "TESTNUMBER, MULTIPLE OK"}
+	given, surname := identity.SplitName()
+	if given != "MULTIPLE OK" || surname != "TESTNUMBER" {
+		t.Error("unexpected split", given, surname)
+	}
+
+	identity = Identity{
+		CommonName: "ignored",
+		GivenName:  "MARI-LIIS",
+		Surname:    "MÄNNIK",
+	}
+	if got := identity.NormalizedName(); got != "mari liis mannik" {
+		t.Error("expected mari liis mannik got", got)
+	}
+}
+
+func TestIdentity_BirthDate(t *testing.T) {
+	testdata := []struct {
+		serial string
+		date   string
+		ok     bool
+	}{
+		{"PNOEE-30303039914", "1903-03-03", true},
+		{"PNOEE-50701019992", "2007-01-01", true},
+		{"PNOLT-49912318881", "1999-12-31", true},
+		{"PNOLV-311299-18886", "1999-12-31", true},
+		{"PNOLV-329999-99901", "", false},
+		{"PNOEE-39902309999", "", false},
+		{"PASKZ-1234567890", "", false},
+		{"foobar", "", false},
+	}
+	for _, test := range testdata {
+		identity := Identity{SerialNumber: test.serial}
+		date, ok := identity.BirthDate()
+		if ok != test.ok {
+			t.Error(test.serial, "expected", test.ok, "got", ok)
+			continue
+		}
+		if ok && date.Format("2006-01-02") != test.date {
+			t.Error(test.serial, "expected", test.date, "got", date)
+		}
+	}
+}
+
+func TestIdentity_Match(t *testing.T) {
+	identity := Identity{
+		Country:      CountryEE,
+		CommonName:   "TAMM-KÕIV,MARI-LIIS",
+		SerialNumber: "PNOEE-48801010000",
+	}
+	birth := time.Date(1988, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	m := identity.Match("Mari-Liis", "Tamm-Koiv", birth)
+	if !m.IsMatch() || m.Score < 0.99 {
+		t.Error("expected exact match got", m)
+	}
+
+	m = identity.Match("Mari Liis", "Tamm", birth)
+	if !m.IsMatch() {
+		t.Error("expected partial surname to match got", m)
+	}
+
+	m = identity.Match("Tamm-Koiv", "Mari-Liis", time.Time{})
+	if !m.IsMatch() || m.BirthDateKnown {
+		t.Error("expected swapped names to match got", m)
+	}
+
+	m = identity.Match("Mari-Liis", "Tamm-Koiv", birth.AddDate(0, 0, 1))
+	if m.IsMatch() || !m.BirthDateKnown || m.BirthDateMatch {
+		t.Error("expected birth date mismatch got", m)
+	}
+
+	m = identity.Match("Jaan", "Kask", birth)
+	if m.IsMatch() {
+		t.Error("expected no match got", m)
+	}
+
+	identity = Identity{Country: CountryEE, CommonName: "MÄGI,JÜRI"}
+	m = identity.Match("Juerii", "Maegi", time.Time{})
+	if !m.IsMatch() {
+		t.Error("expected transliterated match got", m)
+	}
+}
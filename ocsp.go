@@ -0,0 +1,394 @@
+package smartid
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"time"
+)
+
+// OCSP certificate statuses.
+const (
+	OCSPStatusGood    = 0
+	OCSPStatusRevoked = 1
+	OCSPStatusUnknown = 2
+)
+
+var (
+	oidOCSPBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	oidOCSPNonce = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+)
+
+var (
+	// ErrOCSPUnsuccessful error when OCSP response status is not
+	// successful.
+	ErrOCSPUnsuccessful = errors.New("OCSP response is not successful")
+
+	// ErrOCSPNotBasic error when OCSP response is not basic response.
+	ErrOCSPNotBasic = errors.New("OCSP response type is not basic")
+
+	// ErrOCSPNoStatus error when OCSP response has no status for the
+	// certificate.
+	ErrOCSPNoStatus = errors.New("OCSP response has no status for certificate")
+
+	// ErrOCSPResponderNotFound error when OCSP responder certificate is not
+	// found.
+	ErrOCSPResponderNotFound = errors.New("OCSP responder certificate not found")
+
+	// ErrOCSPResponderNotAuthorized error when responder certificate is not
+	// authorized to sign OCSP responses.
+	ErrOCSPResponderNotAuthorized = errors.New("OCSP responder is not authorized")
+
+	// ErrCertRevoked error when certificate is revoked.
+	ErrCertRevoked = errors.New("Certificate is revoked")
+
+	// ErrCertStatusUnknown error when responder does not know the
+	// certificate.
+	ErrCertStatusUnknown = errors.New("Certificate status is unknown")
+)
+
+// OCSPResponse is parsed OCSP response for one certificate.
+type OCSPResponse struct {
+	// Raw is DER encoded OCSPResponse.
+	Raw []byte
+
+	// Status is the status of certificate: OCSPStatusGood,
+	// OCSPStatusRevoked or OCSPStatusUnknown.
+	Status int
+
+	// SerialNumber is the serial number of the certificate.
+	SerialNumber *big.Int
+
+	// ProducedAt is the time when response was signed.
+	ProducedAt time.Time
+
+	// ThisUpdate and NextUpdate are the validity of the status.
+	ThisUpdate time.Time
+	NextUpdate time.Time
+
+	// RevokedAt is the time of revocation, if revoked.
+	RevokedAt time.Time
+
+	// Nonce is the nonce extension, nil if not given.
+	Nonce []byte
+
+	// Certificates are the certificates embedded in the response.
+	Certificates []*x509.Certificate
+
+	tbs         []byte
+	responderID asn1.RawValue
+	sigAlgo     pkix.AlgorithmIdentifier
+	signature   []byte
+}
+
+//	OCSPResponse ::= SEQUENCE {
+//		responseStatus OCSPResponseStatus,
+//		responseBytes  [0] EXPLICIT ResponseBytes OPTIONAL }
+type ocspResponse struct {
+	Status        asn1.Enumerated
+	ResponseBytes ocspResponseBytes `asn1:"explicit,optional,tag:0"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type basicOCSPResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type ocspResponseData struct {
+	Version     int `asn1:"explicit,optional,default:0,tag:0"`
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []ocspSingleResponse
+	Extensions  []pkix.Extension `asn1:"explicit,optional,tag:1"`
+}
+
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.Flag        `asn1:"optional,tag:0"`
+	Revoked    ocspRevokedInfo  `asn1:"optional,tag:1"`
+	Unknown    asn1.Flag        `asn1:"optional,tag:2"`
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,optional,tag:0"`
+	Extensions []pkix.Extension `asn1:"explicit,optional,tag:1"`
+}
+
+type ocspCertID struct {
+	HashAlgorithm  pkix.AlgorithmIdentifier
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+	SerialNumber   *big.Int
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,optional,tag:0"`
+}
+
+// ParseOCSPResponse parses DER encoded OCSP response and finds the status
+// of the certificate. If cert is nil, the first status is used. Signature
+// of the response is not verified, use Verify for that.
+func ParseOCSPResponse(der []byte, cert *x509.Certificate) (*OCSPResponse, error) {
+	var resp ocspResponse
+	if _, err := asn1.Unmarshal(der, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Status != 0 {
+		return nil, ErrOCSPUnsuccessful
+	}
+	if !resp.ResponseBytes.ResponseType.Equal(oidOCSPBasic) {
+		return nil, ErrOCSPNotBasic
+	}
+	var basic basicOCSPResponse
+	if _, err := asn1.Unmarshal(resp.ResponseBytes.Response, &basic); err != nil {
+		return nil, err
+	}
+	var data ocspResponseData
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data); err != nil {
+		return nil, err
+	}
+
+	r := &OCSPResponse{
+		Raw:         der,
+		ProducedAt:  data.ProducedAt,
+		tbs:         basic.TBSResponseData.FullBytes,
+		responderID: data.ResponderID,
+		sigAlgo:     basic.SignatureAlgorithm,
+		signature:   basic.Signature.RightAlign(),
+	}
+	for _, raw := range basic.Certificates {
+		c, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, err
+		}
+		r.Certificates = append(r.Certificates, c)
+	}
+	for _, ext := range data.Extensions {
+		if ext.Id.Equal(oidOCSPNonce) {
+			r.Nonce = ext.Value
+		}
+	}
+
+	for _, single := range data.Responses {
+		if cert != nil && !single.CertID.matches(cert) {
+			continue
+		}
+		r.SerialNumber = single.CertID.SerialNumber
+		r.ThisUpdate = single.ThisUpdate
+		r.NextUpdate = single.NextUpdate
+		switch {
+		case bool(single.Good):
+			r.Status = OCSPStatusGood
+		case bool(single.Unknown):
+			r.Status = OCSPStatusUnknown
+		default:
+			r.Status = OCSPStatusRevoked
+			r.RevokedAt = single.Revoked.RevocationTime
+		}
+		return r, nil
+	}
+	return nil, ErrOCSPNoStatus
+}
+
+// ocspBasicResponse returns DER encoded BasicOCSPResponse of successful
+// OCSP response.
+func ocspBasicResponse(der []byte) ([]byte, error) {
+	var resp ocspResponse
+	if _, err := asn1.Unmarshal(der, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Status != 0 {
+		return nil, ErrOCSPUnsuccessful
+	}
+	if !resp.ResponseBytes.ResponseType.Equal(oidOCSPBasic) {
+		return nil, ErrOCSPNotBasic
+	}
+	return resp.ResponseBytes.Response, nil
+}
+
+// ocspResponseFromBasic wraps BasicOCSPResponse into successful OCSP
+// response.
+func ocspResponseFromBasic(basic []byte) ([]byte, error) {
+	return asn1.Marshal(ocspResponse{
+		ResponseBytes: ocspResponseBytes{ResponseType: oidOCSPBasic, Response: basic},
+	})
+}
+
+// Err returns error for not good certificate status.
+func (r *OCSPResponse) Err() error {
+	switch r.Status {
+	case OCSPStatusGood:
+		return nil
+	case OCSPStatusRevoked:
+		return ErrCertRevoked
+	default:
+		return ErrCertStatusUnknown
+	}
+}
+
+// Verify verifies the signature of the response. Responder must be either
+// the issuer of the certificate, delegated by the issuer with OCSPSigning
+// extended key usage, or trusted by the trust store. The responder
+// certificate is returned.
+func (r *OCSPResponse) Verify(
+	ctx context.Context,
+	ts *TrustStore,
+	issuer *x509.Certificate,
+) (*x509.Certificate, error) {
+	return r.verify(ctx, ts, issuer, false)
+}
+
+// verify is like Verify, legacy allows SHA-1 signed responder
+// certificates.
+func (r *OCSPResponse) verify(
+	ctx context.Context,
+	ts *TrustStore,
+	issuer *x509.Certificate,
+	legacy bool,
+) (*x509.Certificate, error) {
+	candidates := append([]*x509.Certificate{issuer}, r.Certificates...)
+	var responder *x509.Certificate
+	for _, c := range candidates {
+		if c != nil && r.isResponder(c) {
+			responder = c
+			break
+		}
+	}
+	if responder == nil {
+		return nil, ErrOCSPResponderNotFound
+	}
+
+	h, err := signatureHash(r.sigAlgo.Algorithm)
+	if err != nil {
+		return responder, err
+	}
+	hash := h.New()
+	hash.Write(r.tbs)
+	scheme := oidSignatureScheme(r.sigAlgo.Algorithm)
+	if err := verifySignatureScheme(responder, scheme, h, hash.Sum(nil), r.signature); err != nil {
+		return responder, err
+	}
+
+	if issuer != nil && responder.Equal(issuer) {
+		return responder, nil
+	}
+	if !hasExtKeyUsage(responder, x509.ExtKeyUsageOCSPSigning) {
+		return responder, ErrOCSPResponderNotAuthorized
+	}
+	if issuer != nil && responder.CheckSignatureFrom(issuer) == nil {
+		return responder, nil
+	}
+	if legacy && issuer != nil && checkIssuer(responder, issuer) == nil {
+		return responder, nil
+	}
+	if ts == nil {
+		return responder, ErrOCSPResponderNotAuthorized
+	}
+	_, err = ts.VerifyWithIntermediates(ctx, responder, r.Certificates, r.ProducedAt)
+	if err != nil && legacy {
+		_, err = ts.verifyLegacy(responder, r.Certificates, r.ProducedAt)
+	}
+	return responder, err
+}
+
+// isResponder checks that certificate matches responder ID.
+//
+//	ResponderID ::= CHOICE {
+//		byName [1] Name,
+//		byKey  [2] KeyHash }
+func (r *OCSPResponse) isResponder(cert *x509.Certificate) bool {
+	switch r.responderID.Tag {
+	case 1:
+		return bytes.Equal(r.responderID.Bytes, cert.RawSubject)
+	case 2:
+		var keyHash []byte
+		if _, err := asn1.Unmarshal(r.responderID.Bytes, &keyHash); err != nil {
+			return false
+		}
+		return bytes.Equal(keyHash, publicKeyHash(cert))
+	}
+	return false
+}
+
+// matches checks that CertID identifies the certificate. Issuer key hash
+// cannot be checked without the issuer, issuer name hash and serial
+// number are enough to identify the certificate.
+func (id ocspCertID) matches(cert *x509.Certificate) bool {
+	if id.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		return false
+	}
+	var sum []byte
+	if id.HashAlgorithm.Algorithm.Equal(oidDigestSHA1) {
+		s := sha1.Sum(cert.RawIssuer)
+		sum = s[:]
+	} else {
+		algo, err := digestAlgorithmName(id.HashAlgorithm.Algorithm)
+		if err != nil {
+			return false
+		}
+		d, err := HashData(cert.RawIssuer, algo)
+		if err != nil {
+			return false
+		}
+		sum = d.AuthHash()
+	}
+	return bytes.Equal(sum, id.IssuerNameHash)
+}
+
+// publicKeyHash returns SHA-1 hash of subjectPublicKey BIT STRING value.
+func publicKeyHash(cert *x509.Certificate) []byte {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(cert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil
+	}
+	sum := sha1.Sum(spki.PublicKey.RightAlign())
+	return sum[:]
+}
+
+// signatureHash returns hash function of the signature algorithm.
+func signatureHash(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	for h, oids := range signatureAlgorithmOIDs {
+		for _, o := range oids {
+			if o.Equal(oid) {
+				return h, nil
+			}
+		}
+	}
+	return 0, ErrHashUnsupported
+}
+
+// signatureAlgorithmOIDs maps hash functions to RSA and ECDSA signature
+// algorithm identifiers.
+var signatureAlgorithmOIDs = map[crypto.Hash][]asn1.ObjectIdentifier{
+	crypto.SHA1: {
+		{1, 2, 840, 113549, 1, 1, 5},
+		{1, 2, 840, 10045, 4, 1},
+	},
+	crypto.SHA256: {
+		{1, 2, 840, 113549, 1, 1, 11},
+		{1, 2, 840, 10045, 4, 3, 2},
+	},
+	crypto.SHA384: {
+		{1, 2, 840, 113549, 1, 1, 12},
+		{1, 2, 840, 10045, 4, 3, 3},
+	},
+	crypto.SHA512: {
+		{1, 2, 840, 113549, 1, 1, 13},
+		{1, 2, 840, 10045, 4, 3, 4},
+	},
+}
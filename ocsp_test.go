@@ -0,0 +1,149 @@
+package smartid
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+	"time"
+)
+
+// newTestOCSPResponse makes OCSP response for the certificate signed by
+// the responder.
+func newTestOCSPResponse(
+	t *testing.T,
+	cert, issuer, responder *x509.Certificate,
+	responderKey *rsa.PrivateKey,
+	status int,
+	producedAt time.Time,
+) []byte {
+	t.Helper()
+	nameHash := sha1.Sum(cert.RawIssuer)
+	single := ocspSingleResponse{
+		CertID: ocspCertID{
+			HashAlgorithm:  pkix.AlgorithmIdentifier{Algorithm: oidDigestSHA1},
+			IssuerNameHash: nameHash[:],
+			IssuerKeyHash:  publicKeyHash(issuer),
+			SerialNumber:   cert.SerialNumber,
+		},
+		ThisUpdate: producedAt.UTC(),
+	}
+	switch status {
+	case OCSPStatusGood:
+		single.Good = true
+	case OCSPStatusRevoked:
+		single.Revoked = ocspRevokedInfo{RevocationTime: producedAt.Add(-time.Minute).UTC()}
+	default:
+		single.Unknown = true
+	}
+	tbs, err := asn1.Marshal(ocspResponseData{
+		ResponderID: asn1.RawValue{
+			Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true,
+			Bytes: responder.RawSubject,
+		},
+		ProducedAt: producedAt.UTC(),
+		Responses:  []ocspSingleResponse{single},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(tbs)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, responderKey, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic, err := asn1.Marshal(basicOCSPResponse{
+		TBSResponseData: asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11},
+		},
+		Signature:    asn1.BitString{Bytes: sig, BitLength: len(sig) * 8},
+		Certificates: []asn1.RawValue{{FullBytes: responder.Raw}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(ocspResponse{
+		ResponseBytes: ocspResponseBytes{ResponseType: oidOCSPBasic, Response: basic},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestOCSPResponse(t *testing.T) {
+	ctx := context.Background()
+	ca, caKey := newTestCA(t, "TEST of EID-SK 2016")
+	cert, _ := newTestLeaf(t, signTemplate(), ca, caKey)
+	now := time.Now().Truncate(time.Second)
+
+	der := newTestOCSPResponse(t, cert, ca, ca, caKey, OCSPStatusGood, now)
+	resp, err := ParseOCSPResponse(der, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != OCSPStatusGood || resp.Err() != nil {
+		t.Error("expected", OCSPStatusGood, "got", resp.Status)
+	}
+	if !resp.ProducedAt.Equal(now) {
+		t.Error("expected", now, "got", resp.ProducedAt)
+	}
+	if _, err := resp.Verify(ctx, nil, ca); err != nil {
+		t.Error("expected", nil, "got", err)
+	}
+
+	other, _ := newTestLeaf(t, signTemplate(), ca, caKey)
+	if _, err := ParseOCSPResponse(der, other); err != ErrOCSPNoStatus {
+		t.Error("expected", ErrOCSPNoStatus, "got", err)
+	}
+
+	der = newTestOCSPResponse(t, cert, ca, ca, caKey, OCSPStatusRevoked, now)
+	resp, _ = ParseOCSPResponse(der, cert)
+	if resp.Err() != ErrCertRevoked || !resp.RevokedAt.Equal(now.Add(-time.Minute)) {
+		t.Error("expected", ErrCertRevoked, "got", resp.Err(), resp.RevokedAt)
+	}
+
+	// Delegated responder must have OCSPSigning usage.
+	responder, responderKey := newTestLeaf(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "TEST of SK OCSP RESPONDER"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+	}, ca, caKey)
+	der = newTestOCSPResponse(t, cert, ca, responder, responderKey, OCSPStatusGood, now)
+	resp, _ = ParseOCSPResponse(der, cert)
+	if r, err := resp.Verify(ctx, nil, ca); err != nil || !r.Equal(responder) {
+		t.Error("expected", nil, "got", err)
+	}
+
+	notResponder, key := newTestLeaf(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "TEST of NOT RESPONDER"},
+	}, ca, caKey)
+	der = newTestOCSPResponse(t, cert, ca, notResponder, key, OCSPStatusGood, now)
+	resp, _ = ParseOCSPResponse(der, cert)
+	if _, err := resp.Verify(ctx, nil, ca); err != ErrOCSPResponderNotAuthorized {
+		t.Error("expected", ErrOCSPResponderNotAuthorized, "got", err)
+	}
+
+	// Responder trusted by the trust store, but not delegated by issuer.
+	otherCA, otherKey := newTestCA(t, "TEST of SK OCSP CA")
+	trusted, trustedKey := newTestLeaf(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "TEST of SK OCSP RESPONDER 2011"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+	}, otherCA, otherKey)
+	der = newTestOCSPResponse(t, cert, ca, trusted, trustedKey, OCSPStatusGood, now)
+	resp, _ = ParseOCSPResponse(der, cert)
+	if _, err := resp.Verify(ctx, NewTrustStore(), ca); err == nil {
+		t.Error("expected untrusted responder error")
+	}
+	ts := NewTrustStore()
+	ts.AddRoot(otherCA)
+	if _, err := resp.Verify(ctx, ts, ca); err != nil {
+		t.Error("expected", nil, "got", err)
+	}
+}
@@ -0,0 +1,70 @@
+package oidc
+
+import "html/template"
+
+// loginTemplate is the default login page. Personal code and country make
+// the semantic identifier, verification code is shown while the session
+// events are streamed.
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Log in with Smart-ID</title>
+</head>
+<body>
+<h1>Log in to {{with .Client.Name}}{{.}}{{else}}{{.Client.ID}}{{end}} with Smart-ID</h1>
+<form id="login" data-csrf="{{.CSRFToken}}" data-request="{{.Request}}">
+<select name="country">
+<option value="EE">Estonia</option>
+<option value="LV">Latvia</option>
+<option value="LT">Lithuania</option>
+</select>
+<input name="code" placeholder="Personal code" autocomplete="username" required>
+<button>Log in</button>
+</form>
+<p id="code" hidden>Verification code: <strong></strong></p>
+<p id="error" hidden></p>
+<script>
+const form = document.getElementById("login");
+const headers = {"X-CSRF-Token": form.dataset.csrf};
+const show = (id, text) => {
+	const el = document.getElementById(id);
+	(el.querySelector("strong") || el).textContent = text;
+	el.hidden = false;
+};
+const post = (path, params) =>
+	fetch(path, {method: "POST", headers, body: new URLSearchParams(params)});
+form.addEventListener("submit", async (e) => {
+	e.preventDefault();
+	document.getElementById("error").hidden = true;
+	const identifier = "PNO" + form.country.value + "-" + form.code.value.trim();
+	const resp = await post("login/start", {identifier});
+	const start = await resp.json();
+	if (!resp.ok) {
+		show("error", start.error);
+		return;
+	}
+	show("code", start.verificationCode);
+	const events = new EventSource("login/events?token=" + encodeURIComponent(start.token));
+	events.addEventListener("completed", async () => {
+		events.close();
+		const resp = await post("login/complete", {token: start.token, request: form.dataset.request});
+		const data = await resp.json();
+		if (resp.ok) {
+			location.assign(data.redirect);
+		} else {
+			show("error", data.error);
+		}
+	});
+	for (const type of ["refused", "timeout", "failed"]) {
+		events.addEventListener(type, () => {
+			events.close();
+			show("error", "Login " + type);
+		});
+	}
+});
+</script>
+</body>
+</html>
+`))
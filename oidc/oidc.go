@@ -0,0 +1,487 @@
+// Package oidc is OpenID Connect provider (OP) of Smart-ID
+// authentication. It implements discovery, JWKS, authorization code flow
+// with PKCE, token and userinfo endpoints.
+//
+// The login page of the authorization endpoint drives the Smart-ID
+// authentication with smartidhttp handlers. ID token and userinfo claims
+// come from the identity of the authentication certificate: sub is the
+// semantic identifier, like PNOEE-30303039914, profile scope adds name,
+// given_name, family_name, birthdate and country.
+//
+// Keys and clients are provided by KeyStore and ClientStore, file based
+// stores are included.
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dknight/go-smartid"
+	"github.com/dknight/go-smartid/internal/httputil"
+	"github.com/dknight/go-smartid/smartidhttp"
+)
+
+// Defaults of the provider.
+const (
+	DefaultCodeLifetime  = time.Minute
+	DefaultTokenLifetime = time.Hour
+)
+
+// Scopes of the provider.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+)
+
+// requestLifetime limits the time to log in on the login page.
+const requestLifetime = 10 * time.Minute
+
+// maxRequests limits the pending authorization requests. When full,
+// request which expires first is dropped.
+const maxRequests = 1024
+
+// Error codes of OAuth 2.0 and OpenID Connect.
+const (
+	ErrorInvalidRequest          = "invalid_request"
+	ErrorInvalidClient           = "invalid_client"
+	ErrorInvalidGrant            = "invalid_grant"
+	ErrorInvalidScope            = "invalid_scope"
+	ErrorInvalidToken            = "invalid_token"
+	ErrorServer                  = "server_error"
+	ErrorUnsupportedGrantType    = "unsupported_grant_type"
+	ErrorUnsupportedResponseType = "unsupported_response_type"
+)
+
+// ErrRedirectURI error when client or redirect URI of the authorization
+// request is invalid. The error is shown to the user, not redirected.
+var ErrRedirectURI = errors.New("Invalid client or redirect URI")
+
+// Provider is http.Handler of the OpenID provider. Endpoints are at the
+// paths relative to the issuer URL, use http.StripPrefix to mount it, if
+// the issuer has a path.
+type Provider struct {
+	// Issuer is the issuer URL, the base of the endpoint URLs.
+	Issuer string
+
+	// CodeLifetime is the lifetime of the authorization code,
+	// DefaultCodeLifetime if zero.
+	CodeLifetime time.Duration
+
+	// TokenLifetime is the lifetime of ID and access tokens,
+	// DefaultTokenLifetime if zero.
+	TokenLifetime time.Duration
+
+	// LoginTemplate renders the login page with LoginPage data. The page
+	// uses login handlers at the relative path login/.
+	LoginTemplate *template.Template
+
+	// Login is the handler of the login page, configure its trust store
+	// and timeouts.
+	Login *smartidhttp.Handler
+
+	keys    KeyStore
+	clients ClientStore
+	mux     *http.ServeMux
+
+	mu       sync.Mutex
+	requests map[string]*authRequest
+	codes    map[string]*grant
+	tokens   map[string]*grant
+}
+
+// authRequest is the pending authorization request of the login page.
+type authRequest struct {
+	client      *Client
+	redirectURI string
+	state       string
+	nonce       string
+	scopes      []string
+	challenge   string
+	binding     string
+	expires     time.Time
+}
+
+// grant is the authorization code or access token of the authenticated
+// identity.
+type grant struct {
+	*authRequest
+	identity *smartid.Identity
+	authTime time.Time
+	expires  time.Time
+}
+
+// LoginPage is the data of the login template. Request is posted with
+// the complete request of the login handler.
+type LoginPage struct {
+	Client    *Client
+	Request   string
+	CSRFToken string
+}
+
+// NewProvider creates the provider of the issuer, which authenticates with
+// the client. Request is the template of the authentication requests.
+func NewProvider(
+	issuer string,
+	c *smartid.Client,
+	req *smartid.AuthRequest,
+	keys KeyStore,
+	clients ClientStore,
+) *Provider {
+	p := &Provider{
+		Issuer:        strings.TrimSuffix(issuer, "/"),
+		LoginTemplate: loginTemplate,
+		keys:          keys,
+		clients:       clients,
+		mux:           http.NewServeMux(),
+		requests:      make(map[string]*authRequest),
+		codes:         make(map[string]*grant),
+		tokens:        make(map[string]*grant),
+	}
+	p.Login = smartidhttp.New(c, req, p.onLogin)
+	p.mux.HandleFunc("GET /.well-known/openid-configuration", p.handleDiscovery)
+	p.mux.HandleFunc("GET /jwks", p.handleJWKS)
+	p.mux.HandleFunc("/authorize", p.handleAuthorize)
+	p.mux.HandleFunc("POST /token", p.handleToken)
+	p.mux.HandleFunc("/userinfo", p.handleUserInfo)
+	p.mux.Handle("/login/", http.StripPrefix("/login", p.Login))
+	return p
+}
+
+// ServeHTTP serves the endpoints of the provider.
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+func (p *Provider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	httputil.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.Issuer,
+		"authorization_endpoint":                p.Issuer + "/authorize",
+		"token_endpoint":                        p.Issuer + "/token",
+		"userinfo_endpoint":                     p.Issuer + "/userinfo",
+		"jwks_uri":                              p.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{ScopeOpenID, ScopeProfile},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported": []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce",
+			"name", "given_name", "family_name", "birthdate", "country",
+		},
+	})
+}
+
+func (p *Provider) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	keys, err := p.keys.Keys()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jwks := make([]map[string]string, 0, len(keys))
+	for _, k := range keys {
+		pub := k.PrivateKey.PublicKey
+		jwks = append(jwks, map[string]string{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": k.ID,
+			"n":   base64URL(pub.N.Bytes()),
+			"e":   base64URL(big.NewInt(int64(pub.E)).Bytes()),
+		})
+	}
+	httputil.WriteJSON(w, http.StatusOK, map[string]interface{}{"keys": jwks})
+}
+
+// handleAuthorize validates the authorization request and renders the
+// login page. PKCE with S256 is required.
+func (p *Provider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	client, err := p.clients.Client(r.FormValue("client_id"))
+	redirectURI := r.FormValue("redirect_uri")
+	if err != nil || !slices.Contains(client.RedirectURIs, redirectURI) {
+		http.Error(w, ErrRedirectURI.Error(), http.StatusBadRequest)
+		return
+	}
+	ar := &authRequest{
+		client:      client,
+		redirectURI: redirectURI,
+		state:       r.FormValue("state"),
+		nonce:       r.FormValue("nonce"),
+		scopes:      strings.Fields(r.FormValue("scope")),
+		challenge:   r.FormValue("code_challenge"),
+		expires:     time.Now().Add(requestLifetime),
+	}
+	switch {
+	case r.FormValue("response_type") != "code":
+		p.redirectError(w, r, ar, ErrorUnsupportedResponseType)
+		return
+	case !slices.Contains(ar.scopes, ScopeOpenID):
+		p.redirectError(w, r, ar, ErrorInvalidScope)
+		return
+	case ar.challenge == "" || r.FormValue("code_challenge_method") != "S256":
+		p.redirectError(w, r, ar, ErrorInvalidRequest)
+		return
+	}
+
+	id, err := httputil.RandomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if ar.binding, err = p.Login.Binding(w, r); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.mu.Lock()
+	expires := func(ar *authRequest) time.Time { return ar.expires }
+	httputil.RemoveExpired(p.requests, expires)
+	httputil.Evict(p.requests, maxRequests, expires)
+	p.requests[id] = ar
+	p.mu.Unlock()
+
+	csrf, err := p.Login.CSRFToken(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	p.LoginTemplate.Execute(w, LoginPage{
+		Client:    client,
+		Request:   id,
+		CSRFToken: csrf,
+	})
+}
+
+// onLogin issues the authorization code of the request form value and
+// responds with the redirect URL to the client. Request must be created
+// in the same browser session.
+func (p *Provider) onLogin(w http.ResponseWriter, r *http.Request, identity *smartid.Identity) {
+	binding, err := p.Login.Binding(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id := r.FormValue("request")
+	p.mu.Lock()
+	ar, ok := p.requests[id]
+	delete(p.requests, id)
+	p.mu.Unlock()
+	if !ok || time.Now().After(ar.expires) ||
+		subtle.ConstantTimeCompare([]byte(ar.binding), []byte(binding)) != 1 {
+		httputil.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": ErrorInvalidRequest})
+		return
+	}
+
+	code, err := httputil.RandomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	p.mu.Lock()
+	httputil.RemoveExpired(p.codes, func(g *grant) time.Time { return g.expires })
+	p.codes[code] = &grant{
+		authRequest: ar,
+		identity:    identity,
+		authTime:    now,
+		expires:     now.Add(lifetime(p.CodeLifetime, DefaultCodeLifetime)),
+	}
+	p.mu.Unlock()
+
+	params := url.Values{"code": {code}}
+	if ar.state != "" {
+		params.Set("state", ar.state)
+	}
+	httputil.WriteJSON(w, http.StatusOK, map[string]string{"redirect": redirectURL(ar.redirectURI, params)})
+}
+
+// handleToken exchanges the authorization code for ID and access tokens.
+// Confidential clients authenticate with client_secret_basic or
+// client_secret_post, code verifier is checked for all clients.
+func (p *Provider) handleToken(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, basic := r.BasicAuth()
+	if !basic {
+		clientID, secret = r.FormValue("client_id"), r.FormValue("client_secret")
+	}
+	client, err := p.clients.Client(clientID)
+	if err != nil || (client.Secret != "" &&
+		subtle.ConstantTimeCompare([]byte(secret), []byte(client.Secret)) != 1) {
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		}
+		tokenError(w, http.StatusUnauthorized, ErrorInvalidClient)
+		return
+	}
+	if r.FormValue("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, ErrorUnsupportedGrantType)
+		return
+	}
+
+	code := r.FormValue("code")
+	p.mu.Lock()
+	g, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+	verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || time.Now().After(g.expires) || g.client.ID != client.ID ||
+		g.redirectURI != r.FormValue("redirect_uri") ||
+		subtle.ConstantTimeCompare([]byte(base64URL(verifier[:])), []byte(g.challenge)) != 1 {
+		tokenError(w, http.StatusBadRequest, ErrorInvalidGrant)
+		return
+	}
+
+	tokenLifetime := lifetime(p.TokenLifetime, DefaultTokenLifetime)
+	now := time.Now()
+	idToken, err := p.idToken(g, now, tokenLifetime)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, ErrorServer)
+		return
+	}
+	accessToken, err := httputil.RandomString()
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, ErrorServer)
+		return
+	}
+	p.mu.Lock()
+	httputil.RemoveExpired(p.tokens, func(g *grant) time.Time { return g.expires })
+	p.tokens[accessToken] = &grant{
+		authRequest: g.authRequest,
+		identity:    g.identity,
+		authTime:    g.authTime,
+		expires:     now.Add(tokenLifetime),
+	}
+	p.mu.Unlock()
+
+	w.Header().Set("Cache-Control", "no-store")
+	httputil.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(tokenLifetime.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+// handleUserInfo returns claims of the bearer access token.
+func (p *Provider) handleUserInfo(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	p.mu.Lock()
+	g, found := p.tokens[token]
+	p.mu.Unlock()
+	if !ok || !found || time.Now().After(g.expires) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="`+ErrorInvalidToken+`"`)
+		tokenError(w, http.StatusUnauthorized, ErrorInvalidToken)
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, claims(g))
+}
+
+// idToken returns ID token of the grant signed with the first key.
+func (p *Provider) idToken(g *grant, now time.Time, lifetime time.Duration) (string, error) {
+	keys, err := p.keys.Keys()
+	if err != nil {
+		return "", err
+	}
+	if len(keys) == 0 {
+		return "", ErrNoKeys
+	}
+	c := claims(g)
+	c["iss"] = p.Issuer
+	c["aud"] = g.client.ID
+	c["iat"] = now.Unix()
+	c["exp"] = now.Add(lifetime).Unix()
+	c["auth_time"] = g.authTime.Unix()
+	if g.nonce != "" {
+		c["nonce"] = g.nonce
+	}
+	return signJWT(keys[0], c)
+}
+
+// claims returns the user claims of the grant.
+func claims(g *grant) map[string]interface{} {
+	c := map[string]interface{}{"sub": g.identity.SerialNumber}
+	if !slices.Contains(g.scopes, ScopeProfile) {
+		return c
+	}
+	given, surname := g.identity.SplitName()
+	c["name"] = strings.TrimSpace(given + " " + surname)
+	c["given_name"] = given
+	c["family_name"] = surname
+	if bd, ok := g.identity.BirthDate(); ok {
+		c["birthdate"] = bd.Format(time.DateOnly)
+	}
+	if g.identity.Country != "" {
+		c["country"] = g.identity.Country
+	}
+	return c
+}
+
+// signJWT signs the claims with RS256.
+func signJWT(key *Key, claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": key.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64URL(header) + "." + base64URL(payload)
+	sum := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key.PrivateKey, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64URL(sig), nil
+}
+
+// redirectError redirects the error of the authorization request to the
+// client.
+func (p *Provider) redirectError(w http.ResponseWriter, r *http.Request, ar *authRequest, code string) {
+	params := url.Values{"error": {code}}
+	if ar.state != "" {
+		params.Set("state", ar.state)
+	}
+	http.Redirect(w, r, redirectURL(ar.redirectURI, params), http.StatusFound)
+}
+
+// redirectURL adds the parameters to the query of the redirect URI.
+func redirectURL(uri string, params url.Values) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func lifetime(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
+// tokenError writes OAuth error response.
+func tokenError(w http.ResponseWriter, status int, code string) {
+	httputil.WriteJSON(w, status, map[string]string{"error": code})
+}
@@ -0,0 +1,419 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/dknight/go-smartid"
+	"github.com/dknight/go-smartid/internal/smartidtest"
+)
+
+const (
+	testRedirectURI = "https://app.example.com/callback"
+	testVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// newTestProvider starts the provider with confidential client "app" and
+// public client "spa".
+func newTestProvider(t *testing.T) (*Provider, *httptest.Server) {
+	t.Helper()
+	dir := t.TempDir()
+	keys, err := NewFileKeyStore(filepath.Join(dir, "keys.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientsFile := filepath.Join(dir, "clients.json")
+	os.WriteFile(clientsFile, []byte(`[
+		{"client_id": "app", "client_secret": "s3cret", "redirect_uris": ["`+testRedirectURI+`"], "client_name": "App"},
+		{"client_id": "spa", "redirect_uris": ["`+testRedirectURI+`"]}
+	]`), 0o600)
+	clients, err := NewFileClientStore(clientsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock := smartidtest.NewServer(t)
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	p := NewProvider(srv.URL, mock.Client(), &smartid.AuthRequest{}, keys, clients)
+	p.Login.InsecureCookie = true
+	mux.Handle("/", p)
+	return p, srv
+}
+
+// newTestUserAgent returns browser, which does not follow redirects.
+func newTestUserAgent() *http.Client {
+	jar, _ := cookiejar.New(nil)
+	return &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// authorizeURL returns authorization request URL of the client.
+func authorizeURL(issuer, clientID string, params url.Values) string {
+	challenge := sha256.Sum256([]byte(testVerifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"openid profile"},
+		"state":                 {"af0ifjsldkj"},
+		"nonce":                 {"n-0S6_WzA2Mj"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	for k, v := range params {
+		q[k] = v
+	}
+	return issuer + "/authorize?" + q.Encode()
+}
+
+// login logs in on the login page and returns the redirect URL.
+func login(t *testing.T, ua *http.Client, authorize string) *url.URL {
+	t.Helper()
+	status, u := loginRequest(t, ua, authorize, "")
+	if status != http.StatusOK {
+		t.Fatal("expected", http.StatusOK, "got", status)
+	}
+	return u
+}
+
+// loginRequest logs in on the login page and completes the login with the
+// authorization request, the one of the page if empty. Status of the
+// complete request and the redirect URL are returned.
+func loginRequest(t *testing.T, ua *http.Client, authorize, request string) (int, *url.URL) {
+	t.Helper()
+	resp, err := ua.Get(authorize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("expected", http.StatusOK, "got", resp.StatusCode, string(page))
+	}
+	csrf := regexp.MustCompile(`data-csrf="([^"]+)"`).FindSubmatch(page)
+	pageRequest := regexp.MustCompile(`data-request="([^"]+)"`).FindSubmatch(page)
+	if csrf == nil || pageRequest == nil {
+		t.Fatal("expected CSRF token and request in login page")
+	}
+	if request == "" {
+		request = string(pageRequest[1])
+	}
+	base := strings.TrimSuffix(authorize[:strings.Index(authorize, "?")], "authorize")
+
+	post := func(path string, form url.Values, v interface{}) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, base+path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-CSRF-Token", string(csrf[1]))
+		resp, err := ua.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(v)
+		return resp.StatusCode
+	}
+	var start struct{ Token string }
+	if status := post("login/start", url.Values{"identifier": {smartidtest.Identifier}}, &start); status != http.StatusOK {
+		t.Fatal("expected", http.StatusOK, "got", status)
+	}
+	resp, err = ua.Get(base + "login/status?wait=5&token=" + start.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	var complete struct{ Redirect string }
+	status := post("login/complete", url.Values{"token": {start.Token}, "request": {request}}, &complete)
+	u, err := url.Parse(complete.Redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status, u
+}
+
+// exchange exchanges the code at the token endpoint.
+func exchange(t *testing.T, issuer string, form url.Values, basic ...string) (int, map[string]interface{}) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, issuer+"/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if len(basic) == 2 {
+		req.SetBasicAuth(basic[0], basic[1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var v map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&v)
+	return resp.StatusCode, v
+}
+
+// verifyTestJWT verifies RS256 JWT with JWKS of the issuer and returns
+// the claims.
+func verifyTestJWT(t *testing.T, issuer, token string) map[string]interface{} {
+	t.Helper()
+	resp, err := http.Get(issuer + "/jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var jwks struct{ Keys []struct{ Kid, N, E string } }
+	json.NewDecoder(resp.Body).Decode(&jwks)
+	if len(jwks.Keys) != 1 {
+		t.Fatal("expected 1 key, got", len(jwks.Keys))
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatal("expected JWT, got", token)
+	}
+	var header struct{ Alg, Kid string }
+	data, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	json.Unmarshal(data, &header)
+	if header.Alg != "RS256" || header.Kid != jwks.Keys[0].Kid {
+		t.Error("expected RS256 with key", jwks.Keys[0].Kid, "got", header)
+	}
+	n, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0].N)
+	e, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0].E)
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig); err != nil {
+		t.Error("expected valid ID token signature, got", err)
+	}
+	var claims map[string]interface{}
+	data, _ = base64.RawURLEncoding.DecodeString(parts[1])
+	json.Unmarshal(data, &claims)
+	return claims
+}
+
+func TestProvider_discovery(t *testing.T) {
+	_, srv := newTestProvider(t)
+	resp, err := http.Get(srv.URL + "/.well-known/openid-configuration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var config map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&config)
+	if config["issuer"] != srv.URL || config["token_endpoint"] != srv.URL+"/token" ||
+		config["jwks_uri"] != srv.URL+"/jwks" {
+		t.Error("expected endpoints of the issuer, got", config)
+	}
+}
+
+func TestProvider_codeFlow(t *testing.T) {
+	_, srv := newTestProvider(t)
+	ua := newTestUserAgent()
+
+	redirect := login(t, ua, authorizeURL(srv.URL, "app", nil))
+	code := redirect.Query().Get("code")
+	if !strings.HasPrefix(redirect.String(), testRedirectURI) || code == "" ||
+		redirect.Query().Get("state") != "af0ifjsldkj" {
+		t.Fatal("expected redirect with code and state, got", redirect)
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testVerifier},
+	}
+	if status, v := exchange(t, srv.URL, form, "app", "wrong"); status != http.StatusUnauthorized ||
+		v["error"] != ErrorInvalidClient {
+		t.Error("expected", ErrorInvalidClient, "got", status, v)
+	}
+	status, tokens := exchange(t, srv.URL, form, "app", "s3cret")
+	if status != http.StatusOK {
+		t.Fatal("expected", http.StatusOK, "got", status, tokens)
+	}
+	claims := verifyTestJWT(t, srv.URL, tokens["id_token"].(string))
+	expected := map[string]interface{}{
+		"iss":         srv.URL,
+		"aud":         "app",
+		"sub":         smartidtest.Identifier,
+		"nonce":       "n-0S6_WzA2Mj",
+		"given_name":  "OK",
+		"family_name": "TESTNUMBER",
+		"birthdate":   "1903-03-03",
+		"country":     "EE",
+	}
+	for k, v := range expected {
+		if claims[k] != v {
+			t.Error(k, "expected", v, "got", claims[k])
+		}
+	}
+
+	if status, v := exchange(t, srv.URL, form, "app", "s3cret"); status != http.StatusBadRequest ||
+		v["error"] != ErrorInvalidGrant {
+		t.Error("expected code to be used once, got", status, v)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens["access_token"].(string))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var userinfo map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&userinfo)
+	if userinfo["sub"] != smartidtest.Identifier || userinfo["name"] != "OK TESTNUMBER" {
+		t.Error("expected userinfo of the person, got", userinfo)
+	}
+
+	req.Header.Set("Authorization", "Bearer invalid")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Error("expected", http.StatusUnauthorized, "got", resp.StatusCode)
+	}
+}
+
+func TestProvider_publicClient(t *testing.T) {
+	_, srv := newTestProvider(t)
+	redirect := login(t, newTestUserAgent(), authorizeURL(srv.URL, "spa", url.Values{"scope": {"openid"}}))
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"spa"},
+		"code":          {redirect.Query().Get("code")},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {"wrong-verifier"},
+	}
+	if status, v := exchange(t, srv.URL, form); status != http.StatusBadRequest || v["error"] != ErrorInvalidGrant {
+		t.Error("expected PKCE failure, got", status, v)
+	}
+
+	redirect = login(t, newTestUserAgent(), authorizeURL(srv.URL, "spa", url.Values{"scope": {"openid"}}))
+	form.Set("code", redirect.Query().Get("code"))
+	form.Set("code_verifier", testVerifier)
+	status, tokens := exchange(t, srv.URL, form)
+	if status != http.StatusOK {
+		t.Fatal("expected", http.StatusOK, "got", status, tokens)
+	}
+	claims := verifyTestJWT(t, srv.URL, tokens["id_token"].(string))
+	if claims["sub"] != smartidtest.Identifier || claims["given_name"] != nil {
+		t.Error("expected only openid claims, got", claims)
+	}
+}
+
+func TestProvider_authorizeErrors(t *testing.T) {
+	_, srv := newTestProvider(t)
+	ua := newTestUserAgent()
+
+	testdata := []struct {
+		name   string
+		client string
+		params url.Values
+		status int
+		error  string
+	}{
+		{"client", "unknown", nil, http.StatusBadRequest, ""},
+		{"redirect", "app", url.Values{"redirect_uri": {"https://evil.example.com/"}}, http.StatusBadRequest, ""},
+		{"responseType", "app", url.Values{"response_type": {"token"}}, http.StatusFound, ErrorUnsupportedResponseType},
+		{"scope", "app", url.Values{"scope": {"profile"}}, http.StatusFound, ErrorInvalidScope},
+		{"pkce", "app", url.Values{"code_challenge": {""}}, http.StatusFound, ErrorInvalidRequest},
+		{"plain", "app", url.Values{"code_challenge_method": {"plain"}}, http.StatusFound, ErrorInvalidRequest},
+	}
+	for _, test := range testdata {
+		resp, err := ua.Get(authorizeURL(srv.URL, test.client, test.params))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Error(test.name, "expected", test.status, "got", resp.StatusCode)
+			continue
+		}
+		if test.error == "" {
+			continue
+		}
+		u, _ := url.Parse(resp.Header.Get("Location"))
+		if u.Query().Get("error") != test.error || u.Query().Get("state") != "af0ifjsldkj" {
+			t.Error(test.name, "expected", test.error, "got", u)
+		}
+	}
+}
+
+func TestProvider_requestBinding(t *testing.T) {
+	_, srv := newTestProvider(t)
+
+	// Request of the other browser can not be completed.
+	resp, err := newTestUserAgent().Get(authorizeURL(srv.URL, "app", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	request := regexp.MustCompile(`data-request="([^"]+)"`).FindSubmatch(page)
+	if request == nil {
+		t.Fatal("expected request in login page")
+	}
+	status, _ := loginRequest(t, newTestUserAgent(), authorizeURL(srv.URL, "app", nil), string(request[1]))
+	if status != http.StatusBadRequest {
+		t.Error("expected", http.StatusBadRequest, "got", status)
+	}
+}
+
+func TestProvider_maxRequests(t *testing.T) {
+	p, srv := newTestProvider(t)
+	for i := 0; i < maxRequests+10; i++ {
+		req := httptest.NewRequest(http.MethodGet, authorizeURL(srv.URL, "app", nil), nil)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatal("expected", http.StatusOK, "got", rec.Code)
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.requests) != maxRequests {
+		t.Error("expected", maxRequests, "got", len(p.requests))
+	}
+}
+
+func TestNewFileKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.pem")
+	generated, err := NewFileKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := NewFileKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k1, _ := generated.Keys()
+	k2, _ := loaded.Keys()
+	if len(k2) != 1 || k1[0].ID != k2[0].ID {
+		t.Error("expected generated key to be loaded")
+	}
+
+	os.WriteFile(path, []byte("no keys"), 0o600)
+	if _, err := NewFileKeyStore(path); err != ErrNoKeys {
+		t.Error("expected", ErrNoKeys, "got", err)
+	}
+}
@@ -0,0 +1,170 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"math/big"
+	"os"
+)
+
+var (
+	// ErrClientNotFound error when client is not registered.
+	ErrClientNotFound = errors.New("Client not found")
+
+	// ErrNoKeys error when key store has no signing keys.
+	ErrNoKeys = errors.New("No signing keys")
+)
+
+// Key is the signing key of ID tokens.
+type Key struct {
+	// ID is the key ID (kid) of the key.
+	ID string
+
+	// PrivateKey is RSA private key, ID tokens are signed with RS256.
+	PrivateKey *rsa.PrivateKey
+}
+
+// NewKey creates key with RFC 7638 thumbprint as the key ID.
+func NewKey(key *rsa.PrivateKey) *Key {
+	thumbprint, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{
+		E:   base64URL(big.NewInt(int64(key.E)).Bytes()),
+		Kty: "RSA",
+		N:   base64URL(key.N.Bytes()),
+	})
+	sum := sha256.Sum256(thumbprint)
+	return &Key{ID: base64URL(sum[:]), PrivateKey: key}
+}
+
+// KeyStore provides the signing keys. The first key signs, all keys are
+// published in JWKS, so the keys can be rotated.
+type KeyStore interface {
+	Keys() ([]*Key, error)
+}
+
+// ClientStore provides the registered clients.
+type ClientStore interface {
+	// Client returns the client, ErrClientNotFound if it is not
+	// registered.
+	Client(id string) (*Client, error)
+}
+
+// Client is the registered relying party.
+type Client struct {
+	ID string `json:"client_id"`
+
+	// Secret authenticates the confidential client, public client has
+	// none.
+	Secret string `json:"client_secret,omitempty"`
+
+	// RedirectURIs are the allowed redirect URIs, matched exactly.
+	RedirectURIs []string `json:"redirect_uris"`
+
+	Name string `json:"client_name,omitempty"`
+}
+
+// FileKeyStore is the key store of PEM encoded RSA private keys in the
+// file.
+type FileKeyStore struct {
+	keys []*Key
+}
+
+// NewFileKeyStore loads the keys of PEM file, PKCS #1 and PKCS #8 keys are
+// supported. If the file does not exist, a new 2048 bit key is generated
+// and saved to the file.
+func NewFileKeyStore(path string) (*FileKeyStore, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+			return nil, err
+		}
+		return &FileKeyStore{keys: []*Key{NewKey(key)}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileKeyStore{}
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+		var key interface{}
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+			s.keys = append(s.keys, NewKey(rsaKey))
+		}
+	}
+	if len(s.keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	return s, nil
+}
+
+// Keys returns the keys in the order of the file.
+func (s *FileKeyStore) Keys() ([]*Key, error) {
+	return s.keys, nil
+}
+
+// FileClientStore is the client store of JSON file, which has array of
+// clients.
+type FileClientStore struct {
+	clients map[string]*Client
+}
+
+// NewFileClientStore loads the clients of JSON file.
+func NewFileClientStore(path string) (*FileClientStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var clients []*Client
+	if err := json.Unmarshal(data, &clients); err != nil {
+		return nil, err
+	}
+	s := &FileClientStore{clients: make(map[string]*Client)}
+	for _, c := range clients {
+		s.clients[c.ID] = c
+	}
+	return s, nil
+}
+
+// Client returns the client of the ID.
+func (s *FileClientStore) Client(id string) (*Client, error) {
+	c, ok := s.clients[id]
+	if !ok {
+		return nil, ErrClientNotFound
+	}
+	return c, nil
+}
+
+// base64URL encodes data to base64url without padding.
+func base64URL(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
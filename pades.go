@@ -0,0 +1,839 @@
+package smartid
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// defaultPDFContentsSize is the default space reserved for CMS signature
+// in bytes.
+const defaultPDFContentsSize = 32768
+
+// byteRangePlaceholder reserves space for ByteRange array which is
+// patched after the update is written.
+const byteRangePlaceholder = "[0 0000000000 0000000000 0000000000]"
+
+var (
+	// ErrPDFNoPages error when PDF has no pages for signature widget.
+	ErrPDFNoPages = errors.New("PDF has no pages")
+
+	// ErrPDFSignatureTooLarge error when CMS signature does not fit into
+	// the reserved space.
+	ErrPDFSignatureTooLarge = errors.New("Signature does not fit into PDF signature contents")
+
+	// ErrPDFByteRangeInvalid error when signature byte range does not
+	// cover the revision except the signature contents.
+	ErrPDFByteRangeInvalid = errors.New("Invalid PDF signature byte range")
+
+	// ErrPDFModifiedAfterSigning error when the document is changed after
+	// signing by other means than adding signatures or validation data.
+	ErrPDFModifiedAfterSigning = errors.New("PDF is modified after signing")
+
+	// ErrPDFSubFilterUnsupported error when signature format of the PDF
+	// signature is not supported.
+	ErrPDFSubFilterUnsupported = errors.New("Unsupported PDF signature format")
+)
+
+// PDFSignatureOptions are optional properties of PDF signature.
+type PDFSignatureOptions struct {
+	// FieldName is the name of signature field. Unique name is generated
+	// if empty.
+	FieldName string
+
+	// Name, Reason, Location and ContactInfo are informational entries
+	// of the signature dictionary.
+	Name        string
+	Reason      string
+	Location    string
+	ContactInfo string
+
+	// SigningTime is the claimed signing time, current time by default.
+	SigningTime time.Time
+
+	// Chain are the intermediate certificates included in the signature.
+	Chain []*x509.Certificate
+
+	// ContentsSize is the space reserved for CMS signature in bytes,
+	// 32768 by default.
+	ContentsSize int
+}
+
+// PDFSignature is PAdES B-B signature which is being prepared as
+// incremental update of the PDF. Digest of the CMS signed attributes is
+// signed with Smart-ID and the value is set with SetSignatureValue.
+// Optionally timestamp makes it PAdES B-T.
+type PDFSignature struct {
+	data          []byte
+	contentsStart int
+	contentsEnd   int
+	fieldName     string
+	signingTime   time.Time
+	cms           *cmsSigner
+}
+
+// PreparePDFSignature adds invisible signature field to the first page of
+// the PDF as incremental update and computes the digest of the signed byte
+// ranges.
+func PreparePDFSignature(
+	pdf []byte,
+	cert *x509.Certificate,
+	algo string,
+	opts *PDFSignatureOptions,
+) (*PDFSignature, error) {
+	if opts == nil {
+		opts = &PDFSignatureOptions{}
+	}
+	doc, err := parsePDF(pdf)
+	if err != nil {
+		return nil, err
+	}
+	rootRef := doc.trailer["Root"].(pdfRef)
+	root := copyPDFDict(doc.resolveDict(rootRef))
+	if root == nil {
+		return nil, ErrPDFInvalid
+	}
+	pageRef, page, err := firstPDFPage(doc, root)
+	if err != nil {
+		return nil, err
+	}
+
+	u := newPDFUpdate(doc)
+	var acroFormRef *pdfRef
+	if ref, ok := root["AcroForm"].(pdfRef); ok {
+		acroFormRef = &ref
+	}
+	acroForm := copyPDFDict(doc.resolveDict(root["AcroForm"]))
+	if acroForm == nil {
+		acroForm = pdfDict{}
+	}
+	fields := append(pdfArray{}, doc.resolveArray(acroForm["Fields"])...)
+
+	fieldName := opts.FieldName
+	if fieldName == "" {
+		fieldName = uniquePDFFieldName(doc, fields)
+	}
+	signingTime := opts.SigningTime
+	if signingTime.IsZero() {
+		signingTime = time.Now()
+	}
+	signingTime = signingTime.UTC().Truncate(time.Second)
+	contentsSize := opts.ContentsSize
+	if contentsSize <= 0 {
+		contentsSize = defaultPDFContentsSize
+	}
+
+	sig := pdfDict{
+		"Type":      pdfName("Sig"),
+		"Filter":    pdfName("Adobe.PPKLite"),
+		"SubFilter": pdfName("ETSI.CAdES.detached"),
+		"M":         pdfString(formatPDFDate(signingTime)),
+		"ByteRange": pdfRaw(byteRangePlaceholder),
+		"Contents":  pdfRaw("<" + string(bytes.Repeat([]byte("0"), 2*contentsSize)) + ">"),
+	}
+	for key, value := range map[pdfName]string{
+		"Name":        opts.Name,
+		"Reason":      opts.Reason,
+		"Location":    opts.Location,
+		"ContactInfo": opts.ContactInfo,
+	} {
+		if value != "" {
+			sig[key] = pdfTextString(value)
+		}
+	}
+	sigRef := u.add(sig)
+
+	widgetRef := u.add(pdfDict{
+		"Type":    pdfName("Annot"),
+		"Subtype": pdfName("Widget"),
+		"FT":      pdfName("Sig"),
+		"T":       pdfTextString(fieldName),
+		"V":       sigRef,
+		"Rect":    pdfArray{int64(0), int64(0), int64(0), int64(0)},
+		"F":       int64(132),
+		"P":       pageRef,
+	})
+
+	page = copyPDFDict(page)
+	page["Annots"] = append(append(pdfArray{}, doc.resolveArray(page["Annots"])...), widgetRef)
+	u.set(pageRef, page)
+
+	acroForm["Fields"] = append(fields, widgetRef)
+	acroForm["SigFlags"] = int64(3)
+	if acroFormRef != nil {
+		u.set(*acroFormRef, acroForm)
+	} else {
+		root["AcroForm"] = acroForm
+	}
+	if _, ok := root["Extensions"]; !ok {
+		root["Extensions"] = pdfDict{"ESIC": pdfDict{
+			"BaseVersion":    pdfName("1.7"),
+			"ExtensionLevel": int64(2),
+		}}
+	}
+	u.set(rootRef, root)
+
+	data, offsets := u.write()
+	s := &PDFSignature{
+		data:        data,
+		fieldName:   fieldName,
+		signingTime: signingTime,
+	}
+	sigOffset := offsets[sigRef.Num]
+	contents := bytes.Index(data[sigOffset:], []byte("/Contents <"))
+	byteRange := bytes.Index(data[sigOffset:], []byte("/ByteRange "+byteRangePlaceholder))
+	if contents < 0 || byteRange < 0 {
+		return nil, ErrPDFInvalid
+	}
+	s.contentsStart = sigOffset + contents + len("/Contents ")
+	s.contentsEnd = s.contentsStart + 2*contentsSize + 2
+
+	ranges := fmt.Sprintf("[0 %d %d %d]", s.contentsStart, s.contentsEnd, len(data)-s.contentsEnd)
+	start := sigOffset + byteRange + len("/ByteRange ")
+	copy(data[start:], ranges)
+	copy(data[start+len(ranges):start+len(byteRangePlaceholder)],
+		bytes.Repeat([]byte(" "), len(byteRangePlaceholder)-len(ranges)))
+
+	h, err := newHash(algo)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(data[:s.contentsStart])
+	h.Write(data[s.contentsEnd:])
+	digest, err := NewDigest(algo, h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	// PAdES forbids signing-time attribute, time is in M entry instead.
+	s.cms, err = newCMSSigner(oidContentTypeData, digest, cert, opts.Chain, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// FieldName returns the name of the signature field.
+func (s *PDFSignature) FieldName() string {
+	return s.fieldName
+}
+
+// Digest returns digest of the CMS signed attributes, which is signed by
+// Smart-ID.
+func (s *PDFSignature) Digest() *Digest {
+	return s.cms.digest
+}
+
+// SigningTime returns claimed signing time.
+func (s *PDFSignature) SigningTime() time.Time {
+	return s.signingTime
+}
+
+// Certificate returns the signer certificate.
+func (s *PDFSignature) Certificate() *x509.Certificate {
+	return s.cms.cert
+}
+
+// SetSignatureValue sets the signature value returned by Smart-ID. Value
+// is verified with the signer certificate.
+func (s *PDFSignature) SetSignatureValue(sig Signature) error {
+	value, err := base64.StdEncoding.DecodeString(sig.Value)
+	if err != nil {
+		return err
+	}
+	return s.cms.setSignature(value)
+}
+
+// Sign signs the signature with Smart-ID using SignSync. Request should
+// have the identifier of the person, preferably document number from the
+// certificate choice. Digest of the request is set automatically.
+func (s *PDFSignature) Sign(ctx context.Context, c *Client, req *AuthRequest) (*SessionResponse, error) {
+	resp, err := signDigest(ctx, c, req, s.cms.digest, s.cms.cert)
+	if err != nil {
+		return resp, err
+	}
+	return resp, s.SetSignatureValue(resp.Signature)
+}
+
+// AddTimestamp adds signature timestamp over the signature value, which
+// makes the signature PAdES B-T.
+func (s *PDFSignature) AddTimestamp(ctx context.Context, ts Timestamper) error {
+	return s.cms.addTimestamp(ctx, ts)
+}
+
+// Bytes returns the signed PDF.
+func (s *PDFSignature) Bytes() ([]byte, error) {
+	der, err := s.cms.bytes()
+	if err != nil {
+		return nil, err
+	}
+	if 2*len(der) > s.contentsEnd-s.contentsStart-2 {
+		return nil, ErrPDFSignatureTooLarge
+	}
+	data := append([]byte(nil), s.data...)
+	hex.Encode(data[s.contentsStart+1:], der)
+	return data, nil
+}
+
+// ValidatePDF validates all signatures and document timestamps of the PDF.
+// ID of the result is the fully qualified name of the signature field.
+// Certificates and OCSP responses of the document security store are used
+// for validation.
+func ValidatePDF(ctx context.Context, pdf []byte, ts *TrustStore) ([]*SignatureResult, error) {
+	doc, err := parsePDF(pdf)
+	if err != nil {
+		return nil, err
+	}
+	root := doc.resolveDict(doc.trailer["Root"])
+	certs, ocspValues := pdfDSS(doc, root)
+	var results []*SignatureResult
+	for _, f := range pdfSignatureFields(doc, root) {
+		results = append(results, validatePDFSignature(ctx, ts, doc, f, certs, ocspValues))
+	}
+	return results, nil
+}
+
+// pdfSignatureField is the signature field with signature value.
+type pdfSignatureField struct {
+	name string
+	sig  pdfDict
+}
+
+// validatePDFSignature validates signature of the field.
+func validatePDFSignature(
+	ctx context.Context,
+	ts *TrustStore,
+	doc *pdfDocument,
+	f pdfSignatureField,
+	certs []*x509.Certificate,
+	ocspValues [][]byte,
+) *SignatureResult {
+	r := &SignatureResult{ID: f.name}
+	if m, ok := f.sig["M"].(pdfString); ok {
+		r.SigningTime, _ = parsePDFDate(string(m))
+	}
+	contents, _ := f.sig["Contents"].(pdfString)
+	signed, revEnd, err := pdfSignedBytes(doc.data, f.sig["ByteRange"], contents)
+	if err != nil {
+		r.fail(err)
+		return r
+	}
+	// Contents is padded with zeros after DER encoded value.
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal([]byte(contents), &raw); err != nil {
+		r.fail(err)
+		return r
+	}
+
+	switch f.sig["SubFilter"] {
+	case pdfName("ETSI.CAdES.detached"), pdfName("adbe.pkcs7.detached"):
+		r.verifyCMS(ctx, ts, raw.FullBytes, signed, certs, ocspValues,
+			f.sig["SubFilter"] == pdfName("ETSI.CAdES.detached"))
+		if r.OCSP == nil {
+			r.warn(ErrRevocationMissing)
+		}
+	case pdfName("ETSI.RFC3161"):
+		r.verifyDocumentTimestamp(ctx, ts, raw.FullBytes, signed)
+	default:
+		r.fail(ErrPDFSubFilterUnsupported)
+		return r
+	}
+	if revEnd < len(doc.data) {
+		if err := checkPDFModifications(doc, revEnd); err != nil {
+			r.fail(err)
+		}
+	}
+	return r
+}
+
+// verifyDocumentTimestamp verifies document timestamp over the signed
+// bytes. The TSA is the signer of document timestamp.
+func (r *SignatureResult) verifyDocumentTimestamp(ctx context.Context, ts *TrustStore, der, signed []byte) {
+	token, err := ParseTimestampToken(der)
+	if err != nil {
+		r.fail(err)
+		return
+	}
+	r.SigningTime = token.Time
+	digest, err := HashData(signed, token.Digest.Algorithm())
+	if err != nil {
+		r.fail(err)
+		return
+	}
+	if err := token.CheckDigest(digest); err != nil {
+		r.fail(err)
+		return
+	}
+	cert, err := token.Verify(ctx, ts)
+	if err != nil {
+		r.fail(err)
+		return
+	}
+	r.setSigner(cert)
+	r.TrustedTime = token.Time
+	r.Timestamps = append(r.Timestamps, token)
+}
+
+// pdfSignedBytes checks that the byte range covers the revision except
+// the signature contents and returns the signed bytes and the end of the
+// revision.
+func pdfSignedBytes(data []byte, byteRange interface{}, contents pdfString) ([]byte, int, error) {
+	br, _ := byteRange.(pdfArray)
+	if len(br) != 4 {
+		return nil, 0, ErrPDFByteRangeInvalid
+	}
+	var n [4]int
+	for i, v := range br {
+		x, ok := v.(int64)
+		if !ok || x < 0 || x > int64(len(data)) {
+			return nil, 0, ErrPDFByteRangeInvalid
+		}
+		n[i] = int(x)
+	}
+	start, end, revEnd := n[1], n[2], n[2]+n[3]
+	if n[0] != 0 || start >= end || revEnd > len(data) {
+		return nil, 0, ErrPDFByteRangeInvalid
+	}
+	l := &pdfLexer{data: data[:end], pos: start}
+	if data[start] != '<' || l.mustToken() != contents || l.pos != end {
+		return nil, 0, ErrPDFByteRangeInvalid
+	}
+	if !bytes.HasSuffix(bytes.TrimRight(data[:revEnd], "\r\n \t"), []byte("%%EOF")) {
+		return nil, 0, ErrPDFByteRangeInvalid
+	}
+	signed := append(append([]byte(nil), data[:start]...), data[end:revEnd]...)
+	return signed, revEnd, nil
+}
+
+// pdfSignatureFields finds signed signature fields of the interactive
+// form.
+func pdfSignatureFields(doc *pdfDocument, root pdfDict) []pdfSignatureField {
+	var fields []pdfSignatureField
+	visited := make(map[pdfRef]bool)
+	var walk func(v interface{}, name string, ft interface{}, depth int)
+	walk = func(v interface{}, name string, ft interface{}, depth int) {
+		if ref, ok := v.(pdfRef); ok {
+			if visited[ref] {
+				return
+			}
+			visited[ref] = true
+		}
+		field := doc.resolveDict(v)
+		if field == nil || depth > 32 {
+			return
+		}
+		if t, ok := field["T"].(pdfString); ok {
+			if name != "" {
+				name += "."
+			}
+			name += decodePDFText(t)
+		}
+		if v, ok := field["FT"]; ok {
+			ft = v
+		}
+		if sig := doc.resolveDict(field["V"]); ft == pdfName("Sig") && sig != nil {
+			fields = append(fields, pdfSignatureField{name: name, sig: sig})
+		}
+		for _, kid := range doc.resolveArray(field["Kids"]) {
+			walk(kid, name, ft, depth+1)
+		}
+	}
+	acroForm := doc.resolveDict(root["AcroForm"])
+	for _, f := range doc.resolveArray(acroForm["Fields"]) {
+		walk(f, "", nil, 0)
+	}
+	return fields
+}
+
+// pdfDSS returns certificates and OCSP responses of the document security
+// store.
+func pdfDSS(doc *pdfDocument, root pdfDict) ([]*x509.Certificate, [][]byte) {
+	dss := doc.resolveDict(root["DSS"])
+	var certs []*x509.Certificate
+	for _, v := range doc.resolveArray(dss["Certs"]) {
+		if data := pdfStreamData(doc, v); data != nil {
+			if cert, err := x509.ParseCertificate(data); err == nil {
+				certs = append(certs, cert)
+			}
+		}
+	}
+	var ocspValues [][]byte
+	for _, v := range doc.resolveArray(dss["OCSPs"]) {
+		if data := pdfStreamData(doc, v); data != nil {
+			ocspValues = append(ocspValues, data)
+		}
+	}
+	return certs, ocspValues
+}
+
+// pdfStreamData returns decoded data of the stream, nil if it is not
+// a stream.
+func pdfStreamData(doc *pdfDocument, v interface{}) []byte {
+	obj, err := doc.resolve(v)
+	if err != nil {
+		return nil
+	}
+	stream, ok := obj.(*pdfStream)
+	if !ok {
+		return nil
+	}
+	data, err := doc.decodeStream(stream)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// checkPDFModifications checks that incremental updates after the revision
+// only add signatures, signature fields and validation data.
+func checkPDFModifications(doc *pdfDocument, revEnd int) error {
+	rev, err := parsePDF(doc.data[:revEnd])
+	if err != nil || rev.trailer["Root"] != doc.trailer["Root"] {
+		return ErrPDFModifiedAfterSigning
+	}
+	for num, entry := range doc.xref {
+		ref := pdfRef{Num: num, Gen: entry.gen}
+		old, ok := rev.xref[num]
+		if !ok {
+			// New objects are visible only through changed objects, but
+			// annotations must be signature widgets anyway.
+			if d := doc.resolveDict(ref); (d["Type"] == pdfName("Annot") || d["Subtype"] == pdfName("Widget")) &&
+				!permittedPDFSignatureWidget(doc, ref, 0) {
+				return fmt.Errorf("%w: object %d", ErrPDFModifiedAfterSigning, num)
+			}
+			continue
+		}
+		if old == entry || doc.trailer["Info"] == ref {
+			continue
+		}
+		oldObj, err := rev.object(num)
+		if err != nil {
+			return ErrPDFModifiedAfterSigning
+		}
+		newObj, err := doc.object(num)
+		if err != nil || !permittedPDFChange(rev, doc, ref, oldObj, newObj) {
+			return fmt.Errorf("%w: object %d", ErrPDFModifiedAfterSigning, num)
+		}
+	}
+	return nil
+}
+
+// permittedPDFChange checks that the object is changed only by adding
+// signatures or validation data.
+func permittedPDFChange(rev, doc *pdfDocument, ref pdfRef, oldObj, newObj interface{}) bool {
+	if pdfEqual(oldObj, newObj) {
+		return true
+	}
+	if old, ok := oldObj.(pdfArray); ok {
+		cur, ok := newObj.(pdfArray)
+		return ok && onlySignaturesAdded(doc, old, cur)
+	}
+	old, ok1 := oldObj.(pdfDict)
+	cur, ok2 := newObj.(pdfDict)
+	if !ok1 || !ok2 {
+		return false
+	}
+	switch {
+	case old["Type"] == pdfName("Catalog"):
+		return pdfEqual(old, cur, "AcroForm", "DSS", "Extensions") &&
+			pdfEntriesKept(rev, doc, old["Extensions"], cur["Extensions"], 0) &&
+			permittedAcroFormChange(rev, doc,
+				rev.resolveDict(old["AcroForm"]), doc.resolveDict(cur["AcroForm"]))
+	case old["Type"] == pdfName("Page"):
+		return pdfEqual(old, cur, "Annots") &&
+			onlySignaturesAdded(doc, rev.resolveArray(old["Annots"]), doc.resolveArray(cur["Annots"]))
+	case old["FT"] == pdfName("Sig"):
+		_, signed := old["V"]
+		return !signed && pdfEqual(old, cur, "V", "AP", "AS") &&
+			(pdfEqual(old["AP"], cur["AP"]) || permittedPDFSignatureWidget(doc, ref, 0))
+	case old["Fields"] != nil:
+		return permittedAcroFormChange(rev, doc, old, cur)
+	}
+	return false
+}
+
+// permittedAcroFormChange checks that only signature fields are added to
+// the interactive form.
+func permittedAcroFormChange(rev, doc *pdfDocument, old, cur pdfDict) bool {
+	if old == nil || cur == nil {
+		return old == nil
+	}
+	return pdfEqual(old, cur, "Fields", "SigFlags", "DR") &&
+		pdfEntriesKept(rev, doc, old["DR"], cur["DR"], 1) &&
+		onlySignaturesAdded(doc, rev.resolveArray(old["Fields"]), doc.resolveArray(cur["Fields"]))
+}
+
+// onlySignaturesAdded checks that the array keeps old items and added
+// items are signature fields or their widgets.
+func onlySignaturesAdded(doc *pdfDocument, old, cur pdfArray) bool {
+	items := make(map[interface{}]bool, len(cur))
+	for _, v := range cur {
+		if _, ok := v.(pdfRef); ok {
+			items[v] = true
+		}
+	}
+	existing := make(map[interface{}]bool, len(old))
+	for _, v := range old {
+		if _, ok := v.(pdfRef); ok && !items[v] {
+			return false
+		}
+		existing[v] = true
+	}
+	for _, v := range cur {
+		if !existing[v] && !permittedPDFSignatureWidget(doc, v, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// permittedPDFSignatureWidget checks that the added signature field or
+// widget has no visible appearance, unless the appearance is covered by
+// the signature of the field. Otherwise anyone could overlay the signed
+// page with an appearance of the unsigned field.
+func permittedPDFSignatureWidget(doc *pdfDocument, v interface{}, depth int) bool {
+	d := doc.resolveDict(v)
+	field := d
+	if _, ok := d["FT"]; !ok {
+		field = doc.resolveDict(d["Parent"])
+	}
+	if field["FT"] != pdfName("Sig") || depth > 1 {
+		return false
+	}
+	for _, kid := range doc.resolveArray(d["Kids"]) {
+		if !permittedPDFSignatureWidget(doc, kid, depth+1) {
+			return false
+		}
+	}
+	if pdfWidgetHidden(d) {
+		return true
+	}
+	ref, ok := v.(pdfRef)
+	sig := doc.resolveDict(field["V"])
+	contents, _ := sig["Contents"].(pdfString)
+	_, revEnd, err := pdfSignedBytes(doc.data, sig["ByteRange"], contents)
+	return ok && err == nil && pdfDefinedBefore(doc, ref, revEnd) &&
+		pdfRefsDefinedBefore(doc, d["AP"], revEnd, make(map[pdfRef]bool))
+}
+
+// pdfWidgetHidden checks that the widget has no appearance, zero size or
+// hidden flags.
+func pdfWidgetHidden(widget pdfDict) bool {
+	const hidden, noView = 1 << 1, 1 << 5
+	if _, ok := widget["AP"]; !ok {
+		return true
+	}
+	if f, ok := widget["F"].(int64); ok && f&(hidden|noView) != 0 {
+		return true
+	}
+	rect, _ := widget["Rect"].(pdfArray)
+	if len(rect) != 4 {
+		return false
+	}
+	var n [4]float64
+	for i, v := range rect {
+		switch x := v.(type) {
+		case int64:
+			n[i] = float64(x)
+		case float64:
+			n[i] = x
+		default:
+			return false
+		}
+	}
+	return n[0] == n[2] || n[1] == n[3]
+}
+
+// pdfDefinedBefore checks that the last definition of the object is
+// before the offset.
+func pdfDefinedBefore(doc *pdfDocument, ref pdfRef, offset int) bool {
+	entry, ok := doc.xref[ref.Num]
+	if ok && entry.stream > 0 {
+		entry, ok = doc.xref[entry.stream]
+	}
+	return ok && entry.stream == 0 && entry.offset < int64(offset)
+}
+
+// pdfRefsDefinedBefore checks that all objects referenced by the value
+// are defined before the offset.
+func pdfRefsDefinedBefore(doc *pdfDocument, v interface{}, offset int, visited map[pdfRef]bool) bool {
+	switch o := v.(type) {
+	case pdfRef:
+		if visited[o] {
+			return true
+		}
+		if len(visited) > 1024 || !pdfDefinedBefore(doc, o, offset) {
+			return false
+		}
+		visited[o] = true
+		obj, err := doc.object(o.Num)
+		return err == nil && pdfRefsDefinedBefore(doc, obj, offset, visited)
+	case pdfDict:
+		for _, x := range o {
+			if !pdfRefsDefinedBefore(doc, x, offset, visited) {
+				return false
+			}
+		}
+	case pdfArray:
+		for _, x := range o {
+			if !pdfRefsDefinedBefore(doc, x, offset, visited) {
+				return false
+			}
+		}
+	case *pdfStream:
+		return pdfRefsDefinedBefore(doc, o.Dict, offset, visited)
+	}
+	return true
+}
+
+// pdfEntriesKept checks that the dictionary keeps old entries. New entries
+// may be added, for example fonts of the default resources. Nested
+// dictionaries are compared the same way up to the depth.
+func pdfEntriesKept(rev, doc *pdfDocument, oldObj, newObj interface{}, depth int) bool {
+	old, cur := rev.resolveDict(oldObj), doc.resolveDict(newObj)
+	for key, v := range old {
+		w, ok := cur[key]
+		if !ok {
+			return false
+		}
+		if !pdfEqual(v, w) && (depth == 0 || !pdfEntriesKept(rev, doc, v, w, depth-1)) {
+			return false
+		}
+	}
+	return true
+}
+
+// pdfEqual compares serialized objects. Excluded keys of dictionaries are
+// ignored.
+func pdfEqual(a, b interface{}, exclude ...pdfName) bool {
+	if len(exclude) > 0 {
+		a, b = pdfDictWithout(a.(pdfDict), exclude), pdfDictWithout(b.(pdfDict), exclude)
+	}
+	var x, y bytes.Buffer
+	writePDFObject(&x, a)
+	writePDFObject(&y, b)
+	return bytes.Equal(x.Bytes(), y.Bytes())
+}
+
+// pdfDictWithout returns copy of the dictionary without the keys.
+func pdfDictWithout(d pdfDict, keys []pdfName) pdfDict {
+	c := copyPDFDict(d)
+	for _, k := range keys {
+		delete(c, k)
+	}
+	return c
+}
+
+// parsePDFDate parses PDF date string. Missing time parts and time zone
+// are allowed.
+func parsePDFDate(s string) (time.Time, error) {
+	s = strings.ReplaceAll(strings.TrimPrefix(s, "D:"), "'", "")
+	if i := strings.IndexByte(s, 'Z'); i >= 0 {
+		s = s[:i+1]
+	}
+	for _, layout := range []string{
+		"20060102150405Z0700", "20060102150405Z07", "20060102150405",
+		"200601021504", "2006010215", "20060102", "200601", "2006",
+	} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, ErrPDFInvalid
+}
+
+// firstPDFPage finds the first page of the document.
+func firstPDFPage(doc *pdfDocument, root pdfDict) (pdfRef, pdfDict, error) {
+	node := root["Pages"]
+	for depth := 0; depth < 32; depth++ {
+		ref, ok := node.(pdfRef)
+		if !ok {
+			return pdfRef{}, nil, ErrPDFInvalid
+		}
+		dict := doc.resolveDict(ref)
+		if dict == nil {
+			return pdfRef{}, nil, ErrPDFInvalid
+		}
+		if dict["Type"] == pdfName("Page") {
+			return ref, dict, nil
+		}
+		kids := doc.resolveArray(dict["Kids"])
+		if len(kids) == 0 {
+			return pdfRef{}, nil, ErrPDFNoPages
+		}
+		node = kids[0]
+	}
+	return pdfRef{}, nil, ErrPDFInvalid
+}
+
+// uniquePDFFieldName returns the first unused name SignatureN.
+func uniquePDFFieldName(doc *pdfDocument, fields pdfArray) string {
+	names := make(map[string]bool)
+	for _, f := range fields {
+		if name, ok := doc.resolveDict(f)["T"].(pdfString); ok {
+			names[decodePDFText(name)] = true
+		}
+	}
+	for i := 1; ; i++ {
+		name := "Signature" + strconv.Itoa(i)
+		if !names[name] {
+			return name
+		}
+	}
+}
+
+// copyPDFDict makes shallow copy of the dictionary.
+func copyPDFDict(d pdfDict) pdfDict {
+	if d == nil {
+		return nil
+	}
+	c := make(pdfDict, len(d))
+	for k, v := range d {
+		c[k] = v
+	}
+	return c
+}
+
+// formatPDFDate formats time as PDF date string.
+func formatPDFDate(t time.Time) string {
+	return "D:" + t.UTC().Format("20060102150405") + "+00'00'"
+}
+
+// pdfTextString encodes text string, non-ASCII text as UTF-16BE.
+func pdfTextString(s string) pdfString {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			out := []byte{0xfe, 0xff}
+			for _, c := range utf16.Encode([]rune(s)) {
+				out = append(out, byte(c>>8), byte(c))
+			}
+			return pdfString(out)
+		}
+	}
+	return pdfString(s)
+}
+
+// decodePDFText decodes text string. UTF-16BE is recognized by byte order
+// mark, otherwise the text is treated as Latin-1 superset.
+func decodePDFText(s pdfString) string {
+	if len(s) >= 2 && s[0] == 0xfe && s[1] == 0xff {
+		units := make([]uint16, 0, len(s)/2)
+		for i := 2; i+1 < len(s); i += 2 {
+			units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	runes := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		runes[i] = rune(s[i])
+	}
+	return string(runes)
+}
@@ -0,0 +1,588 @@
+package smartid
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreparePDFSignature(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	client := mock.client()
+	signingTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, xrefStream := range []bool{false, true} {
+		orig := testPDF(t, xrefStream)
+		sig, err := PreparePDFSignature(orig, mock.signCert, SHA256, &PDFSignatureOptions{
+			Reason:      "Lepingu allkirjastamine",
+			Location:    "Tallinn, Eesti",
+			SigningTime: signingTime,
+			Chain:       []*x509.Certificate{mock.ca},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig.FieldName() != "Signature1" {
+			t.Error("expected", "Signature1", "got", sig.FieldName())
+		}
+		if _, err := sig.Bytes(); err != ErrCMSNotSigned {
+			t.Error("expected", ErrCMSNotSigned, "got", err)
+		}
+		_, err = sig.Sign(ctx, client, &AuthRequest{
+			Identifier: mockDocumentNumber,
+			AuthType:   AuthTypeDocument,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tsa := newTestTSA(t, mock.ca, mock.caKey)
+		if err := sig.AddTimestamp(ctx, tsa); err != nil {
+			t.Fatal(err)
+		}
+		data, err := sig.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, orig) {
+			t.Error("expected incremental update")
+		}
+
+		doc, err := parsePDF(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		acroForm := doc.resolveDict(doc.resolveDict(doc.trailer["Root"])["AcroForm"])
+		if acroForm["SigFlags"] != int64(3) {
+			t.Error("expected", 3, "got", acroForm["SigFlags"])
+		}
+		fields := doc.resolveArray(acroForm["Fields"])
+		if len(fields) != 1 {
+			t.Fatal("expected", 1, "got", len(fields))
+		}
+		field := doc.resolveDict(fields[0])
+		if field["T"] != pdfString("Signature1") || field["P"] != (pdfRef{Num: 3}) {
+			t.Error("expected", "Signature1", "got", field["T"], field["P"])
+		}
+		annots := doc.resolveArray(doc.resolveDict(pdfRef{Num: 3})["Annots"])
+		if len(annots) != 1 || annots[0] != fields[0] {
+			t.Error("expected widget in page annotations, got", annots)
+		}
+
+		v := doc.resolveDict(field["V"])
+		if v["SubFilter"] != pdfName("ETSI.CAdES.detached") {
+			t.Error("expected", "ETSI.CAdES.detached", "got", v["SubFilter"])
+		}
+		if v["M"] != pdfString("D:20240102030405+00'00'") {
+			t.Error("expected", "D:20240102030405+00'00'", "got", v["M"])
+		}
+		if decodePDFText(v["Location"].(pdfString)) != "Tallinn, Eesti" {
+			t.Error("expected", "Tallinn, Eesti", "got", v["Location"])
+		}
+		br := v["ByteRange"].(pdfArray)
+		start, end, length := br[1].(int64), br[2].(int64), br[3].(int64)
+		if br[0] != int64(0) || end+length != int64(len(data)) {
+			t.Fatal("expected ByteRange to cover the file, got", br)
+		}
+		if data[start] != '<' || data[end-1] != '>' {
+			t.Error("expected ByteRange to exclude only Contents")
+		}
+
+		var raw asn1.RawValue
+		if _, err := asn1.Unmarshal([]byte(v["Contents"].(pdfString)), &raw); err != nil {
+			t.Fatal(err)
+		}
+		sd, err := parseSignedData(raw.FullBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed := append(append([]byte(nil), data[:start]...), data[end:]...)
+		cert, si, err := sd.verify(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cert.Equal(mock.signCert) {
+			t.Error("expected signer certificate")
+		}
+		if _, err := si.signedAttribute(oidAttrSigningTime, new(time.Time)); err != ErrCMSAttributeMissing {
+			t.Error("expected", ErrCMSAttributeMissing, "got", err)
+		}
+		if _, err := si.signedAttribute(oidAttrSigningCertificateV2, new(signingCertificateV2)); err != nil {
+			t.Error("expected", nil, "got", err)
+		}
+		var token asn1.RawValue
+		if _, err := si.unsignedAttribute(oidAttrTimeStampToken, &token); err != nil {
+			t.Error("expected", nil, "got", err)
+		}
+		certs, _ := sd.certificates()
+		if len(certs) != 2 {
+			t.Error("expected", 2, "got", len(certs))
+		}
+
+		// Second signature gets another field name.
+		sig2, err := PreparePDFSignature(data, mock.signCert, SHA256, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig2.FieldName() != "Signature2" {
+			t.Error("expected", "Signature2", "got", sig2.FieldName())
+		}
+	}
+}
+
+func TestPDFSignature_Bytes_tooLarge(t *testing.T) {
+	mock := newMockSmartID(t)
+	sig, err := PreparePDFSignature(testPDF(t, false), mock.signCert, SHA256, &PDFSignatureOptions{
+		ContentsSize: 64,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sig.Sign(context.Background(), mock.client(), &AuthRequest{
+		Identifier: mockDocumentNumber,
+		AuthType:   AuthTypeDocument,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sig.Bytes(); err != ErrPDFSignatureTooLarge {
+		t.Error("expected", ErrPDFSignatureTooLarge, "got", err)
+	}
+}
+
+// signedTestPDF signs the PDF with the mock service and the test TSA.
+func signedTestPDF(t *testing.T, mock *mockSmartID, pdf []byte) []byte {
+	t.Helper()
+	sig, err := PreparePDFSignature(pdf, mock.signCert, SHA256, &PDFSignatureOptions{
+		Chain: []*x509.Certificate{mock.ca},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sig.Sign(context.Background(), mock.client(), &AuthRequest{
+		Identifier: mockDocumentNumber,
+		AuthType:   AuthTypeDocument,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sig.AddTimestamp(context.Background(), newTestTSA(t, mock.ca, mock.caKey)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := sig.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// updateTestPDF appends incremental update made by the function.
+func updateTestPDF(t *testing.T, pdf []byte, update func(doc *pdfDocument, u *pdfUpdate)) []byte {
+	t.Helper()
+	doc, err := parsePDF(pdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := newPDFUpdate(doc)
+	update(doc, u)
+	data, _ := u.write()
+	return data
+}
+
+func TestValidatePDF(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+
+	data := signedTestPDF(t, mock, signedTestPDF(t, mock, testPDF(t, true)))
+	results, err := ValidatePDF(ctx, data, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatal("expected", 2, "got", len(results))
+	}
+	for i, r := range results {
+		if id := "Signature" + string(rune('1'+i)); r.ID != id {
+			t.Error("expected", id, "got", r.ID)
+		}
+		if !r.IsValid() {
+			t.Error(r.ID, "expected valid signature, got", r.Err())
+		}
+		if r.Identity == nil || r.Identity.SerialNumber != "PNOEE-30303039914" {
+			t.Error("expected", "PNOEE-30303039914", "got", r.Identity)
+		}
+		if len(r.Timestamps) != 1 || r.TrustedTime.IsZero() || len(r.Chain) != 2 {
+			t.Error("expected timestamp and chain, got", r.Timestamps, r.Chain)
+		}
+		if r.SigningTime.IsZero() {
+			t.Error("expected signing time")
+		}
+		if len(r.Warnings) == 0 || r.Warnings[len(r.Warnings)-1] != ErrRevocationMissing {
+			t.Error("expected", ErrRevocationMissing, "got", r.Warnings)
+		}
+	}
+
+	results, _ = ValidatePDF(ctx, data, NewTrustStore())
+	if results[0].IsValid() {
+		t.Error("expected untrusted signer error")
+	}
+}
+
+func TestValidatePDF_dss(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+
+	resp := newTestOCSPResponse(t, mock.signCert, mock.ca, mock.ca, mock.caKey, OCSPStatusGood, time.Now())
+	data := updateTestPDF(t, signedTestPDF(t, mock, testPDF(t, false)), func(doc *pdfDocument, u *pdfUpdate) {
+		rootRef := doc.trailer["Root"].(pdfRef)
+		root := copyPDFDict(doc.resolveDict(rootRef))
+		root["DSS"] = pdfDict{
+			"OCSPs": pdfArray{u.add(&pdfStream{Dict: pdfDict{}, Data: resp})},
+		}
+		u.set(rootRef, root)
+	})
+	results, err := ValidatePDF(ctx, data, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results[0].IsValid() || results[0].OCSP == nil {
+		t.Error("expected valid signature with OCSP, got", results[0].Err())
+	}
+}
+
+func TestValidatePDF_modified(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+	signed := signedTestPDF(t, mock, testPDF(t, false))
+
+	testdata := []struct {
+		name   string
+		update func(doc *pdfDocument, u *pdfUpdate)
+	}{
+		{"page contents", func(doc *pdfDocument, u *pdfUpdate) {
+			page := copyPDFDict(doc.resolveDict(pdfRef{Num: 3}))
+			page["Contents"] = u.add(&pdfStream{Dict: pdfDict{}, Data: []byte("BT (Forged) Tj ET")})
+			u.set(pdfRef{Num: 3}, page)
+		}},
+		{"annotation", func(doc *pdfDocument, u *pdfUpdate) {
+			page := copyPDFDict(doc.resolveDict(pdfRef{Num: 3}))
+			annots := append(pdfArray{}, doc.resolveArray(page["Annots"])...)
+			page["Annots"] = append(annots, u.add(pdfDict{
+				"Type": pdfName("Annot"), "Subtype": pdfName("FreeText"),
+			}))
+			u.set(pdfRef{Num: 3}, page)
+		}},
+		{"content stream", func(doc *pdfDocument, u *pdfUpdate) {
+			u.set(pdfRef{Num: 4}, &pdfStream{Dict: pdfDict{}, Data: []byte("BT (Forged) Tj ET")})
+		}},
+	}
+	for _, test := range testdata {
+		results, err := ValidatePDF(ctx, updateTestPDF(t, signed, test.update), ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !errors.Is(results[0].Err(), ErrPDFModifiedAfterSigning) {
+			t.Error(test.name, "expected", ErrPDFModifiedAfterSigning, "got", results[0].Err())
+		}
+	}
+
+	// Info dictionary may be updated.
+	data := updateTestPDF(t, signed, func(doc *pdfDocument, u *pdfUpdate) {
+		u.set(pdfRef{Num: 5}, pdfDict{"Title": pdfString("Signed")})
+	})
+	if results, _ := ValidatePDF(ctx, data, ts); !results[0].IsValid() {
+		t.Error("expected", nil, "got", results[0].Err())
+	}
+
+	tampered := bytes.Replace(signed, []byte("Hello, PDF!"), []byte("Hello, Bob!"), 1)
+	results, _ := ValidatePDF(ctx, tampered, ts)
+	if !errors.Is(results[0].Err(), ErrCMSMessageDigestMismatch) {
+		t.Error("expected", ErrCMSMessageDigestMismatch, "got", results[0].Err())
+	}
+
+	doc, _ := parsePDF(signed)
+	br := pdfSignatureFields(doc, doc.resolveDict(doc.trailer["Root"]))[0].sig["ByteRange"].(pdfArray)
+	shifted := bytes.Replace(signed,
+		[]byte(fmt.Sprintf("/ByteRange [0 %d ", br[1])),
+		[]byte(fmt.Sprintf("/ByteRange [0 %d ", br[1].(int64)-1)), 1)
+	results, _ = ValidatePDF(ctx, shifted, ts)
+	if !errors.Is(results[0].Err(), ErrPDFByteRangeInvalid) {
+		t.Error("expected", ErrPDFByteRangeInvalid, "got", results[0].Err())
+	}
+}
+
+// overlayTestPDF adds signature field with visible appearance over the
+// first page. The field value is the signature object sig, if not zero.
+func overlayTestPDF(t *testing.T, pdf []byte, sig pdfRef) []byte {
+	t.Helper()
+	return updateTestPDF(t, pdf, func(doc *pdfDocument, u *pdfUpdate) {
+		ap := u.add(&pdfStream{
+			Dict: pdfDict{"Type": pdfName("XObject"), "Subtype": pdfName("Form"),
+				"BBox": pdfArray{int64(0), int64(0), int64(595), int64(842)}},
+			Data: []byte("BT /F1 24 Tf 72 720 Td (Forged) Tj ET"),
+		})
+		widget := pdfDict{
+			"Type": pdfName("Annot"), "Subtype": pdfName("Widget"),
+			"FT": pdfName("Sig"), "T": pdfString("Overlay"),
+			"Rect": pdfArray{int64(0), int64(0), int64(595), int64(842)},
+			"AP":   pdfDict{"N": ap},
+		}
+		if sig.Num != 0 {
+			widget["V"] = sig
+		}
+		widgetRef := u.add(widget)
+		page := copyPDFDict(doc.resolveDict(pdfRef{Num: 3}))
+		page["Annots"] = append(append(pdfArray{}, doc.resolveArray(page["Annots"])...), widgetRef)
+		u.set(pdfRef{Num: 3}, page)
+	})
+}
+
+func TestValidatePDF_appearance(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+	signed := signedTestPDF(t, mock, testPDF(t, false))
+
+	results, err := ValidatePDF(ctx, overlayTestPDF(t, signed, pdfRef{}), ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(results[0].Err(), ErrPDFModifiedAfterSigning) {
+		t.Error("expected", ErrPDFModifiedAfterSigning, "got", results[0].Err())
+	}
+
+	// Overlay is signed by the next signature, which takes the next
+	// object number for its signature dictionary.
+	doc, err := parsePDF(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := newPDFUpdate(doc).nextNum
+	data := signedTestPDF(t, mock, overlayTestPDF(t, signed, pdfRef{Num: next + 2}))
+	results, err = ValidatePDF(ctx, data, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if !r.IsValid() {
+			t.Error(r.ID, "expected valid signature, got", r.Err())
+		}
+	}
+
+	// Overlay is not covered, if its appearance is changed after the
+	// next signature.
+	data = updateTestPDF(t, data, func(doc *pdfDocument, u *pdfUpdate) {
+		u.set(pdfRef{Num: next}, &pdfStream{Dict: pdfDict{}, Data: []byte("BT (Forged again) Tj ET")})
+	})
+	results, _ = ValidatePDF(ctx, data, ts)
+	if !errors.Is(results[0].Err(), ErrPDFModifiedAfterSigning) {
+		t.Error("expected", ErrPDFModifiedAfterSigning, "got", results[0].Err())
+	}
+}
+
+func TestValidatePDF_resources(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+	font := func(name string) pdfDict {
+		return pdfDict{"Type": pdfName("Font"), "Subtype": pdfName("Type1"), "BaseFont": pdfName(name)}
+	}
+	unsigned := updateTestPDF(t, testPDF(t, false), func(doc *pdfDocument, u *pdfUpdate) {
+		root := copyPDFDict(doc.resolveDict(pdfRef{Num: 1}))
+		root["AcroForm"] = pdfDict{
+			"Fields": pdfArray{},
+			"DR":     pdfDict{"Font": pdfDict{"Helv": u.add(font("Helvetica"))}},
+		}
+		u.set(pdfRef{Num: 1}, root)
+	})
+	signed := signedTestPDF(t, mock, unsigned)
+
+	testdata := []struct {
+		name  string
+		valid bool
+		dr    func(u *pdfUpdate, fonts pdfDict)
+		ext   pdfDict
+	}{
+		{"font added", true, func(u *pdfUpdate, fonts pdfDict) {
+			fonts["ZaDb"] = u.add(font("ZapfDingbats"))
+		}, nil},
+		{"font replaced", false, func(u *pdfUpdate, fonts pdfDict) {
+			fonts["Helv"] = u.add(font("Courier"))
+		}, nil},
+		{"font removed", false, func(u *pdfUpdate, fonts pdfDict) {
+			delete(fonts, "Helv")
+		}, nil},
+		{"extension added", true, nil, pdfDict{"ADBE": pdfDict{"ExtensionLevel": int64(3)}}},
+		{"extension changed", false, nil, pdfDict{"ESIC": pdfDict{"ExtensionLevel": int64(5)}}},
+	}
+	for _, test := range testdata {
+		data := updateTestPDF(t, signed, func(doc *pdfDocument, u *pdfUpdate) {
+			root := copyPDFDict(doc.resolveDict(pdfRef{Num: 1}))
+			if test.dr != nil {
+				acroForm := copyPDFDict(doc.resolveDict(root["AcroForm"]))
+				dr := copyPDFDict(doc.resolveDict(acroForm["DR"]))
+				fonts := copyPDFDict(doc.resolveDict(dr["Font"]))
+				test.dr(u, fonts)
+				dr["Font"] = fonts
+				acroForm["DR"] = dr
+				root["AcroForm"] = acroForm
+			}
+			if test.ext != nil {
+				ext := copyPDFDict(doc.resolveDict(root["Extensions"]))
+				for k, v := range test.ext {
+					ext[k] = v
+				}
+				root["Extensions"] = ext
+			}
+			u.set(pdfRef{Num: 1}, root)
+		})
+		results, err := ValidatePDF(ctx, data, ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.valid && !results[0].IsValid() {
+			t.Error(test.name, "expected", nil, "got", results[0].Err())
+		}
+		if !test.valid && !errors.Is(results[0].Err(), ErrPDFModifiedAfterSigning) {
+			t.Error(test.name, "expected", ErrPDFModifiedAfterSigning, "got", results[0].Err())
+		}
+	}
+}
+
+func TestParsePDFDate(t *testing.T) {
+	testdata := []struct {
+		in  string
+		out time.Time
+	}{
+		{"D:20240102030405+00'00'", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"D:20240102030405+02'00'", time.Date(2024, 1, 2, 1, 4, 5, 0, time.UTC)},
+		{"D:20240102030405Z00'00'", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"D:20240102", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range testdata {
+		if out, err := parsePDFDate(test.in); err != nil || !out.Equal(test.out) {
+			t.Error(test.in, "expected", test.out, "got", out, err)
+		}
+	}
+}
+
+// xrefStreamTestPDF makes PDF of the cross-reference stream only, with the
+// dictionary entries and the rows as the stream data.
+func xrefStreamTestPDF(dict string, rows []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n")
+	offset := buf.Len()
+	fmt.Fprintf(&buf, "1 0 obj\n<< /Type /XRef /Size 4 /Root 2 0 R %s /Length %d >>\nstream\n",
+		dict, len(rows))
+	buf.Write(rows)
+	fmt.Fprintf(&buf, "\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", offset)
+	return buf.Bytes()
+}
+
+func flateTestData(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestValidatePDF_malformed(t *testing.T) {
+	ctx := context.Background()
+	// Object 2 is compressed in the object stream 2 or 3.
+	testdata := []struct {
+		name string
+		pdf  []byte
+	}{
+		{
+			"self-contained object stream",
+			xrefStreamTestPDF("/W [1 1 1] /Index [1 2]", []byte{1, 9, 0, 2, 2, 0}),
+		},
+		{
+			"object streams contain each other",
+			xrefStreamTestPDF("/W [1 1 1] /Index [1 3]", []byte{1, 9, 0, 2, 3, 0, 2, 2, 0}),
+		},
+	}
+	for _, test := range testdata {
+		doc, err := parsePDF(test.pdf)
+		if err != nil {
+			t.Fatal(test.name, err)
+		}
+		if _, err := doc.resolve(doc.trailer["Root"]); err != ErrPDFInvalid {
+			t.Error(test.name, "expected", ErrPDFInvalid, "got", err)
+		}
+		if results, _ := ValidatePDF(ctx, test.pdf, NewTrustStore()); len(results) != 0 {
+			t.Error(test.name, "expected no signatures, got", len(results))
+		}
+	}
+
+	rows := flateTestData([]byte{0, 1, 9, 0})
+	invalid := []struct {
+		name string
+		pdf  []byte
+	}{
+		{"zero widths", xrefStreamTestPDF("/W [0 0 0] /Index [0 1000000000]", nil)},
+		{"count over data", xrefStreamTestPDF("/W [1 1 1] /Index [1 1000000000]", []byte{1, 9, 0})},
+		{"negative count", xrefStreamTestPDF("/W [1 1 1] /Index [1 -1]", []byte{1, 9, 0})},
+		{"nested arrays", xrefStreamTestPDF("/W [1 1 1] /Index [1 1] /X "+
+			strings.Repeat("[", 1000)+strings.Repeat("]", 1000), []byte{1, 9, 0})},
+	}
+	for _, params := range []string{
+		"/Columns -1",
+		"/Columns 0",
+		"/Columns 4611686018427387904",
+		"/Columns 3 /Colors 0",
+		"/Columns 3 /Colors 5",
+		"/Columns 3 /BitsPerComponent 3",
+		"/Columns 3 /BitsPerComponent -8",
+	} {
+		invalid = append(invalid, struct {
+			name string
+			pdf  []byte
+		}{params, xrefStreamTestPDF(
+			"/W [1 1 1] /Index [1 1] /Filter /FlateDecode /DecodeParms << /Predictor 12 "+params+" >>",
+			rows,
+		)})
+	}
+	for _, test := range invalid {
+		if _, err := parsePDF(test.pdf); err != ErrPDFInvalid {
+			t.Error(test.name, "expected", ErrPDFInvalid, "got", err)
+		}
+		if _, err := ValidatePDF(ctx, test.pdf, NewTrustStore()); err != ErrPDFInvalid {
+			t.Error(test.name, "expected", ErrPDFInvalid, "got", err)
+		}
+	}
+
+	// Valid predictor parameters of the same rows.
+	valid := xrefStreamTestPDF("/W [1 1 1] /Index [1 1] /Filter /FlateDecode "+
+		"/DecodeParms << /Predictor 12 /Columns 3 >>", rows)
+	if doc, err := parsePDF(valid); err != nil || doc.xref[1].offset != 9 {
+		t.Error("expected object 1 at", 9, "got", err)
+	}
+}
+
+func FuzzValidatePDF(f *testing.F) {
+	f.Add(testPDF(&testing.T{}, false))
+	f.Add(testPDF(&testing.T{}, true))
+	f.Add(xrefStreamTestPDF("/W [1 1 1] /Index [1 2]", []byte{1, 9, 0, 2, 2, 0}))
+	f.Add(xrefStreamTestPDF("/W [1 1 1] /Index [1 1] /Filter /FlateDecode "+
+		"/DecodeParms << /Predictor 12 /Columns 3 >>", flateTestData([]byte{0, 1, 9, 0})))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ValidatePDF(context.Background(), data, NewTrustStore())
+	})
+}
@@ -0,0 +1,1031 @@
+package smartid
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// PDF object types. Integers are int64, reals float64, booleans bool and
+// null is nil.
+type (
+	pdfName   string
+	pdfString string
+	pdfArray  []interface{}
+	pdfDict   map[pdfName]interface{}
+	pdfRef    struct{ Num, Gen int }
+
+	// pdfStream is the stream object with raw (encoded) data.
+	pdfStream struct {
+		Dict pdfDict
+		Data []byte
+	}
+
+	// pdfKeyword is the bare keyword or delimiter token.
+	pdfKeyword string
+
+	// pdfRaw is written to the output as is.
+	pdfRaw string
+)
+
+var (
+	// ErrPDFInvalid error when PDF structure cannot be parsed.
+	ErrPDFInvalid = errors.New("Invalid PDF")
+
+	// ErrPDFEncrypted error when PDF is encrypted.
+	ErrPDFEncrypted = errors.New("Encrypted PDF is not supported")
+
+	// ErrPDFUnsupportedFilter error when stream filter is not supported.
+	ErrPDFUnsupportedFilter = errors.New("Unsupported PDF stream filter")
+)
+
+// pdfXrefEntry is the location of the object in the file.
+type pdfXrefEntry struct {
+	offset int64
+	gen    int
+
+	// stream is the number of the object stream, if compressed.
+	stream int
+	index  int
+}
+
+// pdfDocument is the parsed PDF file. Objects are loaded lazily.
+type pdfDocument struct {
+	data    []byte
+	xref    map[int]pdfXrefEntry
+	trailer pdfDict
+
+	// startxref is the offset of the last cross-reference section.
+	startxref int64
+
+	// xrefStream is true, if the last cross-reference section is stream.
+	xrefStream bool
+
+	objects    map[int]interface{}
+	objStreams map[int]*pdfObjStream
+
+	// loading are the objects being read, an object which refers to
+	// itself while it is read is invalid.
+	loading map[int]bool
+}
+
+// pdfObjStream is decoded object stream.
+type pdfObjStream struct {
+	data    []byte
+	offsets []int64
+}
+
+// parsePDF parses cross-reference sections and trailer of the file.
+func parsePDF(data []byte) (*pdfDocument, error) {
+	d := &pdfDocument{
+		data:       data,
+		xref:       make(map[int]pdfXrefEntry),
+		objects:    make(map[int]interface{}),
+		objStreams: make(map[int]*pdfObjStream),
+		loading:    make(map[int]bool),
+	}
+	start, err := findStartxref(data)
+	if err != nil {
+		return nil, err
+	}
+	d.startxref = start
+
+	visited := make(map[int64]bool)
+	for offset := start; offset >= 0 && !visited[offset]; {
+		visited[offset] = true
+		trailer, isStream, err := d.readXref(offset)
+		if err != nil {
+			return nil, err
+		}
+		if d.trailer == nil {
+			d.trailer, d.xrefStream = trailer, isStream
+		}
+		if stm, ok := trailer["XRefStm"].(int64); ok && !visited[stm] {
+			visited[stm] = true
+			if _, _, err := d.readXref(stm); err != nil {
+				return nil, err
+			}
+		}
+		prev, ok := trailer["Prev"].(int64)
+		if !ok {
+			break
+		}
+		offset = prev
+	}
+	if _, ok := d.trailer["Encrypt"]; ok {
+		return nil, ErrPDFEncrypted
+	}
+	if _, ok := d.trailer["Root"].(pdfRef); !ok {
+		return nil, ErrPDFInvalid
+	}
+	return d, nil
+}
+
+// findStartxref finds the offset of the last cross-reference section.
+func findStartxref(data []byte) (int64, error) {
+	tail := data
+	if len(tail) > 2048 {
+		tail = tail[len(tail)-2048:]
+	}
+	i := bytes.LastIndex(tail, []byte("startxref"))
+	if i < 0 {
+		return 0, ErrPDFInvalid
+	}
+	l := &pdfLexer{data: tail, pos: i + len("startxref")}
+	tok, err := l.token()
+	if err != nil {
+		return 0, err
+	}
+	offset, ok := tok.(int64)
+	if !ok || offset < 0 || offset >= int64(len(data)) {
+		return 0, ErrPDFInvalid
+	}
+	return offset, nil
+}
+
+// readXref reads cross-reference table or stream at the offset. Entries
+// which are already known from newer sections are kept.
+func (d *pdfDocument) readXref(offset int64) (pdfDict, bool, error) {
+	if offset < 0 || offset >= int64(len(d.data)) {
+		return nil, false, ErrPDFInvalid
+	}
+	l := &pdfLexer{data: d.data, pos: int(offset)}
+	l.skipSpace()
+	if !bytes.HasPrefix(d.data[l.pos:], []byte("xref")) {
+		trailer, err := d.readXrefStream(offset)
+		return trailer, true, err
+	}
+	l.pos += len("xref")
+	for {
+		tok, err := l.token()
+		if err != nil {
+			return nil, false, err
+		}
+		if tok == pdfKeyword("trailer") {
+			break
+		}
+		start, ok1 := tok.(int64)
+		count, ok2 := l.mustToken().(int64)
+		if !ok1 || !ok2 {
+			return nil, false, ErrPDFInvalid
+		}
+		for i := int64(0); i < count; i++ {
+			off, ok1 := l.mustToken().(int64)
+			gen, ok2 := l.mustToken().(int64)
+			kind := l.mustToken()
+			if !ok1 || !ok2 {
+				return nil, false, ErrPDFInvalid
+			}
+			num := int(start + i)
+			if _, ok := d.xref[num]; ok || kind != pdfKeyword("n") {
+				continue
+			}
+			d.xref[num] = pdfXrefEntry{offset: off, gen: int(gen)}
+		}
+	}
+	obj, err := l.object()
+	if err != nil {
+		return nil, false, err
+	}
+	trailer, ok := obj.(pdfDict)
+	if !ok {
+		return nil, false, ErrPDFInvalid
+	}
+	return trailer, false, nil
+}
+
+// readXrefStream reads cross-reference stream at the offset.
+func (d *pdfDocument) readXrefStream(offset int64) (pdfDict, error) {
+	_, obj, err := d.readObjectAt(offset)
+	if err != nil {
+		return nil, err
+	}
+	stream, ok := obj.(*pdfStream)
+	if !ok || stream.Dict["Type"] != pdfName("XRef") {
+		return nil, ErrPDFInvalid
+	}
+	data, err := d.decodeStream(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	w, _ := stream.Dict["W"].(pdfArray)
+	if len(w) != 3 {
+		return nil, ErrPDFInvalid
+	}
+	widths := make([]int, 3)
+	rowLen := 0
+	for i, v := range w {
+		n, ok := v.(int64)
+		if !ok || n < 0 || n > 8 {
+			return nil, ErrPDFInvalid
+		}
+		widths[i] = int(n)
+		rowLen += int(n)
+	}
+	if rowLen == 0 {
+		return nil, ErrPDFInvalid
+	}
+	index, _ := stream.Dict["Index"].(pdfArray)
+	if index == nil {
+		size, _ := stream.Dict["Size"].(int64)
+		index = pdfArray{int64(0), size}
+	}
+
+	pos := 0
+	for i := 0; i+1 < len(index); i += 2 {
+		start, ok1 := index[i].(int64)
+		count, ok2 := index[i+1].(int64)
+		if !ok1 || !ok2 || start < 0 || count < 0 || count > int64((len(data)-pos)/rowLen) {
+			return nil, ErrPDFInvalid
+		}
+		for j := int64(0); j < count; j++ {
+			var fields [3]int64
+			for k, width := range widths {
+				for _, b := range data[pos : pos+width] {
+					fields[k] = fields[k]<<8 | int64(b)
+				}
+				pos += width
+			}
+			if widths[0] == 0 {
+				fields[0] = 1
+			}
+			num := int(start + j)
+			if _, ok := d.xref[num]; ok {
+				continue
+			}
+			switch fields[0] {
+			case 1:
+				d.xref[num] = pdfXrefEntry{offset: fields[1], gen: int(fields[2])}
+			case 2:
+				d.xref[num] = pdfXrefEntry{stream: int(fields[1]), index: int(fields[2])}
+			}
+		}
+	}
+	return stream.Dict, nil
+}
+
+// object returns the object by number, nil if it does not exist.
+func (d *pdfDocument) object(num int) (interface{}, error) {
+	if obj, ok := d.objects[num]; ok {
+		return obj, nil
+	}
+	entry, ok := d.xref[num]
+	if !ok {
+		return nil, nil
+	}
+	if d.loading[num] {
+		return nil, ErrPDFInvalid
+	}
+	d.loading[num] = true
+	defer delete(d.loading, num)
+	var obj interface{}
+	var err error
+	if entry.stream > 0 {
+		obj, err = d.readCompressedObject(entry.stream, entry.index)
+	} else {
+		_, obj, err = d.readObjectAt(entry.offset)
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.objects[num] = obj
+	return obj, nil
+}
+
+// resolve returns the referenced object, other values are returned as
+// is.
+func (d *pdfDocument) resolve(v interface{}) (interface{}, error) {
+	for depth := 0; depth < 32; depth++ {
+		ref, ok := v.(pdfRef)
+		if !ok {
+			return v, nil
+		}
+		var err error
+		if v, err = d.object(ref.Num); err != nil {
+			return nil, err
+		}
+	}
+	return nil, ErrPDFInvalid
+}
+
+// resolveDict resolves value as dictionary, nil if it is not.
+func (d *pdfDocument) resolveDict(v interface{}) pdfDict {
+	obj, err := d.resolve(v)
+	if err != nil {
+		return nil
+	}
+	switch o := obj.(type) {
+	case pdfDict:
+		return o
+	case *pdfStream:
+		return o.Dict
+	}
+	return nil
+}
+
+// resolveArray resolves value as array, nil if it is not.
+func (d *pdfDocument) resolveArray(v interface{}) pdfArray {
+	obj, err := d.resolve(v)
+	if err != nil {
+		return nil
+	}
+	a, _ := obj.(pdfArray)
+	return a
+}
+
+// readObjectAt reads indirect object at the offset.
+func (d *pdfDocument) readObjectAt(offset int64) (pdfRef, interface{}, error) {
+	if offset < 0 || offset >= int64(len(d.data)) {
+		return pdfRef{}, nil, ErrPDFInvalid
+	}
+	l := &pdfLexer{data: d.data, pos: int(offset)}
+	num, ok1 := l.mustToken().(int64)
+	gen, ok2 := l.mustToken().(int64)
+	if !ok1 || !ok2 || l.mustToken() != pdfKeyword("obj") {
+		return pdfRef{}, nil, ErrPDFInvalid
+	}
+	ref := pdfRef{Num: int(num), Gen: int(gen)}
+	obj, err := l.object()
+	if err != nil {
+		return ref, nil, err
+	}
+	dict, ok := obj.(pdfDict)
+	if !ok {
+		return ref, obj, nil
+	}
+	save := l.pos
+	if l.mustToken() != pdfKeyword("stream") {
+		l.pos = save
+		return ref, obj, nil
+	}
+	if l.pos < len(d.data) && d.data[l.pos] == '\r' {
+		l.pos++
+	}
+	if l.pos < len(d.data) && d.data[l.pos] == '\n' {
+		l.pos++
+	}
+	start := l.pos
+
+	length := int64(-1)
+	if v, err := d.resolve(dict["Length"]); err == nil {
+		if n, ok := v.(int64); ok {
+			length = n
+		}
+	}
+	end := start + int(length)
+	if length < 0 || end > len(d.data) ||
+		!bytes.HasPrefix(bytes.TrimLeft(d.data[end:], "\r\n \t"), []byte("endstream")) {
+		i := bytes.Index(d.data[start:], []byte("endstream"))
+		if i < 0 {
+			return ref, nil, ErrPDFInvalid
+		}
+		end = start + i
+		for end > start && (d.data[end-1] == '\n' || d.data[end-1] == '\r') {
+			end--
+		}
+	}
+	return ref, &pdfStream{Dict: dict, Data: d.data[start:end]}, nil
+}
+
+// readCompressedObject reads object from the object stream.
+func (d *pdfDocument) readCompressedObject(streamNum, index int) (interface{}, error) {
+	os, ok := d.objStreams[streamNum]
+	if !ok {
+		obj, err := d.object(streamNum)
+		if err != nil {
+			return nil, err
+		}
+		stream, ok := obj.(*pdfStream)
+		if !ok {
+			return nil, ErrPDFInvalid
+		}
+		data, err := d.decodeStream(stream)
+		if err != nil {
+			return nil, err
+		}
+		n, _ := stream.Dict["N"].(int64)
+		first, _ := stream.Dict["First"].(int64)
+		os = &pdfObjStream{data: data}
+		l := &pdfLexer{data: data}
+		for i := int64(0); i < n; i++ {
+			l.mustToken()
+			off, ok := l.mustToken().(int64)
+			if !ok {
+				return nil, ErrPDFInvalid
+			}
+			os.offsets = append(os.offsets, first+off)
+		}
+		d.objStreams[streamNum] = os
+	}
+	if index < 0 || index >= len(os.offsets) || os.offsets[index] >= int64(len(os.data)) {
+		return nil, ErrPDFInvalid
+	}
+	l := &pdfLexer{data: os.data, pos: int(os.offsets[index])}
+	return l.object()
+}
+
+// decodeStream decodes stream data. Only FlateDecode filter with PNG
+// predictors is supported.
+func (d *pdfDocument) decodeStream(s *pdfStream) ([]byte, error) {
+	var filters pdfArray
+	switch f := s.Dict["Filter"].(type) {
+	case nil:
+		return s.Data, nil
+	case pdfName:
+		filters = pdfArray{f}
+	case pdfArray:
+		filters = f
+	}
+	var params pdfArray
+	switch p := s.Dict["DecodeParms"].(type) {
+	case pdfDict:
+		params = pdfArray{p}
+	case pdfArray:
+		params = p
+	}
+
+	data := s.Data
+	for i, f := range filters {
+		if f != pdfName("FlateDecode") {
+			return nil, ErrPDFUnsupportedFilter
+		}
+		r, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = io.ReadAll(r); err != nil && len(data) == 0 {
+			return nil, err
+		}
+		if i < len(params) {
+			if p, ok := params[i].(pdfDict); ok {
+				if data, err = pngUnpredict(data, p); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return data, nil
+}
+
+// pngUnpredict reverses PNG predictors of the decoded data.
+func pngUnpredict(data []byte, params pdfDict) ([]byte, error) {
+	predictor, _ := params["Predictor"].(int64)
+	if predictor < 10 {
+		return data, nil
+	}
+	columns, ok := params["Columns"].(int64)
+	if !ok {
+		columns = 1
+	}
+	colors, ok := params["Colors"].(int64)
+	if !ok {
+		colors = 1
+	}
+	bpc, ok := params["BitsPerComponent"].(int64)
+	if !ok {
+		bpc = 8
+	}
+	switch {
+	case columns <= 0 || columns > int64(len(data))*8,
+		colors < 1 || colors > 4,
+		bpc != 1 && bpc != 2 && bpc != 4 && bpc != 8 && bpc != 16:
+		return nil, ErrPDFInvalid
+	}
+	bpp := int((colors*bpc + 7) / 8)
+	rowLen := int((columns*colors*bpc + 7) / 8)
+
+	var out []byte
+	prev := make([]byte, rowLen)
+	for pos := 0; pos+rowLen+1 <= len(data); pos += rowLen + 1 {
+		filter := data[pos]
+		row := append([]byte(nil), data[pos+1:pos+1+rowLen]...)
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = row[i-bpp], prev[i-bpp]
+			}
+			up := prev[i]
+			switch filter {
+			case 0:
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			default:
+				return nil, ErrPDFInvalid
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := absInt(p-int(a)), absInt(p-int(b)), absInt(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	default:
+		return c
+	}
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// maxPDFNesting limits nesting of arrays and dictionaries.
+const maxPDFNesting = 256
+
+// pdfLexer tokenizes PDF syntax.
+type pdfLexer struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+func isPDFSpace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isPDFDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+// skipSpace skips white-space and comments.
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		if !isPDFSpace(c) {
+			return
+		}
+		l.pos++
+	}
+}
+
+// mustToken returns the next token or nil on error.
+func (l *pdfLexer) mustToken() interface{} {
+	tok, err := l.token()
+	if err != nil {
+		return nil
+	}
+	return tok
+}
+
+// token reads the next token: number, name, string or keyword.
+func (l *pdfLexer) token() (interface{}, error) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	c := l.data[l.pos]
+	switch {
+	case c == '/':
+		l.pos++
+		return pdfName(unescapePDFName(l.regular())), nil
+	case c == '(':
+		return l.literalString()
+	case c == '<':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '<' {
+			l.pos += 2
+			return pdfKeyword("<<"), nil
+		}
+		return l.hexString()
+	case c == '>':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '>' {
+			l.pos += 2
+			return pdfKeyword(">>"), nil
+		}
+		return nil, ErrPDFInvalid
+	case c == '[' || c == ']' || c == '{' || c == '}':
+		l.pos++
+		return pdfKeyword(c), nil
+	case c == ')':
+		return nil, ErrPDFInvalid
+	}
+
+	word := l.regular()
+	if c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9') {
+		if n, err := strconv.ParseInt(word, 10, 64); err == nil {
+			return n, nil
+		}
+		if f, err := strconv.ParseFloat(word, 64); err == nil {
+			return f, nil
+		}
+	}
+	return pdfKeyword(word), nil
+}
+
+// regular reads regular characters.
+func (l *pdfLexer) regular() string {
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	return string(l.data[start:l.pos])
+}
+
+// literalString reads string in parentheses.
+func (l *pdfLexer) literalString() (interface{}, error) {
+	l.pos++
+	var buf bytes.Buffer
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return pdfString(buf.String()), nil
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return nil, ErrPDFInvalid
+			}
+			c = l.data[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					n := int(c - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) &&
+						l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						n = n*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(n)
+				}
+			}
+		}
+		buf.WriteByte(c)
+	}
+	return nil, ErrPDFInvalid
+}
+
+// hexString reads string in angle brackets.
+func (l *pdfLexer) hexString() (interface{}, error) {
+	l.pos++
+	var digits []byte
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		if c == '>' {
+			if len(digits)%2 == 1 {
+				digits = append(digits, '0')
+			}
+			out := make([]byte, len(digits)/2)
+			for i := range out {
+				out[i] = unhex(digits[2*i])<<4 | unhex(digits[2*i+1])
+			}
+			return pdfString(out), nil
+		}
+		if isPDFSpace(c) {
+			continue
+		}
+		if !isHexDigit(c) {
+			return nil, ErrPDFInvalid
+		}
+		digits = append(digits, c)
+	}
+	return nil, ErrPDFInvalid
+}
+
+// object reads direct object. Indirect references are recognized.
+func (l *pdfLexer) object() (interface{}, error) {
+	if l.depth > maxPDFNesting {
+		return nil, ErrPDFInvalid
+	}
+	l.depth++
+	defer func() { l.depth-- }()
+	tok, err := l.token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case pdfKeyword:
+		switch t {
+		case "<<":
+			dict := make(pdfDict)
+			for {
+				l.skipSpace()
+				if bytes.HasPrefix(l.data[l.pos:], []byte(">>")) {
+					l.pos += 2
+					return dict, nil
+				}
+				key, err := l.token()
+				if err != nil {
+					return nil, err
+				}
+				name, ok := key.(pdfName)
+				if !ok {
+					return nil, ErrPDFInvalid
+				}
+				if dict[name], err = l.object(); err != nil {
+					return nil, err
+				}
+			}
+		case "[":
+			var arr pdfArray
+			for {
+				l.skipSpace()
+				if l.pos < len(l.data) && l.data[l.pos] == ']' {
+					l.pos++
+					if arr == nil {
+						arr = pdfArray{}
+					}
+					return arr, nil
+				}
+				v, err := l.object()
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, v)
+			}
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return nil, ErrPDFInvalid
+	case int64:
+		save := l.pos
+		if gen, ok := l.mustToken().(int64); ok {
+			if l.mustToken() == pdfKeyword("R") {
+				return pdfRef{Num: int(t), Gen: int(gen)}, nil
+			}
+		}
+		l.pos = save
+		return t, nil
+	}
+	return tok, nil
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	default:
+		return c - '0'
+	}
+}
+
+// unescapePDFName decodes #xx escapes of the name.
+func unescapePDFName(s string) string {
+	if !bytes.ContainsRune([]byte(s), '#') {
+		return s
+	}
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '#' && i+2 < len(s) && isHexDigit(s[i+1]) && isHexDigit(s[i+2]) {
+			out = append(out, unhex(s[i+1])<<4|unhex(s[i+2]))
+			i += 2
+			continue
+		}
+		out = append(out, s[i])
+	}
+	return string(out)
+}
+
+// writePDFObject serializes direct object.
+func writePDFObject(w *bytes.Buffer, v interface{}) {
+	switch o := v.(type) {
+	case nil:
+		w.WriteString("null")
+	case bool:
+		w.WriteString(strconv.FormatBool(o))
+	case int:
+		w.WriteString(strconv.Itoa(o))
+	case int64:
+		w.WriteString(strconv.FormatInt(o, 10))
+	case float64:
+		w.WriteString(strconv.FormatFloat(o, 'f', -1, 64))
+	case pdfName:
+		w.WriteByte('/')
+		for i := 0; i < len(o); i++ {
+			c := o[i]
+			if c < 0x21 || c > 0x7e || c == '#' || isPDFDelimiter(c) {
+				fmt.Fprintf(w, "#%02X", c)
+				continue
+			}
+			w.WriteByte(c)
+		}
+	case pdfString:
+		w.WriteByte('(')
+		for i := 0; i < len(o); i++ {
+			c := o[i]
+			switch {
+			case c == '(' || c == ')' || c == '\\':
+				w.WriteByte('\\')
+				w.WriteByte(c)
+			case c < 0x20 || c > 0x7e:
+				fmt.Fprintf(w, "\\%03o", c)
+			default:
+				w.WriteByte(c)
+			}
+		}
+		w.WriteByte(')')
+	case pdfArray:
+		w.WriteByte('[')
+		for i, item := range o {
+			if i > 0 {
+				w.WriteByte(' ')
+			}
+			writePDFObject(w, item)
+		}
+		w.WriteByte(']')
+	case pdfDict:
+		keys := make([]string, 0, len(o))
+		for k := range o {
+			keys = append(keys, string(k))
+		}
+		sort.Strings(keys)
+		w.WriteString("<<")
+		for _, k := range keys {
+			writePDFObject(w, pdfName(k))
+			w.WriteByte(' ')
+			writePDFObject(w, o[pdfName(k)])
+		}
+		w.WriteString(">>")
+	case pdfRef:
+		fmt.Fprintf(w, "%d %d R", o.Num, o.Gen)
+	case *pdfStream:
+		dict := make(pdfDict, len(o.Dict)+1)
+		for k, v := range o.Dict {
+			dict[k] = v
+		}
+		dict["Length"] = int64(len(o.Data))
+		writePDFObject(w, dict)
+		w.WriteString("\nstream\n")
+		w.Write(o.Data)
+		w.WriteString("\nendstream")
+	case pdfRaw:
+		w.WriteString(string(o))
+	}
+}
+
+// pdfUpdate is the incremental update of the document. New and changed
+// objects are appended after the original file.
+type pdfUpdate struct {
+	doc     *pdfDocument
+	objects map[int]interface{}
+	gens    map[int]int
+	nextNum int
+}
+
+// newPDFUpdate starts incremental update.
+func newPDFUpdate(doc *pdfDocument) *pdfUpdate {
+	size, _ := doc.trailer["Size"].(int64)
+	next := int(size)
+	for num := range doc.xref {
+		if num >= next {
+			next = num + 1
+		}
+	}
+	return &pdfUpdate{
+		doc:     doc,
+		objects: make(map[int]interface{}),
+		gens:    make(map[int]int),
+		nextNum: next,
+	}
+}
+
+// add adds new object and returns reference to it.
+func (u *pdfUpdate) add(obj interface{}) pdfRef {
+	ref := pdfRef{Num: u.nextNum}
+	u.nextNum++
+	u.objects[ref.Num] = obj
+	return ref
+}
+
+// set replaces existing object.
+func (u *pdfUpdate) set(ref pdfRef, obj interface{}) {
+	u.objects[ref.Num] = obj
+	u.gens[ref.Num] = ref.Gen
+}
+
+// write writes the original document with the update. Offsets of the
+// written objects are returned.
+func (u *pdfUpdate) write() ([]byte, map[int]int) {
+	var buf bytes.Buffer
+	buf.Write(u.doc.data)
+	if n := len(u.doc.data); n > 0 && u.doc.data[n-1] != '\n' && u.doc.data[n-1] != '\r' {
+		buf.WriteByte('\n')
+	}
+
+	nums := make([]int, 0, len(u.objects))
+	for num := range u.objects {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	offsets := make(map[int]int, len(nums))
+	for _, num := range nums {
+		offsets[num] = buf.Len()
+		fmt.Fprintf(&buf, "%d %d obj\n", num, u.gens[num])
+		writePDFObject(&buf, u.objects[num])
+		buf.WriteString("\nendobj\n")
+	}
+
+	trailer := pdfDict{
+		"Size": int64(u.nextNum),
+		"Root": u.doc.trailer["Root"],
+		"Prev": u.doc.startxref,
+	}
+	for _, key := range []pdfName{"Info", "ID"} {
+		if v, ok := u.doc.trailer[key]; ok {
+			trailer[key] = v
+		}
+	}
+
+	xrefOffset := buf.Len()
+	if u.doc.xrefStream {
+		num := u.nextNum
+		trailer["Size"] = int64(num + 1)
+		nums = append(nums, num)
+		offsets[num] = xrefOffset
+		var data []byte
+		var index pdfArray
+		for _, n := range nums {
+			index = append(index, int64(n), int64(1))
+			off := offsets[n]
+			data = append(data, 1, byte(off>>24), byte(off>>16), byte(off>>8), byte(off),
+				byte(u.gens[n]>>8), byte(u.gens[n]))
+		}
+		trailer["Type"] = pdfName("XRef")
+		trailer["W"] = pdfArray{int64(1), int64(4), int64(2)}
+		trailer["Index"] = index
+		fmt.Fprintf(&buf, "%d 0 obj\n", num)
+		writePDFObject(&buf, &pdfStream{Dict: trailer, Data: data})
+		buf.WriteString("\nendobj\n")
+	} else {
+		buf.WriteString("xref\n")
+		for i := 0; i < len(nums); {
+			j := i + 1
+			for j < len(nums) && nums[j] == nums[j-1]+1 {
+				j++
+			}
+			fmt.Fprintf(&buf, "%d %d\n", nums[i], j-i)
+			for _, n := range nums[i:j] {
+				fmt.Fprintf(&buf, "%010d %05d n\r\n", offsets[n], u.gens[n])
+			}
+			i = j
+		}
+		buf.WriteString("trailer\n")
+		writePDFObject(&buf, trailer)
+		buf.WriteString("\n")
+	}
+	fmt.Fprintf(&buf, "startxref\n%d\n%%%%EOF\n", xrefOffset)
+	return buf.Bytes(), offsets
+}
@@ -0,0 +1,174 @@
+package smartid
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"testing"
+)
+
+// testPDF makes one page PDF with classic cross-reference table or with
+// compressed cross-reference stream using PNG Up predictor.
+func testPDF(t *testing.T, xrefStream bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Contents 4 0 R >>",
+		"<< /Length 42 >>\nstream\nBT /F1 24 Tf 72 720 Td (Hello, PDF!) Tj ET\nendstream",
+		"<< /Title (Test \\(document\\)) /Producer <536D6172742D4944> >>",
+	}
+	offsets := make([]int, len(objects)+1)
+	for i, obj := range objects {
+		offsets[i+1] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xrefOffset := buf.Len()
+	if !xrefStream {
+		fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f\r\n", len(offsets))
+		for _, off := range offsets[1:] {
+			fmt.Fprintf(&buf, "%010d 00000 n\r\n", off)
+		}
+		fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\n", len(offsets))
+	} else {
+		// Rows of type, 3 byte offset and generation, Up predictor.
+		offsets = append(offsets, xrefOffset)
+		var rows []byte
+		prev := make([]byte, 5)
+		for i, off := range offsets {
+			row := []byte{1, byte(off >> 16), byte(off >> 8), byte(off), 0}
+			if i == 0 {
+				row = []byte{0, 0, 0, 0, 0xff}
+			}
+			rows = append(rows, 2)
+			for j := range row {
+				rows = append(rows, row[j]-prev[j])
+			}
+			prev = row
+		}
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(rows)
+		zw.Close()
+		fmt.Fprintf(&buf, "%d 0 obj\n<< /Type /XRef /Size %d /W [1 3 1] /Root 1 0 R /Info 5 0 R"+
+			" /Filter /FlateDecode /DecodeParms << /Predictor 12 /Columns 5 >> /Length %d >>\n"+
+			"stream\n", len(offsets)-1, len(offsets), z.Len())
+		buf.Write(z.Bytes())
+		buf.WriteString("\nendstream\nendobj\n")
+	}
+	fmt.Fprintf(&buf, "startxref\n%d\n%%%%EOF\n", xrefOffset)
+	return buf.Bytes()
+}
+
+func TestParsePDF(t *testing.T) {
+	for _, xrefStream := range []bool{false, true} {
+		doc, err := parsePDF(testPDF(t, xrefStream))
+		if err != nil {
+			t.Fatal(xrefStream, err)
+		}
+		if doc.xrefStream != xrefStream {
+			t.Error("expected", xrefStream, "got", doc.xrefStream)
+		}
+		root := doc.resolveDict(doc.trailer["Root"])
+		if root["Type"] != pdfName("Catalog") {
+			t.Error("expected", "Catalog", "got", root["Type"])
+		}
+		ref, page, err := firstPDFPage(doc, root)
+		if err != nil || ref != (pdfRef{Num: 3}) || page["Type"] != pdfName("Page") {
+			t.Error("expected", pdfRef{Num: 3}, "got", ref, err)
+		}
+		obj, _ := doc.resolve(page["Contents"])
+		if s, ok := obj.(*pdfStream); !ok || len(s.Data) != 42 {
+			t.Error("expected content stream of 42 bytes, got", obj)
+		}
+		info := doc.resolveDict(doc.trailer["Info"])
+		if info["Title"] != pdfString("Test (document)") {
+			t.Error("expected", "Test (document)", "got", info["Title"])
+		}
+		if info["Producer"] != pdfString("Smart-ID") {
+			t.Error("expected", "Smart-ID", "got", info["Producer"])
+		}
+	}
+
+	encrypted := bytes.Replace(testPDF(t, false), []byte("/Info 5 0 R"), []byte("/Encrypt 5 0 R"), 1)
+	if _, err := parsePDF(encrypted); err != ErrPDFEncrypted {
+		t.Error("expected", ErrPDFEncrypted, "got", err)
+	}
+	if _, err := parsePDF([]byte("%PDF-1.7\nnot a PDF")); err != ErrPDFInvalid {
+		t.Error("expected", ErrPDFInvalid, "got", err)
+	}
+}
+
+func TestPDFLexer_object(t *testing.T) {
+	testdata := []struct {
+		in  string
+		out interface{}
+	}{
+		{"/Name#20With#2FSpace", pdfName("Name With/Space")},
+		{"(a\\(b\\)\\n\\101(c))", pdfString("a(b)\nA(c)")},
+		{"(line\\\ncontinued)", pdfString("linecontinued")},
+		{"<48 65 6C6C 6f7>", pdfString("Hellop")},
+		{"-12", int64(-12)},
+		{".5", 0.5},
+		{"12 0 R", pdfRef{Num: 12}},
+		{"true", true},
+		{"null", nil},
+	}
+	for _, test := range testdata {
+		l := &pdfLexer{data: []byte(test.in)}
+		obj, err := l.object()
+		if err != nil || obj != test.out {
+			t.Error(test.in, "expected", test.out, "got", obj, err)
+		}
+	}
+
+	l := &pdfLexer{data: []byte("<< /A [1 2 0 R /B] % comment\n /C << >> >>")}
+	obj, err := l.object()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict := obj.(pdfDict)
+	if a := dict["A"].(pdfArray); len(a) != 3 || a[1] != (pdfRef{Num: 2}) {
+		t.Error("expected", "[1 2 0 R /B]", "got", a)
+	}
+	if c, ok := dict["C"].(pdfDict); !ok || len(c) != 0 {
+		t.Error("expected empty dictionary, got", dict["C"])
+	}
+}
+
+func TestPDFUpdate(t *testing.T) {
+	for _, xrefStream := range []bool{false, true} {
+		orig := testPDF(t, xrefStream)
+		doc, _ := parsePDF(orig)
+		u := newPDFUpdate(doc)
+		ref := u.add(pdfDict{"Title": pdfTextString("Allkiri õ")})
+		info := copyPDFDict(doc.resolveDict(doc.trailer["Info"]))
+		info["Title"] = pdfString("Updated")
+		u.set(doc.trailer["Info"].(pdfRef), info)
+		data, _ := u.write()
+		if !bytes.HasPrefix(data, orig) {
+			t.Error("expected original file to be kept")
+		}
+
+		updated, err := parsePDF(data)
+		if err != nil {
+			t.Fatal(xrefStream, err)
+		}
+		if updated.xrefStream != xrefStream {
+			t.Error("expected", xrefStream, "got", updated.xrefStream)
+		}
+		if v := updated.resolveDict(updated.trailer["Info"])["Title"]; v != pdfString("Updated") {
+			t.Error("expected", "Updated", "got", v)
+		}
+		title, _ := updated.resolveDict(ref)["Title"].(pdfString)
+		if decodePDFText(title) != "Allkiri õ" {
+			t.Error("expected", "Allkiri õ", "got", decodePDFText(title))
+		}
+		if updated.resolveDict(pdfRef{Num: 3})["Type"] != pdfName("Page") {
+			t.Error("expected objects of original revision to be found")
+		}
+	}
+}
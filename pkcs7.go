@@ -0,0 +1,84 @@
+package smartid
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+)
+
+// Object identifiers of CMS content types (RFC 5652).
+var (
+	oidContentTypeData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidContentTypeSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+var (
+	// ErrPKCS7NotSignedData error when PKCS#7 content is not SignedData.
+	ErrPKCS7NotSignedData = errors.New("PKCS#7 content is not SignedData")
+)
+
+// contentInfo is the top level CMS structure.
+//
+//	ContentInfo ::= SEQUENCE {
+//		contentType ContentType,
+//		content [0] EXPLICIT ANY DEFINED BY contentType }
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// encapsulatedContentInfo is the signed content, empty for detached
+// signatures.
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// signedData is CMS SignedData.
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      asn1.RawValue
+}
+
+// parseSignedData parses DER encoded ContentInfo with SignedData.
+func parseSignedData(der []byte) (*signedData, error) {
+	var ci contentInfo
+	rest, err := asn1.Unmarshal(der, &ci)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, asn1.SyntaxError{Msg: "trailing data after ContentInfo"}
+	}
+	if !ci.ContentType.Equal(oidContentTypeSignedData) {
+		return nil, ErrPKCS7NotSignedData
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, err
+	}
+	return &sd, nil
+}
+
+// certificates parses certificates embedded in SignedData.
+func (sd *signedData) certificates() ([]*x509.Certificate, error) {
+	if len(sd.Certificates.Bytes) == 0 {
+		return nil, nil
+	}
+	return x509.ParseCertificates(sd.Certificates.Bytes)
+}
+
+// parsePKCS7Certificates extracts certificates from degenerate
+// "certs-only" PKCS#7 SignedData (application/pkcs7-mime).
+func parsePKCS7Certificates(der []byte) ([]*x509.Certificate, error) {
+	sd, err := parseSignedData(der)
+	if err != nil {
+		return nil, err
+	}
+	return sd.certificates()
+}
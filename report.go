@@ -0,0 +1,413 @@
+package smartid
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Main indications of ETSI EN 319 102-1 validation.
+const (
+	IndicationTotalPassed   = "urn:etsi:019102:mainindication:total-passed"
+	IndicationIndeterminate = "urn:etsi:019102:mainindication:indeterminate"
+	IndicationTotalFailed   = "urn:etsi:019102:mainindication:total-failed"
+)
+
+// Sub-indications of ETSI EN 319 102-1 validation.
+const (
+	SubIndicationFormatFailure             = "urn:etsi:019102:subindication:FORMAT_FAILURE"
+	SubIndicationHashFailure               = "urn:etsi:019102:subindication:HASH_FAILURE"
+	SubIndicationSigCryptoFailure          = "urn:etsi:019102:subindication:SIG_CRYPTO_FAILURE"
+	SubIndicationRevoked                   = "urn:etsi:019102:subindication:REVOKED"
+	SubIndicationSigConstraintsFailure     = "urn:etsi:019102:subindication:SIG_CONSTRAINTS_FAILURE"
+	SubIndicationChainConstraintsFailure   = "urn:etsi:019102:subindication:CHAIN_CONSTRAINTS_FAILURE"
+	SubIndicationCertificateChainFailure   = "urn:etsi:019102:subindication:CERTIFICATE_CHAIN_GENERAL_FAILURE"
+	SubIndicationCryptoConstraintsFailure  = "urn:etsi:019102:subindication:CRYPTO_CONSTRAINTS_FAILURE_NO_POE"
+	SubIndicationOutOfBoundsNoPOE          = "urn:etsi:019102:subindication:OUT_OF_BOUNDS_NO_POE"
+	SubIndicationNoSigningCertificateFound = "urn:etsi:019102:subindication:NO_SIGNING_CERTIFICATE_FOUND"
+	SubIndicationNoCertificateChainFound   = "urn:etsi:019102:subindication:NO_CERTIFICATE_CHAIN_FOUND"
+	SubIndicationTryLater                  = "urn:etsi:019102:subindication:TRY_LATER"
+	SubIndicationSignedDataNotFound        = "urn:etsi:019102:subindication:SIGNED_DATA_NOT_FOUND"
+	SubIndicationTimestampOrderFailure     = "urn:etsi:019102:subindication:TIMESTAMP_ORDER_FAILURE"
+	SubIndicationGeneric                   = "urn:etsi:019102:subindication:GENERIC"
+)
+
+// Types of validation objects.
+const (
+	validationObjectCertificate = "urn:etsi:019102:validationObject:certificate"
+	validationObjectOCSP        = "urn:etsi:019102:validationObject:OCSPResponse"
+	validationObjectTimestamp   = "urn:etsi:019102:validationObject:timestamp"
+)
+
+// poeTypeValidation is the type of proof of existence obtained during
+// the validation.
+const poeTypeValidation = "urn:etsi:019102:poetype:validation"
+
+// indications map errors of the checks to the indication and
+// sub-indication. The first matching entry is used.
+var indications = []struct {
+	err           error
+	indication    string
+	subIndication string
+}{
+	{ErrDigestMismatch, IndicationTotalFailed, SubIndicationHashFailure},
+	{ErrCMSMessageDigestMismatch, IndicationTotalFailed, SubIndicationHashFailure},
+	{ErrPDFModifiedAfterSigning, IndicationTotalFailed, SubIndicationHashFailure},
+	{ErrDataFileNotSigned, IndicationTotalFailed, SubIndicationHashFailure},
+	{ErrSignatureInvalid, IndicationTotalFailed, SubIndicationSigCryptoFailure},
+	{ErrCertRevoked, IndicationTotalFailed, SubIndicationRevoked},
+	{ErrXAdESMalformed, IndicationTotalFailed, SubIndicationFormatFailure},
+	{ErrJWSMalformed, IndicationTotalFailed, SubIndicationFormatFailure},
+	{ErrPDFInvalid, IndicationTotalFailed, SubIndicationFormatFailure},
+	{ErrPDFByteRangeInvalid, IndicationTotalFailed, SubIndicationFormatFailure},
+	{ErrCMSContentTypeMismatch, IndicationTotalFailed, SubIndicationFormatFailure},
+	{ErrCMSAttributeMissing, IndicationTotalFailed, SubIndicationFormatFailure},
+	{ErrSignerCertNotFound, IndicationIndeterminate, SubIndicationNoSigningCertificateFound},
+	{ErrCMSSignerCertNotFound, IndicationIndeterminate, SubIndicationNoSigningCertificateFound},
+	{ErrSigningCertMismatch, IndicationIndeterminate, SubIndicationNoSigningCertificateFound},
+	{ErrDataFileNotFound, IndicationIndeterminate, SubIndicationSignedDataNotFound},
+	{ErrXMLDocumentNotSigned, IndicationIndeterminate, SubIndicationSignedDataNotFound},
+	{ErrHashUnsupported, IndicationIndeterminate, SubIndicationCryptoConstraintsFailure},
+	{ErrSignatureKeyUnsupported, IndicationIndeterminate, SubIndicationCryptoConstraintsFailure},
+	{ErrJWSAlgorithmUnsupported, IndicationIndeterminate, SubIndicationCryptoConstraintsFailure},
+	{ErrCertStatusUnknown, IndicationIndeterminate, SubIndicationTryLater},
+	{ErrRevocationMissing, IndicationIndeterminate, SubIndicationTryLater},
+	{ErrOCSPStale, IndicationIndeterminate, SubIndicationTryLater},
+	{ErrRevocationUnavailable, IndicationIndeterminate, SubIndicationTryLater},
+	{ErrOCSPUnsuccessful, IndicationIndeterminate, SubIndicationTryLater},
+	{ErrOCSPNoStatus, IndicationIndeterminate, SubIndicationTryLater},
+	{ErrOCSPResponderNotFound, IndicationIndeterminate, SubIndicationCertificateChainFailure},
+	{ErrOCSPResponderNotAuthorized, IndicationIndeterminate, SubIndicationCertificateChainFailure},
+	{ErrChainNotVerified, IndicationIndeterminate, SubIndicationNoCertificateChainFound},
+	{ErrCertTestIssuer, IndicationIndeterminate, SubIndicationChainConstraintsFailure},
+	{ErrCertNotSigning, IndicationIndeterminate, SubIndicationChainConstraintsFailure},
+	{ErrCertNotAuthentication, IndicationIndeterminate, SubIndicationChainConstraintsFailure},
+	{ErrTimestampMissing, IndicationIndeterminate, SubIndicationSigConstraintsFailure},
+	{ErrTimestampImprintMismatch, IndicationIndeterminate, SubIndicationTimestampOrderFailure},
+	{ErrTimestampNotTSA, IndicationIndeterminate, SubIndicationTimestampOrderFailure},
+}
+
+// ValidationReport is the validation report of ETSI TS 119 102-2. It is
+// encoded as XML by XML and as JSON with the same structure by JSON.
+type ValidationReport struct {
+	XMLName xml.Name `xml:"http://uri.etsi.org/19102/v1.2.1# ValidationReport" json:"-"`
+
+	// Signatures are the reports of each validated signature.
+	Signatures []*SignatureValidationReport `xml:"SignatureValidationReport" json:"signatureValidationReport"`
+
+	// ValidationObjects are the certificates, OCSP responses and
+	// timestamps used in the validation, referenced by their ID.
+	ValidationObjects []*ValidationObject `xml:"SignatureValidationObjects>ValidationObject,omitempty" json:"signatureValidationObjects,omitempty"`
+}
+
+// SignatureValidationReport is the validation report of one signature.
+type SignatureValidationReport struct {
+	SignatureIdentifier       *SignatureIdentifier       `xml:"SignatureIdentifier" json:"signatureIdentifier"`
+	ValidationTimeInfo        *ValidationTimeInfo        `xml:"ValidationTimeInfo" json:"validationTimeInfo"`
+	SignatureAttributes       *SignatureAttributes       `xml:"SignatureAttributes,omitempty" json:"signatureAttributes,omitempty"`
+	SignerInformation         *SignerInformation         `xml:"SignerInformation,omitempty" json:"signerInformation,omitempty"`
+	SignatureValidationStatus *SignatureValidationStatus `xml:"SignatureValidationStatus" json:"signatureValidationStatus"`
+}
+
+// SignatureIdentifier identifies the signature in the document.
+type SignatureIdentifier struct {
+	ID          string `xml:"id,attr" json:"id"`
+	HashOnly    bool   `xml:"HashOnly" json:"hashOnly"`
+	DocHashOnly bool   `xml:"DocHashOnly" json:"docHashOnly"`
+
+	// DAIdentifier is the ID of the signature, see SignatureResult.
+	DAIdentifier string `xml:"DAIdentifier,omitempty" json:"daIdentifier,omitempty"`
+}
+
+// ValidationTimeInfo is the time of validation and the best signature
+// time proven by timestamp or OCSP response.
+type ValidationTimeInfo struct {
+	ValidationTime    time.Time `xml:"ValidationTime" json:"validationTime"`
+	BestSignatureTime *POE      `xml:"BestSignatureTime,omitempty" json:"bestSignatureTime,omitempty"`
+}
+
+// POE is the proof of existence.
+type POE struct {
+	POETime     time.Time `xml:"POETime" json:"poeTime"`
+	TypeOfProof string    `xml:"TypeOfProof" json:"typeOfProof"`
+}
+
+// SignatureAttributes are the attributes of the signature.
+type SignatureAttributes struct {
+	SigningTime        *SigningTimeAttribute `xml:"SigningTime,omitempty" json:"signingTime,omitempty"`
+	SignatureTimeStamp []*TimestampAttribute `xml:"SignatureTimeStamp,omitempty" json:"signatureTimeStamp,omitempty"`
+	ArchiveTimeStamp   []*TimestampAttribute `xml:"ArchiveTimeStamp,omitempty" json:"archiveTimeStamp,omitempty"`
+}
+
+// SigningTimeAttribute is the signing time claimed by the signer.
+type SigningTimeAttribute struct {
+	Signed bool      `xml:"Signed,attr" json:"signed"`
+	Time   time.Time `xml:"Time" json:"time"`
+}
+
+// TimestampAttribute is the timestamp of the signature.
+type TimestampAttribute struct {
+	Signed          bool        `xml:"Signed,attr" json:"signed"`
+	TimeStampValue  time.Time   `xml:"TimeStampValue" json:"timeStampValue"`
+	AttributeObject VOReference `xml:"AttributeObject" json:"attributeObject"`
+}
+
+// VOReference references validation objects by their IDs.
+type VOReference struct {
+	VOReference string `xml:"VOReference,attr" json:"voReference"`
+}
+
+// SignerInformation is the signer certificate and identity.
+type SignerInformation struct {
+	SignerCertificate VOReference `xml:"SignerCertificate" json:"signerCertificate"`
+	Signer            string      `xml:"Signer,omitempty" json:"signer,omitempty"`
+
+	// Identity is the identity of the signer. It has no XML
+	// representation, Signer contains the common name.
+	Identity *Identity `xml:"-" json:"identity,omitempty"`
+}
+
+// SignatureValidationStatus is the final indication of the validation.
+type SignatureValidationStatus struct {
+	MainIndication                 string                            `xml:"MainIndication" json:"mainIndication"`
+	SubIndication                  []string                          `xml:"SubIndication,omitempty" json:"subIndication,omitempty"`
+	AssociatedValidationReportData []*AssociatedValidationReportData `xml:"AssociatedValidationReportData,omitempty" json:"associatedValidationReportData,omitempty"`
+}
+
+// AssociatedValidationReportData are the certificate chain, revocation
+// status and reasons of the indication.
+type AssociatedValidationReportData struct {
+	CertificateChain               *CertificateChain            `xml:"CertificateChain,omitempty" json:"certificateChain,omitempty"`
+	RevocationStatusInformation    *RevocationStatusInformation `xml:"RevocationStatusInformation,omitempty" json:"revocationStatusInformation,omitempty"`
+	AdditionalValidationReportData []*ReportData                `xml:"AdditionalValidationReportData>ReportData,omitempty" json:"additionalValidationReportData,omitempty"`
+}
+
+// CertificateChain is the verified chain of the signer certificate.
+type CertificateChain struct {
+	SigningCertificate      VOReference   `xml:"SigningCertificate" json:"signingCertificate"`
+	IntermediateCertificate []VOReference `xml:"IntermediateCertificate,omitempty" json:"intermediateCertificate,omitempty"`
+	TrustAnchor             *VOReference  `xml:"TrustAnchor,omitempty" json:"trustAnchor,omitempty"`
+}
+
+// RevocationStatusInformation is the revocation status of the signer
+// certificate.
+type RevocationStatusInformation struct {
+	ValidationObjectID VOReference  `xml:"ValidationObjectId" json:"validationObjectId"`
+	RevocationTime     *time.Time   `xml:"RevocationTime,omitempty" json:"revocationTime,omitempty"`
+	RevocationObject   *VOReference `xml:"RevocationObject,omitempty" json:"revocationObject,omitempty"`
+}
+
+// ReportData is additional data of the report. Type is the
+// sub-indication, or warning, and Value is the error message.
+type ReportData struct {
+	Type  string `xml:"Type" json:"type"`
+	Value string `xml:"Value" json:"value"`
+}
+
+// ValidationObject is the object used in the validation.
+type ValidationObject struct {
+	ID                             string                          `xml:"id,attr" json:"id"`
+	ObjectType                     string                          `xml:"ObjectType" json:"objectType"`
+	ValidationObjectRepresentation *ValidationObjectRepresentation `xml:"ValidationObjectRepresentation" json:"validationObjectRepresentation"`
+	POE                            *POE                            `xml:"POE,omitempty" json:"poe,omitempty"`
+}
+
+// ValidationObjectRepresentation is the base64 encoded object.
+type ValidationObjectRepresentation struct {
+	Direct string `xml:"direct" json:"direct"`
+}
+
+// reportWarning is the type of report data of warnings, which do not
+// affect the indication. It is not defined by ETSI.
+const reportWarning = "urn:smartid:warning"
+
+// NewValidationReport makes validation report of the signature results.
+func NewValidationReport(results []*SignatureResult) *ValidationReport {
+	vr := &ValidationReport{}
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, r := range results {
+		vr.Signatures = append(vr.Signatures, vr.signatureReport(fmt.Sprintf("sig-%d", i), r, now))
+	}
+	return vr
+}
+
+// NewSessionValidationReport makes validation report of the
+// authentication or signature session response. The certificate chain
+// is verified with the trust store. Without trust store, the indication
+// is INDETERMINATE with NO_CERTIFICATE_CHAIN_FOUND.
+func NewSessionValidationReport(ctx context.Context, resp *SessionResponse, ts *TrustStore) *ValidationReport {
+	r := &SignatureResult{ID: resp.SessionID}
+	if _, err := resp.Validate(); err != nil {
+		r.fail(err)
+	}
+	resp.Cert.createX509CertIfNeeded()
+	if cert := resp.Cert.GetX509Cert(); cert != nil {
+		r.setSigner(cert)
+		if ts == nil {
+			r.fail(ErrChainNotVerified)
+		} else if chains, err := ts.Verify(ctx, cert, time.Time{}); err != nil {
+			r.fail(err)
+		} else {
+			r.Chain = chains[0]
+		}
+	} else if len(r.Errors) == 0 {
+		r.fail(ErrSignerCertNotFound)
+	}
+	return NewValidationReport([]*SignatureResult{r})
+}
+
+// XML returns the report encoded as XML.
+func (vr *ValidationReport) XML() ([]byte, error) {
+	data, err := xml.MarshalIndent(vr, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// JSON returns the report encoded as JSON.
+func (vr *ValidationReport) JSON() ([]byte, error) {
+	return json.MarshalIndent(vr, "", "  ")
+}
+
+// signatureReport makes report of the signature result.
+func (vr *ValidationReport) signatureReport(id string, r *SignatureResult, now time.Time) *SignatureValidationReport {
+	sr := &SignatureValidationReport{
+		SignatureIdentifier: &SignatureIdentifier{ID: id, DAIdentifier: r.ID},
+		ValidationTimeInfo:  &ValidationTimeInfo{ValidationTime: now},
+	}
+	if !r.TrustedTime.IsZero() {
+		sr.ValidationTimeInfo.BestSignatureTime = &POE{
+			POETime:     r.TrustedTime.UTC(),
+			TypeOfProof: poeTypeValidation,
+		}
+	}
+
+	attrs := &SignatureAttributes{}
+	if !r.SigningTime.IsZero() {
+		attrs.SigningTime = &SigningTimeAttribute{Signed: true, Time: r.SigningTime.UTC()}
+	}
+	for _, token := range r.Timestamps {
+		attrs.SignatureTimeStamp = append(attrs.SignatureTimeStamp, vr.timestampAttribute(token))
+	}
+	for _, token := range r.ArchiveTimestamps {
+		attrs.ArchiveTimeStamp = append(attrs.ArchiveTimeStamp, vr.timestampAttribute(token))
+	}
+	if attrs.SigningTime != nil || len(attrs.SignatureTimeStamp) > 0 ||
+		len(attrs.ArchiveTimeStamp) > 0 {
+		sr.SignatureAttributes = attrs
+	}
+
+	status := &SignatureValidationStatus{MainIndication: IndicationTotalPassed}
+	data := &AssociatedValidationReportData{}
+	if r.Certificate != nil {
+		certID := vr.addCertificate(r.Certificate)
+		sr.SignerInformation = &SignerInformation{
+			SignerCertificate: VOReference{certID},
+			Signer:            r.Identity.CommonName,
+			Identity:          r.Identity,
+		}
+		if len(r.Chain) > 0 {
+			chain := &CertificateChain{SigningCertificate: VOReference{certID}}
+			for i, c := range r.Chain[1:] {
+				ref := VOReference{vr.addCertificate(c)}
+				if i == len(r.Chain)-2 {
+					chain.TrustAnchor = &ref
+				} else {
+					chain.IntermediateCertificate = append(chain.IntermediateCertificate, ref)
+				}
+			}
+			data.CertificateChain = chain
+		}
+		if r.OCSP != nil {
+			info := &RevocationStatusInformation{
+				ValidationObjectID: VOReference{certID},
+				RevocationObject:   &VOReference{vr.addObject(validationObjectOCSP, r.OCSP.Raw, nil)},
+			}
+			if r.OCSP.Status == OCSPStatusRevoked {
+				t := r.OCSP.RevokedAt.UTC()
+				info.RevocationTime = &t
+			}
+			data.RevocationStatusInformation = info
+		}
+	}
+
+	for _, err := range r.Errors {
+		indication, sub := Indication(err)
+		if status.MainIndication != IndicationTotalFailed && indication != status.MainIndication {
+			status.MainIndication = indication
+			status.SubIndication = []string{sub}
+		}
+		data.AdditionalValidationReportData = append(data.AdditionalValidationReportData,
+			&ReportData{Type: sub, Value: err.Error()})
+	}
+	for _, err := range r.Warnings {
+		data.AdditionalValidationReportData = append(data.AdditionalValidationReportData,
+			&ReportData{Type: reportWarning, Value: err.Error()})
+	}
+	if data.CertificateChain != nil || data.RevocationStatusInformation != nil ||
+		len(data.AdditionalValidationReportData) > 0 {
+		status.AssociatedValidationReportData = []*AssociatedValidationReportData{data}
+	}
+	sr.SignatureValidationStatus = status
+	return sr
+}
+
+// timestampAttribute makes attribute of the timestamp token.
+func (vr *ValidationReport) timestampAttribute(token *TimestampToken) *TimestampAttribute {
+	poe := &POE{POETime: token.Time.UTC(), TypeOfProof: poeTypeValidation}
+	return &TimestampAttribute{
+		TimeStampValue:  token.Time.UTC(),
+		AttributeObject: VOReference{vr.addObject(validationObjectTimestamp, token.Raw, poe)},
+	}
+}
+
+// addCertificate adds certificate validation object and returns its ID.
+func (vr *ValidationReport) addCertificate(cert *x509.Certificate) string {
+	return vr.addObject(validationObjectCertificate, cert.Raw, nil)
+}
+
+// addObject adds validation object, if not added yet, and returns its ID.
+func (vr *ValidationReport) addObject(objectType string, der []byte, poe *POE) string {
+	direct := base64.StdEncoding.EncodeToString(der)
+	for _, o := range vr.ValidationObjects {
+		if o.ObjectType == objectType && o.ValidationObjectRepresentation.Direct == direct {
+			return o.ID
+		}
+	}
+	o := &ValidationObject{
+		ID:                             fmt.Sprintf("vo-%d", len(vr.ValidationObjects)),
+		ObjectType:                     objectType,
+		ValidationObjectRepresentation: &ValidationObjectRepresentation{Direct: direct},
+		POE:                            poe,
+	}
+	vr.ValidationObjects = append(vr.ValidationObjects, o)
+	return o.ID
+}
+
+// Indication returns the indication and sub-indication of the validation
+// error. Unknown errors are INDETERMINATE with GENERIC sub-indication.
+func Indication(err error) (indication, subIndication string) {
+	for _, i := range indications {
+		if errors.Is(err, i.err) {
+			return i.indication, i.subIndication
+		}
+	}
+	var uaErr x509.UnknownAuthorityError
+	if errors.As(err, &uaErr) {
+		return IndicationIndeterminate, SubIndicationNoCertificateChainFound
+	}
+	var ciErr x509.CertificateInvalidError
+	if errors.As(err, &ciErr) {
+		if ciErr.Reason == x509.Expired {
+			return IndicationIndeterminate, SubIndicationOutOfBoundsNoPOE
+		}
+		return IndicationIndeterminate, SubIndicationChainConstraintsFailure
+	}
+	return IndicationIndeterminate, SubIndicationGeneric
+}
@@ -0,0 +1,156 @@
+package smartid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestNewValidationReport(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+	data := signedTestASiCE(t, mock, OCSPStatusGood)
+
+	vr := NewValidationReport(readTestASiCE(t, data).Validate(ctx, ts))
+	doc, err := vr.XML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed ValidationReport
+	if err := xml.Unmarshal(doc, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.XMLName.Space != "http://uri.etsi.org/19102/v1.2.1#" {
+		t.Error("expected ETSI namespace, got", parsed.XMLName.Space)
+	}
+	sr := parsed.Signatures[0]
+	if sr.SignatureValidationStatus.MainIndication != IndicationTotalPassed {
+		t.Error("expected", IndicationTotalPassed, "got", sr.SignatureValidationStatus.MainIndication)
+	}
+	if sr.SignerInformation.Signer != mock.signCert.Subject.CommonName {
+		t.Error("expected", mock.signCert.Subject.CommonName, "got", sr.SignerInformation.Signer)
+	}
+	if len(sr.SignatureAttributes.SignatureTimeStamp) != 1 || sr.ValidationTimeInfo.BestSignatureTime == nil {
+		t.Error("expected signature timestamp, got", sr.SignatureAttributes)
+	}
+	avr := sr.SignatureValidationStatus.AssociatedValidationReportData[0]
+	if avr.CertificateChain == nil || avr.CertificateChain.TrustAnchor == nil ||
+		avr.RevocationStatusInformation == nil {
+		t.Fatal("expected chain and revocation status, got", avr)
+	}
+	// Signer and CA certificates, OCSP response and timestamp.
+	if len(parsed.ValidationObjects) != 4 {
+		t.Error("expected", 4, "got", len(parsed.ValidationObjects))
+	}
+	objects := make(map[string]string)
+	for _, o := range parsed.ValidationObjects {
+		objects[o.ID] = o.ObjectType
+	}
+	if objects[avr.RevocationStatusInformation.RevocationObject.VOReference] != validationObjectOCSP {
+		t.Error("expected reference to OCSP response")
+	}
+
+	js, err := vr.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct {
+		SignatureValidationReport []struct {
+			SignerInformation struct {
+				Identity *Identity `json:"identity"`
+			} `json:"signerInformation"`
+			SignatureValidationStatus struct {
+				MainIndication string `json:"mainIndication"`
+			} `json:"signatureValidationStatus"`
+		} `json:"signatureValidationReport"`
+	}
+	if err := json.Unmarshal(js, &v); err != nil {
+		t.Fatal(err)
+	}
+	if s := v.SignatureValidationReport[0]; s.SignatureValidationStatus.MainIndication != IndicationTotalPassed ||
+		s.SignerInformation.Identity.SerialNumber != "PNOEE-30303039914" {
+		t.Error("expected passed signature with identity, got", string(js))
+	}
+
+	modified := rewriteZip(t, data, func(name string, data []byte) []byte {
+		if name == "test.txt" {
+			return []byte("modified")
+		}
+		return data
+	}, nil)
+	vr = NewValidationReport(readTestASiCE(t, modified).Validate(ctx, ts))
+	status := vr.Signatures[0].SignatureValidationStatus
+	if status.MainIndication != IndicationTotalFailed || status.SubIndication[0] != SubIndicationHashFailure {
+		t.Error("expected", SubIndicationHashFailure, "got", status.MainIndication, status.SubIndication)
+	}
+
+	vr = NewValidationReport(readTestASiCE(t, data).Validate(ctx, NewTrustStore()))
+	status = vr.Signatures[0].SignatureValidationStatus
+	if status.MainIndication != IndicationIndeterminate || status.SubIndication[0] != SubIndicationNoCertificateChainFound {
+		t.Error("expected", SubIndicationNoCertificateChainFound, "got", status.MainIndication, status.SubIndication)
+	}
+	if doc, _ := vr.XML(); !bytes.Contains(doc, []byte("<MainIndication>"+IndicationIndeterminate)) {
+		t.Error("expected indication in XML, got", string(doc))
+	}
+}
+
+func TestNewSessionValidationReport(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+
+	resp, err := mock.client().ChooseCertificateSync(ctx, &AuthRequest{
+		Identifier: "PNOEE-30303039914",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vr := NewSessionValidationReport(ctx, resp, ts)
+	sr := vr.Signatures[0]
+	if sr.SignatureValidationStatus.MainIndication != IndicationTotalPassed {
+		t.Error("expected", IndicationTotalPassed, "got", sr.SignatureValidationStatus)
+	}
+	if sr.SignatureIdentifier.DAIdentifier != resp.SessionID {
+		t.Error("expected", resp.SessionID, "got", sr.SignatureIdentifier.DAIdentifier)
+	}
+
+	vr = NewSessionValidationReport(ctx, resp, NewTrustStore())
+	if sr := vr.Signatures[0]; sr.SignatureValidationStatus.MainIndication != IndicationIndeterminate {
+		t.Error("expected", IndicationIndeterminate, "got", sr.SignatureValidationStatus)
+	}
+
+	vr = NewSessionValidationReport(ctx, resp, nil)
+	status := vr.Signatures[0].SignatureValidationStatus
+	if status.MainIndication != IndicationIndeterminate || len(status.SubIndication) != 1 ||
+		status.SubIndication[0] != SubIndicationNoCertificateChainFound {
+		t.Error("expected", IndicationIndeterminate, SubIndicationNoCertificateChainFound, "got", status)
+	}
+}
+
+func TestIndication(t *testing.T) {
+	testdata := []struct {
+		err        error
+		indication string
+		sub        string
+	}{
+		{ErrSignatureInvalid, IndicationTotalFailed, SubIndicationSigCryptoFailure},
+		{fmt.Errorf("test.txt: %w", ErrDigestMismatch), IndicationTotalFailed, SubIndicationHashFailure},
+		{ErrCertRevoked, IndicationTotalFailed, SubIndicationRevoked},
+		{ErrSigningCertMismatch, IndicationIndeterminate, SubIndicationNoSigningCertificateFound},
+		{ErrCertStatusUnknown, IndicationIndeterminate, SubIndicationTryLater},
+		{errors.New("other"), IndicationIndeterminate, SubIndicationGeneric},
+	}
+	for _, test := range testdata {
+		indication, sub := Indication(test.err)
+		if indication != test.indication || sub != test.sub {
+			t.Error(test.err, "expected", test.indication, test.sub, "got", indication, sub)
+		}
+	}
+}
@@ -31,3 +31,8 @@ type Error struct {
 func (e *Error) Error() string {
 	return fmt.Sprintf("Smart ID error: %v %v", e.Code, e.Message)
 }
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,180 @@
+package smartid
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxRevocationSize is the maximum size of OCSP response or CRL.
+const DefaultMaxRevocationSize = 10 << 20
+
+var (
+	// ErrRevocationUnavailable error when neither OCSP response nor CRL
+	// can be obtained for the certificate.
+	ErrRevocationUnavailable = errors.New("Revocation data is not available")
+
+	// ErrRevocationTooLarge error when OCSP response or CRL exceeds the
+	// size limit.
+	ErrRevocationTooLarge = errors.New("Revocation data is too large")
+
+	// ErrOCSPNonceMismatch error when nonce of OCSP response does not match
+	// the request.
+	ErrOCSPNonceMismatch = errors.New("OCSP nonce does not match")
+)
+
+// RevocationSource obtains revocation data of certificates, which is
+// stored in long-term signatures.
+type RevocationSource interface {
+	// OCSP returns DER encoded OCSP response for the certificate issued
+	// by the issuer.
+	OCSP(ctx context.Context, cert, issuer *x509.Certificate) ([]byte, error)
+
+	// CRL returns DER encoded CRL, which covers the certificate.
+	CRL(ctx context.Context, cert *x509.Certificate) ([]byte, error)
+}
+
+//	OCSPRequest ::= SEQUENCE {
+//		tbsRequest        TBSRequest,
+//		optionalSignature [0] EXPLICIT Signature OPTIONAL }
+type ocspRequest struct {
+	TBSRequest ocspTBSRequest
+}
+
+//	TBSRequest ::= SEQUENCE {
+//		version           [0] EXPLICIT Version DEFAULT v1,
+//		requestorName     [1] EXPLICIT GeneralName OPTIONAL,
+//		requestList       SEQUENCE OF Request,
+//		requestExtensions [2] EXPLICIT Extensions OPTIONAL }
+type ocspTBSRequest struct {
+	RequestList []ocspSingleRequest
+	Extensions  []pkix.Extension `asn1:"explicit,optional,tag:2"`
+}
+
+type ocspSingleRequest struct {
+	CertID ocspCertID
+}
+
+// HTTPRevocationSource fetches OCSP responses from the OCSP responders and
+// CRLs from the distribution points listed in the certificate.
+type HTTPRevocationSource struct {
+	// Client is the HTTP client, if nil http.DefaultClient is used.
+	Client *http.Client
+
+	// MaxSize is the maximum size of the response in bytes, if zero
+	// DefaultMaxRevocationSize is used.
+	MaxSize int64
+}
+
+// OCSP implements RevocationSource. Request has the nonce, which is
+// checked if the responder returns it.
+func (s *HTTPRevocationSource) OCSP(ctx context.Context, cert, issuer *x509.Certificate) ([]byte, error) {
+	nameHash := sha1.Sum(cert.RawIssuer)
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	nonceValue, err := asn1.Marshal(nonce)
+	if err != nil {
+		return nil, err
+	}
+	req, err := asn1.Marshal(ocspRequest{TBSRequest: ocspTBSRequest{
+		RequestList: []ocspSingleRequest{{CertID: ocspCertID{
+			HashAlgorithm:  pkix.AlgorithmIdentifier{Algorithm: oidDigestSHA1},
+			IssuerNameHash: nameHash[:],
+			IssuerKeyHash:  publicKeyHash(issuer),
+			SerialNumber:   cert.SerialNumber,
+		}}},
+		Extensions: []pkix.Extension{{Id: oidOCSPNonce, Value: nonceValue}},
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	err = ErrRevocationUnavailable
+	for _, url := range cert.OCSPServer {
+		var data []byte
+		data, err = s.fetch(ctx, http.MethodPost, url, req)
+		if err != nil {
+			continue
+		}
+		var resp *OCSPResponse
+		if resp, err = ParseOCSPResponse(data, cert); err != nil {
+			continue
+		}
+		if resp.Nonce != nil && !bytes.Equal(resp.Nonce, nonceValue) {
+			err = ErrOCSPNonceMismatch
+			continue
+		}
+		return data, nil
+	}
+	return nil, err
+}
+
+// CRL implements RevocationSource.
+func (s *HTTPRevocationSource) CRL(ctx context.Context, cert *x509.Certificate) ([]byte, error) {
+	err := ErrRevocationUnavailable
+	for _, url := range cert.CRLDistributionPoints {
+		var data []byte
+		if data, err = s.fetch(ctx, http.MethodGet, url, nil); err != nil {
+			continue
+		}
+		if _, err = x509.ParseRevocationList(data); err != nil {
+			continue
+		}
+		return data, nil
+	}
+	return nil, err
+}
+
+// fetch sends the request and reads the response with size limit.
+func (s *HTTPRevocationSource) fetch(ctx context.Context, method, url string, body []byte) ([]byte, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("%w: %s", ErrRevocationUnavailable, url)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	maxSize := s.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxRevocationSize
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/ocsp-request")
+		req.Header.Set("Accept", "application/ocsp-response")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Revocation fetch %v: %v", url, resp.Status)
+	}
+	if resp.ContentLength > maxSize {
+		return nil, ErrRevocationTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, ErrRevocationTooLarge
+	}
+	return data, nil
+}
@@ -1,6 +1,10 @@
 package smartid
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
 
 // Supported countries by Smart-ID.
 const (
@@ -26,6 +30,20 @@ const (
 	IdentifierTypePNO = "PNO"
 )
 
+// ErrSemanticIdentifierInvalid error when semantic identifier is malformed
+// or its type or country is not supported.
+var ErrSemanticIdentifierInvalid = errors.New("Invalid semantic identifier")
+
+// Semantic identifier has digits, capital letters and at most one inner
+// dash in ID. Document number adds the account suffix to it, e.g.
+// PNOEE-30303039914-MOCK-Q.
+var (
+	semanticIdentifierPattern = regexp.MustCompile(
+		`^(PNO|PAS|IDC)(EE|LV|LT|KZ)-([0-9A-Z]+(?:-[0-9A-Z]+)?)$`)
+	documentNumberPattern = regexp.MustCompile(
+		`^(PNO|PAS|IDC)(EE|LV|LT|KZ)-[0-9A-Z]+(-[0-9A-Z]+)?-[0-9A-Z]+-[A-Z]$`)
+)
+
 // NewSemanticIdentifier creates new semantic identifier as string.
 func NewSemanticIdentifier(typ, country, id string) string {
 	semid := SemanticIdentifier{
@@ -52,3 +70,19 @@ type SemanticIdentifier struct {
 func (sd SemanticIdentifier) String() string {
 	return fmt.Sprintf("%v%v-%v", sd.Type, sd.Country, sd.ID)
 }
+
+// ParseSemanticIdentifier parses semantic identifier, e.g.
+// PNOEE-30303039914.
+func ParseSemanticIdentifier(s string) (SemanticIdentifier, error) {
+	m := semanticIdentifierPattern.FindStringSubmatch(s)
+	if m == nil {
+		return SemanticIdentifier{}, ErrSemanticIdentifierInvalid
+	}
+	return SemanticIdentifier{Type: m[1], Country: m[2], ID: m[3]}, nil
+}
+
+// IsValidDocumentNumber checks that s is well-formed document number of
+// Smart-ID account.
+func IsValidDocumentNumber(s string) bool {
+	return documentNumberPattern.MatchString(s)
+}
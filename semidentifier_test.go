@@ -41,3 +41,47 @@ func TestSemanticIdentifier_String(t *testing.T) {
 		}
 	}
 }
+
+func TestParseSemanticIdentifier(t *testing.T) {
+	testdata := []struct {
+		identifier string
+		valid      bool
+	}{
+		{"PNOEE-30303039914", true},
+		{"PNOLV-329999-99901", true},
+		{"IDCLV-030303-10012", true},
+		{"PASKZ-N1234567", true},
+		{"PNOFI-30303039914", false},
+		{"XXXEE-30303039914", false},
+		{"PNOEE-", false},
+		{"PNOEE-30303039914-MOCK-Q", false},
+		{"PNOEE-1/../x", false},
+		{"PNOEE-1?x=", false},
+		{"pnoee-30303039914", false},
+	}
+	for _, test := range testdata {
+		semid, err := ParseSemanticIdentifier(test.identifier)
+		if test.valid && (err != nil || semid.String() != test.identifier) {
+			t.Error(test.identifier, "expected", test.identifier, "got", semid, err)
+		}
+		if !test.valid && err != ErrSemanticIdentifierInvalid {
+			t.Error(test.identifier, "expected", ErrSemanticIdentifierInvalid, "got", err)
+		}
+	}
+}
+
+func TestIsValidDocumentNumber(t *testing.T) {
+	testdata := map[string]bool{
+		"PNOEE-30303039914-MOCK-Q":  true,
+		"PNOLV-329999-99901-AAAA-Q": true,
+		"PASEE-AB1234567-ABCD-Q":    true,
+		"PNOEE-30303039914":         false,
+		"PNOEE-30303039914-MOCK-Q/": false,
+		"PNOEE-30303039914-MOCK-q":  false,
+	}
+	for s, valid := range testdata {
+		if IsValidDocumentNumber(s) != valid {
+			t.Error(s, "expected", valid, "got", !valid)
+		}
+	}
+}
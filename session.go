@@ -2,7 +2,9 @@ package smartid
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 )
 
 // Session response status. There are only 2 statuses available for Smart-ID
@@ -28,6 +30,44 @@ const (
 	SessionResultRequiredInteractionNotSupportedByApp       = "REQUIRED_INTERACTION_NOT_SUPPORTED_BY_APP"
 )
 
+var (
+	// ErrUserRefused error when user refused the session in the app.
+	ErrUserRefused = errors.New("User refused")
+
+	// ErrSessionTimeout error when user did not respond in time.
+	ErrSessionTimeout = errors.New("Session timed out")
+
+	// ErrWrongVC error when user chose wrong verification code.
+	ErrWrongVC = errors.New("Wrong verification code chosen")
+)
+
+// SessionError is the error of session, which has completed without
+// success. It unwraps to ErrUserRefused, ErrSessionTimeout or ErrWrongVC,
+// if the result is one of them.
+type SessionError struct {
+	// Result is the end result code of the session.
+	Result string
+}
+
+// Error returns the end result code, as Validate always returned it.
+func (e *SessionError) Error() string {
+	return e.Result
+}
+
+// Unwrap returns the error of the result.
+func (e *SessionError) Unwrap() error {
+	switch {
+	case strings.HasPrefix(e.Result, "USER_REFUSED"):
+		return ErrUserRefused
+	case e.Result == SessionResultTimeout:
+		return ErrSessionTimeout
+	case e.Result == SessionResultWrongVC:
+		return ErrWrongVC
+	default:
+		return nil
+	}
+}
+
 // Session represents information about session.
 type Session struct {
 	// SessionID is the session identified in UUID format.
@@ -36,8 +76,20 @@ type Session struct {
 	// Hash for authentication and verification code.
 	hash AuthHash
 
+	// hashType of the hash for signature check.
+	hashType string
+
 	// certificateLevel for certificate level check.
 	certificateLevel string
+
+	// environment of the client for certificate issuer check.
+	environment string
+
+	// endpoint of the request for certificate usage check.
+	endpoint string
+
+	// onPoll is called with each status response.
+	onPoll func(resp *SessionResponse)
 }
 
 // getResponse makes response to session endpoint API. It also polls from
@@ -53,6 +105,9 @@ func (s Session) getResponse(ctx context.Context, c *Client) (*SessionResponse,
 		if err != nil {
 			return nil, err
 		}
+		if s.onPoll != nil {
+			s.onPoll(resp)
+		}
 
 		if resp.IsCompleted() {
 			return resp, nil
@@ -131,10 +186,11 @@ func (r *SessionResponse) Validate() (bool, error) {
 		return false, fmt.Errorf("Response is not completed")
 	}
 	if r.IsFailed() {
-		return false, fmt.Errorf(r.GetFailureReason())
+		return false, &SessionError{Result: r.GetFailureReason()}
 	}
-	if !r.IsValidSignature() {
-		return false, fmt.Errorf("Invalid signature")
+	// Certificate choice has no signature.
+	if r.endpoint != EndpointCertificateChoice && !r.IsValidSignature() {
+		return false, ErrSignatureInvalid
 	}
 	if r.Cert.IsExpired() {
 		return false, fmt.Errorf("Certificate has expired")
@@ -145,12 +201,34 @@ func (r *SessionResponse) Validate() (bool, error) {
 	if !r.Cert.IsSameLevel(r.certificateLevel) {
 		return false, fmt.Errorf("Certificate level does not match")
 	}
+	if r.environment == EnvironmentProduction && r.Cert.IsTestIssued() {
+		return false, ErrCertTestIssuer
+	}
+	switch r.endpoint {
+	case EndpointAuthentication:
+		if err := r.Cert.CheckAuthenticationUsage(); err != nil {
+			return false, err
+		}
+	case EndpointSignature, EndpointCertificateChoice:
+		if err := r.Cert.CheckSigningUsage(); err != nil {
+			return false, err
+		}
+	}
 	return true, nil
 }
 
-// IsValidSignature checks validity of the signature.
+// IsValidSignature checks validity of the signature. The signature is
+// verified with the hash type of the request, algorithm of the response
+// must match it.
 func (r *SessionResponse) IsValidSignature() bool {
-	return r.Signature.IsValid(r.Cert, r.hash)
+	h, err := hashFunc(r.hashType)
+	if err != nil {
+		return r.Signature.IsValid(r.Cert, r.hash)
+	}
+	if algo := r.Signature.resolveSignatureAlgo(); algo != 0 && algo != h {
+		return false
+	}
+	return r.Signature.verify(r.Cert, h, r.hash)
 }
 
 // IsCompleted checks that response has completed. If the return value is
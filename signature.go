@@ -1,9 +1,28 @@
 package smartid
 
 import (
+	"context"
 	"crypto"
+	"crypto/ecdsa"
 	"crypto/rsa"
+	"crypto/x509"
 	"encoding/base64"
+	"errors"
+	"math/big"
+)
+
+var (
+	// ErrSignatureInvalid error when signature value does not verify.
+	ErrSignatureInvalid = errors.New("Invalid signature")
+
+	// ErrSignatureKeyUnsupported error when public key type of the
+	// certificate is not supported.
+	ErrSignatureKeyUnsupported = errors.New("Unsupported public key type")
+
+	// ErrSignatureCertMismatch error when the signature is made with
+	// another certificate than prepared.
+	ErrSignatureCertMismatch = errors.New(
+		"Signature certificate does not match chosen certificate")
 )
 
 // Signature represents signature from session response.
@@ -17,6 +36,11 @@ type Signature struct {
 
 // IsValid checks the validity of signature.
 func (sig Signature) IsValid(c Cert, h AuthHash) bool {
+	return sig.verify(c, sig.resolveSignatureAlgo(), h)
+}
+
+// verify checks the signature of the hash made with the hash function.
+func (sig Signature) verify(c Cert, hf crypto.Hash, h AuthHash) bool {
 	decodedSig, err := base64.StdEncoding.DecodeString(sig.Value)
 	if err != nil {
 		return false
@@ -24,7 +48,7 @@ func (sig Signature) IsValid(c Cert, h AuthHash) bool {
 
 	c.createX509CertIfNeeded()
 	pubkey := c.x509Cert.PublicKey.(*rsa.PublicKey)
-	err = rsa.VerifyPKCS1v15(pubkey, sig.resolveSignatureAlgo(), h, decodedSig)
+	err = rsa.VerifyPKCS1v15(pubkey, hf, h, decodedSig)
 	if err != nil {
 		return false
 	}
@@ -41,7 +65,96 @@ func (sig Signature) resolveSignatureAlgo() crypto.Hash {
 		return crypto.SHA384
 	case "sha512WithRSAEncryption":
 		return crypto.SHA512
+	case "sha3-256WithRSAEncryption":
+		return crypto.SHA3_256
+	case "sha3-384WithRSAEncryption":
+		return crypto.SHA3_384
+	case "sha3-512WithRSAEncryption":
+		return crypto.SHA3_512
 	default:
 		return 0
 	}
 }
+
+// signatureScheme is the padding or encoding of the signature value,
+// declared by the signature algorithm.
+type signatureScheme int
+
+// Signature schemes.
+const (
+	schemePKCS1v15 signatureScheme = iota + 1 // RSASSA-PKCS1-v1_5
+	schemePSS                                 // RSASSA-PSS
+	schemeECDSA                               // ECDSA, ASN.1 encoded
+	schemeECDSARaw                            // ECDSA, r||s encoded
+)
+
+// signerScheme returns the scheme of signature values returned by Smart-ID
+// and other signers for the key of the certificate.
+func signerScheme(cert *x509.Certificate) signatureScheme {
+	if _, ok := cert.PublicKey.(*ecdsa.PublicKey); ok {
+		return schemeECDSARaw
+	}
+	return schemePKCS1v15
+}
+
+// verifySignatureValue verifies raw signature value returned by the signer
+// of the certificate, see signerScheme.
+func verifySignatureValue(cert *x509.Certificate, h crypto.Hash, digest, sig []byte) error {
+	return verifySignatureScheme(cert, signerScheme(cert), h, digest, sig)
+}
+
+// verifySignatureScheme verifies raw signature value of the digest with the
+// public key of the certificate. Only the declared scheme is accepted.
+func verifySignatureScheme(cert *x509.Certificate, scheme signatureScheme, h crypto.Hash, digest, sig []byte) error {
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		switch scheme {
+		case schemePKCS1v15:
+			return rsa.VerifyPKCS1v15(pub, h, digest, sig)
+		case schemePSS:
+			return rsa.VerifyPSS(pub, h, digest, sig, nil)
+		}
+		return ErrSignatureInvalid
+	case *ecdsa.PublicKey:
+		var ok bool
+		switch scheme {
+		case schemeECDSA:
+			ok = ecdsa.VerifyASN1(pub, digest, sig)
+		case schemeECDSARaw:
+			if size := (pub.Curve.Params().BitSize + 7) / 8; len(sig) == 2*size {
+				r := new(big.Int).SetBytes(sig[:size])
+				s := new(big.Int).SetBytes(sig[size:])
+				ok = ecdsa.Verify(pub, digest, r, s)
+			}
+		}
+		if !ok {
+			return ErrSignatureInvalid
+		}
+		return nil
+	default:
+		return ErrSignatureKeyUnsupported
+	}
+}
+
+// signDigest signs the digest with Smart-ID using SignSync. Response is
+// validated and the signer certificate must be the prepared one.
+func signDigest(
+	ctx context.Context,
+	c *Client,
+	req *AuthRequest,
+	digest *Digest,
+	cert *x509.Certificate,
+) (*SessionResponse, error) {
+	req.Digest = digest
+	resp, err := c.SignSync(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := resp.Validate(); err != nil {
+		return resp, err
+	}
+	if !resp.Cert.GetX509Cert().Equal(cert) {
+		return resp, ErrSignatureCertMismatch
+	}
+	return resp, nil
+}
@@ -2,6 +2,12 @@ package smartid
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
 	"io/ioutil"
 	"testing"
 )
@@ -34,6 +40,13 @@ func TestSignature_resolveSignatureAlgo(t *testing.T) {
 	if sig4.resolveSignatureAlgo() != 0 {
 		t.Error("expected", 0, "got", sig4.resolveSignatureAlgo())
 	}
+
+	sig5 := Signature{
+		Algorithm: "sha3-256WithRSAEncryption",
+	}
+	if sig5.resolveSignatureAlgo() != crypto.SHA3_256 {
+		t.Error("expected", crypto.SHA3_256, "got", sig5.resolveSignatureAlgo())
+	}
 }
 
 func TestSignature_IsValid(t *testing.T) {
@@ -60,3 +73,82 @@ func TestSignature_IsValid(t *testing.T) {
 		t.Error("Test fail signature should be invalid")
 	}
 }
+
+func TestVerifySignatureScheme(t *testing.T) {
+	digest := sha256.Sum256([]byte("Hello, Smart-ID!"))
+	rsaKey := testKey(t, "rsa")
+	rsaCert := &x509.Certificate{PublicKey: &rsaKey.PublicKey}
+	pkcs1, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	pss, _ := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest[:], nil)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecCert := &x509.Certificate{PublicKey: &ecKey.PublicKey}
+	der, _ := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	r, s, _ := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+	raw := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	padded := append(append([]byte{0}, raw[:32]...), append([]byte{0}, raw[32:]...)...)
+
+	testdata := []struct {
+		name   string
+		cert   *x509.Certificate
+		scheme signatureScheme
+		sig    []byte
+		ok     bool
+	}{
+		{"pkcs1", rsaCert, schemePKCS1v15, pkcs1, true},
+		{"pss", rsaCert, schemePSS, pss, true},
+		{"pss as pkcs1", rsaCert, schemePKCS1v15, pss, false},
+		{"pkcs1 as pss", rsaCert, schemePSS, pkcs1, false},
+		{"rsa as ecdsa", rsaCert, schemeECDSA, pkcs1, false},
+		{"unknown scheme", rsaCert, 0, pkcs1, false},
+		{"ecdsa", ecCert, schemeECDSA, der, true},
+		{"ecdsa raw", ecCert, schemeECDSARaw, raw, true},
+		{"raw as asn1", ecCert, schemeECDSA, raw, false},
+		{"asn1 as raw", ecCert, schemeECDSARaw, der, false},
+		{"raw padded", ecCert, schemeECDSARaw, padded, false},
+	}
+	for _, test := range testdata {
+		err := verifySignatureScheme(test.cert, test.scheme, crypto.SHA256, digest[:], test.sig)
+		if (err == nil) != test.ok {
+			t.Error(test.name, "expected valid", test.ok, "got", err)
+		}
+	}
+}
+
+func TestVerifySignatureValue(t *testing.T) {
+	digest := sha256.Sum256([]byte("Hello, Smart-ID!"))
+	rsaKey := testKey(t, "rsa")
+	rsaCert := &x509.Certificate{PublicKey: &rsaKey.PublicKey}
+	pkcs1, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	pss, _ := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest[:], nil)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecCert := &x509.Certificate{PublicKey: &ecKey.PublicKey}
+	der, _ := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	r, s, _ := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+	raw := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+
+	testdata := []struct {
+		name string
+		cert *x509.Certificate
+		sig  []byte
+		ok   bool
+	}{
+		{"pkcs1", rsaCert, pkcs1, true},
+		{"pss", rsaCert, pss, false},
+		{"ecdsa raw", ecCert, raw, true},
+		{"ecdsa asn1", ecCert, der, false},
+	}
+	for _, test := range testdata {
+		err := verifySignatureValue(test.cert, crypto.SHA256, digest[:], test.sig)
+		if (err == nil) != test.ok {
+			t.Error(test.name, "expected valid", test.ok, "got", err)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package smartid
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+)
+
+// DocumentBuilder builds signed document in two phases. Data to be signed
+// depends on the signer certificate, so it is prepared after certificate
+// choice. The signed artifact is built from the signature value.
+type DocumentBuilder interface {
+	// Prepare builds data to be signed for the signer certificate and
+	// returns its digest, which is signed with Smart-ID.
+	Prepare(cert *x509.Certificate) (*Digest, error)
+
+	// Finish sets the signature value and returns the signed artifact.
+	// If timestamper is not nil, signature timestamp is added.
+	Finish(ctx context.Context, sig Signature, ts Timestamper) ([]byte, error)
+}
+
+// Signer signs documents with Smart-ID: chooses the signing certificate,
+// prepares data to be signed and signs it by document number of the
+// chosen certificate.
+type Signer struct {
+	client *Client
+
+	// Timestamper adds signature timestamp to the documents, optional.
+	Timestamper Timestamper
+}
+
+// NewSigner creates signer, which uses the client.
+func NewSigner(c *Client) *Signer {
+	return &Signer{client: c}
+}
+
+// Sign signs the document for the person. Request must have the
+// identifier of the person, other fields like certificate level and
+// interactions are used in both certificate choice and signing. Signature
+// certificate is checked to be the chosen certificate.
+func (s *Signer) Sign(ctx context.Context, req *AuthRequest, b DocumentBuilder) ([]byte, *SessionResponse, error) {
+	choiceReq := *req
+	choiceReq.Hash, choiceReq.HashType, choiceReq.Digest = nil, "", nil
+	choice, err := s.client.ChooseCertificateSync(ctx, &choiceReq)
+	if err != nil {
+		return nil, choice, err
+	}
+	if _, err := choice.Validate(); err != nil {
+		return nil, choice, err
+	}
+	cert := choice.Cert.GetX509Cert()
+	if cert == nil {
+		return nil, choice, ErrSignerCertNotFound
+	}
+
+	digest, err := b.Prepare(cert)
+	if err != nil {
+		return nil, choice, err
+	}
+	signReq := choiceReq
+	signReq.Identifier = choice.Result.DocumentNumber
+	signReq.AuthType = AuthTypeDocument
+	resp, err := signDigest(ctx, s.client, &signReq, digest, cert)
+	if err != nil {
+		return nil, resp, err
+	}
+	artifact, err := b.Finish(ctx, resp.Signature, s.Timestamper)
+	if err != nil {
+		return nil, resp, err
+	}
+	return artifact, resp, nil
+}
+
+// pdfDocumentBuilder signs PDF document.
+type pdfDocumentBuilder struct {
+	pdf  []byte
+	algo string
+	opts *PDFSignatureOptions
+	sig  *PDFSignature
+}
+
+// NewPDFDocumentBuilder creates builder of PAdES signature. The artifact
+// is the signed PDF.
+func NewPDFDocumentBuilder(pdf []byte, algo string, opts *PDFSignatureOptions) DocumentBuilder {
+	return &pdfDocumentBuilder{pdf: pdf, algo: algo, opts: opts}
+}
+
+func (b *pdfDocumentBuilder) Prepare(cert *x509.Certificate) (*Digest, error) {
+	var err error
+	if b.sig, err = PreparePDFSignature(b.pdf, cert, b.algo, b.opts); err != nil {
+		return nil, err
+	}
+	return b.sig.Digest(), nil
+}
+
+func (b *pdfDocumentBuilder) Finish(ctx context.Context, sig Signature, ts Timestamper) ([]byte, error) {
+	if err := b.sig.SetSignatureValue(sig); err != nil {
+		return nil, err
+	}
+	if ts != nil {
+		if err := b.sig.AddTimestamp(ctx, ts); err != nil {
+			return nil, err
+		}
+	}
+	return b.sig.Bytes()
+}
+
+// cadesDocumentBuilder signs detached content with CAdES.
+type cadesDocumentBuilder struct {
+	digest *Digest
+	chain  []*x509.Certificate
+	sig    *CAdESSignature
+}
+
+// NewCAdESDocumentBuilder creates builder of detached CAdES signature
+// over the content digest. The artifact is DER encoded .p7s signature.
+func NewCAdESDocumentBuilder(contentDigest *Digest, chain []*x509.Certificate) DocumentBuilder {
+	return &cadesDocumentBuilder{digest: contentDigest, chain: chain}
+}
+
+func (b *cadesDocumentBuilder) Prepare(cert *x509.Certificate) (*Digest, error) {
+	var err error
+	if b.sig, err = PrepareCAdESSignature(b.digest, cert, b.chain); err != nil {
+		return nil, err
+	}
+	return b.sig.Digest(), nil
+}
+
+func (b *cadesDocumentBuilder) Finish(ctx context.Context, sig Signature, ts Timestamper) ([]byte, error) {
+	if err := b.sig.SetSignatureValue(sig); err != nil {
+		return nil, err
+	}
+	if ts != nil {
+		if err := b.sig.AddTimestamp(ctx, ts); err != nil {
+			return nil, err
+		}
+	}
+	return b.sig.Bytes()
+}
+
+// asiceDocumentBuilder signs ASiC-E container.
+type asiceDocumentBuilder struct {
+	container *ASiCEBuilder
+	algo      string
+	sig       *XAdESSignature
+}
+
+// NewASiCEDocumentBuilder creates builder of XAdES signature of the
+// container. The artifact is the signed container.
+func NewASiCEDocumentBuilder(container *ASiCEBuilder, algo string) DocumentBuilder {
+	return &asiceDocumentBuilder{container: container, algo: algo}
+}
+
+func (b *asiceDocumentBuilder) Prepare(cert *x509.Certificate) (*Digest, error) {
+	var err error
+	if b.sig, err = b.container.PrepareSignature(cert, b.algo); err != nil {
+		return nil, err
+	}
+	return b.sig.Digest(), nil
+}
+
+func (b *asiceDocumentBuilder) Finish(ctx context.Context, sig Signature, ts Timestamper) ([]byte, error) {
+	if err := b.sig.SetSignatureValue(sig); err != nil {
+		return nil, err
+	}
+	if ts != nil {
+		if err := b.sig.AddTimestamp(ctx, ts); err != nil {
+			return nil, err
+		}
+	}
+	var buf bytes.Buffer
+	if err := b.container.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// xmlDocumentBuilder signs XML document with enveloped signature.
+type xmlDocumentBuilder struct {
+	doc  []byte
+	algo string
+	sig  *XAdESSignature
+}
+
+// NewXMLDocumentBuilder creates builder of enveloped XAdES signature. The
+// artifact is the signed XML document.
+func NewXMLDocumentBuilder(doc []byte, algo string) DocumentBuilder {
+	return &xmlDocumentBuilder{doc: doc, algo: algo}
+}
+
+func (b *xmlDocumentBuilder) Prepare(cert *x509.Certificate) (*Digest, error) {
+	var err error
+	if b.sig, err = PrepareXMLSignature(b.doc, cert, b.algo); err != nil {
+		return nil, err
+	}
+	return b.sig.Digest(), nil
+}
+
+func (b *xmlDocumentBuilder) Finish(ctx context.Context, sig Signature, ts Timestamper) ([]byte, error) {
+	if err := b.sig.SetSignatureValue(sig); err != nil {
+		return nil, err
+	}
+	if ts != nil {
+		if err := b.sig.AddTimestamp(ctx, ts); err != nil {
+			return nil, err
+		}
+	}
+	return b.sig.Bytes()
+}
@@ -0,0 +1,131 @@
+package smartid
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"testing"
+)
+
+// swapCertBuilder changes the signing certificate of the mock service
+// after certificate choice.
+type swapCertBuilder struct {
+	DocumentBuilder
+	mock *mockSmartID
+	cert *x509.Certificate
+}
+
+func (b *swapCertBuilder) Prepare(cert *x509.Certificate) (*Digest, error) {
+	b.mock.signCert = b.cert
+	return b.DocumentBuilder.Prepare(cert)
+}
+
+func TestSigner_Sign(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+	signer := NewSigner(mock.client())
+	signer.Timestamper = newTestTSA(t, mock.ca, mock.caKey)
+	req := &AuthRequest{Identifier: "PNOEE-30303039914"}
+
+	content := []byte("Hello, Smart-ID!")
+	digest, _ := HashData(content, SHA256)
+	p7s, resp, err := signer.Sign(ctx, req, NewCAdESDocumentBuilder(digest, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Cert.GetX509Cert().Equal(mock.signCert) {
+		t.Error("expected signing certificate in response")
+	}
+	if len(mock.requests) != 2 {
+		t.Fatal("expected", 2, "got", len(mock.requests))
+	}
+	if _, ok := mock.requests[0]["hash"]; ok {
+		t.Error("expected no hash in certificate choice request")
+	}
+	if req.Identifier != "PNOEE-30303039914" || req.Digest != nil {
+		t.Error("expected request not to be modified, got", req.Identifier, req.Digest)
+	}
+	r := VerifyCAdES(ctx, p7s, content, ts)
+	if !r.IsValid() || len(r.Timestamps) != 1 {
+		t.Error("expected valid signature with timestamp, got", r.Err())
+	}
+
+	pdf, _, err := signer.Sign(ctx, req, NewPDFDocumentBuilder(testPDF(t, false), SHA256, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := ValidatePDF(ctx, pdf, ts)
+	if err != nil || len(results) != 1 || !results[0].IsValid() {
+		t.Error("expected valid PDF signature, got", err, results)
+	}
+
+	b := NewASiCEBuilder()
+	b.AddFile("test.txt", "text/plain", content)
+	asice, _, err := signer.Sign(ctx, req, NewASiCEDocumentBuilder(b, SHA256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := readTestASiCE(t, asice)
+	if r := container.Validate(ctx, ts); len(r) != 1 || len(r[0].Timestamps) != 1 {
+		t.Error("expected signature with timestamp, got", r)
+	}
+}
+
+func TestSigner_Sign_certMismatch(t *testing.T) {
+	mock := newMockSmartID(t)
+	other, _ := newTestLeaf(t, signTemplate(), mock.ca, mock.caKey)
+	digest, _ := HashData([]byte("Hello, Smart-ID!"), SHA256)
+	b := &swapCertBuilder{
+		DocumentBuilder: NewCAdESDocumentBuilder(digest, nil),
+		mock:            mock,
+		cert:            other,
+	}
+	_, _, err := NewSigner(mock.client()).Sign(context.Background(),
+		&AuthRequest{Identifier: "PNOEE-30303039914"}, b)
+	if err != ErrSignatureCertMismatch {
+		t.Error("expected", ErrSignatureCertMismatch, "got", err)
+	}
+}
+
+func TestSigner_Sign_refused(t *testing.T) {
+	mock := newMockSmartID(t)
+	digest, _ := HashData([]byte("Hello, Smart-ID!"), SHA256)
+	_, _, err := NewSigner(mock.client()).Sign(context.Background(),
+		&AuthRequest{Identifier: "PNOEE-REFUSED"}, NewCAdESDocumentBuilder(digest, nil))
+	if !errors.Is(err, ErrUserRefused) {
+		t.Error("expected", ErrUserRefused, "got", err)
+	}
+	if len(mock.requests) != 1 {
+		t.Error("expected", 1, "got", len(mock.requests))
+	}
+
+	// Signature session is refused after certificate choice.
+	mock.refuseSignatures = true
+	_, _, err = NewSigner(mock.client()).Sign(context.Background(),
+		&AuthRequest{Identifier: "PNOEE-30303039914"}, NewCAdESDocumentBuilder(digest, nil))
+	var sessionErr *SessionError
+	if !errors.As(err, &sessionErr) || !errors.Is(err, ErrUserRefused) ||
+		sessionErr.Result != SessionResultUserRefusedDisplayTextAndPIN {
+		t.Error("expected", ErrUserRefused, "got", err)
+	}
+}
+
+func TestSigner_Sign_xml(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+
+	doc, _, err := NewSigner(mock.client()).Sign(ctx,
+		&AuthRequest{Identifier: "PNOEE-30303039914"},
+		NewXMLDocumentBuilder([]byte(testInvoice), SHA512))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := VerifyXMLSignature(ctx, doc, ts)
+	if err != nil || !results[0].IsValid() {
+		t.Error("expected valid signature, got", err, results)
+	}
+}
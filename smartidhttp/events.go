@@ -0,0 +1,226 @@
+package smartidhttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dknight/go-smartid"
+)
+
+// Lifecycle events of the login session.
+const (
+	EventCreated   = "created"
+	EventRunning   = "running"
+	EventCompleted = "completed"
+	EventRefused   = "refused"
+	EventTimeout   = "timeout"
+	EventFailed    = "failed"
+)
+
+// websocketGUID is the GUID of the WebSocket accept key (RFC 6455).
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// writeTimeout limits writing of a WebSocket frame.
+const writeTimeout = 10 * time.Second
+
+// ErrWebSocketHandshake error when WebSocket upgrade request is invalid.
+var ErrWebSocketHandshake = errors.New("Invalid WebSocket handshake")
+
+// Event is the lifecycle event of the login session. Created event has
+// the verification code, final events have the end result of the session,
+// ERROR if the session request failed. Running event is sent on each poll
+// of the session status.
+type Event struct {
+	Type             string `json:"type"`
+	VerificationCode string `json:"verificationCode,omitempty"`
+	Result           string `json:"result,omitempty"`
+}
+
+// IsFinal reports whether the event is the last one of the session.
+func (e Event) IsFinal() bool {
+	return e.Type != EventCreated && e.Type != EventRunning
+}
+
+// completedEvent returns the final event of the session.
+func completedEvent(resp *smartid.SessionResponse, err error) Event {
+	if err != nil {
+		return Event{Type: EventFailed, Result: "ERROR"}
+	}
+	result := resp.GetFailureReason()
+	switch {
+	case result == smartid.SessionResultOK:
+		return Event{Type: EventCompleted, Result: result}
+	case strings.HasPrefix(result, "USER_REFUSED"):
+		return Event{Type: EventRefused, Result: result}
+	case result == smartid.SessionResultTimeout:
+		return Event{Type: EventTimeout, Result: result}
+	default:
+		return Event{Type: EventFailed, Result: result}
+	}
+}
+
+// publish publishes the event of the login, lock must be held.
+func (h *Handler) publish(l *login, e Event) {
+	l.events = append(l.events, e)
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// Events streams the lifecycle events of the login of token query
+// parameter. Events published before are sent first and the stream ends
+// with the final event. WebSocket is used if the request asks for the
+// upgrade, Server-Sent Events otherwise.
+func (h *Handler) Events(w http.ResponseWriter, r *http.Request) {
+	l, ok := h.login(r, r.URL.Query().Get("token"))
+	if !ok {
+		h.error(w, r, ErrTokenInvalid)
+		return
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		h.serveWebSocket(w, r, l)
+		return
+	}
+	h.serveSSE(w, r, l)
+}
+
+// stream sends the events of the login until the final event.
+func (h *Handler) stream(ctx context.Context, l *login, send func(Event) error) error {
+	for next := 0; ; {
+		h.mu.Lock()
+		events, notify := l.events[next:], l.notify
+		h.mu.Unlock()
+		for _, e := range events {
+			if err := send(e); err != nil {
+				return err
+			}
+			if e.IsFinal() {
+				return nil
+			}
+		}
+		next += len(events)
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// serveSSE streams events as Server-Sent Events. Event name is the event
+// type, data is the JSON encoded event.
+func (h *Handler) serveSSE(w http.ResponseWriter, r *http.Request, l *login) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	h.stream(r.Context(), l, func(e Event) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	})
+}
+
+// serveWebSocket streams events as JSON text messages of WebSocket and
+// closes the connection after the final event.
+func (h *Handler) serveWebSocket(w http.ResponseWriter, r *http.Request, l *login) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" ||
+		!headerContains(r.Header, "Connection", "upgrade") || !sameOrigin(r) {
+		h.error(w, r, ErrWebSocketHandshake)
+		return
+	}
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	defer conn.Close()
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	if err := brw.Flush(); err != nil {
+		return
+	}
+
+	// Messages of the client are not used, reading detects the close.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		io.Copy(io.Discard, brw.Reader)
+		cancel()
+	}()
+
+	send := func(opcode byte, payload []byte) error {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		return writeFrame(brw.Writer, opcode, payload)
+	}
+	err = h.stream(ctx, l, func(e Event) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return send(0x1, data)
+	})
+	if err == nil {
+		// Normal closure.
+		send(0x8, []byte{0x03, 0xe8})
+	}
+}
+
+// writeFrame writes unmasked WebSocket frame of the server.
+func writeFrame(w *bufio.Writer, opcode byte, payload []byte) error {
+	w.WriteByte(0x80 | opcode)
+	switch n := len(payload); {
+	case n < 126:
+		w.WriteByte(byte(n))
+	case n <= 0xffff:
+		w.WriteByte(126)
+		binary.Write(w, binary.BigEndian, uint16(n))
+	default:
+		w.WriteByte(127)
+		binary.Write(w, binary.BigEndian, uint64(n))
+	}
+	w.Write(payload)
+	return w.Flush()
+}
+
+// headerContains reports whether comma separated header has the token.
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameOrigin reports whether Origin of the request, if any, is the host of
+// the request. Browsers send cookies with cross-site WebSocket handshakes.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
@@ -0,0 +1,172 @@
+package smartidhttp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dknight/go-smartid"
+	"github.com/dknight/go-smartid/internal/smartidtest"
+)
+
+// startTestLogin starts login in the browser and returns the token.
+func startTestLogin(t *testing.T, browser *testBrowser) string {
+	t.Helper()
+	var start StartResponse
+	status, body := browser.post("/login/start", url.Values{"identifier": {smartidtest.Identifier}}, &start)
+	if status != http.StatusOK {
+		t.Fatal("expected", http.StatusOK, "got", status, body)
+	}
+	return start.Token
+}
+
+func TestHandler_Events_sse(t *testing.T) {
+	mock := smartidtest.NewServer(t)
+	mock.Running = 2
+	_, browser := newTestApp(t, mock, make(chan *smartid.Identity, 1))
+	token := startTestLogin(t, browser)
+
+	status, body := browser.get("/login/events?token="+token, nil)
+	if status != http.StatusOK {
+		t.Fatal("expected", http.StatusOK, "got", status, body)
+	}
+	var types []string
+	for _, line := range strings.Split(body, "\n") {
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			types = append(types, name)
+		}
+	}
+	expected := []string{EventCreated, EventRunning, EventRunning, EventCompleted}
+	if strings.Join(types, ",") != strings.Join(expected, ",") {
+		t.Error("expected", expected, "got", types)
+	}
+	if !strings.Contains(body, `data: {"type":"completed","result":"OK"}`) {
+		t.Error("expected completed event data, got", body)
+	}
+
+	other := newTestBrowser(t, browser.url)
+	if status, _ := other.get("/login/events?token="+token, nil); status != http.StatusNotFound {
+		t.Error("expected", http.StatusNotFound, "got", status)
+	}
+}
+
+func TestHandler_Events_webSocket(t *testing.T) {
+	mock := smartidtest.NewServer(t)
+	_, browser := newTestApp(t, mock, make(chan *smartid.Identity, 1))
+	token := startTestLogin(t, browser)
+	u, _ := url.Parse(browser.url)
+
+	conn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req, _ := http.NewRequest(http.MethodGet, browser.url+"/login/events?token="+token, nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	for _, c := range browser.client.Jar.Cookies(u) {
+		req.AddCookie(c)
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatal("expected WebSocket upgrade, got", resp.Status, resp.Header)
+	}
+
+	var events []Event
+	for {
+		opcode, payload := readTestFrame(t, r)
+		if opcode == 0x8 {
+			if binary.BigEndian.Uint16(payload) != 1000 {
+				t.Error("expected normal closure, got", payload)
+			}
+			break
+		}
+		var e Event
+		if err := json.Unmarshal(payload, &e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+	if len(events) != 2 || events[0].Type != EventCreated || events[0].VerificationCode == "" ||
+		events[1].Type != EventCompleted {
+		t.Error("expected created and completed events, got", events)
+	}
+}
+
+// readTestFrame reads unmasked WebSocket frame.
+func readTestFrame(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		t.Fatal(err)
+	}
+	n := uint64(header[1] & 0x7f)
+	switch n {
+	case 126:
+		var n16 uint16
+		binary.Read(r, binary.BigEndian, &n16)
+		n = uint64(n16)
+	case 127:
+		binary.Read(r, binary.BigEndian, &n)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0f, payload
+}
+
+func TestHandler_Events_crossOrigin(t *testing.T) {
+	mock := smartidtest.NewServer(t)
+	_, browser := newTestApp(t, mock, make(chan *smartid.Identity, 1))
+	token := startTestLogin(t, browser)
+
+	req, _ := http.NewRequest(http.MethodGet, browser.url+"/login/events?token="+token, nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Origin", "https://evil.example.com")
+	if status, _ := browser.do(req, nil); status != http.StatusBadRequest {
+		t.Error("expected", http.StatusBadRequest, "got", status)
+	}
+}
+
+func TestCompletedEvent(t *testing.T) {
+	testdata := []struct {
+		result string
+		err    error
+		event  string
+	}{
+		{smartid.SessionResultOK, nil, EventCompleted},
+		{smartid.SessionResultUserRefusedCertChoice, nil, EventRefused},
+		{smartid.SessionResultTimeout, nil, EventTimeout},
+		{smartid.SessionResultWrongVC, nil, EventFailed},
+		{"", errors.New("network"), EventFailed},
+	}
+	for _, test := range testdata {
+		resp := &smartid.SessionResponse{Result: smartid.Result{EndResult: test.result}}
+		e := completedEvent(resp, test.err)
+		if e.Type != test.event || !e.IsFinal() {
+			t.Error(fmt.Sprint(test.result, test.err), "expected", test.event, "got", e.Type)
+		}
+	}
+}
@@ -0,0 +1,516 @@
+// Package smartidhttp provides net/http handlers of the Smart-ID login
+// flow: start the authentication session, show the verification code,
+// poll the status or stream the session events, and complete the login.
+//
+// Handlers are bound to the browser with the session cookie: login token
+// is usable only with the cookie of the browser which started the login.
+// POST requests must have the CSRF token of the cookie in X-CSRF-Token
+// header or csrf_token form field, get it with CSRFToken when rendering
+// the login page.
+package smartidhttp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dknight/go-smartid"
+	"github.com/dknight/go-smartid/internal/httputil"
+)
+
+// Defaults of the handler.
+const (
+	DefaultCookieName = "smartid_session"
+	DefaultTimeout    = 3 * time.Minute
+	DefaultMaxWait    = 30 * time.Second
+)
+
+// maxLogins limits the running logins. When full, login which expires
+// first is cancelled.
+const maxLogins = 1024
+
+// Login states of the status response.
+const (
+	StateRunning  = smartid.SessionStatusRunning
+	StateComplete = smartid.SessionStatusComplete
+)
+
+var (
+	// ErrCSRF error when CSRF token is missing or invalid.
+	ErrCSRF = errors.New("Invalid CSRF token")
+
+	// ErrTokenInvalid error when login token is unknown, expired or
+	// belongs to other browser session.
+	ErrTokenInvalid = errors.New("Invalid login token")
+
+	// ErrIdentifierRequired error when identifier is missing or invalid.
+	ErrIdentifierRequired = errors.New("Identifier is required")
+
+	// ErrLoginRunning error when login is completed before the session.
+	ErrLoginRunning = errors.New("Login is not completed")
+
+	// ErrAuthenticationFailed error when the session response or the
+	// certificate is not valid.
+	ErrAuthenticationFailed = errors.New("Authentication failed")
+
+	// ErrIdentityMismatch error when the authenticated person is not the
+	// one identified by the identifier.
+	ErrIdentityMismatch = errors.New("Identity does not match the identifier")
+)
+
+// StartResponse is the response of start handler.
+type StartResponse struct {
+	Token            string `json:"token"`
+	VerificationCode string `json:"verificationCode"`
+}
+
+// StatusResponse is the response of status handler. Result is the end
+// result of the completed session, ERROR if the session request failed.
+type StatusResponse struct {
+	State  string `json:"state"`
+	Result string `json:"result,omitempty"`
+}
+
+// Handler serves the login flow. Mount it with http.StripPrefix, the
+// handlers are at the relative paths:
+//
+//	POST /start     identifier → StartResponse
+//	GET  /status    token, optional wait in seconds → StatusResponse
+//	GET  /events    token → Event stream, SSE or WebSocket
+//	POST /complete  token → OnSuccess
+type Handler struct {
+	// TrustStore verifies the authentication certificate, if set.
+	TrustStore *smartid.TrustStore
+
+	// Timeout limits the Smart-ID session and lifetime of the login
+	// token, DefaultTimeout if zero.
+	Timeout time.Duration
+
+	// MaxWait limits the long-poll wait of status, DefaultMaxWait if zero.
+	MaxWait time.Duration
+
+	// CookieName is the name of the browser session cookie,
+	// DefaultCookieName if empty.
+	CookieName string
+
+	// InsecureCookie allows the browser session cookie over plain HTTP,
+	// for local development. Cookie is Secure by default, as TLS is often
+	// terminated by the proxy in front of the handler.
+	InsecureCookie bool
+
+	// OnError writes the error response, JSON error by default.
+	OnError func(w http.ResponseWriter, r *http.Request, err error)
+
+	client    *smartid.Client
+	req       smartid.AuthRequest
+	onSuccess func(w http.ResponseWriter, r *http.Request, identity *smartid.Identity)
+	key       []byte
+	mux       *http.ServeMux
+
+	mu     sync.Mutex
+	logins map[string]*login
+}
+
+// login is the authentication session started by the browser.
+type login struct {
+	binding    string
+	identifier string
+	digest     *smartid.Digest
+	expires    time.Time
+	cancel     context.CancelFunc
+
+	// done is closed when the session has completed.
+	done chan struct{}
+	resp *smartid.SessionResponse
+	err  error
+
+	// events are the lifecycle events published so far, notify is closed
+	// and replaced on publish.
+	events []Event
+	notify chan struct{}
+}
+
+// New creates the handler, which authenticates with the client. Request
+// is the template of the authentication requests: relying party,
+// certificate level, interactions and the type of identifier. OnSuccess
+// is called on completion with the validated identity to create the login
+// session of the application.
+func New(
+	c *smartid.Client,
+	req *smartid.AuthRequest,
+	onSuccess func(w http.ResponseWriter, r *http.Request, identity *smartid.Identity),
+) *Handler {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	h := &Handler{
+		client:    c,
+		req:       *req,
+		onSuccess: onSuccess,
+		key:       key,
+		mux:       http.NewServeMux(),
+		logins:    make(map[string]*login),
+	}
+	h.mux.HandleFunc("POST /start", h.Start)
+	h.mux.HandleFunc("GET /status", h.Status)
+	h.mux.HandleFunc("GET /events", h.Events)
+	h.mux.HandleFunc("POST /complete", h.Complete)
+	return h
+}
+
+// ServeHTTP serves start, status, events and complete handlers.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// CSRFToken returns CSRF token of the browser session, setting the session
+// cookie if needed. Embed it in the login page.
+func (h *Handler) CSRFToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	binding, err := h.binding(w, r)
+	if err != nil {
+		return "", err
+	}
+	return h.csrfToken(binding), nil
+}
+
+// Binding returns the browser session of the request, setting the session
+// cookie if needed. Use it to bind the state of the login page to the
+// browser, like login tokens are bound.
+func (h *Handler) Binding(w http.ResponseWriter, r *http.Request) (string, error) {
+	return h.binding(w, r)
+}
+
+// Start starts the authentication session of the identifier form value
+// and responds with the login token and the verification code. Running
+// login of the browser session is cancelled, its token becomes invalid.
+func (h *Handler) Start(w http.ResponseWriter, r *http.Request) {
+	binding, ok := h.checkCSRF(r)
+	if !ok {
+		h.error(w, r, ErrCSRF)
+		return
+	}
+	identifier := r.FormValue("identifier")
+	if !h.validIdentifier(identifier) {
+		h.error(w, r, ErrIdentifierRequired)
+		return
+	}
+	digest, err := smartid.GenerateDigest(smartid.SHA512)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	token, err := httputil.RandomString()
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout())
+	l := &login{
+		binding:    binding,
+		identifier: identifier,
+		digest:     digest,
+		expires:    time.Now().Add(h.timeout()),
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		notify:     make(chan struct{}),
+	}
+	h.mu.Lock()
+	h.removeExpired()
+	h.removeBinding(binding)
+	h.evict()
+	h.logins[token] = l
+	h.publish(l, Event{
+		Type:             EventCreated,
+		VerificationCode: digest.CalculateVerificationCode(),
+	})
+	h.mu.Unlock()
+
+	req := h.req
+	req.Identifier = identifier
+	req.Digest = digest
+	req.OnPoll = func(resp *smartid.SessionResponse) {
+		if resp.State == smartid.SessionStatusRunning {
+			h.mu.Lock()
+			h.publish(l, Event{Type: EventRunning})
+			h.mu.Unlock()
+		}
+	}
+	go func() {
+		defer cancel()
+		resp, err := h.client.AuthenticateSync(ctx, &req)
+		h.mu.Lock()
+		l.resp, l.err = resp, err
+		h.publish(l, completedEvent(resp, err))
+		h.mu.Unlock()
+		close(l.done)
+	}()
+
+	httputil.WriteJSON(w, http.StatusOK, StartResponse{
+		Token:            token,
+		VerificationCode: digest.CalculateVerificationCode(),
+	})
+}
+
+// Status responds with the state of the login. With wait query parameter
+// it waits up to the given seconds for the session to complete.
+func (h *Handler) Status(w http.ResponseWriter, r *http.Request) {
+	l, ok := h.login(r, r.URL.Query().Get("token"))
+	if !ok {
+		h.error(w, r, ErrTokenInvalid)
+		return
+	}
+	if wait, _ := strconv.Atoi(r.URL.Query().Get("wait")); wait > 0 {
+		d := min(time.Duration(wait)*time.Second, h.maxWait())
+		timer := time.NewTimer(d)
+		select {
+		case <-l.done:
+		case <-timer.C:
+		case <-r.Context().Done():
+		}
+		timer.Stop()
+	}
+	httputil.WriteJSON(w, http.StatusOK, h.status(l))
+}
+
+// Complete validates the completed session of the token form value and
+// calls OnSuccess with the identity. Response must match the generated
+// hash, and the certificate must belong to the person identified by the
+// identifier. Token can be completed once.
+func (h *Handler) Complete(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.checkCSRF(r); !ok {
+		h.error(w, r, ErrCSRF)
+		return
+	}
+	token := r.FormValue("token")
+	l, ok := h.login(r, token)
+	if !ok {
+		h.error(w, r, ErrTokenInvalid)
+		return
+	}
+	select {
+	case <-l.done:
+	default:
+		h.error(w, r, ErrLoginRunning)
+		return
+	}
+	h.mu.Lock()
+	delete(h.logins, token)
+	h.mu.Unlock()
+
+	identity, err := h.validate(r.Context(), l)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	h.onSuccess(w, r, identity)
+}
+
+// validate validates the session response and binds the identity to the
+// identifier.
+func (h *Handler) validate(ctx context.Context, l *login) (*smartid.Identity, error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	if _, err := l.resp.Validate(); err != nil {
+		var sessionErr *smartid.SessionError
+		if errors.As(err, &sessionErr) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrAuthenticationFailed, err)
+	}
+	identity := l.resp.GetIdentity()
+	switch h.req.AuthType {
+	case smartid.AuthTypeDocument:
+		if l.resp.Result.DocumentNumber != l.identifier {
+			return nil, ErrIdentityMismatch
+		}
+	default:
+		if identity.SerialNumber != l.identifier {
+			return nil, ErrIdentityMismatch
+		}
+	}
+	if h.TrustStore != nil {
+		if _, err := l.resp.Cert.VerifyWithTrustStore(ctx, h.TrustStore); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrAuthenticationFailed, err)
+		}
+	}
+	return identity, nil
+}
+
+// status returns the status of the login.
+func (h *Handler) status(l *login) StatusResponse {
+	select {
+	case <-l.done:
+	default:
+		return StatusResponse{State: StateRunning}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if l.err != nil {
+		return StatusResponse{State: StateComplete, Result: "ERROR"}
+	}
+	return StatusResponse{State: StateComplete, Result: l.resp.GetFailureReason()}
+}
+
+// login returns the login of the token, if it belongs to the browser
+// session of the request.
+func (h *Handler) login(r *http.Request, token string) (*login, bool) {
+	c, err := r.Cookie(h.cookieName())
+	if err != nil {
+		return nil, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	l, ok := h.logins[token]
+	if !ok || time.Now().After(l.expires) ||
+		!hmac.Equal([]byte(l.binding), []byte(c.Value)) {
+		return nil, false
+	}
+	return l, true
+}
+
+// removeExpired removes and cancels expired logins, lock must be held.
+func (h *Handler) removeExpired() {
+	for _, l := range httputil.RemoveExpired(h.logins, loginExpires) {
+		l.cancel()
+	}
+}
+
+// removeBinding removes and cancels the login of the browser session, as
+// browser has one running login, lock must be held.
+func (h *Handler) removeBinding(binding string) {
+	for token, l := range h.logins {
+		if hmac.Equal([]byte(l.binding), []byte(binding)) {
+			l.cancel()
+			delete(h.logins, token)
+		}
+	}
+}
+
+// evict removes and cancels logins which expire first until there is room
+// for one more, lock must be held.
+func (h *Handler) evict() {
+	for _, l := range httputil.Evict(h.logins, maxLogins, loginExpires) {
+		l.cancel()
+	}
+}
+
+// loginExpires returns expiration time of the login.
+func loginExpires(l *login) time.Time {
+	return l.expires
+}
+
+// binding returns the browser session cookie value, a new cookie is set if
+// the request has none.
+func (h *Handler) binding(w http.ResponseWriter, r *http.Request) (string, error) {
+	if c, err := r.Cookie(h.cookieName()); err == nil && c.Value != "" {
+		return c.Value, nil
+	}
+	value, err := httputil.RandomString()
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.cookieName(),
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   !h.InsecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+	// Later calls in the same request see the new cookie.
+	r.AddCookie(&http.Cookie{Name: h.cookieName(), Value: value})
+	return value, nil
+}
+
+// checkCSRF checks CSRF token of the request and returns the browser
+// session cookie value.
+func (h *Handler) checkCSRF(r *http.Request) (string, bool) {
+	c, err := r.Cookie(h.cookieName())
+	if err != nil || c.Value == "" {
+		return "", false
+	}
+	token := r.Header.Get("X-CSRF-Token")
+	if token == "" {
+		token = r.FormValue("csrf_token")
+	}
+	return c.Value, hmac.Equal([]byte(token), []byte(h.csrfToken(c.Value)))
+}
+
+// csrfToken returns CSRF token of the browser session.
+func (h *Handler) csrfToken(binding string) string {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(binding))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// error writes the error response.
+func (h *Handler) error(w http.ResponseWriter, r *http.Request, err error) {
+	if h.OnError != nil {
+		h.OnError(w, r, err)
+		return
+	}
+	httputil.WriteJSON(w, StatusCode(err), map[string]string{"error": err.Error()})
+}
+
+func (h *Handler) timeout() time.Duration {
+	if h.Timeout > 0 {
+		return h.Timeout
+	}
+	return DefaultTimeout
+}
+
+func (h *Handler) maxWait() time.Duration {
+	if h.MaxWait > 0 {
+		return h.MaxWait
+	}
+	return DefaultMaxWait
+}
+
+func (h *Handler) cookieName() string {
+	if h.CookieName != "" {
+		return h.CookieName
+	}
+	return DefaultCookieName
+}
+
+// StatusCode returns HTTP status code of the handler error.
+func StatusCode(err error) int {
+	var sessionErr *smartid.SessionError
+	switch {
+	case errors.Is(err, ErrCSRF):
+		return http.StatusForbidden
+	case errors.Is(err, ErrIdentifierRequired), errors.Is(err, ErrWebSocketHandshake):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrTokenInvalid):
+		return http.StatusNotFound
+	case errors.Is(err, ErrLoginRunning):
+		return http.StatusConflict
+	case errors.As(err, &sessionErr), errors.Is(err, ErrAuthenticationFailed),
+		errors.Is(err, ErrIdentityMismatch):
+		return http.StatusUnauthorized
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// validIdentifier reports whether identifier is the document number, with
+// document authentication type, or the semantic identifier. Identifier is
+// part of the Smart-ID API URL path.
+func (h *Handler) validIdentifier(identifier string) bool {
+	if h.req.AuthType == smartid.AuthTypeDocument {
+		return smartid.IsValidDocumentNumber(identifier)
+	}
+	_, err := smartid.ParseSemanticIdentifier(identifier)
+	return err == nil
+}
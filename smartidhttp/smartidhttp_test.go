@@ -0,0 +1,297 @@
+package smartidhttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dknight/go-smartid"
+	"github.com/dknight/go-smartid/internal/smartidtest"
+)
+
+// testBrowser is the browser session of the login page.
+type testBrowser struct {
+	t      *testing.T
+	client *http.Client
+	url    string
+	csrf   string
+}
+
+// newTestApp starts the application with the login handler and returns
+// the handler and the browser, which has loaded the login page.
+func newTestApp(t *testing.T, mock *smartidtest.Server, identities chan *smartid.Identity) (*Handler, *testBrowser) {
+	t.Helper()
+	h := New(mock.Client(), &smartid.AuthRequest{}, func(w http.ResponseWriter, r *http.Request, identity *smartid.Identity) {
+		identities <- identity
+		w.Write([]byte("Welcome"))
+	})
+	h.InsecureCookie = true
+	mux := http.NewServeMux()
+	mux.Handle("/login/", http.StripPrefix("/login", h))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		token, err := h.CSRFToken(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(token))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return h, newTestBrowser(t, srv.URL)
+}
+
+func newTestBrowser(t *testing.T, url string) *testBrowser {
+	t.Helper()
+	jar, _ := cookiejar.New(nil)
+	b := &testBrowser{t: t, client: &http.Client{Jar: jar}, url: url}
+	resp, err := b.client.Get(url + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrf, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	b.csrf = string(csrf)
+	return b
+}
+
+// post posts the form with CSRF token and decodes JSON response into v.
+func (b *testBrowser) post(path string, form url.Values, v interface{}) (int, string) {
+	b.t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, b.url+path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-CSRF-Token", b.csrf)
+	return b.do(req, v)
+}
+
+func (b *testBrowser) get(path string, v interface{}) (int, string) {
+	b.t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, b.url+path, nil)
+	return b.do(req, v)
+}
+
+func (b *testBrowser) do(req *http.Request, v interface{}) (int, string) {
+	b.t.Helper()
+	resp, err := b.client.Do(req)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if v != nil && resp.StatusCode == http.StatusOK {
+		if err := json.Unmarshal(body, v); err != nil {
+			b.t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestHandler(t *testing.T) {
+	mock := smartidtest.NewServer(t)
+	identities := make(chan *smartid.Identity, 1)
+	_, browser := newTestApp(t, mock, identities)
+
+	var start StartResponse
+	status, body := browser.post("/login/start", url.Values{"identifier": {smartidtest.Identifier}}, &start)
+	if status != http.StatusOK {
+		t.Fatal("expected", http.StatusOK, "got", status, body)
+	}
+	if len(start.VerificationCode) != 4 || start.Token == "" {
+		t.Error("expected verification code and token, got", start)
+	}
+
+	var st StatusResponse
+	browser.get("/login/status?wait=5&token="+start.Token, &st)
+	if st.State != StateComplete || st.Result != smartid.SessionResultOK {
+		t.Error("expected completed session, got", st)
+	}
+	if req := mock.Requests()[0]; req["identifier"] != smartidtest.Identifier {
+		t.Error("expected", smartidtest.Identifier, "got", req["identifier"])
+	}
+
+	// Other browser cannot use the token.
+	other := newTestBrowser(t, browser.url)
+	if status, _ := other.get("/login/status?token="+start.Token, nil); status != http.StatusNotFound {
+		t.Error("expected", http.StatusNotFound, "got", status)
+	}
+	if status, _ := other.post("/login/complete", url.Values{"token": {start.Token}}, nil); status != http.StatusNotFound {
+		t.Error("expected", http.StatusNotFound, "got", status)
+	}
+
+	status, body = browser.post("/login/complete", url.Values{"token": {start.Token}}, nil)
+	if status != http.StatusOK || body != "Welcome" {
+		t.Fatal("expected", http.StatusOK, "got", status, body)
+	}
+	if identity := <-identities; identity.SerialNumber != smartidtest.Identifier {
+		t.Error("expected", smartidtest.Identifier, "got", identity.SerialNumber)
+	}
+	if status, _ := browser.post("/login/complete", url.Values{"token": {start.Token}}, nil); status != http.StatusNotFound {
+		t.Error("expected token to be completed once, got", status)
+	}
+}
+
+func TestHandler_errors(t *testing.T) {
+	mock := smartidtest.NewServer(t)
+	identities := make(chan *smartid.Identity, 1)
+	_, browser := newTestApp(t, mock, identities)
+
+	csrf := browser.csrf
+	browser.csrf = "forged"
+	if status, _ := browser.post("/login/start", url.Values{"identifier": {smartidtest.Identifier}}, nil); status != http.StatusForbidden {
+		t.Error("expected", http.StatusForbidden, "got", status)
+	}
+	browser.csrf = csrf
+	if status, _ := browser.post("/login/start", nil, nil); status != http.StatusBadRequest {
+		t.Error("expected", http.StatusBadRequest, "got", status)
+	}
+
+	testdata := []struct {
+		identifier string
+		status     int
+	}{
+		{smartidtest.RefusedIdentifier, http.StatusUnauthorized},
+		// Mock answers with the certificate of other person.
+		{"PNOEE-10101010005", http.StatusUnauthorized},
+	}
+	for _, test := range testdata {
+		var start StartResponse
+		browser.post("/login/start", url.Values{"identifier": {test.identifier}}, &start)
+		var st StatusResponse
+		browser.get("/login/status?wait=5&token="+start.Token, &st)
+		if st.State != StateComplete {
+			t.Fatal(test.identifier, "expected", StateComplete, "got", st.State)
+		}
+		status, body := browser.post("/login/complete", url.Values{"token": {start.Token}}, nil)
+		if status != test.status {
+			t.Error(test.identifier, "expected", test.status, "got", status, body)
+		}
+	}
+	if len(identities) != 0 {
+		t.Error("expected no successful logins")
+	}
+}
+
+func TestHandler_identifier(t *testing.T) {
+	mock := smartidtest.NewServer(t)
+	h, browser := newTestApp(t, mock, make(chan *smartid.Identity, 1))
+
+	testdata := []struct {
+		authType, identifier string
+		status               int
+	}{
+		{"", smartidtest.Identifier, http.StatusOK},
+		{"", "PNOLV-329999-99901", http.StatusOK},
+		{"", "PASEE-AB1234567", http.StatusOK},
+		{"", "IDCLT-12345678", http.StatusOK},
+		{"", smartidtest.DocumentNumber, http.StatusBadRequest},
+		{"", "PNOEE-1/../../certificatechoice/etsi/PNOEE-2", http.StatusBadRequest},
+		{"", "PNOEE-1?x=", http.StatusBadRequest},
+		{"", "PNOFI-123456789012", http.StatusBadRequest},
+		{"", "pnoee-30303039914", http.StatusBadRequest},
+		{"", "../session/session-0", http.StatusBadRequest},
+		{smartid.AuthTypeDocument, smartidtest.DocumentNumber, http.StatusOK},
+		{smartid.AuthTypeDocument, smartidtest.Identifier, http.StatusBadRequest},
+		{smartid.AuthTypeDocument, smartidtest.Identifier + "-MOCK-Q/x", http.StatusBadRequest},
+	}
+	for _, test := range testdata {
+		h.req.AuthType = test.authType
+		status, body := browser.post("/login/start", url.Values{"identifier": {test.identifier}}, nil)
+		if status != test.status {
+			t.Error(test.identifier, "expected", test.status, "got", status, body)
+		}
+	}
+	for _, req := range mock.Requests() {
+		if id, _ := req["identifier"].(string); id != smartidtest.DocumentNumber &&
+			!strings.HasPrefix(id, "PNOLV-") && !strings.HasPrefix(id, "PNOEE-") &&
+			!strings.HasPrefix(id, "PASEE-") && !strings.HasPrefix(id, "IDCLT-") {
+			t.Error("expected valid identifier, got", id)
+		}
+	}
+}
+
+func TestHandler_limits(t *testing.T) {
+	mock := smartidtest.NewServer(t)
+	h, browser := newTestApp(t, mock, make(chan *smartid.Identity, 1))
+
+	// Browser has one running login, the previous token is cancelled.
+	var first, second StartResponse
+	browser.post("/login/start", url.Values{"identifier": {smartidtest.Identifier}}, &first)
+	browser.post("/login/start", url.Values{"identifier": {smartidtest.Identifier}}, &second)
+	if status, _ := browser.get("/login/status?token="+first.Token, nil); status != http.StatusNotFound {
+		t.Error("expected", http.StatusNotFound, "got", status)
+	}
+	if status, _ := browser.get("/login/status?token="+second.Token, nil); status != http.StatusOK {
+		t.Error("expected", http.StatusOK, "got", status)
+	}
+
+	// Login which expires first is cancelled when full.
+	h.mu.Lock()
+	ctx, cancel := context.WithCancel(context.Background())
+	h.logins["oldest"] = &login{binding: "oldest", expires: time.Now().Add(time.Second), cancel: cancel}
+	for i := len(h.logins); i < maxLogins; i++ {
+		h.logins[strconv.Itoa(i)] = &login{
+			binding: strconv.Itoa(i),
+			expires: time.Now().Add(time.Hour),
+			cancel:  func() {},
+		}
+	}
+	h.mu.Unlock()
+	other := newTestBrowser(t, browser.url)
+	if status, body := other.post("/login/start", url.Values{"identifier": {smartidtest.Identifier}}, nil); status != http.StatusOK {
+		t.Fatal("expected", http.StatusOK, "got", status, body)
+	}
+	h.mu.Lock()
+	_, ok := h.logins["oldest"]
+	n := len(h.logins)
+	h.mu.Unlock()
+	if ok || ctx.Err() == nil || n != maxLogins {
+		t.Error("expected oldest login to be cancelled, got", ok, n)
+	}
+}
+
+func TestHandler_CSRFToken(t *testing.T) {
+	mock := smartidtest.NewServer(t)
+	h := New(mock.Client(), &smartid.AuthRequest{}, nil)
+	for _, insecure := range []bool{false, true} {
+		h.InsecureCookie = insecure
+		w := httptest.NewRecorder()
+		token, err := h.CSRFToken(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if err != nil || token == "" {
+			t.Fatal(token, err)
+		}
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 {
+			t.Fatal("expected session cookie got", cookies)
+		}
+		if cookies[0].Secure == insecure || !cookies[0].HttpOnly {
+			t.Error("expected Secure", !insecure, "got", cookies[0].Secure)
+		}
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	testdata := []struct {
+		err    error
+		status int
+	}{
+		{ErrCSRF, http.StatusForbidden},
+		{ErrLoginRunning, http.StatusConflict},
+		{&smartid.SessionError{Result: smartid.SessionResultTimeout}, http.StatusUnauthorized},
+		{ErrIdentityMismatch, http.StatusUnauthorized},
+		{errors.New("network"), http.StatusBadGateway},
+	}
+	for _, test := range testdata {
+		if status := StatusCode(test.err); status != test.status {
+			t.Error(test.err, "expected", test.status, "got", status)
+		}
+	}
+}
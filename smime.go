@@ -0,0 +1,209 @@
+package smartid
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrSMIMENotSigned error when message is not multipart/signed S/MIME
+	// message.
+	ErrSMIMENotSigned = errors.New("Message is not S/MIME signed")
+
+	// ErrSMIMEMalformed error when multipart/signed message structure is
+	// invalid.
+	ErrSMIMEMalformed = errors.New("Malformed S/MIME message")
+)
+
+// smimeMicAlgs are micalg parameter values of the hash algorithms.
+var smimeMicAlgs = map[string]string{
+	SHA256:   "sha-256",
+	SHA384:   "sha-384",
+	SHA512:   "sha-512",
+	SHA3_256: "sha3-256",
+	SHA3_384: "sha3-384",
+	SHA3_512: "sha3-512",
+}
+
+// SMIMESignature is S/MIME (RFC 8551) multipart/signed message which is
+// being prepared. The MIME entity is signed with detached CAdES signature.
+type SMIMESignature struct {
+	cades  *CAdESSignature
+	entity []byte
+	micalg string
+}
+
+// PrepareSMIME prepares signature of the MIME entity, which is the signed
+// body part with its Content-* headers. Line endings are canonicalized to
+// CRLF. Chain certificates are included in the signature.
+func PrepareSMIME(
+	entity []byte,
+	cert *x509.Certificate,
+	chain []*x509.Certificate,
+	algo string,
+) (*SMIMESignature, error) {
+	micalg, ok := smimeMicAlgs[algo]
+	if !ok {
+		return nil, ErrHashUnsupported
+	}
+	entity = canonicalLineEndings(entity)
+	digest, err := HashData(entity, algo)
+	if err != nil {
+		return nil, err
+	}
+	cades, err := PrepareCAdESSignature(digest, cert, chain)
+	if err != nil {
+		return nil, err
+	}
+	return &SMIMESignature{cades: cades, entity: entity, micalg: micalg}, nil
+}
+
+// Digest returns digest of the signed attributes, which is signed by
+// Smart-ID.
+func (s *SMIMESignature) Digest() *Digest {
+	return s.cades.Digest()
+}
+
+// SigningTime returns the signing time of the signed attributes.
+func (s *SMIMESignature) SigningTime() time.Time {
+	return s.cades.SigningTime()
+}
+
+// Certificate returns the signer certificate.
+func (s *SMIMESignature) Certificate() *x509.Certificate {
+	return s.cades.Certificate()
+}
+
+// SetSignatureValue sets the signature value returned by Smart-ID.
+func (s *SMIMESignature) SetSignatureValue(sig Signature) error {
+	return s.cades.SetSignatureValue(sig)
+}
+
+// Sign signs the message with Smart-ID using SignSync. Request should have
+// the identifier of the person, preferably document number from the
+// certificate choice.
+func (s *SMIMESignature) Sign(ctx context.Context, c *Client, req *AuthRequest) (*SessionResponse, error) {
+	return s.cades.Sign(ctx, c, req)
+}
+
+// AddTimestamp adds signature timestamp over the signature value.
+func (s *SMIMESignature) AddTimestamp(ctx context.Context, ts Timestamper) error {
+	return s.cades.AddTimestamp(ctx, ts)
+}
+
+// Bytes returns the multipart/signed MIME entity with MIME-Version header.
+// Message headers, like From, To and Subject, are prepended by the caller.
+func (s *SMIMESignature) Bytes() ([]byte, error) {
+	p7s, err := s.cades.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	boundary := "----=_smartid_" + hex.EncodeToString(b)
+
+	var buf bytes.Buffer
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: " + mime.FormatMediaType("multipart/signed", map[string]string{
+		"protocol": "application/pkcs7-signature",
+		"micalg":   s.micalg,
+		"boundary": boundary,
+	}) + "\r\n\r\n")
+	buf.WriteString("This is a cryptographically signed message in MIME format.\r\n\r\n")
+	buf.WriteString("--" + boundary + "\r\n")
+	buf.Write(s.entity)
+	buf.WriteString("\r\n--" + boundary + "\r\n")
+	buf.WriteString("Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n")
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n")
+	buf.WriteString("Content-Disposition: attachment; filename=\"smime.p7s\"\r\n\r\n")
+	enc := base64.StdEncoding.EncodeToString(p7s)
+	for len(enc) > 76 {
+		buf.WriteString(enc[:76] + "\r\n")
+		enc = enc[76:]
+	}
+	buf.WriteString(enc + "\r\n")
+	buf.WriteString("--" + boundary + "--\r\n")
+	return buf.Bytes(), nil
+}
+
+// VerifySMIME validates multipart/signed S/MIME message: the signature
+// over the first body part and the signer certificate. Signer identity is
+// in the result. Error is returned if the message is not signed or cannot
+// be parsed.
+func VerifySMIME(ctx context.Context, msg []byte, ts *TrustStore) (*SignatureResult, error) {
+	msg = canonicalLineEndings(msg)
+	header, body, err := splitMIMEEntity(msg)
+	if err != nil {
+		return nil, err
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/signed" {
+		return nil, ErrSMIMENotSigned
+	}
+	switch params["protocol"] {
+	case "application/pkcs7-signature", "application/x-pkcs7-signature":
+	default:
+		return nil, ErrSMIMENotSigned
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, ErrSMIMEMalformed
+	}
+
+	// Signed part is taken as is, without the CRLF before the delimiter.
+	delimiter := []byte("\r\n--" + boundary)
+	body = append([]byte("\r\n"), body...)
+	parts := bytes.Split(body, delimiter)
+	if len(parts) < 4 || !bytes.HasPrefix(parts[3], []byte("--")) {
+		return nil, ErrSMIMEMalformed
+	}
+	entity := bytes.TrimPrefix(parts[1], []byte("\r\n"))
+	sigHeader, sigBody, err := splitMIMEEntity(bytes.TrimPrefix(parts[2], []byte("\r\n")))
+	if err != nil {
+		return nil, err
+	}
+	sigType, _, _ := mime.ParseMediaType(sigHeader.Get("Content-Type"))
+	if sigType != "application/pkcs7-signature" && sigType != "application/x-pkcs7-signature" {
+		return nil, ErrSMIMEMalformed
+	}
+	p7s := sigBody
+	if strings.EqualFold(sigHeader.Get("Content-Transfer-Encoding"), "base64") {
+		if p7s, err = decodeBase64Text(string(sigBody)); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrSMIMEMalformed, err)
+		}
+	}
+	return VerifyCAdES(ctx, p7s, entity, ts), nil
+}
+
+// splitMIMEEntity parses headers of the entity and returns the body.
+func splitMIMEEntity(data []byte) (textproto.MIMEHeader, []byte, error) {
+	i := bytes.Index(data, []byte("\r\n\r\n"))
+	if i < 0 {
+		return nil, nil, ErrSMIMEMalformed
+	}
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(data[:i+4])))
+	header, err := r.ReadMIMEHeader()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrSMIMEMalformed, err)
+	}
+	return header, data[i+4:], nil
+}
+
+// canonicalLineEndings converts line endings to CRLF.
+func canonicalLineEndings(data []byte) []byte {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+}
@@ -0,0 +1,87 @@
+package smartid
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"testing"
+)
+
+func TestSMIMESignature(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+	entity := []byte("Content-Type: text/plain; charset=utf-8\n\nHello, Smart-ID!\n")
+
+	if _, err := PrepareSMIME(entity, mock.signCert, nil, "MD5"); err != ErrHashUnsupported {
+		t.Error("expected", ErrHashUnsupported, "got", err)
+	}
+	sig, err := PrepareSMIME(entity, mock.signCert, []*x509.Certificate{mock.ca}, SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sig.Sign(ctx, mock.client(), &AuthRequest{
+		Identifier: mockDocumentNumber,
+		AuthType:   AuthTypeDocument,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := sig.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(body, []byte(`micalg=sha-256`)) {
+		t.Error("expected micalg sha-256, got", string(body))
+	}
+	msg := append([]byte("From: alice@example.com\r\nSubject: Test\r\n"), body...)
+
+	r, err := VerifySMIME(ctx, msg, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.IsValid() {
+		t.Fatal("expected", nil, "got", r.Err())
+	}
+	if r.Identity.SerialNumber != "PNOEE-30303039914" {
+		t.Error("expected", "PNOEE-30303039914", "got", r.Identity.SerialNumber)
+	}
+
+	// Mail transfer may convert line endings.
+	lf := bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n"))
+	if r, err := VerifySMIME(ctx, lf, ts); err != nil || !r.IsValid() {
+		t.Error("expected valid signature of LF message, got", err, r.Err())
+	}
+
+	tampered := bytes.Replace(msg, []byte("Hello, Smart-ID!"), []byte("Hello, Mallory!"), 1)
+	r, err = VerifySMIME(ctx, tampered, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(r.Err(), ErrCMSMessageDigestMismatch) {
+		t.Error("expected", ErrCMSMessageDigestMismatch, "got", r.Err())
+	}
+}
+
+func TestVerifySMIME_errors(t *testing.T) {
+	ctx := context.Background()
+	testdata := []struct {
+		name string
+		msg  string
+		err  error
+	}{
+		{"plain", "Content-Type: text/plain\r\n\r\nHello\r\n", ErrSMIMENotSigned},
+		{"pgp", "Content-Type: multipart/signed; protocol=\"application/pgp-signature\"; boundary=b\r\n\r\n", ErrSMIMENotSigned},
+		{"boundary", "Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"\r\n\r\n", ErrSMIMEMalformed},
+		{"parts", "Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; boundary=b\r\n\r\n--b\r\nContent-Type: text/plain\r\n\r\nHello\r\n--b--\r\n", ErrSMIMEMalformed},
+		{"headers", "Subject: Test", ErrSMIMEMalformed},
+	}
+	for _, test := range testdata {
+		_, err := VerifySMIME(ctx, []byte(test.msg), NewTrustStore())
+		if !errors.Is(err, test.err) {
+			t.Error(test.name, "expected", test.err, "got", err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package smartid
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"time"
+)
+
+var (
+	// ErrTimestampNotTSTInfo error when token content is not TSTInfo.
+	ErrTimestampNotTSTInfo = errors.New("Timestamp token content is not TSTInfo")
+
+	// ErrTimestampImprintMismatch error when message imprint of the token
+	// does not match the digest.
+	ErrTimestampImprintMismatch = errors.New("Timestamp message imprint does not match")
+
+	// ErrTimestampNotTSA error when timestamp is signed by the certificate
+	// without timeStamping extended key usage.
+	ErrTimestampNotTSA = errors.New("Timestamp signer is not a time-stamping authority")
+)
+
+// Timestamper obtains RFC 3161 timestamp token for the digest. Token is
+// DER encoded TimeStampToken (CMS ContentInfo with SignedData).
+type Timestamper interface {
+	Timestamp(ctx context.Context, digest *Digest) ([]byte, error)
+}
+
+// TimestampToken is parsed RFC 3161 timestamp token.
+type TimestampToken struct {
+	// Raw is DER encoded TimeStampToken.
+	Raw []byte
+
+	// Time is the generation time of the token.
+	Time time.Time
+
+	// Accuracy is the accuracy of the time, zero if not given.
+	Accuracy time.Duration
+
+	// Digest is the timestamped message imprint.
+	Digest *Digest
+
+	// SerialNumber is the serial number of the token.
+	SerialNumber *big.Int
+
+	// Nonce is the nonce of the request, nil if not given.
+	Nonce *big.Int
+
+	// Policy is the TSA policy of the token.
+	Policy asn1.ObjectIdentifier
+
+	// Certificates are the certificates embedded in the token.
+	Certificates []*x509.Certificate
+
+	sd *signedData
+}
+
+// tstInfo is the content of the timestamp token.
+//
+//	TSTInfo ::= SEQUENCE {
+//		version        INTEGER { v1(1) },
+//		policy         TSAPolicyId,
+//		messageImprint MessageImprint,
+//		serialNumber   INTEGER,
+//		genTime        GeneralizedTime,
+//		accuracy       Accuracy OPTIONAL,
+//		ordering       BOOLEAN DEFAULT FALSE,
+//		nonce          INTEGER OPTIONAL,
+//		tsa            [0] GeneralName OPTIONAL,
+//		extensions     [1] IMPLICIT Extensions OPTIONAL }
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time        `asn1:"generalized"`
+	Accuracy       accuracy         `asn1:"optional"`
+	Ordering       bool             `asn1:"optional"`
+	Nonce          *big.Int         `asn1:"optional"`
+	TSA            asn1.RawValue    `asn1:"explicit,optional,tag:0"`
+	Extensions     []pkix.Extension `asn1:"optional,tag:1"`
+}
+
+// messageImprint is the digest of the timestamped data.
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+// accuracy is the accuracy of the generation time.
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+// duration converts accuracy to duration.
+func (a accuracy) duration() time.Duration {
+	return time.Duration(a.Seconds)*time.Second +
+		time.Duration(a.Millis)*time.Millisecond +
+		time.Duration(a.Micros)*time.Microsecond
+}
+
+// ParseTimestampToken parses DER encoded RFC 3161 TimeStampToken. The
+// signature of the token is not verified, use Verify for that.
+func ParseTimestampToken(der []byte) (*TimestampToken, error) {
+	sd, err := parseSignedData(der)
+	if err != nil {
+		return nil, err
+	}
+	if !sd.EncapContentInfo.EContentType.Equal(oidContentTypeTSTInfo) {
+		return nil, ErrTimestampNotTSTInfo
+	}
+	content, err := sd.content()
+	if err != nil {
+		return nil, err
+	}
+	var info tstInfo
+	if _, err := asn1.Unmarshal(content, &info); err != nil {
+		return nil, err
+	}
+	algo, err := digestAlgorithmName(info.MessageImprint.HashAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := NewDigest(algo, info.MessageImprint.HashedMessage)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := sd.certificates()
+	if err != nil {
+		return nil, err
+	}
+	return &TimestampToken{
+		Raw:          der,
+		Time:         info.GenTime,
+		Accuracy:     info.Accuracy.duration(),
+		Digest:       digest,
+		SerialNumber: info.SerialNumber,
+		Nonce:        info.Nonce,
+		Policy:       info.Policy,
+		Certificates: certs,
+		sd:           sd,
+	}, nil
+}
+
+// CheckDigest checks that the token is issued for the digest.
+func (t *TimestampToken) CheckDigest(digest *Digest) error {
+	if t.Digest.Algorithm() != digest.Algorithm() ||
+		!bytes.Equal(t.Digest.AuthHash(), digest.AuthHash()) {
+		return ErrTimestampImprintMismatch
+	}
+	return nil
+}
+
+// Verify verifies the signature of the token and the certificate of the
+// TSA with the trust store at the generation time. Token must have signed
+// reference to the TSA certificate (RFC 3161 section 2.4.2). The signer
+// certificate is returned.
+func (t *TimestampToken) Verify(ctx context.Context, ts *TrustStore) (*x509.Certificate, error) {
+	cert, si, err := t.sd.verify(nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := si.checkSigningCertificate(cert, true); err != nil {
+		return cert, err
+	}
+	if !hasExtKeyUsage(cert, x509.ExtKeyUsageTimeStamping) {
+		return cert, ErrTimestampNotTSA
+	}
+	if _, err := ts.VerifyWithIntermediates(ctx, cert, t.Certificates, t.Time); err != nil {
+		return cert, err
+	}
+	return cert, nil
+}
+
+// hasExtKeyUsage checks that certificate has the extended key usage.
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == usage {
+			return true
+		}
+	}
+	return false
+}
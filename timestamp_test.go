@@ -0,0 +1,136 @@
+package smartid
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testTSA issues RFC 3161 timestamp tokens signed by the test TSA
+// certificate.
+type testTSA struct {
+	t    *testing.T
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+	now  time.Time
+
+	// certID is referenced by signing certificate attribute instead of
+	// the TSA certificate, if set.
+	certID *x509.Certificate
+}
+
+// newTestTSA creates TSA certificate issued by the CA.
+func newTestTSA(t *testing.T, ca *x509.Certificate, caKey *rsa.PrivateKey) *testTSA {
+	t.Helper()
+	cert, key := newTestLeaf(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "TEST of SK TSA", Country: []string{"EE"}},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	}, ca, caKey)
+	return &testTSA{t: t, cert: cert, key: key, now: time.Now()}
+}
+
+func (tsa *testTSA) Timestamp(ctx context.Context, digest *Digest) ([]byte, error) {
+	return tsa.token(digest.Algorithm(), digest.AuthHash(), nil), nil
+}
+
+// token issues timestamp token for the message imprint.
+func (tsa *testTSA) token(algo string, hashed []byte, nonce *big.Int) []byte {
+	tsa.t.Helper()
+	content, err := asn1.Marshal(tstInfo{
+		Version: 1,
+		Policy:  asn1.ObjectIdentifier{1, 2, 3, 4},
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm: digestAlgorithmOIDs[algo],
+			},
+			HashedMessage: hashed,
+		},
+		SerialNumber: big.NewInt(tsa.now.UnixNano()),
+		GenTime:      tsa.now.UTC().Truncate(time.Second),
+		Accuracy:     accuracy{Seconds: 1},
+		Nonce:        nonce,
+	})
+	if err != nil {
+		tsa.t.Fatal(err)
+	}
+	certID := tsa.certID
+	if certID == nil {
+		certID = tsa.cert
+	}
+	certHash := sha256.Sum256(certID.Raw)
+	signingCert := testAttribute(tsa.t, oidAttrSigningCertificateV2,
+		signingCertificateV2{Certs: []essCertIDv2{{CertHash: certHash[:]}}})
+	return testSignedData(tsa.t, oidContentTypeTSTInfo, content, false, tsa.cert, tsa.key, signingCert)
+}
+
+func TestTimestampToken(t *testing.T) {
+	ctx := context.Background()
+	ca, caKey := newTestCA(t, "TEST of EID-SK 2016")
+	tsa := newTestTSA(t, ca, caKey)
+	digest, _ := HashData([]byte("Hello, Smart-ID!"), SHA256)
+
+	der, _ := tsa.Timestamp(ctx, digest)
+	token, err := ParseTimestampToken(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !token.Time.Equal(tsa.now.Truncate(time.Second)) {
+		t.Error("expected", tsa.now.Truncate(time.Second), "got", token.Time)
+	}
+	if token.Accuracy != time.Second {
+		t.Error("expected", time.Second, "got", token.Accuracy)
+	}
+	if err := token.CheckDigest(digest); err != nil {
+		t.Error("expected", nil, "got", err)
+	}
+	other, _ := HashData([]byte("other"), SHA256)
+	if err := token.CheckDigest(other); err != ErrTimestampImprintMismatch {
+		t.Error("expected", ErrTimestampImprintMismatch, "got", err)
+	}
+
+	ts := NewTrustStore()
+	ts.AddRoot(ca)
+	cert, err := token.Verify(ctx, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.Equal(tsa.cert) {
+		t.Error("expected TSA certificate")
+	}
+	if _, err := token.Verify(ctx, NewTrustStore()); err == nil {
+		t.Error("expected untrusted TSA error")
+	}
+
+	notTSA := &testTSA{t: t, now: time.Now()}
+	notTSA.cert, notTSA.key = newTestLeaf(t, signTemplate(), ca, caKey)
+	der, _ = notTSA.Timestamp(ctx, digest)
+	token, _ = ParseTimestampToken(der)
+	if _, err := token.Verify(ctx, ts); err != ErrTimestampNotTSA {
+		t.Error("expected", ErrTimestampNotTSA, "got", err)
+	}
+
+	// Signing certificate attribute references other certificate.
+	tsa.certID = notTSA.cert
+	der, _ = tsa.Timestamp(ctx, digest)
+	token, _ = ParseTimestampToken(der)
+	if _, err := token.Verify(ctx, ts); err != ErrSigningCertMismatch {
+		t.Error("expected", ErrSigningCertMismatch, "got", err)
+	}
+	info, _ := token.sd.content()
+	der = testSignedData(t, oidContentTypeTSTInfo, info, false, tsa.cert, tsa.key)
+	token, _ = ParseTimestampToken(der)
+	if _, err := token.Verify(ctx, ts); err != ErrCMSAttributeMissing {
+		t.Error("expected", ErrCMSAttributeMissing, "got", err)
+	}
+
+	if _, err := ParseTimestampToken(certsOnlyPKCS7(t, ca)); err != ErrTimestampNotTSTInfo {
+		t.Error("expected", ErrTimestampNotTSTInfo, "got", err)
+	}
+}
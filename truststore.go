@@ -0,0 +1,426 @@
+package smartid
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxCertSize is the maximum size of the downloaded intermediate
+// certificate or PKCS#7 bundle.
+const DefaultMaxCertSize = 64 << 10
+
+// DefaultAIATimeout limits the download of one AIA certificate.
+const DefaultAIATimeout = 10 * time.Second
+
+// DefaultAIACacheTTL is how long the downloaded certificates are cached.
+const DefaultAIACacheTTL = 24 * time.Hour
+
+// defaultAIADepth is how many intermediates can be fetched for one chain.
+const defaultAIADepth = 3
+
+// maxAIACacheSize limits the number of URLs in the AIA cache.
+const maxAIACacheSize = 256
+
+// maxLegacyChain limits the length of legacy certificate chain.
+const maxLegacyChain = 8
+
+var (
+	// ErrAIACertTooLarge error when downloaded certificate exceeds the
+	// size limit.
+	ErrAIACertTooLarge = errors.New("AIA certificate is too large")
+
+	// ErrAIAUnsupportedURL error when AIA URL is not HTTP or HTTPS.
+	ErrAIAUnsupportedURL = errors.New("AIA URL is not supported")
+
+	// ErrAIAHostNotAllowed error when AIA URL host is not allowed.
+	ErrAIAHostNotAllowed = errors.New("AIA URL host is not allowed")
+)
+
+// CertFetcher downloads raw certificate data from the Authority
+// Information Access caIssuers URL. Data might be DER or PEM encoded
+// certificate, or PKCS#7 certs-only bundle.
+type CertFetcher interface {
+	FetchCert(ctx context.Context, url string) ([]byte, error)
+}
+
+// HTTPCertFetcher fetches certificates over HTTP(S).
+type HTTPCertFetcher struct {
+	// Client is the HTTP client, if nil http.DefaultClient is used.
+	Client *http.Client
+
+	// MaxSize is the maximum size of the response in bytes, if zero
+	// DefaultMaxCertSize is used.
+	MaxSize int64
+
+	// Timeout limits each request, if zero DefaultAIATimeout is used.
+	Timeout time.Duration
+}
+
+// FetchCert implements CertFetcher.
+func (f *HTTPCertFetcher) FetchCert(ctx context.Context, url string) ([]byte, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, ErrAIAUnsupportedURL
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	maxSize := f.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxCertSize
+	}
+	timeout := f.Timeout
+	if timeout == 0 {
+		timeout = DefaultAIATimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("AIA fetch %v: %v", url, resp.Status)
+	}
+	if resp.ContentLength > maxSize {
+		return nil, ErrAIACertTooLarge
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxSize {
+		return nil, ErrAIACertTooLarge
+	}
+	return body, nil
+}
+
+// AIAResolver completes certificate chains by following Authority
+// Information Access caIssuers URLs. URLs come from the certificates being
+// validated, so only HTTP(S) URLs of the allowed hosts are fetched.
+// Downloaded certificates are cached by URL for DefaultAIACacheTTL.
+// AIAResolver is safe for concurrent use.
+type AIAResolver struct {
+	fetcher  CertFetcher
+	hosts    []string
+	maxDepth int
+	now      func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]*aiaCacheEntry
+}
+
+// aiaCacheEntry is the cached certificates of the URL.
+type aiaCacheEntry struct {
+	certs   []*x509.Certificate
+	expires time.Time
+}
+
+// NewAIAResolver creates a new resolver, which fetches certificates only
+// from the hosts, e.g. c.sk.ee. If fetcher is nil, HTTP fetcher with
+// default limits is used.
+func NewAIAResolver(fetcher CertFetcher, hosts ...string) *AIAResolver {
+	if fetcher == nil {
+		fetcher = &HTTPCertFetcher{}
+	}
+	return &AIAResolver{
+		fetcher:  fetcher,
+		hosts:    hosts,
+		maxDepth: defaultAIADepth,
+		now:      time.Now,
+		cache:    make(map[string]*aiaCacheEntry),
+	}
+}
+
+// checkURL checks that the URL is HTTP(S) URL of the allowed host.
+func (r *AIAResolver) checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ErrAIAUnsupportedURL
+	}
+	for _, host := range r.hosts {
+		if strings.EqualFold(u.Hostname(), host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrAIAHostNotAllowed, u.Hostname())
+}
+
+// issuers returns certificates from the URL. Cached certificates are
+// returned without fetching.
+func (r *AIAResolver) issuers(ctx context.Context, url string) ([]*x509.Certificate, error) {
+	if err := r.checkURL(url); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	entry, ok := r.cache[url]
+	r.mu.Unlock()
+	if ok && r.now().Before(entry.expires) {
+		return entry.certs, nil
+	}
+
+	data, err := r.fetcher.FetchCert(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := parseCertBundle(data)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if len(r.cache) >= maxAIACacheSize {
+		for k, e := range r.cache {
+			if !now.Before(e.expires) {
+				delete(r.cache, k)
+			}
+		}
+	}
+	// Cache is full of fresh entries, drop arbitrary ones.
+	for k := range r.cache {
+		if len(r.cache) < maxAIACacheSize {
+			break
+		}
+		delete(r.cache, k)
+	}
+	r.cache[url] = &aiaCacheEntry{certs: certs, expires: now.Add(DefaultAIACacheTTL)}
+	return certs, nil
+}
+
+// TrustStore contains trusted root certificates and known intermediates
+// for certificate chain verification. Optionally intermediates missing
+// from the store are fetched by AIAResolver for the verification, they are
+// not added to the store. Intermediates are accepted only if they chain to
+// the roots of the store.
+type TrustStore struct {
+	roots *x509.CertPool
+
+	mu            sync.RWMutex
+	aia           *AIAResolver
+	rootCerts     []*x509.Certificate
+	intermediates []*x509.Certificate
+}
+
+// NewTrustStore creates an empty trust store.
+func NewTrustStore() *TrustStore {
+	return &TrustStore{roots: x509.NewCertPool()}
+}
+
+// NewTrustStoreFromPaths creates trust store with roots from PEM files.
+func NewTrustStoreFromPaths(paths []string) (*TrustStore, error) {
+	if len(paths) == 0 {
+		return nil, ErrCertNoCertGiven
+	}
+	ts := NewTrustStore()
+	for _, path := range paths {
+		cert, err := createCertFromPath(path)
+		if err != nil {
+			return nil, err
+		}
+		ts.AddRoot(cert)
+	}
+	return ts, nil
+}
+
+// AddRoot adds trusted root certificate.
+func (ts *TrustStore) AddRoot(cert *x509.Certificate) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.roots.AddCert(cert)
+	ts.rootCerts = append(ts.rootCerts, cert)
+}
+
+// AddIntermediate adds intermediate certificate. Intermediate is not
+// trusted itself, it must chain to one of roots.
+func (ts *TrustStore) AddIntermediate(cert *x509.Certificate) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if !slices.ContainsFunc(ts.intermediates, cert.Equal) {
+		ts.intermediates = append(ts.intermediates, cert)
+	}
+}
+
+// SetAIAResolver enables chain completion with the resolver. Nil disables
+// it.
+func (ts *TrustStore) SetAIAResolver(r *AIAResolver) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.aia = r
+}
+
+// Verify verifies the certificate at the given time and returns the
+// verified chains. Zero time means now. If chain cannot be built and AIA
+// resolver is set, missing intermediates are fetched for this verification.
+func (ts *TrustStore) Verify(
+	ctx context.Context,
+	cert *x509.Certificate,
+	at time.Time,
+) ([][]*x509.Certificate, error) {
+	return ts.VerifyWithIntermediates(ctx, cert, nil, at)
+}
+
+// VerifyWithIntermediates is like Verify, but uses also untrusted
+// intermediates, for example embedded in the signature. The intermediates
+// are not added to the store.
+func (ts *TrustStore) VerifyWithIntermediates(
+	ctx context.Context,
+	cert *x509.Certificate,
+	intermediates []*x509.Certificate,
+	at time.Time,
+) ([][]*x509.Certificate, error) {
+	ts.mu.RLock()
+	aia := ts.aia
+	ts.mu.RUnlock()
+	chains, err := ts.verify(cert, intermediates, at)
+	if err == nil || aia == nil {
+		return chains, err
+	}
+	var uaErr x509.UnknownAuthorityError
+	if !errors.As(err, &uaErr) {
+		return nil, err
+	}
+
+	var fetched []*x509.Certificate
+	current := cert
+	for depth := 0; depth < aia.maxDepth; depth++ {
+		issuer := fetchIssuer(ctx, aia, current)
+		if issuer == nil {
+			break
+		}
+		fetched = append(fetched, issuer)
+		extra := append(fetched[:len(fetched):len(fetched)], intermediates...)
+		chains, verr := ts.verify(cert, extra, at)
+		if verr == nil {
+			return chains, nil
+		}
+		current = issuer
+	}
+	return nil, err
+}
+
+// fetchIssuer downloads the certificate which has issued the cert with
+// the resolver. Only the certificate which signature verifies is returned.
+func fetchIssuer(ctx context.Context, aia *AIAResolver, cert *x509.Certificate) *x509.Certificate {
+	for _, url := range cert.IssuingCertificateURL {
+		candidates, err := aia.issuers(ctx, url)
+		if err != nil {
+			continue
+		}
+		for _, candidate := range candidates {
+			if cert.CheckSignatureFrom(candidate) == nil {
+				return candidate
+			}
+		}
+	}
+	return nil
+}
+
+// verify verifies cert with the store and extra intermediates.
+func (ts *TrustStore) verify(
+	cert *x509.Certificate,
+	extra []*x509.Certificate,
+	at time.Time,
+) ([][]*x509.Certificate, error) {
+	intermediates := x509.NewCertPool()
+	ts.mu.RLock()
+	for _, c := range ts.intermediates {
+		intermediates.AddCert(c)
+	}
+	ts.mu.RUnlock()
+	for _, c := range extra {
+		intermediates.AddCert(c)
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         ts.roots,
+		Intermediates: intermediates,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	return cert.Verify(opts)
+}
+
+// verifyLegacy verifies the chain of legacy certificate, like DigiDoc
+// signer certificates of ESTEID-SK 2007, which are signed with SHA-1.
+// crypto/x509 no longer verifies SHA-1 signatures, so the chain is built
+// here: only signatures, CA constraints and validity periods are checked.
+// Intermediates of the store and extra ones are used, AIA is not.
+func (ts *TrustStore) verifyLegacy(
+	cert *x509.Certificate,
+	extra []*x509.Certificate,
+	at time.Time,
+) ([][]*x509.Certificate, error) {
+	if at.IsZero() {
+		at = time.Now()
+	}
+	ts.mu.RLock()
+	roots := ts.rootCerts
+	candidates := append(append([]*x509.Certificate(nil), ts.intermediates...), extra...)
+	ts.mu.RUnlock()
+
+	chain := []*x509.Certificate{cert}
+	for len(chain) <= maxLegacyChain {
+		current := chain[len(chain)-1]
+		if at.Before(current.NotBefore) || at.After(current.NotAfter) {
+			return nil, x509.CertificateInvalidError{Cert: current, Reason: x509.Expired}
+		}
+		for _, root := range roots {
+			if root.Equal(current) {
+				return [][]*x509.Certificate{chain}, nil
+			}
+		}
+		var issuer *x509.Certificate
+		for _, c := range append(roots[:len(roots):len(roots)], candidates...) {
+			if !slices.ContainsFunc(chain, c.Equal) && checkIssuer(current, c) == nil {
+				issuer = c
+				break
+			}
+		}
+		if issuer == nil {
+			return nil, x509.UnknownAuthorityError{Cert: current}
+		}
+		chain = append(chain, issuer)
+	}
+	return nil, x509.UnknownAuthorityError{Cert: cert}
+}
+
+// parseCertBundle parses DER or PEM certificates, or PKCS#7 certs-only
+// bundle.
+func parseCertBundle(data []byte) ([]*x509.Certificate, error) {
+	if block, rest := pem.Decode(data); block != nil {
+		var certs []*x509.Certificate
+		for ; block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, cert)
+		}
+		return certs, nil
+	}
+	if certs, err := x509.ParseCertificates(data); err == nil {
+		return certs, nil
+	}
+	return parsePKCS7Certificates(data)
+}
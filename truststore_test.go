@@ -0,0 +1,292 @@
+package smartid
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestIntermediate creates intermediate CA certificate signed by parent.
+func newTestIntermediate(
+	t *testing.T,
+	cn string,
+	parent *x509.Certificate,
+	parentKey *rsa.PrivateKey,
+) (*x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
+	key := testKey(t, cn)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: cn, Country: []string{"EE"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	return signTestCert(t, tmpl, parent, &key.PublicKey, parentKey), key
+}
+
+// certsOnlyPKCS7 makes degenerate PKCS#7 SignedData with certificates.
+func certsOnlyPKCS7(t *testing.T, certs ...*x509.Certificate) []byte {
+	t.Helper()
+	var raw []byte
+	for _, c := range certs {
+		raw = append(raw, c.Raw...)
+	}
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{},
+		EncapContentInfo: encapsulatedContentInfo{EContentType: oidContentTypeData},
+		Certificates: asn1.RawValue{
+			Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw,
+		},
+		SignerInfos: asn1.RawValue{
+			Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true,
+		},
+	}
+	sdBytes, err := asn1.Marshal(sd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(contentInfo{
+		ContentType: oidContentTypeSignedData,
+		Content: asn1.RawValue{
+			Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true,
+			Bytes: sdBytes,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+type aiaTestServer struct {
+	*httptest.Server
+	hits int32
+}
+
+func newAIATestServer(t *testing.T, files map[string][]byte) *aiaTestServer {
+	s := &aiaTestServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&s.hits, 1)
+			data, ok := files[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(data)
+		}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestTrustStore_Verify_AIA(t *testing.T) {
+	root, rootKey := newTestCA(t, "Test Root")
+	inter, interKey := newTestIntermediate(t, "TEST of EID-Q 2021R", root, rootKey)
+	otherRoot, otherRootKey := newTestCA(t, "Other Root")
+	otherInter, _ := newTestIntermediate(t, "TEST of EID-Q 2021R", otherRoot, otherRootKey)
+
+	server := newAIATestServer(t, map[string][]byte{
+		"/inter.der":   inter.Raw,
+		"/inter.p7c":   certsOnlyPKCS7(t, inter),
+		"/other.der":   otherInter.Raw,
+		"/toolarge.p7": make([]byte, DefaultMaxCertSize+1),
+	})
+
+	testdata := []struct {
+		path string
+		ok   bool
+	}{
+		{"/inter.der", true},
+		{"/inter.p7c", true},
+		{"/other.der", false},
+		{"/toolarge.p7", false},
+		{"/missing.der", false},
+	}
+	for _, test := range testdata {
+		leaf, _ := newTestLeaf(t, &x509.Certificate{
+			IssuingCertificateURL: []string{server.URL + test.path},
+		}, inter, interKey)
+
+		ts := NewTrustStore()
+		ts.AddRoot(root)
+		if _, err := ts.Verify(context.TODO(), leaf, time.Time{}); err == nil {
+			t.Error(test.path, "should fail without AIA")
+		}
+
+		ts.SetAIAResolver(NewAIAResolver(nil, "127.0.0.1"))
+		_, err := ts.Verify(context.TODO(), leaf, time.Time{})
+		if test.ok && err != nil {
+			t.Error(test.path, "expected valid chain got", err)
+		}
+		if !test.ok && err == nil {
+			t.Error(test.path, "expected error")
+		}
+		if len(ts.intermediates) != 0 {
+			t.Error(test.path, "expected fetched intermediates not to be added to the store")
+		}
+	}
+}
+
+func TestTrustStore_Verify_AIANotAllowed(t *testing.T) {
+	root, rootKey := newTestCA(t, "Test Root")
+	inter, interKey := newTestIntermediate(t, "TEST of EID-Q 2021R", root, rootKey)
+	server := newAIATestServer(t, map[string][]byte{"/inter.der": inter.Raw})
+
+	testdata := []struct {
+		url   string
+		hosts []string
+		err   error
+	}{
+		{server.URL + "/inter.der", nil, ErrAIAHostNotAllowed},
+		{server.URL + "/inter.der", []string{"c.sk.ee"}, ErrAIAHostNotAllowed},
+		{"ldap://127.0.0.1/inter.der", []string{"127.0.0.1"}, ErrAIAUnsupportedURL},
+		{"file:///etc/passwd", []string{""}, ErrAIAUnsupportedURL},
+	}
+	for _, test := range testdata {
+		leaf, _ := newTestLeaf(t, &x509.Certificate{
+			IssuingCertificateURL: []string{test.url},
+		}, inter, interKey)
+		ts := NewTrustStore()
+		ts.AddRoot(root)
+		resolver := NewAIAResolver(nil, test.hosts...)
+		ts.SetAIAResolver(resolver)
+		if _, err := ts.Verify(context.TODO(), leaf, time.Time{}); err == nil {
+			t.Error(test.url, "expected error")
+		}
+		if _, err := resolver.issuers(context.TODO(), test.url); !errors.Is(err, test.err) {
+			t.Error(test.url, "expected", test.err, "got", err)
+		}
+	}
+	if hits := atomic.LoadInt32(&server.hits); hits != 0 {
+		t.Error("expected no requests got", hits)
+	}
+}
+
+func TestTrustStore_Verify_AIACache(t *testing.T) {
+	root, rootKey := newTestCA(t, "Test Root")
+	inter, interKey := newTestIntermediate(t, "TEST of EID-Q 2021R", root, rootKey)
+	server := newAIATestServer(t, map[string][]byte{"/inter.der": inter.Raw})
+	leaf, _ := newTestLeaf(t, &x509.Certificate{
+		IssuingCertificateURL: []string{server.URL + "/inter.der"},
+	}, inter, interKey)
+
+	resolver := NewAIAResolver(&HTTPCertFetcher{}, "127.0.0.1")
+	for i := 0; i < 3; i++ {
+		ts := NewTrustStore()
+		ts.AddRoot(root)
+		ts.SetAIAResolver(resolver)
+		cert := Cert{x509Cert: leaf}
+		if ok, err := cert.VerifyWithTrustStore(context.TODO(), ts); !ok {
+			t.Fatal(err)
+		}
+	}
+	if hits := atomic.LoadInt32(&server.hits); hits != 1 {
+		t.Error("expected 1 request got", hits)
+	}
+
+	now := time.Now()
+	resolver.now = func() time.Time { return now.Add(DefaultAIACacheTTL) }
+	if _, err := resolver.issuers(context.TODO(), server.URL+"/inter.der"); err != nil {
+		t.Fatal(err)
+	}
+	if hits := atomic.LoadInt32(&server.hits); hits != 2 {
+		t.Error("expected expired entry to be fetched again, got", hits, "requests")
+	}
+
+	for i := 0; i < maxAIACacheSize+10; i++ {
+		resolver.issuers(context.TODO(), fmt.Sprintf("%s/inter.der?%d", server.URL, i))
+	}
+	if n := len(resolver.cache); n > maxAIACacheSize {
+		t.Error("expected at most", maxAIACacheSize, "cached URLs got", n)
+	}
+}
+
+func TestTrustStore_SetAIAResolver_concurrent(t *testing.T) {
+	root, rootKey := newTestCA(t, "Test Root")
+	inter, interKey := newTestIntermediate(t, "TEST of EID-Q 2021R", root, rootKey)
+	server := newAIATestServer(t, map[string][]byte{"/inter.der": inter.Raw})
+	leaf, _ := newTestLeaf(t, &x509.Certificate{
+		IssuingCertificateURL: []string{server.URL + "/inter.der"},
+	}, inter, interKey)
+
+	ts := NewTrustStore()
+	ts.AddRoot(root)
+	resolver := NewAIAResolver(nil, "127.0.0.1")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			ts.SetAIAResolver(nil)
+			ts.SetAIAResolver(resolver)
+		}
+	}()
+	// Resolver may be disabled during verification, which must not panic.
+	for i := 0; i < 100; i++ {
+		ts.Verify(context.TODO(), leaf, time.Time{})
+	}
+	<-done
+	if _, err := ts.Verify(context.TODO(), leaf, time.Time{}); err != nil {
+		t.Error("expected valid chain got", err)
+	}
+}
+
+func TestHTTPCertFetcher_FetchCert(t *testing.T) {
+	server := newAIATestServer(t, map[string][]byte{
+		"/cert": make([]byte, 100),
+	})
+	fetcher := &HTTPCertFetcher{MaxSize: 50}
+	_, err := fetcher.FetchCert(context.TODO(), server.URL+"/cert")
+	if !errors.Is(err, ErrAIACertTooLarge) {
+		t.Error("expected", ErrAIACertTooLarge, "got", err)
+	}
+	_, err = fetcher.FetchCert(context.TODO(), "ldap://example.com/cert")
+	if !errors.Is(err, ErrAIAUnsupportedURL) {
+		t.Error("expected", ErrAIAUnsupportedURL, "got", err)
+	}
+	fetcher.MaxSize = 0
+	data, err := fetcher.FetchCert(context.TODO(), server.URL+"/cert")
+	if err != nil || len(data) != 100 {
+		t.Error("unexpected result", len(data), err)
+	}
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+	fetcher.Timeout = 50 * time.Millisecond
+	if _, err := fetcher.FetchCert(context.TODO(), slow.URL); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("expected", context.DeadlineExceeded, "got", err)
+	}
+}
+
+func TestParseCertBundle(t *testing.T) {
+	root, _ := newTestCA(t, "Test Root")
+	pemData := "garbage\n" + string(pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: root.Raw,
+	}))
+	for name, data := range map[string][]byte{
+		"der":   root.Raw,
+		"pem":   []byte(pemData),
+		"pkcs7": certsOnlyPKCS7(t, root),
+	} {
+		certs, err := parseCertBundle(data)
+		if err != nil || len(certs) != 1 || !certs[0].Equal(root) {
+			t.Error(name, "unexpected result", certs, err)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package smartid
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxTimestampSize is the maximum size of the TSA response.
+const DefaultMaxTimestampSize = 64 << 10
+
+// PKI status values of the TSA response.
+const (
+	TSAStatusGranted                = 0
+	TSAStatusGrantedWithMods        = 1
+	TSAStatusRejection              = 2
+	TSAStatusWaiting                = 3
+	TSAStatusRevocationWarning      = 4
+	TSAStatusRevocationNotification = 5
+)
+
+var (
+	// ErrTSARejected error when TSA does not grant the timestamp.
+	ErrTSARejected = errors.New("Timestamp request rejected")
+
+	// ErrTSANoToken error when TSA response has no timestamp token.
+	ErrTSANoToken = errors.New("TSA response has no timestamp token")
+
+	// ErrTSAResponseTooLarge error when TSA response exceeds the size
+	// limit.
+	ErrTSAResponseTooLarge = errors.New("TSA response is too large")
+
+	// ErrTimestampNonceMismatch error when nonce of the token does not
+	// match the request.
+	ErrTimestampNonceMismatch = errors.New("Timestamp nonce does not match")
+
+	// ErrTimestampPolicyMismatch error when policy of the token is not the
+	// requested one.
+	ErrTimestampPolicyMismatch = errors.New("Timestamp policy does not match")
+)
+
+// timeStampReq is RFC 3161 timestamp request.
+//
+//	TimeStampReq ::= SEQUENCE {
+//		version        INTEGER { v1(1) },
+//		messageImprint MessageImprint,
+//		reqPolicy      TSAPolicyId OPTIONAL,
+//		nonce          INTEGER OPTIONAL,
+//		certReq        BOOLEAN DEFAULT FALSE,
+//		extensions     [0] IMPLICIT Extensions OPTIONAL }
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional"`
+	Extensions     []pkix.Extension      `asn1:"optional,tag:0"`
+}
+
+// timeStampResp is RFC 3161 timestamp response.
+//
+//	TimeStampResp ::= SEQUENCE {
+//		status         PKIStatusInfo,
+//		timeStampToken TimeStampToken OPTIONAL }
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+// pkiStatusInfo is the status of the response.
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []asn1.RawValue `asn1:"optional"`
+	FailInfo     asn1.BitString  `asn1:"optional"`
+}
+
+// TSATransport sends DER encoded TimeStampReq to the TSA and returns DER
+// encoded TimeStampResp.
+type TSATransport interface {
+	RoundTrip(ctx context.Context, req []byte) ([]byte, error)
+}
+
+// HTTPTSATransport sends timestamp requests over HTTP(S) as described in
+// RFC 3161 section 3.4.
+type HTTPTSATransport struct {
+	// URL is the address of the TSA.
+	URL string
+
+	// Client is the HTTP client, if nil http.DefaultClient is used.
+	Client *http.Client
+
+	// Username and Password are used for basic authentication, if set.
+	Username string
+	Password string
+
+	// MaxSize is the maximum size of the response in bytes, if zero
+	// DefaultMaxTimestampSize is used.
+	MaxSize int64
+}
+
+// RoundTrip implements TSATransport.
+func (t *HTTPTSATransport) RoundTrip(ctx context.Context, body []byte) ([]byte, error) {
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	maxSize := t.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxTimestampSize
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/timestamp-query")
+	req.Header.Set("Accept", "application/timestamp-reply")
+	if t.Username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(t.Username + ":" + t.Password))
+		req.Header.Set("Authorization", "Basic "+auth)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("TSA %v: %v", t.URL, resp.Status)
+	}
+	if resp.ContentLength > maxSize {
+		return nil, ErrTSAResponseTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, ErrTSAResponseTooLarge
+	}
+	return data, nil
+}
+
+// TSAClient requests RFC 3161 timestamps and validates the responses. It
+// implements Timestamper.
+type TSAClient struct {
+	// Transport sends the requests to the TSA.
+	Transport TSATransport
+
+	// TrustStore verifies the TSA certificate chain.
+	TrustStore *TrustStore
+
+	// Policy is the requested TSA policy, optional.
+	Policy asn1.ObjectIdentifier
+}
+
+// NewTSAClient creates client for the TSA at the URL. TSA certificate is
+// verified with the trust store.
+func NewTSAClient(url string, ts *TrustStore) *TSAClient {
+	return &TSAClient{
+		Transport:  &HTTPTSATransport{URL: url},
+		TrustStore: ts,
+	}
+}
+
+// Request requests timestamp token for the digest. Status of the
+// response, message imprint, nonce, policy and the TSA certificate chain
+// are validated.
+func (c *TSAClient) Request(ctx context.Context, digest *Digest) (*TimestampToken, error) {
+	oid, ok := digestAlgorithmOIDs[digest.Algorithm()]
+	if !ok {
+		return nil, ErrHashUnsupported
+	}
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	req, err := asn1.Marshal(timeStampReq{
+		Version: 1,
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oid},
+			HashedMessage: digest.AuthHash(),
+		},
+		ReqPolicy: c.Policy,
+		Nonce:     nonce,
+		CertReq:   true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.Transport.RoundTrip(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp timeStampResp
+	if _, err := asn1.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	if s := resp.Status; s.Status != TSAStatusGranted && s.Status != TSAStatusGrantedWithMods {
+		text := make([]string, len(s.StatusString))
+		for i, v := range s.StatusString {
+			text[i] = string(v.Bytes)
+		}
+		return nil, fmt.Errorf("%w: status %d %s", ErrTSARejected, s.Status, strings.Join(text, " "))
+	}
+	if len(resp.TimeStampToken.FullBytes) == 0 {
+		return nil, ErrTSANoToken
+	}
+	token, err := ParseTimestampToken(resp.TimeStampToken.FullBytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := token.CheckDigest(digest); err != nil {
+		return nil, err
+	}
+	if token.Nonce == nil || token.Nonce.Cmp(nonce) != 0 {
+		return nil, ErrTimestampNonceMismatch
+	}
+	if len(c.Policy) > 0 && !token.Policy.Equal(c.Policy) {
+		return nil, ErrTimestampPolicyMismatch
+	}
+	if _, err := token.Verify(ctx, c.TrustStore); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Timestamp implements Timestamper.
+func (c *TSAClient) Timestamp(ctx context.Context, digest *Digest) ([]byte, error) {
+	token, err := c.Request(ctx, digest)
+	if err != nil {
+		return nil, err
+	}
+	return token.Raw, nil
+}
+
+// TimestampSignature requests timestamp token over the signature value
+// returned by Smart-ID. Value is hashed with the algorithm.
+func (c *TSAClient) TimestampSignature(ctx context.Context, sig Signature, algo string) (*TimestampToken, error) {
+	value, err := base64.StdEncoding.DecodeString(sig.Value)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := HashData(value, algo)
+	if err != nil {
+		return nil, err
+	}
+	return c.Request(ctx, digest)
+}
@@ -0,0 +1,127 @@
+package smartid
+
+import (
+	"context"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testTSAServer is local stand-in of RFC 3161 TSA over HTTP.
+type testTSAServer struct {
+	*httptest.Server
+	tsa *testTSA
+
+	// status is the PKI status of the responses.
+	status int
+
+	// nonceDelta is added to the nonce of the request.
+	nonceDelta int64
+}
+
+// newTestTSAServer starts TSA server with the test TSA.
+func newTestTSAServer(t *testing.T, tsa *testTSA) *testTSAServer {
+	t.Helper()
+	s := &testTSAServer{tsa: tsa}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testTSAServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/timestamp-query" {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	var req timeStampReq
+	if _, err := asn1.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := timeStampResp{Status: pkiStatusInfo{Status: s.status}}
+	var der []byte
+	if s.status == TSAStatusRejection {
+		resp.Status.StatusString = []asn1.RawValue{{
+			Tag: asn1.TagUTF8String, Bytes: []byte("unsupported policy"),
+		}}
+		der, _ = asn1.Marshal(struct{ Status pkiStatusInfo }{resp.Status})
+	} else {
+		algo, _ := digestAlgorithmName(req.MessageImprint.HashAlgorithm.Algorithm)
+		nonce := new(big.Int).Add(req.Nonce, big.NewInt(s.nonceDelta))
+		resp.TimeStampToken = asn1.RawValue{
+			FullBytes: s.tsa.token(algo, req.MessageImprint.HashedMessage, nonce),
+		}
+		der, _ = asn1.Marshal(resp)
+	}
+	w.Header().Set("Content-Type", "application/timestamp-reply")
+	w.Write(der)
+}
+
+func TestTSAClient(t *testing.T) {
+	ctx := context.Background()
+	ca, caKey := newTestCA(t, "TEST of EID-SK 2016")
+	tsa := newTestTSA(t, ca, caKey)
+	server := newTestTSAServer(t, tsa)
+	ts := NewTrustStore()
+	ts.AddRoot(ca)
+	client := NewTSAClient(server.URL, ts)
+
+	digest, _ := HashData([]byte("Hello, Smart-ID!"), SHA512)
+	token, err := client.Request(ctx, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := token.CheckDigest(digest); err != nil {
+		t.Error("expected", nil, "got", err)
+	}
+	if token.Nonce == nil || len(token.Certificates) != 1 {
+		t.Error("expected nonce and TSA certificate, got", token.Nonce, token.Certificates)
+	}
+
+	// Token over the signature value can be embedded as evidence.
+	sig := Signature{Value: base64.StdEncoding.EncodeToString([]byte("signature"))}
+	token, err = client.TimestampSignature(ctx, sig, SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigDigest, _ := HashData([]byte("signature"), SHA256)
+	if err := token.CheckDigest(sigDigest); err != nil {
+		t.Error("expected", nil, "got", err)
+	}
+	if der, err := client.Timestamp(ctx, sigDigest); err != nil || der == nil {
+		t.Error("expected token, got", err)
+	}
+
+	server.nonceDelta = 1
+	if _, err := client.Request(ctx, digest); err != ErrTimestampNonceMismatch {
+		t.Error("expected", ErrTimestampNonceMismatch, "got", err)
+	}
+	server.nonceDelta = 0
+
+	server.status = TSAStatusRejection
+	if _, err := client.Request(ctx, digest); !errors.Is(err, ErrTSARejected) {
+		t.Error("expected", ErrTSARejected, "got", err)
+	}
+	server.status = TSAStatusGranted
+
+	client.Policy = asn1.ObjectIdentifier{1, 2, 3, 5}
+	if _, err := client.Request(ctx, digest); err != ErrTimestampPolicyMismatch {
+		t.Error("expected", ErrTimestampPolicyMismatch, "got", err)
+	}
+	client.Policy = nil
+
+	if _, err := NewTSAClient(server.URL, NewTrustStore()).Request(ctx, digest); err == nil {
+		t.Error("expected untrusted TSA error")
+	}
+
+	client.Transport = &HTTPTSATransport{URL: server.URL, MaxSize: 16}
+	if _, err := client.Request(ctx, digest); err != ErrTSAResponseTooLarge {
+		t.Error("expected", ErrTSAResponseTooLarge, "got", err)
+	}
+}
@@ -0,0 +1,304 @@
+package smartid
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"time"
+)
+
+// MaxOCSPDelay is the maximum time between the signature timestamp and
+// production of the OCSP response of the signer certificate.
+const MaxOCSPDelay = 24 * time.Hour
+
+var (
+	// ErrDigestMismatch error when digest of the signed data does not
+	// match the digest in the signature.
+	ErrDigestMismatch = errors.New("Digest of signed data does not match")
+
+	// ErrDataFileNotFound error when signed data file is missing.
+	ErrDataFileNotFound = errors.New("Signed data file not found")
+
+	// ErrDataFileNotSigned error when data file is not covered by the
+	// signature.
+	ErrDataFileNotSigned = errors.New("Data file is not signed")
+
+	// ErrSignerCertNotFound error when signature has no signer
+	// certificate.
+	ErrSignerCertNotFound = errors.New("Signer certificate not found")
+
+	// ErrSigningCertMismatch error when signed signing certificate
+	// reference does not match the signer certificate.
+	ErrSigningCertMismatch = errors.New("Signing certificate reference does not match")
+
+	// ErrRevocationMissing error when signature has no revocation data for
+	// the signer certificate.
+	ErrRevocationMissing = errors.New("Revocation data of signer certificate not found")
+
+	// ErrOCSPStale error when OCSP response of the signer certificate is
+	// produced before the signature or too long after it.
+	ErrOCSPStale = errors.New("OCSP response is not fresh")
+
+	// ErrTimestampMissing error when signature has no signature timestamp.
+	ErrTimestampMissing = errors.New("Signature timestamp not found")
+
+	// ErrChainNotVerified error when the certificate chain of the signer
+	// is not verified, as there is no trust store.
+	ErrChainNotVerified = errors.New("Certificate chain is not verified")
+
+	// ErrLegacyChain warning when the certificate chain is verified with
+	// SHA-1 signatures allowed.
+	ErrLegacyChain = errors.New("Certificate chain is verified with SHA-1 signatures")
+)
+
+// SignatureResult is the validation result of one signature of container
+// or document.
+type SignatureResult struct {
+	// ID is the identifier of the signature, like Id attribute of XAdES
+	// signature or name of PDF signature field.
+	ID string
+
+	// SigningTime is the signing time claimed by the signer.
+	SigningTime time.Time
+
+	// TrustedTime is the time proven by signature timestamp or OCSP
+	// response, zero if there is no proof.
+	TrustedTime time.Time
+
+	// Certificate is the signer certificate.
+	Certificate *x509.Certificate
+
+	// Chain is the verified certificate chain of the signer, leaf first.
+	Chain []*x509.Certificate
+
+	// Identity is the identity of the signer.
+	Identity *Identity
+
+	// Timestamps are the signature timestamps.
+	Timestamps []*TimestampToken
+
+	// ArchiveTimestamps are the archive timestamps of long-term
+	// signature, which cover the signature and validation data.
+	ArchiveTimestamps []*TimestampToken
+
+	// OCSP is the revocation status of the signer certificate.
+	OCSP *OCSPResponse
+
+	// Errors are the reasons why the signature is not valid.
+	Errors []error
+
+	// Warnings are the problems which do not make signature invalid.
+	Warnings []error
+
+	// legacy allows SHA-1 signed certificates in the chains of signer and
+	// OCSP responder, if they do not verify otherwise.
+	legacy bool
+}
+
+// IsValid checks that signature has no errors.
+func (r *SignatureResult) IsValid() bool {
+	return len(r.Errors) == 0
+}
+
+// Err returns all errors joined, nil if the signature is valid.
+func (r *SignatureResult) Err() error {
+	return errors.Join(r.Errors...)
+}
+
+// fail adds error to the result.
+func (r *SignatureResult) fail(err error) {
+	r.Errors = append(r.Errors, err)
+}
+
+// warn adds warning to the result.
+func (r *SignatureResult) warn(err error) {
+	r.Warnings = append(r.Warnings, err)
+}
+
+// setSigner sets signer certificate and identity.
+func (r *SignatureResult) setSigner(cert *x509.Certificate) {
+	r.Certificate = cert
+	r.Identity = newIdentity(&cert.Subject)
+}
+
+// validationTime returns the time for certificate validation: trusted
+// time or current time if there is no trusted time.
+func (r *SignatureResult) validationTime() time.Time {
+	if r.TrustedTime.IsZero() {
+		return time.Now()
+	}
+	return r.TrustedTime
+}
+
+// verifyCertificate verifies the chain of signer certificate and its
+// revocation status from the OCSP responses. The first OCSP response
+// which is fresh and verifies is used. If there is no trusted time yet,
+// the OCSP response (time-mark) provides it.
+func (r *SignatureResult) verifyCertificate(
+	ctx context.Context,
+	ts *TrustStore,
+	intermediates []*x509.Certificate,
+	ocspValues [][]byte,
+) {
+	var fresh []*OCSPResponse
+	stale := false
+	for _, raw := range ocspValues {
+		o, err := ParseOCSPResponse(raw, r.Certificate)
+		if err != nil {
+			continue
+		}
+		if !r.isFreshOCSP(o) {
+			stale = true
+			continue
+		}
+		fresh = append(fresh, o)
+	}
+	at := r.validationTime()
+	if len(fresh) > 0 && r.TrustedTime.IsZero() {
+		at = fresh[0].ProducedAt
+	}
+
+	chains, err := ts.VerifyWithIntermediates(ctx, r.Certificate, intermediates, at)
+	if err != nil && r.legacy {
+		if chains, err = ts.verifyLegacy(r.Certificate, intermediates, at); err == nil {
+			r.warn(ErrLegacyChain)
+		}
+	}
+	if err != nil {
+		r.fail(err)
+	} else {
+		r.Chain = chains[0]
+	}
+	if err := certFromX509(r.Certificate).CheckSigningUsage(); err != nil {
+		r.fail(err)
+	}
+	if len(fresh) == 0 {
+		if stale {
+			r.fail(ErrOCSPStale)
+		}
+		return
+	}
+
+	candidates := append(append([]*x509.Certificate(nil), r.Chain...), intermediates...)
+	issuer := findIssuer(r.Certificate, candidates)
+	var resp *OCSPResponse
+	for _, o := range fresh {
+		if _, err = o.verify(ctx, ts, issuer, r.legacy); err == nil {
+			resp = o
+			break
+		}
+	}
+	if resp == nil {
+		r.fail(err)
+		return
+	}
+	r.OCSP = resp
+	if r.TrustedTime.IsZero() {
+		at = resp.ProducedAt
+	}
+	if resp.Status == OCSPStatusRevoked && resp.RevokedAt.After(at) {
+		r.warn(ErrCertRevoked)
+	} else if err := resp.Err(); err != nil {
+		r.fail(err)
+	}
+	if r.TrustedTime.IsZero() {
+		r.TrustedTime = resp.ProducedAt
+	}
+}
+
+// isFreshOCSP checks that the OCSP response is produced at or after the
+// trusted time, at most MaxOCSPDelay later. Without trusted time, the
+// response must not be produced before the claimed signing time.
+func (r *SignatureResult) isFreshOCSP(o *OCSPResponse) bool {
+	if r.TrustedTime.IsZero() {
+		return r.SigningTime.IsZero() || !o.ProducedAt.Before(r.SigningTime)
+	}
+	return !o.ProducedAt.Before(r.TrustedTime) &&
+		!o.ProducedAt.After(r.TrustedTime.Add(MaxOCSPDelay))
+}
+
+// findIssuer finds issuer of the certificate from the candidates.
+func findIssuer(cert *x509.Certificate, candidates []*x509.Certificate) *x509.Certificate {
+	for _, c := range candidates {
+		if !c.Equal(cert) && checkIssuer(cert, c) == nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// checkIssuer checks that the certificate is issued by the CA. Unlike
+// CheckSignatureFrom, SHA-1 signatures of legacy certificates are
+// accepted.
+func checkIssuer(cert, ca *x509.Certificate) error {
+	if !bytes.Equal(cert.RawIssuer, ca.RawSubject) {
+		return x509.UnknownAuthorityError{Cert: cert}
+	}
+	if ca.Version == 3 && !ca.BasicConstraintsValid || ca.BasicConstraintsValid && !ca.IsCA ||
+		ca.KeyUsage != 0 && ca.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return x509.ConstraintViolationError{}
+	}
+	return ca.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature)
+}
+
+// verifyCMS verifies CMS SignedData over the detached content, signature
+// timestamps and the signer certificate. Certificates and OCSP responses
+// stored outside of the signature may be given. CAdES signatures must
+// have signed reference to the signer certificate.
+func (r *SignatureResult) verifyCMS(
+	ctx context.Context,
+	ts *TrustStore,
+	der, content []byte,
+	certs []*x509.Certificate,
+	ocspValues [][]byte,
+	cades bool,
+) {
+	sd, err := parseSignedData(der)
+	if err != nil {
+		r.fail(err)
+		return
+	}
+	cert, si, err := sd.verify(content)
+	if cert == nil {
+		if err == ErrCMSSignerCertNotFound {
+			err = ErrSignerCertNotFound
+		}
+		r.fail(err)
+		return
+	}
+	r.setSigner(cert)
+	if err != nil {
+		r.fail(err)
+	}
+	var signingTime time.Time
+	if _, err := si.signedAttribute(oidAttrSigningTime, &signingTime); err == nil {
+		r.SigningTime = signingTime
+	}
+	if err := si.checkSigningCertificate(cert, cades); err != nil {
+		r.fail(err)
+	}
+
+	tokens, err := si.signatureTimestamps(ctx, ts)
+	if err != nil {
+		r.fail(err)
+	}
+	for _, token := range tokens {
+		r.Timestamps = append(r.Timestamps, token)
+		if r.TrustedTime.IsZero() || token.Time.Before(r.TrustedTime) {
+			r.TrustedTime = token.Time
+		}
+	}
+
+	if content == nil {
+		content, _ = sd.content()
+	}
+	if r.ArchiveTimestamps, err = si.archiveTimestamps(ctx, ts, sd, content); err != nil {
+		r.fail(err)
+	}
+
+	embedded, _ := sd.certificates()
+	embedded = append(embedded, si.certificateValues()...)
+	r.verifyCertificate(ctx, ts, append(embedded, certs...),
+		append(si.revocationValues(), ocspValues...))
+}
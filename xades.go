@@ -0,0 +1,912 @@
+package smartid
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// XML signature algorithm identifiers.
+const (
+	algDigestSHA1     = "http://www.w3.org/2000/09/xmldsig#sha1"
+	algDigestSHA256   = "http://www.w3.org/2001/04/xmlenc#sha256"
+	algDigestSHA384   = "http://www.w3.org/2001/04/xmldsig-more#sha384"
+	algDigestSHA512   = "http://www.w3.org/2001/04/xmlenc#sha512"
+	algDigestSHA3_256 = "http://www.w3.org/2007/05/xmldsig-more#sha3-256"
+	algDigestSHA3_384 = "http://www.w3.org/2007/05/xmldsig-more#sha3-384"
+	algDigestSHA3_512 = "http://www.w3.org/2007/05/xmldsig-more#sha3-512"
+
+	algRSASHA1     = "http://www.w3.org/2000/09/xmldsig#rsa-sha1"
+	algRSASHA256   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algRSASHA384   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha384"
+	algRSASHA512   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	algECDSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+	algECDSASHA384 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha384"
+	algECDSASHA512 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512"
+
+	algRSAPSSSHA256   = "http://www.w3.org/2007/05/xmldsig-more#sha256-rsa-MGF1"
+	algRSAPSSSHA384   = "http://www.w3.org/2007/05/xmldsig-more#sha384-rsa-MGF1"
+	algRSAPSSSHA512   = "http://www.w3.org/2007/05/xmldsig-more#sha512-rsa-MGF1"
+	algRSAPSSSHA3_256 = "http://www.w3.org/2007/05/xmldsig-more#sha3-256-rsa-MGF1"
+	algRSAPSSSHA3_384 = "http://www.w3.org/2007/05/xmldsig-more#sha3-384-rsa-MGF1"
+	algRSAPSSSHA3_512 = "http://www.w3.org/2007/05/xmldsig-more#sha3-512-rsa-MGF1"
+
+	algEnvelopedSignature = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+
+	typeSignedProperties = "http://uri.etsi.org/01903#SignedProperties"
+)
+
+// xadesTimeFormat is the format of xsd:dateTime used in XAdES.
+const xadesTimeFormat = "2006-01-02T15:04:05Z"
+
+var (
+	// ErrXAdESNotSigned error when signature value is not yet set.
+	ErrXAdESNotSigned = errors.New("XAdES signature value is not set")
+
+	// ErrXAdESMalformed error when XAdES signature structure is invalid.
+	ErrXAdESMalformed = errors.New("Malformed XAdES signature")
+
+	// ErrSignedPropertiesNotSigned error when signature has no single
+	// valid reference to its SignedProperties.
+	ErrSignedPropertiesNotSigned = errors.New("XAdES signed properties are not signed")
+)
+
+// XAdESSignature is XAdES-BES signature which is being prepared. Digest
+// of the canonicalized SignedInfo is signed with Smart-ID and the value is
+// set with SetSignatureValue. Optionally timestamp makes it XAdES-T.
+type XAdESSignature struct {
+	id          string
+	algo        string
+	scheme      signatureScheme
+	cert        *x509.Certificate
+	signingTime time.Time
+	root        *xmlElement
+	signature   *xmlElement
+	digest      *Digest
+	value       []byte
+
+	// signedProperties is the element of the SignedProperties reference,
+	// signing time and certificate are read only from it.
+	signedProperties *xmlElement
+
+	// enveloped is set for signature enveloped into the signed XML
+	// document, which may be plain XMLDSig without qualifying properties.
+	enveloped bool
+
+	// onSigned is called with the verified signature value before it is
+	// set, the container adds the signature then.
+	onSigned func() error
+}
+
+// xadesReference is the reference to the data object.
+type xadesReference struct {
+	uri, mimeType string
+	digest        *Digest
+
+	// enveloped adds enveloped signature and exclusive canonicalization
+	// transforms.
+	enveloped bool
+}
+
+// newXAdESSignature builds signature over the references. If doc is nil,
+// the signature is put into new XAdESSignatures document, otherwise it is
+// appended to the doc element as enveloped signature.
+func newXAdESSignature(
+	id string,
+	refs []xadesReference,
+	cert *x509.Certificate,
+	algo string,
+	signingTime time.Time,
+	doc *xmlElement,
+) (*XAdESSignature, error) {
+	digestURI, err := xmlDigestURI(algo)
+	if err != nil {
+		return nil, err
+	}
+	sigURI, err := xmlSignatureURI(cert, algo)
+	if err != nil {
+		return nil, err
+	}
+	certDigest, err := HashData(cert.Raw, algo)
+	if err != nil {
+		return nil, err
+	}
+	issuerSerial, err := asn1.Marshal(newIssuerSerial(cert))
+	if err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	w := func(format string, args ...interface{}) {
+		for i, a := range args {
+			if s, ok := a.(string); ok {
+				args[i] = xmlEscape(s)
+			}
+		}
+		fmt.Fprintf(&sb, format, args...)
+	}
+	if doc == nil {
+		w(`<asic:XAdESSignatures xmlns:asic="%s" xmlns:ds="%s" xmlns:xades="%s">`,
+			nsASiC, nsXMLDSig, nsXAdES)
+		w(`<ds:Signature Id="%s">`, id)
+	} else {
+		w(`<ds:Signature xmlns:ds="%s" xmlns:xades="%s" Id="%s">`, nsXMLDSig, nsXAdES, id)
+	}
+	w(`<ds:SignedInfo>`)
+	w(`<ds:CanonicalizationMethod Algorithm="%s"/>`, algExcC14N)
+	w(`<ds:SignatureMethod Algorithm="%s"/>`, sigURI)
+	for i, ref := range refs {
+		w(`<ds:Reference Id="%s-RefId%d" URI="%s">`, id, i, ref.uri)
+		if ref.enveloped {
+			w(`<ds:Transforms><ds:Transform Algorithm="%s"/>`, algEnvelopedSignature)
+			w(`<ds:Transform Algorithm="%s"/></ds:Transforms>`, algExcC14N)
+		}
+		w(`<ds:DigestMethod Algorithm="%s"/>`, digestURI)
+		w(`<ds:DigestValue>%s</ds:DigestValue></ds:Reference>`,
+			ref.digest.AuthHash().ToBase64String())
+	}
+	w(`<ds:Reference Id="%s-RefId%d" Type="%s" URI="#%s-SignedProperties">`,
+		id, len(refs), typeSignedProperties, id)
+	w(`<ds:Transforms><ds:Transform Algorithm="%s"/></ds:Transforms>`, algExcC14N)
+	w(`<ds:DigestMethod Algorithm="%s"/>`, digestURI)
+	w(`<ds:DigestValue></ds:DigestValue></ds:Reference></ds:SignedInfo>`)
+	w(`<ds:SignatureValue Id="%s-SIG"></ds:SignatureValue>`, id)
+	w(`<ds:KeyInfo><ds:X509Data><ds:X509Certificate>%s</ds:X509Certificate>`,
+		base64.StdEncoding.EncodeToString(cert.Raw))
+	w(`</ds:X509Data></ds:KeyInfo>`)
+	w(`<ds:Object><xades:QualifyingProperties Id="%s-QualifyingProperties" Target="#%s">`,
+		id, id)
+	w(`<xades:SignedProperties Id="%s-SignedProperties">`, id)
+	w(`<xades:SignedSignatureProperties>`)
+	w(`<xades:SigningTime>%s</xades:SigningTime>`,
+		signingTime.UTC().Format(xadesTimeFormat))
+	w(`<xades:SigningCertificateV2><xades:Cert><xades:CertDigest>`)
+	w(`<ds:DigestMethod Algorithm="%s"/><ds:DigestValue>%s</ds:DigestValue>`,
+		digestURI, certDigest.AuthHash().ToBase64String())
+	w(`</xades:CertDigest><xades:IssuerSerialV2>%s</xades:IssuerSerialV2>`,
+		base64.StdEncoding.EncodeToString(issuerSerial))
+	w(`</xades:Cert></xades:SigningCertificateV2></xades:SignedSignatureProperties>`)
+	w(`<xades:SignedDataObjectProperties>`)
+	for i, ref := range refs {
+		w(`<xades:DataObjectFormat ObjectReference="#%s-RefId%d">`, id, i)
+		w(`<xades:MimeType>%s</xades:MimeType></xades:DataObjectFormat>`, ref.mimeType)
+	}
+	w(`</xades:SignedDataObjectProperties></xades:SignedProperties>`)
+	w(`</xades:QualifyingProperties></ds:Object></ds:Signature>`)
+	if doc == nil {
+		w(`</asic:XAdESSignatures>`)
+	}
+
+	root, err := parseXML([]byte(sb.String()))
+	if err != nil {
+		return nil, err
+	}
+	s := &XAdESSignature{
+		id:          id,
+		algo:        algo,
+		scheme:      xmlSignatureScheme(sigURI),
+		cert:        cert,
+		signingTime: signingTime.UTC().Truncate(time.Second),
+		root:        root,
+		signature:   root.Child(nsXMLDSig, "Signature"),
+	}
+	if doc != nil {
+		s.root, s.signature, s.enveloped = doc, root, true
+		root.Parent = doc
+		doc.Children = append(doc.Children, root)
+	}
+
+	signedInfo := s.signature.Child(nsXMLDSig, "SignedInfo")
+	spRef := signedInfo.ChildrenNamed(nsXMLDSig, "Reference")[len(refs)]
+	spDigest, err := digestElement(root.FindByID(id+"-SignedProperties"), algExcC14N, algo)
+	if err != nil {
+		return nil, err
+	}
+	spRef.Child(nsXMLDSig, "DigestValue").SetText(spDigest.AuthHash().ToBase64String())
+
+	if s.digest, err = digestElement(signedInfo, algExcC14N, algo); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ID returns the Id attribute of the signature.
+func (s *XAdESSignature) ID() string {
+	return s.id
+}
+
+// Digest returns digest of the canonicalized SignedInfo, which is signed
+// by Smart-ID.
+func (s *XAdESSignature) Digest() *Digest {
+	return s.digest
+}
+
+// SigningTime returns claimed signing time.
+func (s *XAdESSignature) SigningTime() time.Time {
+	return s.signingTime
+}
+
+// Certificate returns the signer certificate.
+func (s *XAdESSignature) Certificate() *x509.Certificate {
+	return s.cert
+}
+
+// SetSignatureValue sets the signature value returned by Smart-ID. Value
+// is verified with the signer certificate.
+func (s *XAdESSignature) SetSignatureValue(sig Signature) error {
+	value, err := base64.StdEncoding.DecodeString(sig.Value)
+	if err != nil {
+		return err
+	}
+	err = verifySignatureScheme(s.cert, s.scheme, s.digest.CryptoHash(), s.digest.AuthHash(), value)
+	if err != nil {
+		return err
+	}
+	if s.onSigned != nil && s.value == nil {
+		if err := s.onSigned(); err != nil {
+			return err
+		}
+	}
+	s.value = value
+	s.signature.Child(nsXMLDSig, "SignatureValue").
+		SetText(base64.StdEncoding.EncodeToString(value))
+	return nil
+}
+
+// Sign signs the signature with Smart-ID using SignSync. Request should
+// have the identifier of the person, preferably document number from the
+// certificate choice. Digest of the request is set automatically.
+func (s *XAdESSignature) Sign(ctx context.Context, c *Client, req *AuthRequest) (*SessionResponse, error) {
+	resp, err := signDigest(ctx, c, req, s.digest, s.cert)
+	if err != nil {
+		return resp, err
+	}
+	return resp, s.SetSignatureValue(resp.Signature)
+}
+
+// AddTimestamp adds signature timestamp over the signature value, which
+// makes the signature XAdES-T.
+func (s *XAdESSignature) AddTimestamp(ctx context.Context, ts Timestamper) error {
+	if s.value == nil {
+		return ErrXAdESNotSigned
+	}
+	sigValue := s.signature.Child(nsXMLDSig, "SignatureValue")
+	digest, err := digestElement(sigValue, algExcC14N, s.algo)
+	if err != nil {
+		return err
+	}
+	token, err := ts.Timestamp(ctx, digest)
+	if err != nil {
+		return err
+	}
+
+	usp := s.unsignedSignatureProperties()
+	sts := usp.AddChild("xades", "SignatureTimeStamp",
+		xmlAttr{Local: "Id", Value: s.id + "-T0"})
+	sts.AddChild("ds", "CanonicalizationMethod",
+		xmlAttr{Local: "Algorithm", Value: algExcC14N})
+	sts.AddChild("xades", "EncapsulatedTimeStamp").
+		SetText(base64.StdEncoding.EncodeToString(token))
+	return nil
+}
+
+// Bytes serializes signatures document, which is stored in the container
+// as META-INF/signatures*.xml. For enveloped signature it is the signed
+// document.
+func (s *XAdESSignature) Bytes() ([]byte, error) {
+	if s.value == nil {
+		return nil, ErrXAdESNotSigned
+	}
+	c14n, err := canonicalize(s.root, algC14N11, nil)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), c14n...), nil
+}
+
+// unsignedSignatureProperties finds or creates UnsignedSignatureProperties
+// element.
+func (s *XAdESSignature) unsignedSignatureProperties() *xmlElement {
+	qp := s.signature.Find(nsXAdES, "QualifyingProperties")
+	up := qp.Child(nsXAdES, "UnsignedProperties")
+	if up == nil {
+		up = qp.AddChild("xades", "UnsignedProperties")
+	}
+	usp := up.Child(nsXAdES, "UnsignedSignatureProperties")
+	if usp == nil {
+		usp = up.AddChild("xades", "UnsignedSignatureProperties")
+	}
+	return usp
+}
+
+// issuerSerial identifies the certificate by issuer and serial number.
+//
+//	IssuerSerial ::= SEQUENCE {
+//		issuer       GeneralNames,
+//		serialNumber CertificateSerialNumber }
+type issuerSerial struct {
+	Issuer       []asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// newIssuerSerial makes IssuerSerial with the issuer as directoryName.
+func newIssuerSerial(cert *x509.Certificate) issuerSerial {
+	return issuerSerial{
+		Issuer: []asn1.RawValue{{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        4,
+			IsCompound: true,
+			Bytes:      cert.RawIssuer,
+		}},
+		SerialNumber: cert.SerialNumber,
+	}
+}
+
+// digestElement canonicalizes element and computes its digest.
+func digestElement(el *xmlElement, c14nAlg, algo string) (*Digest, error) {
+	if el == nil {
+		return nil, ErrXMLNoRoot
+	}
+	c14n, err := canonicalize(el, c14nAlg, nil)
+	if err != nil {
+		return nil, err
+	}
+	return HashData(c14n, algo)
+}
+
+// xmlDigestURI returns XML digest method for the hash type.
+func xmlDigestURI(algo string) (string, error) {
+	switch algo {
+	case SHA256:
+		return algDigestSHA256, nil
+	case SHA384:
+		return algDigestSHA384, nil
+	case SHA512:
+		return algDigestSHA512, nil
+	case SHA3_256:
+		return algDigestSHA3_256, nil
+	case SHA3_384:
+		return algDigestSHA3_384, nil
+	case SHA3_512:
+		return algDigestSHA3_512, nil
+	default:
+		return "", ErrHashUnsupported
+	}
+}
+
+// xmlSignatureURI returns XML signature method for the key of certificate
+// and hash type.
+func xmlSignatureURI(cert *x509.Certificate, algo string) (string, error) {
+	var uris map[string]string
+	switch cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		uris = map[string]string{
+			SHA256: algRSASHA256, SHA384: algRSASHA384, SHA512: algRSASHA512,
+		}
+	case *ecdsa.PublicKey:
+		uris = map[string]string{
+			SHA256: algECDSASHA256, SHA384: algECDSASHA384, SHA512: algECDSASHA512,
+		}
+	default:
+		return "", ErrSignatureKeyUnsupported
+	}
+	uri, ok := uris[algo]
+	if !ok {
+		return "", ErrHashUnsupported
+	}
+	return uri, nil
+}
+
+// xmlEscape escapes text and attribute values.
+func xmlEscape(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
+
+// fileURI makes relative URI of the file in the container.
+func fileURI(name string) string {
+	return (&url.URL{Path: name}).EscapedPath()
+}
+
+// parseXAdESSignatures parses signatures document of the container.
+func parseXAdESSignatures(data []byte) ([]*XAdESSignature, error) {
+	root, err := parseXML(data)
+	if err != nil {
+		return nil, err
+	}
+	var sigs []*XAdESSignature
+	for _, el := range root.FindAll(nsXMLDSig, "Signature") {
+		s, err := parseXAdESSignature(root, el)
+		if err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, s)
+	}
+	if len(sigs) == 0 {
+		return nil, ErrXAdESMalformed
+	}
+	return sigs, nil
+}
+
+// parseXAdESSignature parses signature element of the document.
+func parseXAdESSignature(root, el *xmlElement) (*XAdESSignature, error) {
+	signedInfo := el.Child(nsXMLDSig, "SignedInfo")
+	sigValue := el.Child(nsXMLDSig, "SignatureValue")
+	if signedInfo == nil || sigValue == nil {
+		return nil, ErrXAdESMalformed
+	}
+	method := signedInfo.Child(nsXMLDSig, "SignatureMethod").Attr("Algorithm")
+	algo, ok := xmlSignatureHashes[method]
+	if !ok {
+		return nil, ErrHashUnsupported
+	}
+	s := &XAdESSignature{
+		id:        el.Attr("Id"),
+		algo:      algo,
+		scheme:    xmlSignatureScheme(method),
+		root:      root,
+		signature: el,
+	}
+
+	alg, prefixes := c14nMethod(signedInfo.Child(nsXMLDSig, "CanonicalizationMethod"), algC14N10)
+	c14n, err := canonicalize(signedInfo, alg, prefixes)
+	if err != nil {
+		return nil, err
+	}
+	if s.digest, err = HashData(c14n, algo); err != nil {
+		return nil, err
+	}
+	if s.value, err = decodeBase64Text(sigValue.Text()); err != nil {
+		return nil, err
+	}
+	if v := el.Path(nsXMLDSig, "KeyInfo", "X509Data", "X509Certificate"); v != nil {
+		der, err := decodeBase64Text(v.Text())
+		if err != nil {
+			return nil, err
+		}
+		if s.cert, err = x509.ParseCertificate(der); err != nil {
+			return nil, err
+		}
+	}
+	_, s.signedProperties = s.signedPropertiesReference()
+	if st := s.signedProperties.Path(nsXAdES, "SignedSignatureProperties", "SigningTime"); st != nil {
+		if s.signingTime, err = time.Parse(time.RFC3339, st.Text()); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// signedPropertiesReference returns the reference to SignedProperties and
+// the referenced element. Nil is returned unless there is exactly one such
+// reference and it resolves to SignedProperties of this signature.
+func (s *XAdESSignature) signedPropertiesReference() (*xmlElement, *xmlElement) {
+	var ref *xmlElement
+	for _, r := range s.signature.Child(nsXMLDSig, "SignedInfo").ChildrenNamed(nsXMLDSig, "Reference") {
+		if r.Attr("Type") != typeSignedProperties {
+			continue
+		}
+		if ref != nil {
+			return nil, nil
+		}
+		ref = r
+	}
+	uri := ref.Attr("URI")
+	if !strings.HasPrefix(uri, "#") {
+		return nil, nil
+	}
+	// Element must be the one digested by referenceData.
+	el := s.root.FindByID(uri[1:])
+	if el == nil || !el.Is(nsXAdES, "SignedProperties") || s.signature.FindByID(uri[1:]) != el {
+		return nil, nil
+	}
+	return ref, el
+}
+
+// validate validates the signature over the data files. Names of the
+// signed data files are returned.
+func (s *XAdESSignature) validate(
+	ctx context.Context,
+	ts *TrustStore,
+	files map[string][]byte,
+) (*SignatureResult, map[string]bool) {
+	r := &SignatureResult{ID: s.id}
+	signed := make(map[string]bool)
+	spRef, _ := s.signedPropertiesReference()
+	var spSigned bool
+	refs := s.signature.Child(nsXMLDSig, "SignedInfo").ChildrenNamed(nsXMLDSig, "Reference")
+	for _, ref := range refs {
+		name, err := s.checkReference(ref, files)
+		if err != nil {
+			r.fail(err)
+			continue
+		}
+		if name != "" {
+			signed[name] = true
+		}
+		if ref == spRef {
+			spSigned = true
+			r.SigningTime = s.signingTime
+		}
+	}
+
+	if s.cert == nil {
+		r.fail(ErrSignerCertNotFound)
+		return r, signed
+	}
+	r.setSigner(s.cert)
+	err := verifySignatureScheme(s.cert, s.scheme, s.digest.CryptoHash(), s.digest.AuthHash(), s.value)
+	if err != nil {
+		r.fail(ErrSignatureInvalid)
+	}
+	// Plain XMLDSig has no signed properties with the signing certificate.
+	if !s.enveloped || s.signature.Find(nsXAdES, "QualifyingProperties") != nil {
+		if !spSigned {
+			r.fail(ErrSignedPropertiesNotSigned)
+		} else if err := s.checkSigningCertificate(); err != nil {
+			r.fail(err)
+		}
+	}
+	s.checkTimestamps(ctx, ts, r)
+	s.checkArchiveTimestamps(ctx, ts, r, files)
+
+	var certs []*x509.Certificate
+	for _, v := range s.signature.FindAll(nsXAdES, "EncapsulatedX509Certificate") {
+		der, err := decodeBase64Text(v.Text())
+		if err != nil {
+			continue
+		}
+		if c, err := x509.ParseCertificate(der); err == nil {
+			certs = append(certs, c)
+		}
+	}
+	var ocspValues [][]byte
+	for _, v := range s.signature.FindAll(nsXAdES, "EncapsulatedOCSPValue") {
+		if der, err := decodeBase64Text(v.Text()); err == nil {
+			ocspValues = append(ocspValues, der)
+		}
+	}
+	r.verifyCertificate(ctx, ts, certs, ocspValues)
+	if r.OCSP == nil {
+		r.warn(ErrRevocationMissing)
+	}
+	return r, signed
+}
+
+// checkReference checks digest of the referenced data. For data file its
+// name is returned.
+func (s *XAdESSignature) checkReference(ref *xmlElement, files map[string][]byte) (string, error) {
+	expected, err := decodeBase64Text(ref.Child(nsXMLDSig, "DigestValue").Text())
+	if err != nil {
+		return "", err
+	}
+	method := ref.Child(nsXMLDSig, "DigestMethod").Attr("Algorithm")
+	name, data, err := s.referenceData(ref, files)
+	if err != nil {
+		return "", err
+	}
+
+	sum, err := xmlDigest(data, method)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(sum, expected) {
+		return "", fmt.Errorf("%w: %s", ErrDigestMismatch, ref.Attr("URI"))
+	}
+	return name, nil
+}
+
+// referenceData returns the referenced data after transforms.
+// Same-document references are canonicalized, the empty URI refers to the
+// whole document. Others are data files of the container. For data file
+// its name is returned.
+func (s *XAdESSignature) referenceData(ref *xmlElement, files map[string][]byte) (string, []byte, error) {
+	uri := ref.Attr("URI")
+	if uri == "" || strings.HasPrefix(uri, "#") {
+		el := s.root
+		if uri != "" {
+			el = s.root.FindByID(uri[1:])
+		}
+		if el == nil {
+			return "", nil, fmt.Errorf("%w: %s", ErrXAdESMalformed, uri)
+		}
+		alg, prefixes := algC14N10, []string(nil)
+		for _, t := range ref.Path(nsXMLDSig, "Transforms").ChildrenNamed(nsXMLDSig, "Transform") {
+			if a := t.Attr("Algorithm"); a == algEnvelopedSignature {
+				el = el.clone(s.signature)
+			} else {
+				alg, prefixes = c14nMethod(t, algC14N10)
+			}
+		}
+		data, err := canonicalize(el, alg, prefixes)
+		return "", data, err
+	}
+
+	name, err := url.PathUnescape(uri)
+	if err != nil {
+		return "", nil, err
+	}
+	data, ok := files[name]
+	if !ok {
+		return "", nil, fmt.Errorf("%w: %s", ErrDataFileNotFound, name)
+	}
+	return name, data, nil
+}
+
+// checkSigningCertificate checks that certificate digest of the signed
+// properties matches the signer certificate.
+func (s *XAdESSignature) checkSigningCertificate() error {
+	ssp := s.signedProperties.Child(nsXAdES, "SignedSignatureProperties")
+	sc := ssp.Child(nsXAdES, "SigningCertificateV2")
+	if sc == nil {
+		sc = ssp.Child(nsXAdES, "SigningCertificate")
+	}
+	return checkCertDigest(sc, nsXAdES, s.cert)
+}
+
+// checkCertDigest checks that digest of one of the Cert elements of
+// SigningCertificate in the XAdES namespace matches the certificate.
+func checkCertDigest(sc *xmlElement, space string, cert *x509.Certificate) error {
+	if sc == nil {
+		return ErrSigningCertMismatch
+	}
+	for _, c := range sc.ChildrenNamed(space, "Cert") {
+		certDigest := c.Child(space, "CertDigest")
+		expected, err := decodeBase64Text(certDigest.Child(nsXMLDSig, "DigestValue").Text())
+		if err != nil {
+			continue
+		}
+		method := certDigest.Child(nsXMLDSig, "DigestMethod").Attr("Algorithm")
+		if sum, err := xmlDigest(cert.Raw, method); err == nil && bytes.Equal(sum, expected) {
+			return nil
+		}
+	}
+	return ErrSigningCertMismatch
+}
+
+// checkTimestamps verifies signature timestamps, earliest of them is the
+// trusted time of the signature.
+func (s *XAdESSignature) checkTimestamps(ctx context.Context, ts *TrustStore, r *SignatureResult) {
+	sigValue := s.signature.Child(nsXMLDSig, "SignatureValue")
+	for _, sts := range s.signature.FindAll(nsXAdES, "SignatureTimeStamp") {
+		enc := sts.Child(nsXAdES, "EncapsulatedTimeStamp")
+		if enc == nil {
+			continue
+		}
+		token, err := s.verifyTimestamp(ctx, ts, sts, enc, sigValue)
+		if err != nil {
+			r.fail(err)
+			continue
+		}
+		r.Timestamps = append(r.Timestamps, token)
+		if r.TrustedTime.IsZero() || token.Time.Before(r.TrustedTime) {
+			r.TrustedTime = token.Time
+		}
+	}
+}
+
+// checkArchiveTimestamps verifies archive timestamps of long-term
+// signature.
+func (s *XAdESSignature) checkArchiveTimestamps(
+	ctx context.Context,
+	ts *TrustStore,
+	r *SignatureResult,
+	files map[string][]byte,
+) {
+	for _, ats := range s.signature.FindAll(nsXAdES141, "ArchiveTimeStamp") {
+		token, err := s.verifyArchiveTimestamp(ctx, ts, ats, files)
+		if err != nil {
+			r.fail(err)
+			continue
+		}
+		r.ArchiveTimestamps = append(r.ArchiveTimestamps, token)
+	}
+}
+
+// verifyArchiveTimestamp verifies the archive timestamp token over the
+// signature and the preceding unsigned properties.
+func (s *XAdESSignature) verifyArchiveTimestamp(
+	ctx context.Context,
+	ts *TrustStore,
+	ats *xmlElement,
+	files map[string][]byte,
+) (*TimestampToken, error) {
+	enc := ats.Child(nsXAdES, "EncapsulatedTimeStamp")
+	if enc == nil {
+		return nil, ErrXAdESMalformed
+	}
+	der, err := decodeBase64Text(enc.Text())
+	if err != nil {
+		return nil, err
+	}
+	token, err := ParseTimestampToken(der)
+	if err != nil {
+		return nil, err
+	}
+	alg, prefixes := c14nMethod(ats.Child(nsXMLDSig, "CanonicalizationMethod"), algC14N10)
+	data, err := s.archiveTimestampData(files, ats, alg, prefixes)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := HashData(data, token.Digest.Algorithm())
+	if err != nil {
+		return nil, err
+	}
+	if err := token.CheckDigest(digest); err != nil {
+		return nil, err
+	}
+	if _, err := token.Verify(ctx, ts); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// archiveTimestampData returns data covered by the archive timestamp
+// (XAdES 1.4.1 section 8.2): referenced data, SignedInfo, SignatureValue,
+// KeyInfo, the unsigned signature properties preceding the timestamp and
+// other ds:Object elements. If ats is nil, all unsigned signature
+// properties are included.
+func (s *XAdESSignature) archiveTimestampData(
+	files map[string][]byte,
+	ats *xmlElement,
+	alg string,
+	prefixes []string,
+) ([]byte, error) {
+	var buf bytes.Buffer
+	write := func(el *xmlElement) error {
+		c14n, err := canonicalize(el, alg, prefixes)
+		buf.Write(c14n)
+		return err
+	}
+	refs := s.signature.Child(nsXMLDSig, "SignedInfo").ChildrenNamed(nsXMLDSig, "Reference")
+	for _, ref := range refs {
+		_, data, err := s.referenceData(ref, files)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+	}
+	for _, local := range []string{"SignedInfo", "SignatureValue", "KeyInfo"} {
+		if el := s.signature.Child(nsXMLDSig, local); el != nil {
+			if err := write(el); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if usp := s.signature.Find(nsXAdES, "UnsignedSignatureProperties"); usp != nil {
+		for _, el := range usp.Elements() {
+			if el == ats {
+				break
+			}
+			if err := write(el); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, obj := range s.signature.ChildrenNamed(nsXMLDSig, "Object") {
+		if obj.Child(nsXAdES, "QualifyingProperties") != nil {
+			continue
+		}
+		if err := write(obj); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// verifyTimestamp verifies the timestamp token over the canonicalized
+// element.
+func (s *XAdESSignature) verifyTimestamp(
+	ctx context.Context,
+	ts *TrustStore,
+	sts, enc, el *xmlElement,
+) (*TimestampToken, error) {
+	der, err := decodeBase64Text(enc.Text())
+	if err != nil {
+		return nil, err
+	}
+	token, err := ParseTimestampToken(der)
+	if err != nil {
+		return nil, err
+	}
+	alg, prefixes := c14nMethod(sts.Child(nsXMLDSig, "CanonicalizationMethod"), algC14N10)
+	c14n, err := canonicalize(el, alg, prefixes)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := HashData(c14n, token.Digest.Algorithm())
+	if err != nil {
+		return nil, err
+	}
+	if err := token.CheckDigest(digest); err != nil {
+		return nil, err
+	}
+	if _, err := token.Verify(ctx, ts); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// c14nMethod returns canonicalization algorithm and inclusive namespace
+// prefixes of CanonicalizationMethod or Transform element. If element is
+// nil, the default algorithm is returned.
+func c14nMethod(el *xmlElement, def string) (string, []string) {
+	if el == nil {
+		return def, nil
+	}
+	var prefixes []string
+	if inc := el.Child(algExcC14N, "InclusiveNamespaces"); inc != nil {
+		prefixes = strings.Fields(inc.Attr("PrefixList"))
+	}
+	return el.Attr("Algorithm"), prefixes
+}
+
+// xmlDigest computes digest with XML digest method. SHA-1 is supported
+// only for verification of older signatures.
+func xmlDigest(data []byte, method string) ([]byte, error) {
+	if method == algDigestSHA1 {
+		sum := sha1.Sum(data)
+		return sum[:], nil
+	}
+	for _, algo := range []string{SHA256, SHA384, SHA512, SHA3_256, SHA3_384, SHA3_512} {
+		if uri, _ := xmlDigestURI(algo); uri == method {
+			d, err := HashData(data, algo)
+			if err != nil {
+				return nil, err
+			}
+			return d.AuthHash(), nil
+		}
+	}
+	return nil, ErrHashUnsupported
+}
+
+// decodeBase64Text decodes base64 text content, which may contain line
+// breaks.
+func decodeBase64Text(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
+
+// xmlSignatureScheme returns the scheme of XML signature method. XMLDSig
+// ECDSA signature value is r||s encoded.
+func xmlSignatureScheme(method string) signatureScheme {
+	switch method {
+	case algRSASHA1, algRSASHA256, algRSASHA384, algRSASHA512:
+		return schemePKCS1v15
+	case algRSAPSSSHA256, algRSAPSSSHA384, algRSAPSSSHA512,
+		algRSAPSSSHA3_256, algRSAPSSSHA3_384, algRSAPSSSHA3_512:
+		return schemePSS
+	case algECDSASHA256, algECDSASHA384, algECDSASHA512:
+		return schemeECDSARaw
+	default:
+		return 0
+	}
+}
+
+// xmlSignatureHashes maps XML signature methods to hash types.
+var xmlSignatureHashes = map[string]string{
+	algRSASHA256:      SHA256,
+	algRSASHA384:      SHA384,
+	algRSASHA512:      SHA512,
+	algECDSASHA256:    SHA256,
+	algECDSASHA384:    SHA384,
+	algECDSASHA512:    SHA512,
+	algRSAPSSSHA256:   SHA256,
+	algRSAPSSSHA384:   SHA384,
+	algRSAPSSSHA512:   SHA512,
+	algRSAPSSSHA3_256: SHA3_256,
+	algRSAPSSSHA3_384: SHA3_384,
+	algRSAPSSSHA3_512: SHA3_512,
+}
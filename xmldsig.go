@@ -0,0 +1,80 @@
+package smartid
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrXMLSignatureNotFound error when XML document has no signature.
+	ErrXMLSignatureNotFound = errors.New("XML signature not found")
+
+	// ErrXMLDocumentNotSigned error when signature does not cover the
+	// whole document.
+	ErrXMLDocumentNotSigned = errors.New("XML document is not signed")
+)
+
+// PrepareXMLSignature prepares XAdES-BES signature enveloped into the XML
+// document, e.g. e-invoice. Signature is appended to the root element and
+// references the whole document with enveloped signature and exclusive
+// canonicalization transforms. Signed document is returned by Bytes.
+func PrepareXMLSignature(doc []byte, cert *x509.Certificate, algo string) (*XAdESSignature, error) {
+	root, err := parseXML(doc)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := digestElement(root, algExcC14N, algo)
+	if err != nil {
+		return nil, err
+	}
+	id := "S0"
+	for i := 1; root.FindByID(id) != nil; i++ {
+		id = fmt.Sprintf("S%d", i)
+	}
+	ref := xadesReference{mimeType: "text/xml", digest: digest, enveloped: true}
+	return newXAdESSignature(id, []xadesReference{ref}, cert, algo, time.Now(), root)
+}
+
+// VerifyXMLSignature validates enveloped XMLDSig and XAdES signatures of
+// the XML document. Each signature must cover the whole document. Error is
+// returned if the document or signatures cannot be parsed.
+func VerifyXMLSignature(ctx context.Context, doc []byte, ts *TrustStore) ([]*SignatureResult, error) {
+	root, err := parseXML(doc)
+	if err != nil {
+		return nil, err
+	}
+	els := root.FindAll(nsXMLDSig, "Signature")
+	if len(els) == 0 {
+		return nil, ErrXMLSignatureNotFound
+	}
+	results := make([]*SignatureResult, len(els))
+	for i, el := range els {
+		s, err := parseXAdESSignature(root, el)
+		if err != nil {
+			return nil, err
+		}
+		s.enveloped = true
+		r, _ := s.validate(ctx, ts, nil)
+		if !s.signsDocument() {
+			r.fail(ErrXMLDocumentNotSigned)
+		}
+		results[i] = r
+	}
+	return results, nil
+}
+
+// signsDocument checks that the signature references the root element of
+// the document.
+func (s *XAdESSignature) signsDocument() bool {
+	refs := s.signature.Child(nsXMLDSig, "SignedInfo").ChildrenNamed(nsXMLDSig, "Reference")
+	for _, ref := range refs {
+		uri := ref.Attr("URI")
+		if uri == "" || uri[0] == '#' && s.root.FindByID(uri[1:]) == s.root {
+			return true
+		}
+	}
+	return false
+}
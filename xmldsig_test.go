@@ -0,0 +1,134 @@
+package smartid
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// testInvoice is a shortened UBL invoice.
+const testInvoice = `<?xml version="1.0" encoding="UTF-8"?>
+<!-- e-invoice -->
+<Invoice xmlns="urn:oasis:names:specification:ubl:schema:xsd:Invoice-2"
+	xmlns:cbc="urn:oasis:names:specification:ubl:schema:xsd:CommonBasicComponents-2"
+	xmlns:unused="urn:example:unused">
+	<cbc:ID>INV-001</cbc:ID>
+	<cbc:Note attr="a &amp; b">Smart-ID &lt;test&gt;</cbc:Note>
+	<cbc:PayableAmount currencyID="EUR">10.00</cbc:PayableAmount>
+</Invoice>
+`
+
+func TestXMLSignature(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+
+	s, err := PrepareXMLSignature([]byte(testInvoice), mock.signCert, SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ID() != "S0" {
+		t.Error("expected", "S0", "got", s.ID())
+	}
+	if _, err := s.Bytes(); err != ErrXAdESNotSigned {
+		t.Error("expected", ErrXAdESNotSigned, "got", err)
+	}
+	_, err = s.Sign(ctx, mock.client(), &AuthRequest{
+		Identifier: mockDocumentNumber,
+		AuthType:   AuthTypeDocument,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddTimestamp(ctx, newTestTSA(t, mock.ca, mock.caKey)); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := s.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := VerifyXMLSignature(ctx, doc, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].IsValid() {
+		t.Fatal("expected valid signature, got", results[0].Err())
+	}
+	r := results[0]
+	if r.ID != "S0" || len(r.Timestamps) != 1 || r.TrustedTime.IsZero() {
+		t.Error("expected signature with timestamp, got", r.ID, r.Timestamps)
+	}
+	if r.Identity.SerialNumber != "PNOEE-30303039914" {
+		t.Error("expected", "PNOEE-30303039914", "got", r.Identity)
+	}
+
+	modified := bytes.Replace(doc, []byte("10.00"), []byte("99.00"), 1)
+	results, err = VerifyXMLSignature(ctx, modified, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].IsValid() || !errors.Is(results[0].Err(), ErrDigestMismatch) {
+		t.Error("expected", ErrDigestMismatch, "got", results[0].Err())
+	}
+
+	if _, err := VerifyXMLSignature(ctx, []byte(testInvoice), ts); err != ErrXMLSignatureNotFound {
+		t.Error("expected", ErrXMLSignatureNotFound, "got", err)
+	}
+}
+
+func TestVerifyXMLSignature_xmldsig(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSmartID(t)
+	ts := NewTrustStore()
+	ts.AddRoot(mock.ca)
+
+	doc := `<doc:Form xmlns:doc="urn:example:form" Id="form"><doc:Name>Test</doc:Name>%s</doc:Form>`
+	root, _ := parseXML([]byte(fmt.Sprintf(doc, "")))
+	digest, _ := digestElement(root, algExcC14N, SHA256)
+	sig := fmt.Sprintf(`<ds:Signature xmlns:ds="%s"><ds:SignedInfo>`+
+		`<ds:CanonicalizationMethod Algorithm="%s"/>`+
+		`<ds:SignatureMethod Algorithm="%s"/>`+
+		`<ds:Reference URI="#form"><ds:Transforms>`+
+		`<ds:Transform Algorithm="%s"/><ds:Transform Algorithm="%s"/>`+
+		`</ds:Transforms><ds:DigestMethod Algorithm="%s"/>`+
+		`<ds:DigestValue>%s</ds:DigestValue></ds:Reference></ds:SignedInfo>`+
+		`<ds:SignatureValue>%%s</ds:SignatureValue>`+
+		`<ds:KeyInfo><ds:X509Data><ds:X509Certificate>%s</ds:X509Certificate>`+
+		`</ds:X509Data></ds:KeyInfo></ds:Signature>`,
+		nsXMLDSig, algExcC14N, algRSASHA256, algEnvelopedSignature, algExcC14N,
+		algDigestSHA256, digest.AuthHash().ToBase64String(),
+		base64.StdEncoding.EncodeToString(mock.signCert.Raw))
+	el, _ := parseXML([]byte(sig))
+	siDigest, _ := digestElement(el.Child(nsXMLDSig, "SignedInfo"), algExcC14N, SHA256)
+	value, _ := rsa.SignPKCS1v15(rand.Reader, mock.key, siDigest.CryptoHash(), siDigest.AuthHash())
+	sig = fmt.Sprintf(sig, base64.StdEncoding.EncodeToString(value))
+
+	results, err := VerifyXMLSignature(ctx, []byte(fmt.Sprintf(doc, sig)), ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results[0].IsValid() {
+		t.Error("expected valid signature, got", results[0].Err())
+	}
+
+	// Signature over the child element does not sign the document.
+	results, _ = VerifyXMLSignature(ctx, []byte(fmt.Sprintf(doc,
+		bytes.Replace([]byte(sig), []byte(`URI="#form"`), []byte(`URI="#name"`), 1))), ts)
+	if results[0].IsValid() || !errors.Is(results[0].Err(), ErrXMLDocumentNotSigned) {
+		t.Error("expected", ErrXMLDocumentNotSigned, "got", results[0].Err())
+	}
+
+	// Empty fragment does not refer to the document.
+	results, _ = VerifyXMLSignature(ctx, []byte(fmt.Sprintf(doc,
+		bytes.Replace([]byte(sig), []byte(`URI="#form"`), []byte(`URI="#"`), 1))), ts)
+	if results[0].IsValid() || !errors.Is(results[0].Err(), ErrXMLDocumentNotSigned) {
+		t.Error("expected", ErrXMLDocumentNotSigned, "got", results[0].Err())
+	}
+}
@@ -0,0 +1,349 @@
+package smartid
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Namespaces used by XML signatures.
+const (
+	nsXML      = "http://www.w3.org/XML/1998/namespace"
+	nsXMLDSig  = "http://www.w3.org/2000/09/xmldsig#"
+	nsXAdES    = "http://uri.etsi.org/01903/v1.3.2#"
+	nsXAdES141 = "http://uri.etsi.org/01903/v1.4.1#"
+	nsASiC     = "http://uri.etsi.org/02918/v1.2.1#"
+)
+
+var (
+	// ErrXMLNoRoot error when XML document has no root element.
+	ErrXMLNoRoot = errors.New("XML document has no root element")
+)
+
+// xmlAttr is the attribute or namespace declaration of the element.
+// Prefix is kept as written in the document.
+type xmlAttr struct {
+	Prefix, Local, Value string
+}
+
+// xmlElement is the minimal DOM element, which keeps prefixes and
+// namespace declarations as they appear in the document. It is needed
+// for canonicalization, which encoding/xml does not support.
+type xmlElement struct {
+	Prefix, Local string
+
+	// NS contains namespace declarations, Local is the declared prefix,
+	// empty for the default namespace.
+	NS []xmlAttr
+
+	// Attrs contains attributes without namespace declarations.
+	Attrs []xmlAttr
+
+	// Children are *xmlElement, xmlText, xmlComment or xmlProcInst.
+	Children []interface{}
+
+	Parent *xmlElement
+}
+
+type (
+	xmlText     string
+	xmlComment  string
+	xmlProcInst struct{ Target, Inst string }
+)
+
+// parseXML parses document and returns the root element.
+func parseXML(data []byte) (*xmlElement, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var root, cur *xmlElement
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			el := &xmlElement{
+				Prefix: t.Name.Space,
+				Local:  t.Name.Local,
+				Parent: cur,
+			}
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == "xmlns":
+					el.NS = append(el.NS, xmlAttr{Local: a.Name.Local, Value: a.Value})
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					el.NS = append(el.NS, xmlAttr{Value: a.Value})
+				default:
+					el.Attrs = append(el.Attrs, xmlAttr{a.Name.Space, a.Name.Local, a.Value})
+				}
+			}
+			if cur == nil {
+				if root != nil {
+					return nil, errors.New("XML document has many root elements")
+				}
+				root = el
+			} else {
+				cur.Children = append(cur.Children, el)
+			}
+			cur = el
+		case xml.EndElement:
+			if cur == nil || cur.Prefix != t.Name.Space || cur.Local != t.Name.Local {
+				return nil, errors.New("XML end element does not match")
+			}
+			cur = cur.Parent
+		case xml.CharData:
+			if cur != nil {
+				cur.Children = append(cur.Children, xmlText(t))
+			}
+		case xml.Comment:
+			if cur != nil {
+				cur.Children = append(cur.Children, xmlComment(t))
+			}
+		case xml.ProcInst:
+			if cur != nil {
+				cur.Children = append(cur.Children, xmlProcInst{t.Target, string(t.Inst)})
+			}
+		}
+	}
+	if root == nil {
+		return nil, ErrXMLNoRoot
+	}
+	if cur != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return root, nil
+}
+
+// lookupNS resolves namespace URI of the prefix in scope of the element.
+func (e *xmlElement) lookupNS(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return nsXML, true
+	}
+	for el := e; el != nil; el = el.Parent {
+		for _, ns := range el.NS {
+			if ns.Local == prefix {
+				return ns.Value, true
+			}
+		}
+	}
+	return "", prefix == ""
+}
+
+// Space returns the namespace URI of the element.
+func (e *xmlElement) Space() string {
+	uri, _ := e.lookupNS(e.Prefix)
+	return uri
+}
+
+// Is checks namespace URI and local name of the element.
+func (e *xmlElement) Is(space, local string) bool {
+	return e.Local == local && e.Space() == space
+}
+
+// Attr returns value of the attribute without namespace. Lookup methods
+// are safe to call on nil element.
+func (e *xmlElement) Attr(local string) string {
+	if e == nil {
+		return ""
+	}
+	for _, a := range e.Attrs {
+		if a.Prefix == "" && a.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// AttrNS returns value of the attribute with namespace.
+func (e *xmlElement) AttrNS(space, local string) string {
+	for _, a := range e.Attrs {
+		if a.Local == local && a.Prefix != "" && attrNS(e, a) == space {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// Elements returns child elements.
+func (e *xmlElement) Elements() []*xmlElement {
+	if e == nil {
+		return nil
+	}
+	var els []*xmlElement
+	for _, c := range e.Children {
+		if el, ok := c.(*xmlElement); ok {
+			els = append(els, el)
+		}
+	}
+	return els
+}
+
+// Child returns the first child element with namespace and local name.
+func (e *xmlElement) Child(space, local string) *xmlElement {
+	for _, el := range e.Elements() {
+		if el.Is(space, local) {
+			return el
+		}
+	}
+	return nil
+}
+
+// ChildrenNamed returns child elements with namespace and local name.
+func (e *xmlElement) ChildrenNamed(space, local string) []*xmlElement {
+	var els []*xmlElement
+	for _, el := range e.Elements() {
+		if el.Is(space, local) {
+			els = append(els, el)
+		}
+	}
+	return els
+}
+
+// Path follows child elements of the same namespace by local names.
+func (e *xmlElement) Path(space string, locals ...string) *xmlElement {
+	el := e
+	for _, local := range locals {
+		if el = el.Child(space, local); el == nil {
+			return nil
+		}
+	}
+	return el
+}
+
+// Text returns text content of the element and its descendants.
+func (e *xmlElement) Text() string {
+	if e == nil {
+		return ""
+	}
+	var sb strings.Builder
+	e.walk(func(n interface{}) {
+		if t, ok := n.(xmlText); ok {
+			sb.WriteString(string(t))
+		}
+	})
+	return strings.TrimSpace(sb.String())
+}
+
+// FindByID finds element which Id (or ID, id) attribute equals to id.
+// Empty id matches no element.
+func (e *xmlElement) FindByID(id string) *xmlElement {
+	if e == nil || id == "" {
+		return nil
+	}
+	var found *xmlElement
+	e.walkElements(func(el *xmlElement) bool {
+		for _, name := range []string{"Id", "ID", "id"} {
+			if el.Attr(name) == id {
+				found = el
+				return false
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// FindAll finds all descendant elements (including e) with namespace and
+// local name.
+func (e *xmlElement) FindAll(space, local string) []*xmlElement {
+	if e == nil {
+		return nil
+	}
+	var els []*xmlElement
+	e.walkElements(func(el *xmlElement) bool {
+		if el.Is(space, local) {
+			els = append(els, el)
+		}
+		return true
+	})
+	return els
+}
+
+// Find finds the first descendant element (including e) with namespace
+// and local name.
+func (e *xmlElement) Find(space, local string) *xmlElement {
+	if els := e.FindAll(space, local); len(els) > 0 {
+		return els[0]
+	}
+	return nil
+}
+
+// Remove removes the child element.
+func (e *xmlElement) Remove(child *xmlElement) {
+	for i, c := range e.Children {
+		if c == child {
+			e.Children = append(e.Children[:i:i], e.Children[i+1:]...)
+			child.Parent = nil
+			return
+		}
+	}
+}
+
+// clone makes deep copy of the element without the descendant element
+// skip, if it is not nil. Parent of the copy is the same as the parent of
+// e, so namespaces in scope stay the same.
+func (e *xmlElement) clone(skip *xmlElement) *xmlElement {
+	c := &xmlElement{
+		Prefix: e.Prefix,
+		Local:  e.Local,
+		NS:     append([]xmlAttr(nil), e.NS...),
+		Attrs:  append([]xmlAttr(nil), e.Attrs...),
+		Parent: e.Parent,
+	}
+	for _, child := range e.Children {
+		if el, ok := child.(*xmlElement); ok {
+			if el == skip {
+				continue
+			}
+			elc := el.clone(skip)
+			elc.Parent = c
+			c.Children = append(c.Children, elc)
+		} else {
+			c.Children = append(c.Children, child)
+		}
+	}
+	return c
+}
+
+func (e *xmlElement) walk(fn func(n interface{})) {
+	fn(e)
+	for _, c := range e.Children {
+		if el, ok := c.(*xmlElement); ok {
+			el.walk(fn)
+		} else {
+			fn(c)
+		}
+	}
+}
+
+// walkElements walks elements in document order until fn returns false.
+func (e *xmlElement) walkElements(fn func(el *xmlElement) bool) bool {
+	if !fn(e) {
+		return false
+	}
+	for _, c := range e.Children {
+		if el, ok := c.(*xmlElement); ok {
+			if !el.walkElements(fn) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// AddChild appends a new child element and returns it.
+func (e *xmlElement) AddChild(prefix, local string, attrs ...xmlAttr) *xmlElement {
+	el := &xmlElement{Prefix: prefix, Local: local, Attrs: attrs, Parent: e}
+	e.Children = append(e.Children, el)
+	return el
+}
+
+// SetText replaces the content of the element with the text.
+func (e *xmlElement) SetText(s string) {
+	e.Children = []interface{}{xmlText(s)}
+}
@@ -0,0 +1,36 @@
+package smartid
+
+import "testing"
+
+func TestXMLElement_FindByID(t *testing.T) {
+	root, err := parseXML([]byte(`<doc><a Id="A"><b ID="B"/></a><c id="C"/></doc>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testdata := []struct {
+		id, local string
+	}{
+		{"A", "a"},
+		{"B", "b"},
+		{"C", "c"},
+		{"D", ""},
+		{"", ""},
+	}
+	for _, test := range testdata {
+		el := root.FindByID(test.id)
+		if test.local == "" {
+			if el != nil {
+				t.Error(test.id, "expected", nil, "got", el.Local)
+			}
+		} else if el == nil || el.Local != test.local {
+			t.Error(test.id, "expected", test.local, "got", el)
+		}
+	}
+}
+
+func TestXMLElement_nil(t *testing.T) {
+	var e *xmlElement
+	if e.FindByID("A") != nil || e.FindAll("", "a") != nil || e.Find("", "a") != nil {
+		t.Error("expected nil element to find nothing")
+	}
+}